@@ -26,9 +26,11 @@ import (
 	"net/http"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/process"
 	"tryffel.net/go/virtualpaper/search"
@@ -45,6 +47,9 @@ func (a *Api) AuthorizeAdminV2() echo.MiddlewareFunc {
 			if !ctx.Admin {
 				return echo.ErrUnauthorized
 			}
+			if err := a.requireAdminTwoFactor(ctx.UserId); err != nil {
+				return err
+			}
 
 			return next(c)
 		}
@@ -373,13 +378,17 @@ func (a *Api) adminUpdateUser(c echo.Context) error {
 	if err != nil {
 		return fmt.Errorf("get user %d: %v", userId, err)
 	}
+	oldValue := userToAudit(user)
 
 	dataChanged := false
+	// revoke existing sessions when user can no longer log in with the old credentials
+	revokeCredentials := false
 	if user.IsActive != request.Active {
 		if request.Active {
 			logrus.Infof("Activate user %d by admin user %d", user.Id, ctx.UserId)
 		} else {
 			logrus.Infof("Deactivate user %d by admin user %d", user.Id, ctx.UserId)
+			revokeCredentials = true
 		}
 		user.IsActive = request.Active
 		dataChanged = true
@@ -405,10 +414,14 @@ func (a *Api) adminUpdateUser(c echo.Context) error {
 			return fmt.Errorf("set user's password: %v", err)
 		}
 		dataChanged = true
+		revokeCredentials = true
 	}
 	if dataChanged {
 		user.Update()
 		err = a.db.UserStore.Update(user)
+		if err == nil && revokeCredentials {
+			err = a.revokeUserCredentials(user.Id, ctx.TokenKey)
+		}
 		if err == nil {
 			info := models.UserInfo{
 				UserId:                user.Id,
@@ -425,6 +438,14 @@ func (a *Api) adminUpdateUser(c echo.Context) error {
 				TotalDocumentsIndexed: 0,
 			}
 			opOk = true
+			newValue := userToAudit(user)
+			newValue.PasswordChanged = request.Password != ""
+			a.auditValues(c, &models.AuditEvent{
+				Action:     models.AuditAdminUpdateUser,
+				Resource:   "user",
+				ResourceId: strconv.Itoa(user.Id),
+				Success:    true,
+			}, oldValue, newValue)
 			return c.JSON(200, info)
 		}
 	} else {
@@ -434,6 +455,88 @@ func (a *Api) adminUpdateUser(c echo.Context) error {
 	return err
 }
 
+func (a *Api) adminDeleteUser(c echo.Context) error {
+	// swagger:route DELETE /api/v1/admin/users/{id} Admin AdminDeleteUser
+	// Delete user and all user's data
+	//
+	// Deletes user's documents, files, search index, metadata, rules and all other data. This cannot be undone.
+	// With query parameter dry_run=1 only the summary of the data is returned and nothing is deleted.
+	// Otherwise the user is deactivated and logged out immediately and the data is deleted in background.
+	// responses:
+	//   200: UserDataSummary
+	//   400: RespBadRequest
+	//   404: RespNotFound
+
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	dryRun := c.QueryParam("dry_run") == "1"
+	if userId == ctx.UserId {
+		e := errors.ErrInvalid
+		e.ErrMsg = "cannot delete own account"
+		return e
+	}
+
+	user, err := a.db.UserStore.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	summary, err := a.db.UserStore.GetUserDataSummary(userId)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return c.JSON(http.StatusOK, summary)
+	}
+
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "delete", &opOk, "delete user %d, documents: %d", userId, summary.Documents)
+
+	// prevent using the account while the data is being deleted
+	oldValue := userToAudit(user)
+	if user.IsActive {
+		user.IsActive = false
+		user.Update()
+		err = a.db.UserStore.Update(user)
+		if err != nil {
+			return err
+		}
+	}
+	err = a.revokeUserCredentials(userId, "")
+	if err != nil {
+		return err
+	}
+
+	// result of the deletion is audited once it has finished
+	done := &models.AuditEvent{
+		Action:     models.AuditAdminDeleteUserDone,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(userId),
+		Success:    true,
+	}
+	auditRequest(c, done)
+	go func() {
+		err := a.process.DeleteUser(userId)
+		if err != nil {
+			logrus.Errorf("delete user %d: %v", userId, err)
+			done.Success = false
+			done.Message = err.Error()
+		}
+		a.storeAuditEvent(done)
+	}()
+
+	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditAdminDeleteUser,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(userId),
+		Success:    true,
+	}, map[string]interface{}{"user": oldValue, "data": summary}, nil)
+	return c.JSON(http.StatusOK, summary)
+}
+
 type AdminAddUserRequest struct {
 	UserName      string `json:"user_name" valid:"username"`
 	Email         string `json:"email" valid:"email,optional"`
@@ -459,6 +562,9 @@ func (a *Api) adminAddUser(c echo.Context) error {
 	if err = ValidatePassword(request.Password); err != nil {
 		return err
 	}
+	if err = a.checkUserLimit(); err != nil {
+		return err
+	}
 
 	opOk := false
 	userId := -1
@@ -489,6 +595,12 @@ func (a *Api) adminAddUser(c echo.Context) error {
 	if user.IsAdmin {
 		logrus.Infof("admin user %d created new user %d with admin privileges", ctx.UserId, user.Id)
 	}
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditAdminAddUser,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(user.Id),
+		Success:    true,
+	}, nil, userToAudit(user))
 
 	err = a.search.AddUserIndex(user.Id)
 	info := &models.UserInfo{
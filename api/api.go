@@ -25,6 +25,7 @@ import (
 	"github.com/asaskevich/govalidator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"os"
@@ -33,6 +34,7 @@ import (
 	"syscall"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/oidc"
 	"tryffel.net/go/virtualpaper/process"
 	"tryffel.net/go/virtualpaper/search"
 	"tryffel.net/go/virtualpaper/storage"
@@ -47,9 +49,13 @@ type Api struct {
 
 	cors    http.Handler
 	db      *storage.Database
-	search  *search.Engine
+	search  search.Engine
 	process *process.Manager
 	cron    *process.CronJobs
+	// oidc is nil if single sign-on is disabled.
+	oidc *oidc.Provider
+	// oidcTwoFactor contains single sign-on logins that are waiting for two-factor authentication.
+	oidcTwoFactor *cache.Cache
 }
 
 // NewApi initializes new api instance. It connects to database and opens http port.
@@ -69,7 +75,7 @@ func NewApi(database *storage.Database) (*Api, error) {
 	api.echo.Server.WriteTimeout = time.Second * 30
 
 	var err error
-	api.search, err = search.NewEngine(database, &config.C.Meilisearch)
+	api.search, err = search.NewEngine(database, &config.C.Search, &config.C.Meilisearch)
 	if err != nil {
 		return api, err
 	}
@@ -79,11 +85,16 @@ func NewApi(database *storage.Database) (*Api, error) {
 		return api, err
 	}
 
-	api.cron, err = process.NewCron(database)
+	api.cron, err = process.NewCron(database, api.search)
 	if err != nil {
 		return api, err
 	}
 
+	if config.C.Oidc.Enabled {
+		api.oidc = oidc.NewProvider(&config.C.Oidc)
+		api.oidcTwoFactor = cache.New(oidcTwoFactorTimeout, time.Minute)
+	}
+
 	api.addRoutesV2()
 	return api, err
 }
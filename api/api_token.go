@@ -0,0 +1,260 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// routeScopes are the scopes that personal access tokens need for each route group.
+// Reading requires the read scope and other methods require the write scope.
+// Routes are matched by the first matching prefix. Routes that are not listed, e.g. preferences and
+// authentication, cannot be accessed with access tokens.
+var routeScopes = []struct {
+	prefix string
+	read   string
+	write  string
+}{
+	{"/api/v1/admin", models.ScopeAdmin, models.ScopeAdmin},
+	{"/api/v1/documents/search", models.ScopeDocumentsRead, models.ScopeDocumentsRead},
+	{"/api/v1/documents", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/searches", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/collections", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/jobs", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/groups", models.ScopeDocumentsRead, models.ScopeDocumentsRead},
+	{"/api/v1/tags", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/filetypes", models.ScopeDocumentsRead, models.ScopeDocumentsWrite},
+	{"/api/v1/metadata", models.ScopeMetadataRead, models.ScopeMetadataWrite},
+	{"/api/v1/processing/rules", models.ScopeRulesRead, models.ScopeRulesWrite},
+}
+
+// routeScope returns the scope that is required to access the route, or empty if route cannot
+// be accessed with access tokens.
+func routeScope(method string, path string) string {
+	if method == http.MethodPost && path == "/api/v1/documents" {
+		return models.ScopeDocumentsUpload
+	}
+	for _, v := range routeScopes {
+		if path == v.prefix || strings.HasPrefix(path, v.prefix+"/") {
+			if method == http.MethodGet || method == http.MethodHead {
+				return v.read
+			}
+			return v.write
+		}
+	}
+	return ""
+}
+
+// authorizeApiToken authenticates user with personal access token and checks the token has the scope
+// that the route requires.
+func (a *Api) authorizeApiToken(c echo.Context, key string, next echo.HandlerFunc) error {
+	authErr := errors.ErrUnauthorized
+	authErr.ErrMsg = "invalid token"
+
+	token, err := a.db.AuthStore.UseApiToken(key, c.RealIP())
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return authErr
+		}
+		return err
+	}
+	if token.HasExpired() {
+		return authErr
+	}
+
+	user, err := a.db.UserStore.GetUser(token.UserId)
+	if err != nil || !user.IsActive {
+		return authErr
+	}
+
+	scope := routeScope(c.Request().Method, c.Path())
+	if scope == "" || !token.HasScope(scope) || (scope == models.ScopeAdmin && !user.IsAdmin) {
+		e := errors.ErrForbidden
+		e.ErrMsg = "token does not have access to the resource"
+		if scope != "" {
+			e.ErrMsg = "token is missing scope " + scope
+		}
+		return e
+	}
+
+	ctx := UserContext{
+		Context:  Context{c},
+		Admin:    user.IsAdmin,
+		UserId:   user.Id,
+		User:     user,
+		ApiToken: token,
+	}
+	return next(ctx)
+}
+
+// swagger:model ApiTokenRequest
+type ApiTokenRequest struct {
+	Name   string   `json:"name" valid:"required,stringlength(1|100)"`
+	Scopes []string `json:"scopes" valid:"-"`
+	// ExpiresInDays is the lifetime of the token. 0 creates a token that does not expire.
+	ExpiresInDays int `json:"expires_in_days" valid:"range(0|3650),optional"`
+}
+
+// swagger:model ApiToken
+type ApiTokenResponse struct {
+	Id     int      `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Token is only returned when the token is created.
+	Token      string `json:"token,omitempty"`
+	ExpiresAt  int64  `json:"expires_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	LastUsedIp string `json:"last_used_ip"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func apiTokenToResp(token *models.ApiToken) *ApiTokenResponse {
+	resp := &ApiTokenResponse{
+		Id:         token.Id,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.Scopes,
+		LastUsedIp: token.LastUsedIp,
+		CreatedAt:  token.CreatedAt.Unix() * 1000,
+	}
+	if token.ExpiresAt != nil {
+		resp.ExpiresAt = token.ExpiresAt.Unix() * 1000
+	}
+	if token.LastUsedAt != nil {
+		resp.LastUsedAt = token.LastUsedAt.Unix() * 1000
+	}
+	return resp
+}
+
+func (a *Api) getApiTokens(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/tokens Authentication GetApiTokens
+	// Get personal access tokens
+	// responses:
+	//   200: ApiToken
+	ctx := c.(UserContext)
+	tokens, err := a.db.AuthStore.GetApiTokens(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	resp := make([]*ApiTokenResponse, len(tokens))
+	for i := range tokens {
+		resp[i] = apiTokenToResp(&tokens[i])
+	}
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) addApiToken(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/tokens Authentication AddApiToken
+	// Create personal access token. The token is returned only in this response.
+	// responses:
+	//   200: ApiToken
+	ctx := c.(UserContext)
+	dto := &ApiTokenRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	if err = models.ValidateTokenScopes(dto.Scopes); err != nil {
+		return err
+	}
+	for _, v := range dto.Scopes {
+		if v == models.ScopeAdmin && !ctx.Admin {
+			e := errors.ErrForbidden
+			e.ErrMsg = "only administrators can create tokens with admin scope"
+			return e
+		}
+	}
+
+	opOk := false
+	defer logCrudApiToken(ctx.UserId, "add", &opOk, "name: %s, scopes: %v", dto.Name, dto.Scopes)
+
+	token := &models.ApiToken{
+		UserId: ctx.UserId,
+		Name:   dto.Name,
+		Scopes: dto.Scopes,
+	}
+	if dto.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, dto.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	key, err := token.Init()
+	if err != nil {
+		return err
+	}
+	err = a.db.AuthStore.AddApiToken(token)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := apiTokenToResp(token)
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditApiTokenAdd,
+		Resource:   "api_token",
+		ResourceId: strconv.Itoa(token.Id),
+		Success:    true,
+	}, nil, resp)
+	resp.Token = key
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) deleteApiToken(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/tokens/{id} Authentication DeleteApiToken
+	// Revoke personal access token
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudApiToken(ctx.UserId, "delete", &opOk, "id: %d", id)
+
+	tokens, err := a.db.AuthStore.GetApiTokens(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	var oldValue *ApiTokenResponse
+	for i, v := range tokens {
+		if v.Id == id {
+			oldValue = apiTokenToResp(&tokens[i])
+		}
+	}
+
+	err = a.db.AuthStore.DeleteApiToken(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditApiTokenDelete,
+		Resource:   "api_token",
+		ResourceId: strconv.Itoa(id),
+		Success:    true,
+	}, oldValue, nil)
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
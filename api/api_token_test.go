@@ -0,0 +1,56 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func Test_routeScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/api/v1/documents", models.ScopeDocumentsRead},
+		{http.MethodPost, "/api/v1/documents", models.ScopeDocumentsUpload},
+		{http.MethodGet, "/api/v1/documents/:id/download", models.ScopeDocumentsRead},
+		{http.MethodPut, "/api/v1/documents/:id", models.ScopeDocumentsWrite},
+		{http.MethodPost, "/api/v1/documents/bulkEdit", models.ScopeDocumentsWrite},
+		{http.MethodPost, "/api/v1/documents/search/facets", models.ScopeDocumentsRead},
+		{http.MethodGet, "/api/v1/metadata/keys", models.ScopeMetadataRead},
+		{http.MethodDelete, "/api/v1/metadata/keys/:id", models.ScopeMetadataWrite},
+		{http.MethodGet, "/api/v1/processing/rules/:id", models.ScopeRulesRead},
+		{http.MethodPost, "/api/v1/processing/rules/import", models.ScopeRulesWrite},
+		{http.MethodGet, "/api/v1/admin/users", models.ScopeAdmin},
+		{http.MethodGet, "/api/v1/preferences/user", ""},
+		{http.MethodPost, "/api/v1/auth/tokens", ""},
+		{http.MethodGet, "/api/v1/documentsx", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := routeScope(tt.method, tt.path); got != tt.want {
+				t.Errorf("routeScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// swagger:model AuditEvent
+type AuditEventResponse struct {
+	Id         int             `json:"id"`
+	CreatedAt  int64           `json:"created_at"`
+	ActorId    *int            `json:"actor_id"`
+	ActorName  string          `json:"actor_name"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceId string          `json:"resource_id"`
+	Success    bool            `json:"success"`
+	IpAddr     string          `json:"ip_address"`
+	RequestId  string          `json:"request_id"`
+	OldValue   json.RawMessage `json:"old_value"`
+	NewValue   json.RawMessage `json:"new_value"`
+	Message    string          `json:"message"`
+}
+
+func auditEventToResp(event *models.AuditEvent) *AuditEventResponse {
+	resp := &AuditEventResponse{
+		Id:         event.Id,
+		CreatedAt:  event.CreatedAt.Unix() * 1000,
+		ActorId:    event.ActorId,
+		ActorName:  event.ActorName,
+		Action:     event.Action,
+		Resource:   event.Resource,
+		ResourceId: event.ResourceId,
+		Success:    event.Success,
+		IpAddr:     event.IpAddr,
+		RequestId:  event.RequestId,
+		Message:    event.Message,
+	}
+	if event.OldValue != nil {
+		resp.OldValue = json.RawMessage(*event.OldValue)
+	}
+	if event.NewValue != nil {
+		resp.NewValue = json.RawMessage(*event.NewValue)
+	}
+	return resp
+}
+
+// auditUser is the subset of user that is stored in audit log.
+type auditUser struct {
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	Admin           bool   `json:"admin"`
+	Active          bool   `json:"active"`
+	PasswordChanged bool   `json:"password_changed,omitempty"`
+}
+
+func userToAudit(user *models.User) *auditUser {
+	if user == nil {
+		return nil
+	}
+	return &auditUser{
+		Name:   user.Name,
+		Email:  user.Email,
+		Admin:  user.IsAdmin,
+		Active: user.IsActive,
+	}
+}
+
+// auditDocument is the subset of document that is stored in audit log.
+type auditDocument struct {
+	Name     string `json:"name"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	UserId   int    `json:"user_id"`
+}
+
+func documentToAudit(doc *models.Document) *auditDocument {
+	return &auditDocument{
+		Name:     doc.Name,
+		Filename: doc.Filename,
+		Hash:     doc.Hash,
+		Size:     doc.Size,
+		UserId:   doc.UserId,
+	}
+}
+
+// audit stores event in the audit log. The actor is read from the user context if event does not have one,
+// and the client ip and request id are read from the request.
+// Failing to store the event does not fail the request, the error is only logged.
+func (a *Api) audit(c echo.Context, event *models.AuditEvent) {
+	auditRequest(c, event)
+	a.storeAuditEvent(event)
+}
+
+// auditRequest fills the actor and request details of the event. Use it with storeAuditEvent
+// to record events after the request has finished, since echo reuses the context.
+func auditRequest(c echo.Context, event *models.AuditEvent) {
+	if ctx, ok := c.(UserContext); ok && event.ActorId == nil {
+		actor := ctx.User
+		if ctx.Impersonator != nil {
+			// administrator is responsible for the actions when impersonating
+			actor = ctx.Impersonator
+			event.Message = strings.TrimSpace(fmt.Sprintf("impersonating user %d. %s", ctx.UserId, event.Message))
+		}
+		if actor != nil {
+			actorId := actor.Id
+			event.ActorId = &actorId
+			event.ActorName = actor.Name
+		}
+	}
+	event.IpAddr = c.RealIP()
+	event.RequestId = c.Response().Header().Get(echo.HeaderXRequestID)
+}
+
+func (a *Api) storeAuditEvent(event *models.AuditEvent) {
+	err := a.db.AuditStore.AddEvent(event)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"module":     "api",
+			"action":     event.Action,
+			"request_id": event.RequestId,
+		}).Errorf("store audit event: %v", err)
+	}
+}
+
+// auditValues is a shorthand for auditing an event with old and new values.
+func (a *Api) auditValues(c echo.Context, event *models.AuditEvent, oldValue, newValue interface{}) {
+	err := event.SetValues(oldValue, newValue)
+	if err != nil {
+		logrus.WithField("module", "api").Errorf("serialize audit values for %s: %v", event.Action, err)
+	}
+	a.audit(c, event)
+}
+
+// auditAuth stores an authentication event. UserId is 0 if user is not known, e.g. unknown username.
+func (a *Api) auditAuth(c echo.Context, action string, userId int, userName string, success bool, message string) {
+	event := &models.AuditEvent{
+		ActorName: userName,
+		Action:    action,
+		Resource:  "user",
+		Success:   success,
+		Message:   message,
+	}
+	if userId > 0 {
+		event.ActorId = &userId
+		event.ResourceId = strconv.Itoa(userId)
+	}
+	a.audit(c, event)
+}
+
+type auditLogParams struct {
+	ActorId    int    `query:"actor_id"`
+	Action     string `query:"action"`
+	Resource   string `query:"resource"`
+	ResourceId string `query:"resource_id"`
+	Success    string `query:"success"`
+	IpAddr     string `query:"ip_address"`
+	RequestId  string `query:"request_id"`
+	// Since and Until are unix timestamps in milliseconds.
+	Since int64 `query:"since"`
+	Until int64 `query:"until"`
+}
+
+func bindAuditFilter(c echo.Context) (storage.AuditFilter, error) {
+	params := &auditLogParams{}
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, params)
+	if err != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid filter: actor_id, since and until must be numeric"
+		return storage.AuditFilter{}, e
+	}
+
+	filter := storage.AuditFilter{
+		ActorId:    params.ActorId,
+		Action:     params.Action,
+		Resource:   params.Resource,
+		ResourceId: params.ResourceId,
+		IpAddr:     params.IpAddr,
+		RequestId:  params.RequestId,
+	}
+	switch params.Success {
+	case "":
+	case "true", "1":
+		success := true
+		filter.Success = &success
+	case "false", "0":
+		success := false
+		filter.Success = &success
+	default:
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid filter: success must be true or false"
+		return filter, e
+	}
+	if params.Since != 0 {
+		filter.Since = time.UnixMilli(params.Since)
+	}
+	if params.Until != 0 {
+		filter.Until = time.UnixMilli(params.Until)
+	}
+	return filter, nil
+}
+
+func (a *Api) adminGetAuditLog(c echo.Context) error {
+	// swagger:route GET /api/v1/admin/audit Admin AdminGetAuditLog
+	// Get audit log events
+	//
+	// Events are ordered from newest to oldest. Results can be filtered with query parameters
+	// actor_id, action, resource, resource_id, success, ip_address, request_id, since and until.
+	// Since and until are unix timestamps in milliseconds.
+	// responses:
+	//   200: AuditEvent
+	//   400: RespBadRequest
+	//   401: RespUnauthorized
+	paging, err := bindPaging(c)
+	if err != nil {
+		return err
+	}
+	filter, err := bindAuditFilter(c)
+	if err != nil {
+		return err
+	}
+	events, total, err := a.db.AuditStore.GetEvents(filter, paging)
+	if err != nil {
+		return err
+	}
+	resp := make([]*AuditEventResponse, len(events))
+	for i := range events {
+		resp[i] = auditEventToResp(&events[i])
+	}
+	return resourceList(c, resp, total)
+}
@@ -56,6 +56,9 @@ func (a *Api) authorizeUserV2() echo.MiddlewareFunc {
 			if len(parts) != 2 {
 				return
 			}
+			if strings.HasPrefix(parts[1], models.ApiTokenPrefix) {
+				return a.authorizeApiToken(c, parts[1], next)
+			}
 
 			userId, tokenKey, err := validateToken(parts[1], config.C.Api.Key)
 			if userId == "" || err != nil {
@@ -97,6 +100,13 @@ func (a *Api) authorizeUserV2() echo.MiddlewareFunc {
 					User:     user,
 					TokenKey: token.Key,
 				}
+				if token.IsImpersonation() {
+					ctx.Impersonator, err = a.authorizeImpersonation(c, token)
+					if err != nil {
+						return err
+					}
+					ctx.Admin = false
+				}
 				return next(ctx)
 			}
 			return
@@ -112,6 +122,13 @@ func (a *Api) ConfirmAuthorizedToken() echo.MiddlewareFunc {
 				c.Logger().Error("no UserContext found")
 				return echo.ErrInternalServerError
 			}
+			if ctx.ApiToken != nil {
+				// access tokens cannot be confirmed with password, so they cannot be used for sensitive actions.
+				logrus.Infof("api token %d used for action that requires confirmation", ctx.ApiToken.Id)
+				err := errors.ErrForbidden
+				err.ErrMsg = "action requires login with password"
+				return err
+			}
 			token, err := a.db.AuthStore.GetToken(ctx.TokenKey, false)
 			if err != nil {
 				return err
@@ -220,6 +237,8 @@ func validateToken(tokenString string, privateKey string) (string, string, error
 type LoginRequest struct {
 	Username string `valid:"username,required"`
 	Password string `valid:"required"`
+	// Code is either the one-time password or a recovery code, if user has enabled two-factor authentication.
+	Code string `json:"code" valid:"optional"`
 }
 
 type LoginResponse struct {
@@ -240,6 +259,9 @@ func (a *Api) LoginV2(c echo.Context) error {
 	if len(token) > 0 {
 		return c.String(http.StatusNotModified, "already logged in")
 	}
+	if err := passwordLoginEnabled(); err != nil {
+		return err
+	}
 
 	dto := &LoginRequest{}
 	err := unMarshalBody(req, dto)
@@ -251,10 +273,37 @@ func (a *Api) LoginV2(c echo.Context) error {
 	remoteAddr := getRemoteAddr(req)
 	if userId == -1 || err != nil {
 		logrus.Infof("Failed login attempt for user %s from remote %s", dto.Username, remoteAddr)
+		a.auditAuth(c, models.AuditLogin, 0, dto.Username, false, "invalid username or password")
 		return echo.ErrUnauthorized
 	}
+	err = a.checkSecondFactor(userId, dto.Code, errors.ErrUnauthorized)
+	if err != nil {
+		if dto.Code != "" {
+			logrus.Infof("Failed two-factor authentication for user %s from remote %s", dto.Username, remoteAddr)
+			a.auditAuth(c, models.AuditLogin, userId, dto.Username, false, "invalid two-factor code")
+		}
+		return err
+	}
 
 	c.Logger().Infof("User %d '%s' logged in from %s", userId, dto.Username, remoteAddr)
+	token, err = a.createLoginSession(c, userId)
+	if err != nil {
+		return err
+	}
+	a.auditAuth(c, models.AuditLogin, userId, dto.Username, true, "password")
+	respBody := &LoginResponse{
+		UserId: userId,
+		Token:  token,
+	}
+
+	return c.JSON(http.StatusOK, respBody)
+}
+
+// createLoginSession creates a new session for user that has successfully logged in, notifies the user
+// about the login, and returns the session token.
+func (a *Api) createLoginSession(c echo.Context, userId int) (string, error) {
+	req := c.Request()
+	remoteAddr := getRemoteAddr(req)
 	authToken := &models.Token{
 		Id:            0,
 		UserId:        userId,
@@ -270,22 +319,26 @@ func (a *Api) LoginV2(c echo.Context) error {
 		authToken.ExpiresAt = time.Now().Add(config.C.Api.TokenExpire)
 	}
 
-	err = authToken.Init()
+	err := authToken.Init()
 	if err != nil {
-		return fmt.Errorf("init token: %v", err)
+		return "", fmt.Errorf("init token: %v", err)
 	}
 	err = a.db.AuthStore.InsertToken(authToken)
 	if err != nil {
-		return fmt.Errorf("save auth token to database: %v", err)
+		return "", fmt.Errorf("save auth token to database: %v", err)
 	}
 
-	token, err = newToken(strconv.Itoa(userId), authToken.Key, config.C.Api.Key)
+	token, err := newToken(strconv.Itoa(userId), authToken.Key, config.C.Api.Key)
 	if err != nil {
 		c.Logger().Errorf("Create new token: %v", err)
 	}
 
 	user, err := a.db.UserStore.GetUser(userId)
-	if user.Email != "" {
+	notifications, err := a.db.UserStore.GetNotificationSettings(userId)
+	if err != nil {
+		logrus.Errorf("get notification settings for user %d: %v", userId, err)
+	}
+	if user.Email != "" && notifications.LoginAlerts {
 		logrus.Debugf("Send email for logged in user to %s", user.Email)
 
 		msg := fmt.Sprintf(`User logged in
@@ -299,12 +352,7 @@ user agent: %s,
 			logrus.Errorf("send logged-in email to user: %s: %v", user.Email, err)
 		}
 	}
-	respBody := &LoginResponse{
-		UserId: userId,
-		Token:  token,
-	}
-
-	return c.JSON(http.StatusOK, respBody)
+	return token, nil
 }
 
 type ResetPasswordRequest struct {
@@ -336,6 +384,9 @@ func (a *Api) ResetPassword(c echo.Context) error {
 	// responses:
 	//   200:
 
+	if err := passwordLoginEnabled(); err != nil {
+		return err
+	}
 	req := c.Request()
 	dto := &ResetPasswordRequest{}
 	err := unMarshalBody(req, dto)
@@ -359,6 +410,7 @@ func (a *Api) ResetPassword(c echo.Context) error {
 	if token.HasExpired() {
 		logrus.Warningf("user %d attempted to change password with expired reset token %d, expired at %s",
 			token.UserId, token.Id, token.ExpiresAt)
+		a.auditAuth(c, models.AuditPasswordReset, token.UserId, "", false, "token has expired")
 		e := errors.ErrInvalid
 		e.ErrMsg = "Token has expired. Please create a new reset link."
 		return e
@@ -370,6 +422,7 @@ func (a *Api) ResetPassword(c echo.Context) error {
 		return fmt.Errorf("compare token to hash: %v", err)
 	}
 	if !match {
+		a.auditAuth(c, models.AuditPasswordReset, token.UserId, "", false, "invalid token")
 		e := errors.ErrForbidden
 		e.ErrMsg = "Invalid token"
 		return e
@@ -390,6 +443,7 @@ func (a *Api) ResetPassword(c echo.Context) error {
 		if err != nil {
 			return fmt.Errorf("update user's passowrd: %v", err)
 		}
+		a.auditAuth(c, models.AuditPasswordReset, user.Id, user.Name, true, "")
 	}
 
 	logrus.Warningf("Reset user's (%d) password with reset token %d", user.Id, token.Id)
@@ -402,6 +456,95 @@ func (a *Api) ResetPassword(c echo.Context) error {
 	return c.JSON(200, "ok")
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token" valid:"minstringlength(4)"`
+	Id    int    `json:"id" valid:"required"`
+}
+
+func (a *Api) VerifyEmail(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/verify-email Authentication VerifyEmail
+	// Verify new email address
+	//
+	// Changes user's email to the address that the verification link was sent to.
+	// responses:
+	//   200:
+	//   400: RespBadRequest
+	//   403: RespForbidden
+
+	dto := &VerifyEmailRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.db.UserStore.GetEmailVerificationToken(dto.Id)
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			logrus.Warningf("email verification token not found by id %d", dto.Id)
+			return errors.ErrForbidden
+		}
+		return err
+	}
+
+	if token.HasExpired() {
+		a.auditAuth(c, models.AuditEmailChange, token.UserId, "", false, "token has expired")
+		e := errors.ErrInvalid
+		e.ErrMsg = "Link has expired. Please change email again."
+		return e
+	}
+
+	match, err := token.TokenMatches(dto.Token)
+	if err != nil {
+		logrus.Warningf("user %d attempted to verify email with bad token %d: %v", token.UserId, token.Id, err)
+		return fmt.Errorf("compare token to hash: %v", err)
+	}
+	if !match {
+		a.auditAuth(c, models.AuditEmailChange, token.UserId, "", false, "invalid token")
+		e := errors.ErrForbidden
+		e.ErrMsg = "Invalid token"
+		return e
+	}
+
+	user, err := a.db.UserStore.GetUser(token.UserId)
+	if err != nil {
+		return fmt.Errorf("get user: %v", err)
+	}
+	oldEmail := user.Email
+	user.Email = token.Email
+	user.Update()
+	err = a.db.UserStore.Update(user)
+	if err != nil {
+		user.Email = oldEmail
+		return err
+	}
+
+	err = a.db.UserStore.DeleteEmailVerificationToken(token.Id)
+	if err != nil {
+		logrus.Errorf("delete email verification token %d: %v", token.Id, err)
+	}
+
+	logrus.Warningf("changed user's (%d) email with verification token %d", user.Id, token.Id)
+	event := &models.AuditEvent{
+		ActorId:    &user.Id,
+		ActorName:  user.Name,
+		Action:     models.AuditEmailChange,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(user.Id),
+		Success:    true,
+	}
+	a.auditValues(c, event, map[string]string{"email": oldEmail}, map[string]string{"email": user.Email})
+
+	if oldEmail != "" {
+		go func() {
+			err := mail.EmailChanged(oldEmail, user.Email)
+			if err != nil {
+				logrus.Errorf("send email changed notification to user %d: %v", user.Id, err)
+			}
+		}()
+	}
+	return c.JSON(200, "ok")
+}
+
 type ForgottenPasswordRequest struct {
 	Email string `json:"email" valid:"email"`
 }
@@ -413,6 +556,9 @@ func (a *Api) CreateResetPasswordToken(c echo.Context) error {
 	// responses:
 	//   200:
 
+	if err := passwordLoginEnabled(); err != nil {
+		return err
+	}
 	req := c.Request()
 	dto := &ForgottenPasswordRequest{}
 	err := unMarshalBody(req, dto)
@@ -451,6 +597,12 @@ func (a *Api) CreateResetPasswordToken(c echo.Context) error {
 	msg := map[string]string{"status": "Password reset email has been sent"}
 
 	if !userOk {
+		// email is stored only for unknown users, known user is identified by id
+		if user != nil {
+			a.auditAuth(c, models.AuditPasswordResetRequest, user.Id, user.Name, false, "user is not allowed to reset password")
+		} else {
+			a.auditAuth(c, models.AuditPasswordResetRequest, 0, "", false, "unknown email: "+dto.Email)
+		}
 		// about the time it would take to save the token in db
 		time.Sleep(time.Millisecond * 2)
 		return c.JSON(200, msg)
@@ -468,15 +620,23 @@ func (a *Api) CreateResetPasswordToken(c echo.Context) error {
 	}
 
 	logrus.Warningf("Create password reset token %d for user %d, expires at %s", token.Id, user.Id, token.ExpiresAt)
+	a.auditAuth(c, models.AuditPasswordResetRequest, user.Id, user.Name, true, "")
 	go mail.ResetPassword(user.Email, rawToken, token.Id)
 	return c.JSON(200, msg)
 }
 
 type AuthConfirmationRequest struct {
 	Password string `json:"password" valid:"stringlength(8|150)"`
+	// Code is required if user has enabled two-factor authentication.
+	Code string `json:"code" valid:"optional"`
 }
 
 func (a *Api) ConfirmAuthentication(c echo.Context) error {
+	if err := passwordLoginEnabled(); err != nil {
+		e := errors.ErrForbidden
+		e.ErrMsg = "password login is disabled, log in again with single sign-on"
+		return e
+	}
 	req := c.Request()
 	dto := &AuthConfirmationRequest{}
 	err := unMarshalBody(req, dto)
@@ -491,8 +651,15 @@ func (a *Api) ConfirmAuthentication(c echo.Context) error {
 	remoteAddr := getRemoteAddr(req)
 	if userId == -1 || err != nil {
 		logrus.Infof("Failed authentication confirmation for user %d, token %s, from remote %s", user.UserId, user.TokenKey, remoteAddr)
+		a.auditAuth(c, models.AuditConfirmAuthentication, user.UserId, user.User.Name, false, "invalid password")
 		return echo.ErrForbidden
 	}
+	err = a.checkSecondFactor(user.UserId, dto.Code, errors.ErrForbidden)
+	if err != nil {
+		logrus.Infof("Failed two-factor authentication confirmation for user %d from remote %s", user.UserId, remoteAddr)
+		a.auditAuth(c, models.AuditConfirmAuthentication, user.UserId, user.User.Name, false, "invalid two-factor code")
+		return err
+	}
 
 	token, err := a.db.AuthStore.GetToken(user.TokenKey, true)
 	if err != nil {
@@ -505,6 +672,7 @@ func (a *Api) ConfirmAuthentication(c echo.Context) error {
 		return err
 	}
 	logrus.Infof("Authentication confirmation successful for user %d, token %s, from remote %s", user.UserId, user.TokenKey, remoteAddr)
+	a.auditAuth(c, models.AuditConfirmAuthentication, user.UserId, user.User.Name, true, "")
 	return c.JSON(200, "")
 }
 
@@ -0,0 +1,114 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// swagger:model Collection
+type CollectionResponse struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	DocumentCount int    `json:"document_count"`
+	// DocumentIds are returned only when getting a single collection.
+	DocumentIds []string `json:"document_ids,omitempty"`
+	CreatedAt   int64    `json:"created_at"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+func collectionToResp(collection *models.Collection) *CollectionResponse {
+	return &CollectionResponse{
+		Id:            collection.Id,
+		Name:          collection.Name,
+		DocumentCount: collection.DocumentCount,
+		CreatedAt:     collection.CreatedAt.Unix() * 1000,
+		UpdatedAt:     collection.UpdatedAt.Unix() * 1000,
+	}
+}
+
+func (a *Api) getCollections(c echo.Context) error {
+	// swagger:route GET /api/v1/collections Collections GetCollections
+	// Get document collections
+	// responses:
+	//   200: Collection
+	ctx := c.(UserContext)
+	paging, err := bindPaging(c)
+	if err != nil {
+		return err
+	}
+
+	collections, total, err := a.db.CollectionStore.GetCollections(ctx.UserId, paging)
+	if err != nil {
+		return err
+	}
+	resp := make([]*CollectionResponse, len(collections))
+	for i := range collections {
+		resp[i] = collectionToResp(&collections[i])
+	}
+	return resourceList(c, resp, total)
+}
+
+func (a *Api) getCollection(c echo.Context) error {
+	// swagger:route GET /api/v1/collections/{id} Collections GetCollection
+	// Get collection with its documents
+	// responses:
+	//   200: Collection
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	collection, err := a.db.CollectionStore.GetCollection(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	resp := collectionToResp(collection)
+	resp.DocumentIds, err = a.db.CollectionStore.GetCollectionDocumentIds(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	return resourceList(c, resp, 1)
+}
+
+func (a *Api) deleteCollection(c echo.Context) error {
+	// swagger:route DELETE /api/v1/collections/{id} Collections DeleteCollection
+	// Delete collection. Documents in the collection are not deleted.
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudCollection(ctx.UserId, "delete", &opOk, "collection: %d", id)
+
+	err = a.db.CollectionStore.DeleteCollection(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
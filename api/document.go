@@ -19,6 +19,7 @@
 package api
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"io"
@@ -58,6 +59,14 @@ type DocumentResponse struct {
 	Status      string            `json:"status"`
 	Metadata    []models.Metadata `json:"metadata"`
 	Tags        []models.Tag      `json:"tags"`
+	// Shared is true if document is owned by another user and shared with the user.
+	Shared bool `json:"shared"`
+	// Permission is the permission user has to the document: 'owner', 'edit' or 'read'.
+	Permission string `json:"permission,omitempty"`
+	// SearchMatch tells which part of the content matched, if document is a search result.
+	SearchMatch *models.DocumentSearchMatch `json:"search_match,omitempty"`
+	// DeletedAt is the time document was moved to trash, if it is in trash.
+	DeletedAt int64 `json:"deleted_at,omitempty"`
 }
 
 func responseFromDocument(doc *models.Document) *DocumentResponse {
@@ -78,6 +87,10 @@ func responseFromDocument(doc *models.Document) *DocumentResponse {
 		PrettySize:  doc.GetSize(),
 		Metadata:    doc.Metadata,
 		Tags:        doc.Tags,
+		SearchMatch: doc.SearchMatch,
+	}
+	if doc.DeletedAt.Valid {
+		resp.DeletedAt = doc.DeletedAt.Time.Unix() * 1000
 	}
 	return resp
 }
@@ -139,6 +152,7 @@ func (a *Api) getDocuments(c echo.Context) error {
 
 	for i, v := range *docs {
 		respDocs[i] = responseFromDocument(&v)
+		respDocs[i].Shared = v.UserId != ctx.UserId
 	}
 	return resourceList(c, respDocs, count)
 }
@@ -158,7 +172,11 @@ func (a *Api) getDocument(c echo.Context) error {
 		err.ErrMsg = "query parameter 'visit' must be either 1 or 0"
 		return err
 	}
-	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	permission, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
+	if err != nil {
+		return err
+	}
+	doc, err := a.db.DocumentStore.GetDocument(0, id)
 	if err != nil {
 		return err
 	}
@@ -168,13 +186,14 @@ func (a *Api) getDocument(c echo.Context) error {
 		return err
 	}
 
-	metadata, err := a.db.MetadataStore.GetDocumentMetadata(ctx.UserId, id)
+	// metadata of shared documents belongs to the owner
+	metadata, err := a.db.MetadataStore.GetDocumentMetadata(doc.UserId, id)
 	if err != nil {
 		return err
 	}
 	doc.Metadata = *metadata
 
-	tags, err := a.db.MetadataStore.GetDocumentTags(ctx.UserId, id)
+	tags, err := a.db.MetadataStore.GetDocumentTags(doc.UserId, id)
 	if err != nil {
 		return err
 	}
@@ -182,6 +201,8 @@ func (a *Api) getDocument(c echo.Context) error {
 
 	respDoc := responseFromDocument(doc)
 	respDoc.Status = status
+	respDoc.Shared = permission != models.PermissionOwner
+	respDoc.Permission = string(permission)
 
 	if visit == "1" {
 		err := a.db.DocumentStore.AddVisited(ctx.UserId, id)
@@ -201,7 +222,11 @@ func (a *Api) getDocumentContent(c echo.Context) error {
 	ctx := c.(UserContext)
 	id := c.Param("id")
 
-	content, err := a.db.DocumentStore.GetContent(ctx.UserId, id)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
+	if err != nil {
+		return err
+	}
+	content, err := a.db.DocumentStore.GetContent(0, id)
 	if err != nil {
 		return err
 	}
@@ -216,15 +241,11 @@ func (a *Api) getDocumentLogs(c echo.Context) error {
 
 	ctx := c.(UserContext)
 	id := c.Param("id")
-	owns, err := a.db.DocumentStore.UserOwnsDocument(id, ctx.UserId)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
 	if err != nil {
 		return err
 	}
 
-	if !owns {
-		return echo.NewHTTPError(http.StatusForbidden, "forbidden")
-	}
-
 	job, err := a.db.JobStore.GetByDocument(id)
 	if err != nil {
 		return err
@@ -239,11 +260,19 @@ func (a *Api) getDocumentPreview(c echo.Context) error {
 
 	ctx := c.(UserContext)
 	id := c.Param("id")
-	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
+	if err != nil {
+		return err
+	}
+	doc, err := a.db.DocumentStore.GetDocument(0, id)
 	if err != nil {
 		return err
 	}
+	return sendDocumentPreview(c, doc)
+}
 
+// sendDocumentPreview writes the preview image of the document to the response.
+func sendDocumentPreview(c echo.Context, doc *models.Document) error {
 	filePath := storage.PreviewPath(doc.Id)
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -459,11 +488,31 @@ func (a *Api) downloadDocument(c echo.Context) error {
 
 	opOk := false
 	defer logCrudDocument(ctx.UserId, "download", &opOk, "document: %s", id)
-	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
+	if err != nil {
+		return err
+	}
+	doc, err := a.db.DocumentStore.GetDocument(0, id)
+	if err != nil {
+		return err
+	}
+
+	err = sendDocumentFile(c, doc)
 	if err != nil {
 		return err
 	}
+	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditDocumentDownload,
+		Resource:   "document",
+		ResourceId: doc.Id,
+		Success:    true,
+	}, nil, documentToAudit(doc))
+	return nil
+}
 
+// sendDocumentFile writes the original file of the document to the response.
+func sendDocumentFile(c echo.Context, doc *models.Document) error {
 	filePath := storage.DocumentPath(doc.Id)
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -473,6 +522,9 @@ func (a *Api) downloadDocument(c echo.Context) error {
 	defer file.Close()
 
 	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
 	size := stat.Size()
 
 	resp := c.Response()
@@ -484,7 +536,6 @@ func (a *Api) downloadDocument(c echo.Context) error {
 	if err != nil {
 		logrus.Errorf("send file over http: %v", err)
 	}
-	opOk = true
 	return nil
 }
 
@@ -506,7 +557,11 @@ func (a *Api) updateDocument(c echo.Context) error {
 	defer logCrudDocument(ctx.UserId, "update", &opOk, "document: %s", id)
 
 	dto.Filename = govalidator.SafeFileName(dto.Filename)
-	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	permission, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionEdit)
+	if err != nil {
+		return err
+	}
+	doc, err := a.db.DocumentStore.GetDocument(0, id)
 	if err != nil {
 		return err
 	}
@@ -527,21 +582,37 @@ func (a *Api) updateDocument(c echo.Context) error {
 		}
 	}
 
+	if permission != models.PermissionOwner {
+		// metadata keys and values belong to the owner, shared users can only edit the document itself
+		oldMetadata, err := a.db.MetadataStore.GetDocumentMetadata(doc.UserId, doc.Id)
+		if err != nil {
+			return err
+		}
+		if !sameMetadata(metadata, *oldMetadata) {
+			e := errors.ErrForbidden
+			e.ErrMsg = "only the owner can change metadata of the document"
+			return e
+		}
+		metadata = *oldMetadata
+	}
+
 	doc.Update()
-	doc.Metadata = metadata
 
 	err = a.db.DocumentStore.Update(ctx.UserId, doc)
 	if err != nil {
 		return err
 	}
 
-	err = a.db.MetadataStore.UpdateDocumentKeyValues(ctx.UserId, doc.Id, metadata)
-	if err != nil {
-		return err
+	doc.Metadata = metadata
+	if permission == models.PermissionOwner {
+		err = a.db.MetadataStore.UpdateDocumentKeyValues(ctx.UserId, doc.Id, metadata)
+		if err != nil {
+			return err
+		}
 	}
 
 	logrus.Debugf("document updated, force fts update")
-	err = a.db.JobStore.ForceProcessing(ctx.UserId, doc.Id, models.ProcessFts)
+	err = a.db.JobStore.ForceProcessing(doc.UserId, doc.Id, models.ProcessFts)
 	if err != nil {
 		logrus.Warningf("error marking document for processing (doc %s): %v", doc.Id, err)
 	} else {
@@ -560,11 +631,13 @@ func (d *documentSortParams) FilterAttributes() []string {
 	return []string{"id", "name", "content", "description", "date"}
 }
 
+// SortAttributes returns search sort attributes. Id is the default sort in the frontend,
+// and it sorts by relevance same as no sort.
 func (d *documentSortParams) SortAttributes() []string {
-	return append(d.FilterAttributes(), "created_at", "updated_at")
+	return append([]string{"id"}, search.SortAttributes...)
 }
 
-func (d *documentSortParams) SortNoCase() []string { return d.FilterAttributes() }
+func (d *documentSortParams) SortNoCase() []string { return []string{"name"} }
 
 func (d *documentSortParams) Update() {}
 
@@ -578,6 +651,14 @@ func (a *Api) searchDocuments(userId int, filter *search.DocumentFilter, c echo.
 	if err != nil {
 		return err
 	}
+	if len(sort) == 0 {
+		if key, _ := sortParam(c.Request()); key != "" {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("invalid sort '%s', must be one of: %s", key, strings.Join(search.SortAttributes, ", "))
+			return e
+		}
+		sort = append(sort, storage.SortKey{})
+	}
 
 	if len(sort) == 1 {
 		filter.Sort = sort[0].Key
@@ -615,16 +696,13 @@ func (a *Api) requestDocumentProcessing(c echo.Context) error {
 
 	ctx := c.(UserContext)
 	id := bindPathId(c)
-	owns, err := a.db.DocumentStore.UserOwnsDocument(id, ctx.UserId)
-	if err != nil {
-		return err
-	}
 
 	opOk := false
 	defer logCrudDocument(ctx.UserId, "schedule processing", &opOk, "document: %s", id)
 
-	if !owns {
-		return respForbiddenV2()
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
 	}
 
 	err = a.db.JobStore.ForceProcessing(ctx.UserId, id, models.ProcessRules)
@@ -661,13 +739,13 @@ func (a *Api) deleteDocument(c echo.Context) error {
 	opOk := false
 	defer logCrudDocument(ctx.UserId, "delete", &opOk, "document: %s", id)
 
-	owns, err := a.db.DocumentStore.UserOwnsDocument(id, ctx.UserId)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
 	if err != nil {
 		return err
 	}
-
-	if !owns {
-		return respForbiddenV2()
+	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	if err != nil {
+		return err
 	}
 
 	logrus.Infof("Request user %d removing document %s", ctx.UserId, id)
@@ -684,9 +762,97 @@ func (a *Api) deleteDocument(c echo.Context) error {
 		return err
 	}
 	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditDocumentDelete,
+		Resource:   "document",
+		ResourceId: id,
+		Success:    true,
+	}, documentToAudit(doc), nil)
 	return c.JSON(http.StatusOK, nil)
 }
 
+func (a *Api) getTrashedDocuments(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/trash Documents GetTrashedDocuments
+	// Get documents in trash
+	// Responses:
+	//   200: DocumentResponse
+
+	ctx := c.(UserContext)
+	paging, err := bindPaging(c)
+	if err != nil {
+		return err
+	}
+
+	docs, count, err := a.db.DocumentStore.GetTrashedDocuments(ctx.UserId, paging)
+	if err != nil {
+		return err
+	}
+	respDocs := make([]*DocumentResponse, len(*docs))
+	for i, v := range *docs {
+		respDocs[i] = responseFromDocument(&v)
+	}
+	return resourceList(c, respDocs, count)
+}
+
+func (a *Api) restoreDocument(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/restore Documents RestoreDocument
+	// Restore document from trash
+	// Responses:
+	//   200: DocumentResponse
+	//   400: RespBadRequest
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+
+	ctx := c.(UserContext)
+	id := c.Param("id")
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "restore", &opOk, "document: %s", id)
+
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	doc, err := a.db.DocumentStore.GetDocument(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	if !doc.DeletedAt.Valid {
+		e := errors.ErrInvalid
+		e.ErrMsg = "document is not in trash"
+		return e
+	}
+
+	existingDoc, err := a.db.DocumentStore.GetByHash(0, doc.Hash)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return err
+	}
+	if existingDoc != nil && existingDoc.Id != "" && existingDoc.Id != doc.Id {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("document with the same content exists: %s", existingDoc.Id)
+		return e
+	}
+
+	doc.DeletedAt = sql.NullTime{}
+	err = a.db.DocumentStore.Update(ctx.UserId, doc)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.JobStore.ForceProcessing(doc.UserId, doc.Id, models.ProcessFts)
+	if err != nil {
+		logrus.Warningf("error marking document for processing (doc %s): %v", doc.Id, err)
+	} else {
+		err = a.process.AddDocumentForProcessing(doc)
+		if err != nil {
+			logrus.Warningf("error adding restored document for processing (doc: %s): %v", doc.Id, err)
+		}
+	}
+	opOk = true
+	return resourceList(c, responseFromDocument(doc), 1)
+}
+
 type BulkEditDocumentsRequest struct {
 	Documents      []string              `json:"documents" valid:"required"`
 	AddMetadata    MetadataUpdateRequest `json:"add_metadata" valid:"-"`
@@ -774,6 +940,12 @@ func (a *Api) bulkEditDocuments(c echo.Context) error {
 	}
 	a.process.PullDocumentsToProcess()
 	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:   models.AuditDocumentBulkEdit,
+		Resource: "document",
+		Success:  true,
+		Message:  fmt.Sprintf("%d documents", len(dto.Documents)),
+	}, nil, dto)
 	return resourceList(c, dto.Documents, len(dto.Documents))
 }
 
@@ -813,6 +985,34 @@ func (a *Api) searchSuggestions(c echo.Context) error {
 	return c.JSON(http.StatusOK, suggestions)
 }
 
+func (a *Api) searchFacets(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/search/facets Documents SearchFacets
+	// Get number of documents matching the query for each metadata value, mimetype, year and month.
+	// Counted facets can be configured in user preferences.
+	// consumes:
+	//  - application/json
+	//
+	// Responses:
+	//   200: RespOk
+	//   400: RespBadRequest
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+	ctx := c.(UserContext)
+
+	dto := &SearchSuggestRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	facets, err := a.search.SearchFacets(ctx.UserId, dto.Filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, facets)
+}
+
 func (a *Api) getDocumentHistory(c echo.Context) error {
 	// swagger:route GET /api/v1/documents/:id/history Documents GetHistory
 	// Get document history
@@ -828,19 +1028,43 @@ func (a *Api) getDocumentHistory(c echo.Context) error {
 	opOk := false
 	defer logCrudDocument(ctx.UserId, "delete", &opOk, "document: %s", id)
 
-	owns, err := a.db.DocumentStore.UserOwnsDocument(id, ctx.UserId)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionRead)
 	if err != nil {
 		return err
 	}
 
-	if !owns {
-		return echo.NewHTTPError(http.StatusForbidden, "forbidden")
+	data, err := a.db.DocumentStore.GetDocumentHistory(ctx.UserId, id)
+	if err != nil {
+		return err
 	}
 
-	data, err := a.db.DocumentStore.GetDocumentHistory(ctx.UserId, id)
+	return resourceList(c, data, len(*data))
+}
+
+func (a *Api) getDocumentRulesTrace(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/:id/rules-trace Documents GetRulesTrace
+	// Get traces of latest processing rule runs for the document, newest first.
+	// Responses:
+	//   200: RespOk
+	//   400: RespBadRequest
+	//   401: RespForbidden
+	//   403: RespNotFound
+	//   500: RespInternalError
+
+	ctx := c.(UserContext)
+	id := c.Param("id")
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "get rules trace", &opOk, "document: %s", id)
+
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
 	if err != nil {
 		return err
 	}
 
+	data, err := a.db.RuleStore.GetDocumentRuleTraces(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
 	return resourceList(c, data, len(*data))
 }
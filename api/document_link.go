@@ -0,0 +1,409 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// LinkPasswordHeader is the header that carries the password of a password-protected link.
+// Password can also be given in the body of a POST request, but never in the url, so that it does not
+// end up in access logs or browser history.
+const LinkPasswordHeader = "X-Link-Password"
+
+func publicLinkUrl(token string) string {
+	return fmt.Sprintf("%s/api/v1/public/links/%s", config.C.Api.PublicUrl, token)
+}
+
+// swagger:model DocumentLink
+type DocumentLinkResponse struct {
+	Id     int    `json:"id"`
+	Prefix string `json:"prefix"`
+	// Token and Url are only returned when the link is created.
+	Token             string `json:"token,omitempty"`
+	Url               string `json:"url,omitempty"`
+	PasswordProtected bool   `json:"password_protected"`
+	ExpiresAt         int64  `json:"expires_at"`
+	Expired           bool   `json:"expired"`
+	MaxDownloads      *int   `json:"max_downloads"`
+	DownloadCount     int    `json:"download_count"`
+	LastAccessedAt    int64  `json:"last_accessed_at"`
+	CreatedAt         int64  `json:"created_at"`
+}
+
+func linkToResp(link *models.DocumentLink) DocumentLinkResponse {
+	resp := DocumentLinkResponse{
+		Id:                link.Id,
+		Prefix:            link.Prefix,
+		PasswordProtected: link.HasPassword(),
+		ExpiresAt:         link.ExpiresAt.Unix() * 1000,
+		Expired:           link.HasExpired(),
+		MaxDownloads:      link.MaxDownloads,
+		DownloadCount:     link.DownloadCount,
+		CreatedAt:         link.CreatedAt.Unix() * 1000,
+	}
+	if link.LastAccessedAt != nil {
+		resp.LastAccessedAt = link.LastAccessedAt.Unix() * 1000
+	}
+	return resp
+}
+
+// DocumentLinkRequest creates a public link.
+// swagger:model DocumentLinkRequest
+type DocumentLinkRequest struct {
+	// ExpiresAt in ms.
+	ExpiresAt int64 `json:"expires_at" valid:"required"`
+	// Password is optional.
+	Password string `json:"password" valid:"optional,maxstringlength(150)"`
+	// MaxDownloads limits number of downloads, 0 is unlimited.
+	MaxDownloads int `json:"max_downloads" valid:"optional,range(0|100000)"`
+}
+
+func (a *Api) getDocumentLinks(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/links Documents GetDocumentLinks
+	// Get public links of the document
+	// responses:
+	//   200: DocumentLink
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	links, err := a.db.DocumentStore.GetDocumentLinks(id)
+	if err != nil {
+		return err
+	}
+	resp := make([]DocumentLinkResponse, len(*links))
+	for i := range *links {
+		resp[i] = linkToResp(&(*links)[i])
+	}
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) addDocumentLink(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/links Documents AddDocumentLink
+	// Create a public link to the document. The link token is only returned once.
+	// responses:
+	//   200: DocumentLink
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	dto := &DocumentLinkRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "add public link", &opOk, "document: %s", id)
+
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+
+	link := &models.DocumentLink{
+		DocumentId: id,
+		UserId:     ctx.UserId,
+		ExpiresAt:  time.UnixMilli(dto.ExpiresAt),
+	}
+	if link.HasExpired() {
+		e := errors.ErrInvalid
+		e.ErrMsg = "expires_at must be in the future"
+		return e
+	}
+	if link.ExpiresAt.After(time.Now().Add(models.DocumentLinkMaxDuration)) {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("link can be valid for at most %d days", models.DocumentLinkMaxDuration/(time.Hour*24))
+		return e
+	}
+	if dto.MaxDownloads > 0 {
+		link.MaxDownloads = &dto.MaxDownloads
+	}
+	if dto.Password != "" {
+		err = link.SetPassword(dto.Password)
+		if err != nil {
+			e := errors.ErrInvalid
+			e.ErrMsg = err.Error()
+			return e
+		}
+	}
+
+	token, err := link.Init()
+	if err != nil {
+		return err
+	}
+	err = a.db.DocumentStore.AddDocumentLink(link)
+	if err != nil {
+		return err
+	}
+	resp := linkToResp(link)
+	resp.Token = token
+	resp.Url = publicLinkUrl(token)
+	opOk = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) deleteDocumentLink(c echo.Context) error {
+	// swagger:route DELETE /api/v1/documents/{id}/links/{linkId} Documents DeleteDocumentLink
+	// Revoke public link
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	linkId, err := bindPathInt(c, "linkId")
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "revoke public link", &opOk, "document: %s, link: %d", id, linkId)
+
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	err = a.db.DocumentStore.DeleteDocumentLink(id, linkId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// swagger:model DocumentLinkAccess
+type DocumentLinkAccessResponse struct {
+	Action    string `json:"action"`
+	Success   bool   `json:"success"`
+	IpAddr    string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (a *Api) getDocumentLinkAccess(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/links/{linkId}/access Documents GetDocumentLinkAccess
+	// Get access log of public link
+	// responses:
+	//   200: DocumentLinkAccess
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	linkId, err := bindPathInt(c, "linkId")
+	if err != nil {
+		return err
+	}
+	paging, err := bindPaging(c)
+	if err != nil {
+		return err
+	}
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	access, err := a.db.DocumentStore.GetDocumentLinkAccess(id, linkId, paging)
+	if err != nil {
+		return err
+	}
+	resp := make([]DocumentLinkAccessResponse, len(*access))
+	for i, v := range *access {
+		resp[i] = DocumentLinkAccessResponse{
+			Action:    v.Action,
+			Success:   v.Success,
+			IpAddr:    v.IpAddr,
+			UserAgent: v.UserAgent,
+			CreatedAt: v.CreatedAt.Unix() * 1000,
+		}
+	}
+	return resourceList(c, resp, len(resp))
+}
+
+// swagger:model PublicDocument
+type PublicDocumentResponse struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename"`
+	Mimetype    string `json:"mimetype"`
+	Size        int64  `json:"size"`
+	PrettySize  string `json:"pretty_size"`
+	Date        int64  `json:"date"`
+	ExpiresAt   int64  `json:"expires_at"`
+	PreviewUrl  string `json:"preview_url"`
+	DownloadUrl string `json:"download_url"`
+	// DownloadsLeft is null if downloads are not limited.
+	DownloadsLeft *int `json:"downloads_left"`
+}
+
+// PublicLinkRequest is the body of POST requests to a public link.
+// swagger:model PublicLinkRequest
+type PublicLinkRequest struct {
+	Password string `json:"password"`
+}
+
+// linkPassword returns the link password of the request, either from the header or from the POST body.
+// Form-encoded bodies are accepted so that a plain html form can download the file.
+func linkPassword(c echo.Context) string {
+	req := c.Request()
+	if password := req.Header.Get(LinkPasswordHeader); password != "" {
+		return password
+	}
+	if req.Method != http.MethodPost {
+		return ""
+	}
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+		return req.PostFormValue("password")
+	}
+	dto := &PublicLinkRequest{}
+	if err := json.NewDecoder(req.Body).Decode(dto); err != nil {
+		return ""
+	}
+	return dto.Password
+}
+
+// openPublicLink validates the link in the request and records the access.
+func (a *Api) openPublicLink(c echo.Context, action string) (*models.DocumentLink, *models.Document, error) {
+	token := c.Param("token")
+	link, err := a.db.DocumentStore.GetDocumentLinkByToken(token)
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			logrus.WithFields(logrus.Fields{"module": "api", "action": action, "ip": c.RealIP()}).
+				Warningf("access to unknown public link")
+			e := errors.ErrRecordNotFound
+			e.ErrMsg = "link not found"
+			return nil, nil, e
+		}
+		return nil, nil, err
+	}
+
+	password := linkPassword(c)
+
+	var doc *models.Document
+	if link.HasExpired() {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "link not found"
+		err = e
+	}
+	if err == nil && !link.PasswordMatches(password) {
+		e := errors.ErrForbidden
+		e.ErrMsg = "invalid password"
+		if password == "" {
+			e.ErrMsg = "password required"
+		}
+		err = e
+	}
+	if err == nil {
+		doc, err = a.db.DocumentStore.GetDocument(0, link.DocumentId)
+		if err == nil && doc.DeletedAt.Valid {
+			e := errors.ErrRecordNotFound
+			e.ErrMsg = "link not found"
+			err = e
+		}
+	}
+	if err == nil && action == models.LinkAccessDownload {
+		ok, countErr := a.db.DocumentStore.CountLinkDownload(link.Id)
+		if countErr != nil {
+			err = countErr
+		} else if !ok {
+			e := errors.ErrForbidden
+			e.ErrMsg = "download limit reached"
+			err = e
+		} else {
+			link.DownloadCount += 1
+		}
+	}
+
+	access := &models.DocumentLinkAccess{
+		LinkId:    link.Id,
+		Action:    action,
+		Success:   err == nil,
+		IpAddr:    c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	logrus.WithFields(logrus.Fields{
+		"module":   "api",
+		"document": link.DocumentId,
+		"link":     link.Id,
+		"action":   action,
+		"ip":       access.IpAddr,
+		"success":  access.Success,
+	}).Infof("access public link")
+	if recordErr := a.db.DocumentStore.AddDocumentLinkAccess(access); recordErr != nil {
+		logrus.Errorf("record public link access: %v", recordErr)
+	}
+	return link, doc, err
+}
+
+func (a *Api) getPublicLink(c echo.Context) error {
+	// swagger:route GET /api/v1/public/links/{token} Public GetPublicLink
+	// Get document of public link. Password of a protected link is given with header X-Link-Password
+	// or in the body of a POST request to the same url.
+	// responses:
+	//   200: PublicDocument
+	link, doc, err := a.openPublicLink(c, models.LinkAccessView)
+	if err != nil {
+		return err
+	}
+	url := publicLinkUrl(c.Param("token"))
+	resp := PublicDocumentResponse{
+		Name:        doc.Name,
+		Filename:    doc.Filename,
+		Mimetype:    doc.Mimetype,
+		Size:        doc.Size,
+		PrettySize:  doc.GetSize(),
+		Date:        doc.Date.Unix() * 1000,
+		ExpiresAt:   link.ExpiresAt.Unix() * 1000,
+		PreviewUrl:  url + "/preview",
+		DownloadUrl: url + "/download",
+	}
+	if link.MaxDownloads != nil {
+		left := *link.MaxDownloads - link.DownloadCount
+		resp.DownloadsLeft = &left
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) getPublicLinkPreview(c echo.Context) error {
+	// swagger:route GET /api/v1/public/links/{token}/preview Public GetPublicLinkPreview
+	// Get preview image of public link document. Accepts POST with the link password as well.
+	// responses:
+	_, doc, err := a.openPublicLink(c, models.LinkAccessPreview)
+	if err != nil {
+		return err
+	}
+	return sendDocumentPreview(c, doc)
+}
+
+func (a *Api) downloadPublicLink(c echo.Context) error {
+	// swagger:route GET /api/v1/public/links/{token}/download Public DownloadPublicLink
+	// Download document of public link. Accepts POST with the link password as well.
+	// responses:
+	_, doc, err := a.openPublicLink(c, models.LinkAccessDownload)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", doc.Filename))
+	return sendDocumentFile(c, doc)
+}
@@ -0,0 +1,171 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+const defaultImpersonationMinutes = 15
+
+// impersonationRoutes are the routes that do not modify data but use other methods than GET.
+// Impersonation sessions are read-only, all other requests that are not GET are rejected.
+var impersonationRoutes = map[string]bool{
+	http.MethodPost + " /api/v1/auth/logout":              true,
+	http.MethodPost + " /api/v1/documents/search/suggest": true,
+	http.MethodPost + " /api/v1/documents/search/facets":  true,
+	http.MethodPut + " /api/v1/processing/rules/:id/test": true,
+}
+
+func impersonationAllowed(method, path string) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+	return impersonationRoutes[method+" "+path]
+}
+
+// authorizeImpersonation checks that the administrator that started the impersonation session
+// can still use it and that the request does not modify any data. It returns the administrator.
+func (a *Api) authorizeImpersonation(c echo.Context, token *models.Token) (*models.User, error) {
+	authErr := errors.ErrUnauthorized
+	authErr.ErrMsg = "invalid token"
+
+	admin, err := a.db.UserStore.GetUser(*token.ImpersonatorId)
+	if err != nil || !admin.IsActive || !admin.IsAdmin {
+		return nil, authErr
+	}
+	if !impersonationAllowed(c.Request().Method, c.Path()) {
+		e := errors.ErrForbidden
+		e.ErrMsg = "impersonation session is read-only"
+		return nil, e
+	}
+	logrus.WithFields(logrus.Fields{
+		"module":       "api",
+		"impersonator": admin.Id,
+		"userid":       token.UserId,
+	}).Infof("impersonated request %s %s", c.Request().Method, c.Request().URL.Path)
+	return admin, nil
+}
+
+// swagger:model ImpersonateRequest
+type ImpersonateRequest struct {
+	// Minutes is the lifetime of the session, at most 60 minutes. Defaults to 15 minutes.
+	Minutes int `json:"minutes" valid:"range(0|60),optional"`
+}
+
+// swagger:model ImpersonateResponse
+type ImpersonateResponse struct {
+	UserId    int    `json:"user_id"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (a *Api) adminImpersonateUser(c echo.Context) error {
+	// swagger:route POST /api/v1/admin/users/{id}/impersonate Admin AdminImpersonateUser
+	// View as user
+	//
+	// Creates a read-only session to view the service as the user, e.g. to debug user's rules and search.
+	// The session expires after given minutes. Administrators cannot be impersonated.
+	// The session is visible in user's sessions, and user can revoke it.
+	// responses:
+	//   200: ImpersonateResponse
+	//   400: RespBadRequest
+	//   404: RespNotFound
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	dto := &ImpersonateRequest{}
+	err = unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	if dto.Minutes == 0 {
+		dto.Minutes = defaultImpersonationMinutes
+	}
+
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "impersonate", &opOk, "user: %d, minutes: %d", userId, dto.Minutes)
+
+	if userId == ctx.UserId {
+		e := errors.ErrInvalid
+		e.ErrMsg = "cannot impersonate yourself"
+		return e
+	}
+	user, err := a.db.UserStore.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if user.IsAdmin {
+		e := errors.ErrInvalid
+		e.ErrMsg = "cannot impersonate administrators"
+		return e
+	}
+	if !user.IsActive {
+		e := errors.ErrInvalid
+		e.ErrMsg = "cannot impersonate inactive user"
+		return e
+	}
+
+	adminId := ctx.UserId
+	authToken := &models.Token{
+		UserId:         user.Id,
+		Name:           fmt.Sprintf("Administrator %s", ctx.User.Name),
+		IpAddr:         c.RealIP(),
+		ExpiresAt:      time.Now().Add(time.Minute * time.Duration(dto.Minutes)),
+		LastSeen:       time.Now(),
+		ImpersonatorId: &adminId,
+	}
+	err = authToken.Init()
+	if err != nil {
+		return fmt.Errorf("init token: %v", err)
+	}
+	err = a.db.AuthStore.InsertToken(authToken)
+	if err != nil {
+		return fmt.Errorf("save auth token to database: %v", err)
+	}
+	token, err := newToken(strconv.Itoa(user.Id), authToken.Key, config.C.Api.Key)
+	if err != nil {
+		return fmt.Errorf("create token: %v", err)
+	}
+
+	opOk = true
+	a.audit(c, &models.AuditEvent{
+		Action:     models.AuditAdminImpersonate,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(user.Id),
+		Success:    true,
+		Message:    fmt.Sprintf("session %d expires at %s", authToken.Id, authToken.ExpiresAt.Format(time.RFC3339)),
+	})
+	return c.JSON(http.StatusOK, &ImpersonateResponse{
+		UserId:    user.Id,
+		Token:     token,
+		ExpiresAt: authToken.ExpiresAt.Unix() * 1000,
+	})
+}
@@ -0,0 +1,48 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_impersonationAllowed(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/api/v1/documents", true},
+		{http.MethodGet, "/api/v1/processing/rules/:id", true},
+		{http.MethodPut, "/api/v1/processing/rules/:id/test", true},
+		{http.MethodPost, "/api/v1/documents/search/suggest", true},
+		{http.MethodPost, "/api/v1/auth/logout", true},
+		{http.MethodPut, "/api/v1/processing/rules/:id", false},
+		{http.MethodPost, "/api/v1/documents", false},
+		{http.MethodDelete, "/api/v1/documents/:id", false},
+		{http.MethodPost, "/api/v1/auth/confirm", false},
+		{http.MethodPut, "/api/v1/preferences/user", false},
+	}
+	for _, tt := range tests {
+		if got := impersonationAllowed(tt.method, tt.path); got != tt.want {
+			t.Errorf("impersonationAllowed(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
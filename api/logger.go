@@ -47,10 +47,30 @@ func logCrudRule(userId int, action string, success *bool, fmt string, args ...i
 	logCrudOp("processing-rule", action, userId, success).Infof(fmt, args...)
 }
 
+func logCrudSavedSearch(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("saved-search", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudCollection(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("collection", action, userId, success).Infof(fmt, args...)
+}
+
 func logCrudAdminUsers(userId int, action string, success *bool, fmt string, args ...interface{}) {
 	logCrudOp("admin-users", action, userId, success).Infof(fmt, args...)
 }
 
+func logCrudApiToken(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("api-token", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudSession(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("session", action, userId, success).Infof(fmt, args...)
+}
+
+func logCrudTwoFactor(userId int, action string, success *bool, fmt string, args ...interface{}) {
+	logCrudOp("two-factor", action, userId, success).Infof(fmt, args...)
+}
+
 func loggingMiddlware() echo.MiddlewareFunc {
 	var logger *logrus.Logger
 
@@ -21,8 +21,10 @@ package api
 import (
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
 	"time"
 
+	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/storage"
@@ -36,6 +38,10 @@ type MetadataRequest struct {
 type MetadataKeyRequest struct {
 	Key     string `json:"key" valid:"required,metadata,stringlength(1|30)"`
 	Comment string `json:"comment" valid:"maxstringlength(1000),optional"`
+	// SingleValue keeps only the best matching value when matching values automatically.
+	SingleValue bool `json:"single_value" valid:"-"`
+	// ValueType is one of text, number, date, money or boolean. Defaults to text.
+	ValueType string `json:"value_type" valid:"in(text|number|date|money|boolean),optional"`
 }
 
 type MetadataValueRequest struct {
@@ -48,6 +54,8 @@ type MetadataValueRequest struct {
 	// validate MatchType when creating, allowing default to be empty string
 	MatchType   string `json:"match_type" valid:"in(regex|exact),optional"`
 	MatchFilter string `json:"match_filter" valid:"maxstringlength(100),optional"`
+	// ParentId is an optional parent value of the same key.
+	ParentId int `json:"parent_id" valid:"-"`
 }
 
 type MetadataUpdateRequest struct {
@@ -186,15 +194,59 @@ func (a *Api) getMetadataKeyValues(c echo.Context) error {
 		caseInsensitive = sort[0].CaseInsensitive
 	}
 
+	tree := c.QueryParam("tree") == "1"
+	if tree {
+		// tree needs all values, else values would be missing from their parents
+		paging = storage.Paging{Offset: 0, Limit: config.MaxRows}
+	}
+
 	keys, err := a.db.MetadataStore.GetValues(ctx.UserId, key,
 		storage.NewSortKey(sortfield, "value", sortOrder, caseInsensitive), paging)
 	if err != nil {
 		return err
 	}
 
+	if tree {
+		roots := models.MetadataValueTree(*keys)
+		return resourceList(c, roots, len(roots))
+	}
 	return resourceList(c, keys, len(*keys))
 }
 
+// validateMetadataParent checks that parent value belongs to the key and that the value is not set under itself.
+func (a *Api) validateMetadataParent(userId, keyId, valueId, parentId int) error {
+	if parentId == 0 {
+		return nil
+	}
+	e := errors.ErrInvalid
+	if parentId == valueId {
+		e.ErrMsg = "value cannot be its own parent"
+		return e
+	}
+	ok, err := a.db.MetadataStore.UserHasKeyValue(userId, keyId, parentId)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		e.ErrMsg = "parent value must exist under the same key"
+		return e
+	}
+	if valueId == 0 {
+		return nil
+	}
+	descendants, err := a.db.MetadataStore.GetValueDescendants(userId, valueId)
+	if err != nil {
+		return err
+	}
+	for _, v := range descendants {
+		if v == parentId {
+			e.ErrMsg = "value cannot be moved under its own child"
+			return e
+		}
+	}
+	return nil
+}
+
 func (a *Api) updateDocumentMetadata(c echo.Context) error {
 	// swagger:route POST /api/v1/documents/{id}/metadata Documents UpdateDocumentMetadata
 	// Update document metadata
@@ -238,10 +290,12 @@ func (a *Api) addMetadataKey(c echo.Context) error {
 	}
 
 	key := &models.MetadataKey{
-		UserId:    ctx.UserId,
-		Key:       dto.Key,
-		CreatedAt: time.Now(),
-		Comment:   dto.Comment,
+		UserId:      ctx.UserId,
+		Key:         dto.Key,
+		CreatedAt:   time.Now(),
+		Comment:     dto.Comment,
+		SingleValue: dto.SingleValue,
+		ValueType:   models.MetadataValueType(dto.ValueType),
 	}
 
 	err = a.db.MetadataStore.CreateKey(ctx.UserId, key)
@@ -274,6 +328,19 @@ func (a *Api) addMetadataValue(c echo.Context) error {
 	opOk := false
 	defer logCrudMetadata(ctx.UserId, "add value", &opOk, "key: %d", keyId)
 
+	key, err := a.db.MetadataStore.GetKey(ctx.UserId, keyId)
+	if err != nil {
+		return err
+	}
+	dto.Value, err = key.ValueType.NormalizeValue(dto.Value)
+	if err != nil {
+		return err
+	}
+	err = a.validateMetadataParent(ctx.UserId, keyId, 0, dto.ParentId)
+	if err != nil {
+		return err
+	}
+
 	value := &models.MetadataValue{
 		UserId:         ctx.UserId,
 		KeyId:          keyId,
@@ -283,6 +350,7 @@ func (a *Api) addMetadataValue(c echo.Context) error {
 		MatchDocuments: dto.MatchDocuments,
 		MatchType:      models.MetadataRuleType(dto.MatchType),
 		MatchFilter:    dto.MatchFilter,
+		ParentId:       models.IntId(dto.ParentId),
 	}
 
 	if value.MatchType == "" {
@@ -328,6 +396,15 @@ func (a *Api) updateMetadataValue(c echo.Context) error {
 	opOk := false
 	defer logCrudMetadata(ctx.UserId, "update value", &opOk, "key: %d, value: %d", keyId, valueId)
 
+	key, err := a.db.MetadataStore.GetKey(ctx.UserId, keyId)
+	if err != nil {
+		return err
+	}
+	dto.Value, err = key.ValueType.NormalizeValue(dto.Value)
+	if err != nil {
+		return err
+	}
+
 	value := &models.MetadataValue{
 		Id:             valueId,
 		UserId:         ctx.UserId,
@@ -337,6 +414,7 @@ func (a *Api) updateMetadataValue(c echo.Context) error {
 		MatchDocuments: dto.MatchDocuments,
 		MatchType:      models.MetadataRuleType(dto.MatchType),
 		MatchFilter:    dto.MatchFilter,
+		ParentId:       models.IntId(dto.ParentId),
 	}
 	ownerShip, err := a.db.MetadataStore.UserHasKeyValue(ctx.UserId, keyId, valueId)
 	if err != nil {
@@ -347,6 +425,10 @@ func (a *Api) updateMetadataValue(c echo.Context) error {
 		err := errors.ErrRecordNotFound
 		return err
 	}
+	err = a.validateMetadataParent(ctx.UserId, keyId, valueId, dto.ParentId)
+	if err != nil {
+		return err
+	}
 
 	// rest should be enclodes in a transaction
 	err = a.db.MetadataStore.UpdateValue(value)
@@ -354,13 +436,19 @@ func (a *Api) updateMetadataValue(c echo.Context) error {
 		return err
 	}
 
-	err = a.db.JobStore.AddDocumentsByMetadata(ctx.UserId, keyId, valueId, models.ProcessFts)
+	// paths of all values under the value change as well
+	descendants, err := a.db.MetadataStore.GetValueDescendants(ctx.UserId, valueId)
 	if err != nil {
 		return err
-	} else {
-		opOk = true
-		return resourceList(c, value, 1)
 	}
+	for _, v := range append([]int{valueId}, descendants...) {
+		err = a.db.JobStore.AddDocumentsByMetadata(ctx.UserId, keyId, v, models.ProcessFts)
+		if err != nil {
+			return err
+		}
+	}
+	opOk = true
+	return resourceList(c, value, 1)
 }
 
 func (a *Api) updateMetadataKey(c echo.Context) error {
@@ -396,10 +484,24 @@ func (a *Api) updateMetadataKey(c echo.Context) error {
 	}
 
 	key := &models.MetadataKey{
-		Id:      keyId,
-		UserId:  ctx.UserId,
-		Key:     dto.Key,
-		Comment: dto.Comment,
+		Id:          keyId,
+		UserId:      ctx.UserId,
+		Key:         dto.Key,
+		Comment:     dto.Comment,
+		SingleValue: dto.SingleValue,
+		ValueType:   models.MetadataValueType(dto.ValueType),
+	}
+	if key.ValueType.Typed() {
+		// existing values must be valid for the new type
+		values, err := a.db.MetadataStore.GetKeyValueNames(ctx.UserId, keyId)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if _, err := key.ValueType.NormalizeValue(v); err != nil {
+				return err
+			}
+		}
 	}
 
 	// rest should be enclosed in a transaction
@@ -440,6 +542,10 @@ func (a *Api) deleteMetadataKey(c echo.Context) error {
 		err := errors.ErrRecordNotFound
 		return err
 	}
+	key, err := a.db.MetadataStore.GetKey(ctx.UserId, keyId)
+	if err != nil {
+		return err
+	}
 
 	// need to add processing when the metadata still exists
 	err = a.db.JobStore.AddDocumentsByMetadata(ctx.UserId, keyId, 0, models.ProcessFts)
@@ -454,6 +560,12 @@ func (a *Api) deleteMetadataKey(c echo.Context) error {
 
 	a.process.PullDocumentsToProcess()
 	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditMetadataKeyDelete,
+		Resource:   "metadata_key",
+		ResourceId: strconv.Itoa(keyId),
+		Success:    true,
+	}, key, nil)
 	return c.String(http.StatusOK, "ok")
 }
 
@@ -486,12 +598,23 @@ func (a *Api) deleteMetadataValue(c echo.Context) error {
 		err := errors.ErrRecordNotFound
 		return err
 	}
+	value, err := a.db.MetadataStore.GetValue(ctx.UserId, keyId, valueId)
+	if err != nil {
+		return err
+	}
 
-	// need to add processing when the metadata still exists
-	err = a.db.JobStore.AddDocumentsByMetadata(ctx.UserId, keyId, valueId, models.ProcessFts)
+	// need to add processing when the metadata still exists.
+	// Children of the value are detached from it, which changes their paths too.
+	descendants, err := a.db.MetadataStore.GetValueDescendants(ctx.UserId, valueId)
 	if err != nil {
 		return err
 	}
+	for _, v := range append([]int{valueId}, descendants...) {
+		err = a.db.JobStore.AddDocumentsByMetadata(ctx.UserId, keyId, v, models.ProcessFts)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = a.db.MetadataStore.DeleteValue(ctx.UserId, valueId)
 	if err != nil {
@@ -499,6 +622,12 @@ func (a *Api) deleteMetadataValue(c echo.Context) error {
 	}
 	a.process.PullDocumentsToProcess()
 	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditMetadataValueDelete,
+		Resource:   "metadata_value",
+		ResourceId: strconv.Itoa(valueId),
+		Success:    true,
+	}, value, nil)
 	return c.String(http.StatusOK, "ok")
 }
 
@@ -0,0 +1,379 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo/v4"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/oidc"
+)
+
+// passwordLoginEnabled returns error if users can only log in with single sign-on.
+func passwordLoginEnabled() error {
+	if config.C.Oidc.PasswordLoginEnabled() {
+		return nil
+	}
+	e := errors.ErrForbidden
+	e.ErrMsg = "password login is disabled"
+	return e
+}
+
+// checkUserLimit returns error if no more users can be created.
+func (a *Api) checkUserLimit() error {
+	if config.C.Api.MaxUsers <= 0 {
+		return nil
+	}
+	count, err := a.db.UserStore.CountUsers()
+	if err != nil {
+		return err
+	}
+	if count >= config.C.Api.MaxUsers {
+		e := errors.ErrForbidden
+		e.ErrMsg = fmt.Sprintf("maximum number of users (%d) reached", config.C.Api.MaxUsers)
+		return e
+	}
+	return nil
+}
+
+// swagger:model AuthMethods
+type AuthMethodsResponse struct {
+	Password bool   `json:"password"`
+	Oidc     bool   `json:"oidc"`
+	OidcName string `json:"oidc_name"`
+}
+
+// swagger:model OidcLogin
+type OidcLoginResponse struct {
+	// Url to redirect user to.
+	Url string `json:"url"`
+}
+
+// swagger:model OidcCallbackRequest
+type OidcCallbackRequest struct {
+	Code  string `json:"code" valid:"required"`
+	State string `json:"state" valid:"required"`
+}
+
+// swagger:model OidcCallbackResponse
+type OidcCallbackResponse struct {
+	UserId int
+	Token  string
+	// TwoFactorToken is set instead of Token if user has enabled two-factor authentication.
+	// Login is finished by sending it with the code to /api/v1/auth/oidc/2fa.
+	TwoFactorToken string `json:"two_factor_token,omitempty"`
+}
+
+// swagger:model OidcTwoFactorRequest
+type OidcTwoFactorRequest struct {
+	Token string `json:"token" valid:"required"`
+	// Code is either the one-time password or a recovery code.
+	Code string `json:"code" valid:"required"`
+}
+
+// oidcCookie binds single sign-on to the browser that started it.
+const oidcCookie = "virtualpaper_oidc"
+
+// oidcCookiePath limits the cookie to single sign-on endpoints.
+const oidcCookiePath = "/api/v1/auth/oidc"
+
+// oidcTwoFactorTimeout is the time user has to enter the two-factor code after single sign-on.
+const oidcTwoFactorTimeout = time.Minute * 5
+
+// oidcTwoFactorAttempts is the number of codes user can try before single sign-on needs to be started again.
+const oidcTwoFactorAttempts = 5
+
+// oidcTwoFactorLogin is a single sign-on that is waiting for two-factor authentication.
+type oidcTwoFactorLogin struct {
+	// lock serializes attempts of the login.
+	lock     sync.Mutex
+	userId   int
+	userName string
+	attempts int
+	done     bool
+}
+
+func (a *Api) getAuthMethods(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/methods Authentication GetAuthMethods
+	// Get enabled login methods
+	// responses:
+	//   200: AuthMethods
+	resp := &AuthMethodsResponse{
+		Password: config.C.Oidc.PasswordLoginEnabled(),
+		Oidc:     a.oidc != nil,
+	}
+	if a.oidc != nil {
+		resp.OidcName = config.C.Oidc.Name
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) oidcEnabled() error {
+	if a.oidc != nil {
+		return nil
+	}
+	e := errors.ErrRecordNotFound
+	e.ErrMsg = "single sign-on is not enabled"
+	return e
+}
+
+func (a *Api) startOidcLogin(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/oidc/login Authentication StartOidcLogin
+	// Start single sign-on. Frontend redirects user to the returned url, and identity provider
+	// redirects user back to the configured redirect url with code and state.
+	// responses:
+	//   200: OidcLogin
+	if err := a.oidcEnabled(); err != nil {
+		return err
+	}
+	url, binding, err := a.oidc.StartLogin()
+	if err != nil {
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcCookie,
+		Value:    binding,
+		Path:     oidcCookiePath,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.JSON(http.StatusOK, &OidcLoginResponse{Url: url})
+}
+
+func (a *Api) finishOidcLogin(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/oidc/callback Authentication FinishOidcLogin
+	// Finish single sign-on and log in. If user has enabled two-factor authentication,
+	// login is finished with FinishOidcTwoFactor.
+	// responses:
+	//   200: OidcCallbackResponse
+	if err := a.oidcEnabled(); err != nil {
+		return err
+	}
+	dto := &OidcCallbackRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	binding := ""
+	if cookie, err := c.Cookie(oidcCookie); err == nil {
+		binding = cookie.Value
+	}
+	c.SetCookie(&http.Cookie{Name: oidcCookie, Path: oidcCookiePath, MaxAge: -1, HttpOnly: true})
+
+	remoteAddr := getRemoteAddr(c.Request())
+	claims, err := a.oidc.FinishLogin(dto.Code, dto.State, binding)
+	if err != nil {
+		logrus.Infof("Failed single sign-on from remote %s: %v", remoteAddr, err)
+		return err
+	}
+
+	user, err := a.getOidcUser(claims)
+	if err != nil {
+		logrus.Infof("Failed single sign-on for subject %s from remote %s: %v", claims.String("sub"), remoteAddr, err)
+		a.auditAuth(c, models.AuditLogin, 0, claims.String("sub"), false, "single sign-on: "+err.Error())
+		return err
+	}
+	if !user.IsActive {
+		logrus.Infof("Single sign-on for inactive user %d from remote %s", user.Id, remoteAddr)
+		a.auditAuth(c, models.AuditLogin, user.Id, user.Name, false, "single sign-on: user is not active")
+		return echo.ErrUnauthorized
+	}
+
+	totp, err := a.getEnabledTotp(user.Id)
+	if err != nil {
+		return err
+	}
+	if totp != nil {
+		token, err := config.RandomStringCrypt(40)
+		if err != nil {
+			return fmt.Errorf("generate two-factor token: %v", err)
+		}
+		a.oidcTwoFactor.Set(token, &oidcTwoFactorLogin{userId: user.Id, userName: user.Name}, cache.DefaultExpiration)
+		return c.JSON(http.StatusOK, &OidcCallbackResponse{UserId: user.Id, TwoFactorToken: token})
+	}
+	return a.finishOidcSession(c, user.Id, user.Name)
+}
+
+func (a *Api) finishOidcTwoFactor(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/oidc/2fa Authentication FinishOidcTwoFactor
+	// Finish single sign-on with two-factor authentication code
+	// responses:
+	//   200: OidcCallbackResponse
+	if err := a.oidcEnabled(); err != nil {
+		return err
+	}
+	dto := &OidcTwoFactorRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	e := errors.ErrUnauthorized
+	e.ErrMsg = "login has expired or is invalid, please try again"
+	value, found := a.oidcTwoFactor.Get(dto.Token)
+	if !found {
+		return e
+	}
+	login := value.(*oidcTwoFactorLogin)
+	login.lock.Lock()
+	defer login.lock.Unlock()
+	if login.done || login.attempts >= oidcTwoFactorAttempts {
+		return e
+	}
+	err = a.checkSecondFactor(login.userId, dto.Code, errors.ErrUnauthorized)
+	if err != nil {
+		logrus.Infof("Failed two-factor authentication for user %s from remote %s",
+			login.userName, getRemoteAddr(c.Request()))
+		a.auditAuth(c, models.AuditLogin, login.userId, login.userName, false, "single sign-on: invalid two-factor code")
+		login.attempts += 1
+		if login.attempts >= oidcTwoFactorAttempts {
+			a.oidcTwoFactor.Delete(dto.Token)
+		}
+		return err
+	}
+	login.done = true
+	a.oidcTwoFactor.Delete(dto.Token)
+	return a.finishOidcSession(c, login.userId, login.userName)
+}
+
+// finishOidcSession creates the session for user that has logged in with single sign-on.
+func (a *Api) finishOidcSession(c echo.Context, userId int, userName string) error {
+	c.Logger().Infof("User %d '%s' logged in with single sign-on from %s", userId, userName, getRemoteAddr(c.Request()))
+	token, err := a.createLoginSession(c, userId)
+	if err != nil {
+		return err
+	}
+	a.auditAuth(c, models.AuditLogin, userId, userName, true, "single sign-on")
+	return c.JSON(http.StatusOK, &OidcCallbackResponse{UserId: userId, Token: token})
+}
+
+// getOidcUser returns the user that the identity belongs to. On first login a new user is created for the identity,
+// if allowed. Identity is never linked to an existing user with the same username, since the identity provider
+// does not prove the ownership of that user. User's email, if verified, and administrator privileges
+// are synchronized from the claims.
+func (a *Api) getOidcUser(claims oidc.Claims) (*models.User, error) {
+	conf := &config.C.Oidc
+	subject := claims.String("sub")
+	userName := claims.String(conf.UsernameClaim)
+
+	var user *models.User
+	userId, err := a.db.UserStore.GetUserIdByIdentity(conf.Issuer, subject)
+	if err == nil {
+		user, err = a.db.UserStore.GetUser(userId)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, errors.ErrRecordNotFound) {
+		if userName == "" {
+			e := errors.ErrUnauthorized
+			e.ErrMsg = fmt.Sprintf("identity provider did not return claim '%s'", conf.UsernameClaim)
+			return nil, e
+		}
+		_, err = a.db.UserStore.GetUserByName(userName)
+		if err == nil {
+			e := errors.ErrUnauthorized
+			e.ErrMsg = fmt.Sprintf("user '%s' already exists and is not linked to the identity provider", userName)
+			return nil, e
+		} else if !errors.Is(err, errors.ErrRecordNotFound) {
+			return nil, err
+		}
+		user, err = a.provisionOidcUser(userName)
+		if err != nil {
+			return nil, err
+		}
+		err = a.db.UserStore.AddUserIdentity(user.Id, conf.Issuer, subject)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Linked identity %s to user %d", subject, user.Id)
+	} else {
+		return nil, err
+	}
+
+	changed := false
+	email := claims.String(conf.EmailClaim)
+	if email != "" && email != user.Email && claims.Bool("email_verified") {
+		user.Email = email
+		changed = true
+	}
+	if conf.AdminGroup != "" {
+		isAdmin := false
+		for _, v := range claims.Strings(conf.GroupsClaim) {
+			if v == conf.AdminGroup {
+				isAdmin = true
+			}
+		}
+		if isAdmin != user.IsAdmin {
+			logrus.Infof("Set user %d administrator: %t from identity provider groups", user.Id, isAdmin)
+			user.IsAdmin = isAdmin
+			changed = true
+		}
+	}
+	if changed {
+		user.Update()
+		err = a.db.UserStore.Update(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// provisionOidcUser creates a new user for single sign-on. The user does not have a password.
+func (a *Api) provisionOidcUser(userName string) (*models.User, error) {
+	if !config.C.Oidc.AutoCreateUsers {
+		e := errors.ErrUnauthorized
+		e.ErrMsg = "user does not exist"
+		return nil, e
+	}
+	if !govalidator.TagMap["username"](userName) {
+		e := errors.ErrUnauthorized
+		e.ErrMsg = fmt.Sprintf("username '%s' from identity provider is not a valid username", userName)
+		return nil, e
+	}
+	if err := a.checkUserLimit(); err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Name:     userName,
+		IsActive: true,
+	}
+	user.CreatedAt = time.Now()
+	user.Update()
+	err := a.db.UserStore.AddUser(user)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Created user %d (%s) on first single sign-on", user.Id, user.Name)
+
+	err = a.search.AddUserIndex(user.Id)
+	if err != nil {
+		logrus.Errorf("add search index for user %d: %v", user.Id, err)
+	}
+	return user, nil
+}
@@ -19,6 +19,7 @@
 package api
 
 import (
+	"github.com/labstack/echo/v4"
 	"golang.org/x/time/rate"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
@@ -38,6 +39,18 @@ func (api *Api) addRoutesV2() {
 	api.publicRouter.GET("/api/v1/swagger.json", serverSwaggerDoc)
 	api.publicRouter.GET("/api/v1/version", api.getVersionV2)
 
+	// public links allow access to single document without authentication.
+	publicLinkMiddleware := []echo.MiddlewareFunc{}
+	if !config.C.Api.AuthRatelimitDisabled {
+		publicLinkMiddleware = append(publicLinkMiddleware, newRateLimiter(rate.Every(time.Second*2), 30, time.Minute*15))
+	}
+	api.publicRouter.GET("/api/v1/public/links/:token", api.getPublicLink, publicLinkMiddleware...)
+	api.publicRouter.GET("/api/v1/public/links/:token/preview", api.getPublicLinkPreview, publicLinkMiddleware...)
+	api.publicRouter.GET("/api/v1/public/links/:token/download", api.downloadPublicLink, publicLinkMiddleware...)
+	api.publicRouter.POST("/api/v1/public/links/:token", api.getPublicLink, publicLinkMiddleware...)
+	api.publicRouter.POST("/api/v1/public/links/:token/preview", api.getPublicLinkPreview, publicLinkMiddleware...)
+	api.publicRouter.POST("/api/v1/public/links/:token/download", api.downloadPublicLink, publicLinkMiddleware...)
+
 	// allow one auth operation per minute for past 15 minutes, with burst of 15 requests.
 
 	authGroup := api.apiRouter.Group("/v1/auth")
@@ -47,9 +60,25 @@ func (api *Api) addRoutesV2() {
 	}
 
 	authGroup.POST("/login", api.LoginV2)
+	api.publicRouter.GET("/api/v1/auth/methods", api.getAuthMethods)
+	authGroup.GET("/oidc/login", api.startOidcLogin)
+	authGroup.POST("/oidc/callback", api.finishOidcLogin)
+	authGroup.POST("/oidc/2fa", api.finishOidcTwoFactor)
 	api.privateRouter.POST("/auth/logout", api.Logout)
 	api.privateRouter.POST("/auth/confirm", api.ConfirmAuthentication)
+	api.privateRouter.GET("/auth/tokens", api.getApiTokens)
+	api.privateRouter.POST("/auth/tokens", api.addApiToken, api.ConfirmAuthorizedToken())
+	api.privateRouter.DELETE("/auth/tokens/:id", api.deleteApiToken)
+	api.privateRouter.GET("/auth/sessions", api.getSessions)
+	api.privateRouter.DELETE("/auth/sessions", api.revokeOtherSessions)
+	api.privateRouter.DELETE("/auth/sessions/:id", api.revokeSession)
+	api.privateRouter.GET("/auth/2fa", api.getTwoFactorStatus)
+	api.privateRouter.POST("/auth/2fa/setup", api.setupTwoFactor, api.ConfirmAuthorizedToken())
+	api.privateRouter.POST("/auth/2fa/enable", api.enableTwoFactor, api.ConfirmAuthorizedToken())
+	api.privateRouter.POST("/auth/2fa/recovery-codes", api.regenerateRecoveryCodes, api.ConfirmAuthorizedToken())
+	api.privateRouter.DELETE("/auth/2fa", api.disableTwoFactor, api.ConfirmAuthorizedToken())
 	authGroup.POST("/reset-password", api.ResetPassword)
+	authGroup.POST("/verify-email", api.VerifyEmail)
 	authGroup.POST("/forgot-password", api.CreateResetPasswordToken)
 
 	api.privateRouter.GET("/filetypes", api.getSupportedFileTypes)
@@ -61,6 +90,7 @@ func (api *Api) addRoutesV2() {
 
 	api.privateRouter.POST("/documents", api.uploadFile)
 	api.privateRouter.GET("/documents", api.getDocuments).Name = "get-documents"
+	api.privateRouter.GET("/documents/trash", api.getTrashedDocuments)
 	api.privateRouter.GET("/documents/:id", api.getDocument).Name = "get-document"
 	api.privateRouter.PUT("/documents/:id", api.updateDocument)
 	api.privateRouter.DELETE("/documents/:id", api.deleteDocument)
@@ -71,13 +101,35 @@ func (api *Api) addRoutesV2() {
 	api.privateRouter.GET("/documents/:id/linked-documents", api.getLinkedDocuments)
 	api.privateRouter.POST("/documents/:id/metadata", api.updateDocumentMetadata)
 	api.privateRouter.POST("/documents/:id/process", api.requestDocumentProcessing)
+	api.privateRouter.POST("/documents/:id/restore", api.restoreDocument)
 	api.privateRouter.PUT("/documents/:id/linked-documents", api.updateLinkedDocuments)
 	api.privateRouter.GET("/documents/:id/history", api.getDocumentHistory)
+	api.privateRouter.GET("/documents/:id/rules-trace", api.getDocumentRulesTrace)
 	api.privateRouter.GET("/documents/:id/jobs", api.getDocumentLogs)
+	api.privateRouter.GET("/documents/:id/shares", api.getDocumentShares)
+	api.privateRouter.POST("/documents/:id/shares", api.addDocumentShare)
+	api.privateRouter.DELETE("/documents/:id/shares/:shareId", api.deleteDocumentShare)
+	api.privateRouter.GET("/documents/:id/links", api.getDocumentLinks)
+	api.privateRouter.POST("/documents/:id/links", api.addDocumentLink)
+	api.privateRouter.DELETE("/documents/:id/links/:linkId", api.deleteDocumentLink)
+	api.privateRouter.GET("/documents/:id/links/:linkId/access", api.getDocumentLinkAccess)
+	api.privateRouter.GET("/groups", api.getGroups)
 
 	api.privateRouter.POST("/documents/bulkEdit", api.bulkEditDocuments)
 
 	api.privateRouter.POST("/documents/search/suggest", api.searchSuggestions).Name = "search-suggest"
+	api.privateRouter.POST("/documents/search/facets", api.searchFacets).Name = "search-facets"
+
+	api.privateRouter.GET("/searches", api.getSavedSearches)
+	api.privateRouter.POST("/searches", api.addSavedSearch)
+	api.privateRouter.GET("/searches/:id", api.getSavedSearch)
+	api.privateRouter.PUT("/searches/:id", api.updateSavedSearch)
+	api.privateRouter.DELETE("/searches/:id", api.deleteSavedSearch)
+	api.privateRouter.GET("/searches/:id/count", api.getSavedSearchCount)
+
+	api.privateRouter.GET("/collections", api.getCollections)
+	api.privateRouter.GET("/collections/:id", api.getCollection)
+	api.privateRouter.DELETE("/collections/:id", api.deleteCollection)
 
 	api.privateRouter.GET("/jobs", api.GetJob)
 	api.privateRouter.GET("/tags", api.getTags)
@@ -94,10 +146,16 @@ func (api *Api) addRoutesV2() {
 
 	api.privateRouter.GET("/processing/rules", api.getUserRules)
 	api.privateRouter.POST("/processing/rules", api.addUserRule)
+	api.privateRouter.GET("/processing/rules/export", api.exportUserRules)
+	api.privateRouter.POST("/processing/rules/import", api.importUserRules)
 	api.privateRouter.GET("/processing/rules/:id", api.getUserRule)
 	api.privateRouter.PUT("/processing/rules/:id", api.updateUserRule)
 	api.privateRouter.DELETE("/processing/rules/:id", api.deleteUserRule)
 	api.privateRouter.PUT("/processing/rules/:id/test", api.testRule)
+	api.privateRouter.GET("/processing/rules/:id/versions", api.getUserRuleVersions)
+	api.privateRouter.GET("/processing/rules/:id/versions/:version", api.getUserRuleVersion)
+	api.privateRouter.GET("/processing/rules/:id/versions/:version/diff", api.diffUserRuleVersions)
+	api.privateRouter.POST("/processing/rules/:id/versions/:version/restore", api.restoreUserRuleVersion)
 
 	api.privateRouter.GET("/preferences/user", api.getUserPreferences).Name = "get-user-preferences"
 	api.privateRouter.PUT("/preferences/user", api.updateUserPreferences)
@@ -106,4 +164,15 @@ func (api *Api) addRoutesV2() {
 	api.adminRouter.POST("/users", api.adminAddUser, api.ConfirmAuthorizedToken())
 	api.adminRouter.GET("/users/:id", api.adminGetUser)
 	api.adminRouter.PUT("/users/:id", api.adminUpdateUser, api.ConfirmAuthorizedToken())
+	api.adminRouter.DELETE("/users/:id", api.adminDeleteUser, api.ConfirmAuthorizedToken())
+	api.adminRouter.POST("/users/:id/impersonate", api.adminImpersonateUser, api.ConfirmAuthorizedToken())
+	api.adminRouter.GET("/users/:id/sessions", api.adminGetUserSessions)
+	api.adminRouter.DELETE("/users/:id/sessions", api.adminRevokeUserSessions, api.ConfirmAuthorizedToken())
+	api.adminRouter.DELETE("/users/:id/sessions/:sessionId", api.adminRevokeUserSession, api.ConfirmAuthorizedToken())
+	api.adminRouter.GET("/audit", api.adminGetAuditLog)
+	api.adminRouter.GET("/groups", api.adminGetGroups)
+	api.adminRouter.POST("/groups", api.adminAddGroup)
+	api.adminRouter.GET("/groups/:id", api.adminGetGroup)
+	api.adminRouter.PUT("/groups/:id", api.adminUpdateGroup)
+	api.adminRouter.DELETE("/groups/:id", api.adminDeleteGroup)
 }
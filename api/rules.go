@@ -19,12 +19,19 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"tryffel.net/go/virtualpaper/errors"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/process"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
 type Rule struct {
@@ -34,6 +41,7 @@ type Rule struct {
 	Enabled     bool   `json:"enabled" valid:"-"`
 	Order       int    `json:"order" valid:"-"`
 	Mode        string `json:"mode" valid:"-"`
+	Version     int    `json:"version" valid:"-"`
 	CreatedAt   int64  `json:"created_at" valid:"-"`
 	UpdatedAt   int64  `json:"updated_at" valid:"-"`
 
@@ -139,6 +147,7 @@ func ruleToResp(rule *models.Rule) *Rule {
 		Enabled:     rule.Enabled,
 		Order:       rule.Order,
 		Mode:        rule.Mode.String(),
+		Version:     rule.Version,
 		CreatedAt:   rule.CreatedAt.Unix() * 1000,
 		UpdatedAt:   rule.UpdatedAt.Unix() * 1000,
 	}
@@ -222,7 +231,9 @@ func (a *Api) addUserRule(c echo.Context) error {
 	}
 	ruleId = rule.Id
 	opOk = true
-	return c.JSON(http.StatusOK, ruleToResp(rule))
+	resp := ruleToResp(rule)
+	a.auditValues(c, ruleAuditEvent(models.AuditRuleAdd, rule.Id), nil, resp)
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (a *Api) getUserRules(c echo.Context) error {
@@ -292,12 +303,18 @@ func (a *Api) updateUserRule(c echo.Context) error {
 	}
 	rule.Id = id
 	rule.UserId = ctx.UserId
+	oldRule, err := a.db.RuleStore.GetUserRule(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
 	err = a.db.RuleStore.UpdateRule(ctx.UserId, rule)
 	if err != nil {
 		return err
 	}
 	opOk = true
-	return c.JSON(http.StatusOK, ruleToResp(rule))
+	resp := ruleToResp(rule)
+	a.auditValues(c, ruleAuditEvent(models.AuditRuleUpdate, id), ruleToResp(oldRule), resp)
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (a *Api) deleteUserRule(c echo.Context) error {
@@ -314,11 +331,16 @@ func (a *Api) deleteUserRule(c echo.Context) error {
 	opOk := false
 	defer logCrudRule(ctx.UserId, "update", &opOk, "rule: %d", id)
 
+	oldRule, err := a.db.RuleStore.GetUserRule(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
 	err = a.db.RuleStore.DeleteRule(ctx.UserId, id)
 	if err != nil {
 		return err
 	}
 	opOk = true
+	a.auditValues(c, ruleAuditEvent(models.AuditRuleDelete, id), ruleToResp(oldRule), nil)
 	return c.String(http.StatusOK, "")
 }
 
@@ -367,6 +389,11 @@ func (a *Api) testRule(c echo.Context) error {
 	logrus.Infof("User %d tests processing rule %d on document %s", ctx.UserId, id, processingRule.DocumentId)
 
 	processRule := process.NewDocumentRule(doc, rule)
+	locale, err := a.db.UserStore.GetPreferenceValue(ctx.UserId, storage.PreferenceDateLocale)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return err
+	}
+	processRule.DateLocale = process.DateLocale(locale)
 	status := processRule.MatchTest()
 
 	logrus.Infof("processing rule test finished: %v", status.Match)
@@ -375,3 +402,238 @@ func (a *Api) testRule(c echo.Context) error {
 	matched = status.Match
 	return c.JSON(http.StatusOK, status)
 }
+
+type RuleVersion struct {
+	Version   int    `json:"version"`
+	UserId    int    `json:"user_id"`
+	User      string `json:"user"`
+	CreatedAt int64  `json:"created_at"`
+	Rule      *Rule  `json:"rule"`
+}
+
+func ruleVersionToResp(version *models.RuleVersion) *RuleVersion {
+	rule := models.Rule(version.Content)
+	return &RuleVersion{
+		Version:   version.Version,
+		UserId:    version.UserId,
+		User:      version.User,
+		CreatedAt: version.CreatedAt.Unix() * 1000,
+		Rule:      ruleToResp(&rule),
+	}
+}
+
+func (a *Api) getUserRuleVersions(c echo.Context) error {
+	// swagger:route GET /api/v1/processing/rules/{id}/versions Processing GetRuleVersions
+	// Get all versions of the rule, newest first
+	// responses:
+	//   200: RuleVersion
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	versions, err := a.db.RuleStore.GetRuleVersions(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	resp := make([]*RuleVersion, len(*versions))
+	for i := range *versions {
+		resp[i] = ruleVersionToResp(&(*versions)[i])
+	}
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) getUserRuleVersion(c echo.Context) error {
+	// swagger:route GET /api/v1/processing/rules/{id}/versions/{version} Processing GetRuleVersion
+	// Get rule version
+	// responses:
+	//   200: RuleVersion
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	version, err := bindPathInt(c, "version")
+	if err != nil {
+		return err
+	}
+
+	ruleVersion, err := a.db.RuleStore.GetRuleVersion(ctx.UserId, id, version)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ruleVersionToResp(ruleVersion))
+}
+
+func (a *Api) diffUserRuleVersions(c echo.Context) error {
+	// swagger:route GET /api/v1/processing/rules/{id}/versions/{version}/diff Processing DiffRuleVersions
+	// Get changes from rule version to another version. Query parameter 'to' defaults to current version.
+	// responses:
+	//   200: RuleChange
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	version, err := bindPathInt(c, "version")
+	if err != nil {
+		return err
+	}
+
+	from, err := a.db.RuleStore.GetRuleVersion(ctx.UserId, id, version)
+	if err != nil {
+		return err
+	}
+
+	var to *models.Rule
+	if toParam := c.QueryParam("to"); toParam != "" {
+		toVersion, err := strconv.Atoi(toParam)
+		if err != nil {
+			e := errors.ErrInvalid
+			e.ErrMsg = "query parameter 'to' must be a number"
+			return e
+		}
+		toRuleVersion, err := a.db.RuleStore.GetRuleVersion(ctx.UserId, id, toVersion)
+		if err != nil {
+			return err
+		}
+		rule := models.Rule(toRuleVersion.Content)
+		to = &rule
+	} else {
+		to, err = a.db.RuleStore.GetUserRule(ctx.UserId, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	fromRule := models.Rule(from.Content)
+	changes := fromRule.Diff(to)
+	return resourceList(c, changes, len(changes))
+}
+
+func (a *Api) restoreUserRuleVersion(c echo.Context) error {
+	// swagger:route POST /api/v1/processing/rules/{id}/versions/{version}/restore Processing RestoreRuleVersion
+	// Restore rule contents to given version. This creates a new version of the rule.
+	// responses:
+	//   200: ProcessingRuleResponse
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	version, err := bindPathInt(c, "version")
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudRule(ctx.UserId, "restore", &opOk, "rule: %d, version: %d", id, version)
+
+	oldRule, err := a.db.RuleStore.GetUserRule(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	rule, err := a.db.RuleStore.RestoreRuleVersion(ctx.UserId, id, version)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := ruleToResp(rule)
+	event := ruleAuditEvent(models.AuditRuleRestore, id)
+	event.Message = fmt.Sprintf("restore version %d", version)
+	a.auditValues(c, event, ruleToResp(oldRule), resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) exportUserRules(c echo.Context) error {
+	// swagger:route GET /api/v1/processing/rules/export Processing ExportRules
+	// Export all rules in portable format. Metadata is referenced by names.
+	// Use query parameter format=yaml to get yaml instead of json.
+	// responses:
+	//   200: RulesExport
+	ctx := c.(UserContext)
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "yaml" {
+		e := errors.ErrInvalid
+		e.ErrMsg = "query parameter 'format' must be either json or yaml"
+		return e
+	}
+
+	opOk := false
+	defer logCrudRule(ctx.UserId, "export", &opOk, "format: %s", format)
+
+	export, err := a.db.RuleStore.ExportRules(ctx.UserId)
+	if err != nil {
+		return err
+	}
+
+	opOk = true
+	if format == "yaml" {
+		data, err := yaml.Marshal(export)
+		if err != nil {
+			return fmt.Errorf("marshal yaml: %v", err)
+		}
+		return c.Blob(http.StatusOK, "application/yaml", data)
+	}
+	return c.JSON(http.StatusOK, export)
+}
+
+func (a *Api) importUserRules(c echo.Context) error {
+	// swagger:route POST /api/v1/processing/rules/import Processing ImportRules
+	// Import rules from portable format. Body can be either json or yaml (content-type application/yaml).
+	// Query parameter create_metadata=1 creates missing metadata keys and values,
+	// on_conflict (skip, overwrite, fail) defines what to do with rules that have existing name.
+	// responses:
+	//   200: RuleImportResult
+	//   400: RespBadRequest
+	ctx := c.(UserContext)
+
+	opts := models.RuleImportOptions{
+		CreateMissingMetadata: c.QueryParam("create_metadata") == "1",
+		OnConflict:            models.RuleConflictMode(c.QueryParam("on_conflict")),
+	}
+	switch opts.OnConflict {
+	case "", models.RuleConflictSkip, models.RuleConflictOverwrite, models.RuleConflictFail:
+	default:
+		e := errors.ErrInvalid
+		e.ErrMsg = "query parameter 'on_conflict' must be one of skip, overwrite, fail"
+		return e
+	}
+
+	export := &models.RulesExport{}
+	var err error
+	contentType := c.Request().Header.Get("Content-Type")
+	if strings.Contains(contentType, "yaml") {
+		err = yaml.NewDecoder(c.Request().Body).Decode(export)
+	} else {
+		err = json.NewDecoder(c.Request().Body).Decode(export)
+	}
+	if err != nil {
+		logrus.Debugf("invalid rule import: %v", err)
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid rules file"
+		return e
+	}
+
+	opOk := false
+	defer logCrudRule(ctx.UserId, "import", &opOk, "rules: %d", len(export.Rules))
+
+	result, err := a.db.RuleStore.ImportRules(ctx.UserId, export, opts)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	event := &models.AuditEvent{Action: models.AuditRuleImport, Resource: "rule", Success: true}
+	a.auditValues(c, event, nil, result)
+	return c.JSON(http.StatusOK, result)
+}
+
+func ruleAuditEvent(action string, ruleId int) *models.AuditEvent {
+	return &models.AuditEvent{
+		Action:     action,
+		Resource:   "rule",
+		ResourceId: strconv.Itoa(ruleId),
+		Success:    true,
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// swagger:model SavedSearchRequest
+type SavedSearchRequest struct {
+	Name  string `json:"name" valid:"required,stringlength(1|100)"`
+	Query string `json:"query" valid:"maxstringlength(1000),optional"`
+	// Sort is the document attribute to sort with. Empty value sorts by relevance.
+	Sort   string `json:"sort" valid:"optional"`
+	Order  string `json:"order" valid:"in(asc|desc),optional"`
+	Pinned bool   `json:"pinned" valid:"-"`
+	// Digest enables daily mail of documents that newly match the search.
+	Digest bool `json:"digest" valid:"-"`
+}
+
+// swagger:model SavedSearch
+type SavedSearchResponse struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	Sort      string `json:"sort"`
+	Order     string `json:"order"`
+	Pinned    bool   `json:"pinned"`
+	Digest    bool   `json:"digest"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// swagger:model SavedSearchCount
+type SavedSearchCountResponse struct {
+	Id    int `json:"id"`
+	Count int `json:"count"`
+}
+
+func savedSearchToResp(search *models.SavedSearch) *SavedSearchResponse {
+	return &SavedSearchResponse{
+		Id:        search.Id,
+		Name:      search.Name,
+		Query:     search.Query,
+		Sort:      search.SortKey,
+		Order:     strings.ToLower(storage.SortKey{Order: search.SortDesc}.SortOrder()),
+		Pinned:    search.Pinned,
+		Digest:    search.Digest,
+		CreatedAt: search.CreatedAt.Unix() * 1000,
+		UpdatedAt: search.UpdatedAt.Unix() * 1000,
+	}
+}
+
+// toSavedSearch validates the request and copies it to search.
+func (a *Api) toSavedSearch(userId int, dto *SavedSearchRequest, search *models.SavedSearch) error {
+	if dto.Sort != "" {
+		valid := false
+		for _, v := range (&documentSortParams{}).SortAttributes() {
+			if dto.Sort == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			e := errors.ErrInvalid
+			e.ErrMsg = "invalid sort key: " + dto.Sort
+			return e
+		}
+	}
+
+	suggestions, err := a.search.SuggestSearch(userId, dto.Query)
+	if err != nil {
+		return err
+	}
+	if suggestions.Error != "" {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid query: " + suggestions.Error
+		return e
+	}
+
+	search.UserId = userId
+	search.Name = dto.Name
+	search.Query = dto.Query
+	search.SortKey = dto.Sort
+	search.SortDesc = dto.Order == "desc"
+	search.Pinned = dto.Pinned
+	search.Digest = dto.Digest
+	return nil
+}
+
+// savedSearchError converts name conflict into a validation error.
+func savedSearchError(err error) error {
+	if errors.Is(err, errors.ErrAlreadyExists) {
+		e := errors.ErrInvalid
+		e.ErrMsg = "saved search with same name already exists"
+		return e
+	}
+	return err
+}
+
+func (a *Api) getSavedSearches(c echo.Context) error {
+	// swagger:route GET /api/v1/searches Search GetSavedSearches
+	// Get saved searches. Pinned searches are listed first.
+	// responses:
+	//   200: SavedSearch
+	ctx := c.(UserContext)
+	paging, err := bindPaging(c)
+	if err != nil {
+		return err
+	}
+
+	searches, total, err := a.db.SearchStore.GetSavedSearches(ctx.UserId, paging)
+	if err != nil {
+		return err
+	}
+	resp := make([]*SavedSearchResponse, len(searches))
+	for i := range searches {
+		resp[i] = savedSearchToResp(&searches[i])
+	}
+	return resourceList(c, resp, total)
+}
+
+func (a *Api) getSavedSearch(c echo.Context) error {
+	// swagger:route GET /api/v1/searches/{id} Search GetSavedSearch
+	// Get saved search
+	// responses:
+	//   200: SavedSearch
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	search, err := a.db.SearchStore.GetSavedSearch(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	return resourceList(c, savedSearchToResp(search), 1)
+}
+
+func (a *Api) addSavedSearch(c echo.Context) error {
+	// swagger:route POST /api/v1/searches Search AddSavedSearch
+	// Save search
+	// responses:
+	//   200: SavedSearch
+	ctx := c.(UserContext)
+	dto := &SavedSearchRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudSavedSearch(ctx.UserId, "add", &opOk, "name: %s", dto.Name)
+
+	search := &models.SavedSearch{}
+	err = a.toSavedSearch(ctx.UserId, dto, search)
+	if err != nil {
+		return err
+	}
+	err = a.db.SearchStore.AddSavedSearch(search)
+	if err != nil {
+		return savedSearchError(err)
+	}
+	opOk = true
+	return resourceList(c, savedSearchToResp(search), 1)
+}
+
+func (a *Api) updateSavedSearch(c echo.Context) error {
+	// swagger:route PUT /api/v1/searches/{id} Search UpdateSavedSearch
+	// Update saved search
+	// responses:
+	//   200: SavedSearch
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	dto := &SavedSearchRequest{}
+	err = unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudSavedSearch(ctx.UserId, "update", &opOk, "search: %d", id)
+
+	search, err := a.db.SearchStore.GetSavedSearch(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	err = a.toSavedSearch(ctx.UserId, dto, search)
+	if err != nil {
+		return err
+	}
+	err = a.db.SearchStore.UpdateSavedSearch(search)
+	if err != nil {
+		return savedSearchError(err)
+	}
+	opOk = true
+	return resourceList(c, savedSearchToResp(search), 1)
+}
+
+func (a *Api) deleteSavedSearch(c echo.Context) error {
+	// swagger:route DELETE /api/v1/searches/{id} Search DeleteSavedSearch
+	// Delete saved search
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudSavedSearch(ctx.UserId, "delete", &opOk, "search: %d", id)
+
+	err = a.db.SearchStore.DeleteSavedSearch(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.String(http.StatusOK, "")
+}
+
+func (a *Api) getSavedSearchCount(c echo.Context) error {
+	// swagger:route GET /api/v1/searches/{id}/count Search GetSavedSearchCount
+	// Get number of documents that currently match the saved search
+	// responses:
+	//   200: SavedSearchCount
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	search, err := a.db.SearchStore.GetSavedSearch(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+
+	sort := storage.SortKey{Key: search.SortKey, Order: search.SortDesc}
+	_, count, err := a.search.SearchDocuments(ctx.UserId, search.Query, sort, storage.Paging{Offset: 0, Limit: 1})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &SavedSearchCountResponse{Id: search.Id, Count: count})
+}
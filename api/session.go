@@ -0,0 +1,220 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// swagger:model Session
+type SessionResponse struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	IpAddr    string `json:"ip_address"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	ExpiresAt int64  `json:"expires_at"`
+	// Current is true for the session that made the request.
+	Current bool `json:"current"`
+	// Impersonated is true for read-only sessions that administrator uses to view the service as the user.
+	Impersonated bool `json:"impersonated"`
+}
+
+func sessionsToResp(tokens []models.Token, currentKey string) []SessionResponse {
+	resp := make([]SessionResponse, len(tokens))
+	for i, v := range tokens {
+		resp[i] = SessionResponse{
+			Id:           v.Id,
+			Name:         v.Name,
+			IpAddr:       v.IpAddr,
+			CreatedAt:    v.CreatedAt.Unix() * 1000,
+			LastSeen:     v.LastSeen.Unix() * 1000,
+			Current:      currentKey != "" && v.Key == currentKey,
+			Impersonated: v.IsImpersonation(),
+		}
+		if !v.ExpiresAt.IsZero() {
+			resp[i].ExpiresAt = v.ExpiresAt.Unix() * 1000
+		}
+	}
+	return resp
+}
+
+// swagger:model RevokeSessionsResponse
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+func (a *Api) getSessions(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/sessions Authentication GetSessions
+	// Get active login sessions
+	// responses:
+	//   200: Session
+	ctx := c.(UserContext)
+	tokens, err := a.db.AuthStore.GetUserTokens(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	resp := sessionsToResp(tokens, ctx.TokenKey)
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) revokeSession(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/sessions/{id} Authentication RevokeSession
+	// Revoke login session
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudSession(ctx.UserId, "revoke", &opOk, "session id: %d", id)
+
+	err = a.db.AuthStore.RevokeUserToken(ctx.UserId, id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.audit(c, sessionAuditEvent(models.AuditSessionRevoke, ctx.UserId, id))
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *Api) revokeOtherSessions(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/sessions Authentication RevokeOtherSessions
+	// Revoke all login sessions except the current one
+	// responses:
+	//   200: RevokeSessionsResponse
+	ctx := c.(UserContext)
+	opOk := false
+	defer logCrudSession(ctx.UserId, "revoke", &opOk, "all other sessions")
+
+	revoked, err := a.db.AuthStore.RevokeUserTokens(ctx.UserId, ctx.TokenKey)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := RevokeSessionsResponse{Revoked: revoked}
+	a.auditValues(c, sessionAuditEvent(models.AuditSessionRevoke, ctx.UserId, 0), nil, resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) adminGetUserSessions(c echo.Context) error {
+	// swagger:route GET /api/v1/admin/users/{id}/sessions Admin AdminGetUserSessions
+	// Get user's active login sessions
+	// responses:
+	//   200: Session
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	tokens, err := a.db.AuthStore.GetUserTokens(userId)
+	if err != nil {
+		return err
+	}
+	resp := sessionsToResp(tokens, ctx.TokenKey)
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) adminRevokeUserSession(c echo.Context) error {
+	// swagger:route DELETE /api/v1/admin/users/{id}/sessions/{sessionId} Admin AdminRevokeUserSession
+	// Revoke user's login session
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	sessionId, err := bindPathInt(c, "sessionId")
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "revoke session", &opOk, "user_id: %d, session id: %d", userId, sessionId)
+
+	err = a.db.AuthStore.RevokeUserToken(userId, sessionId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.audit(c, sessionAuditEvent(models.AuditAdminRevokeSession, userId, sessionId))
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *Api) adminRevokeUserSessions(c echo.Context) error {
+	// swagger:route DELETE /api/v1/admin/users/{id}/sessions Admin AdminRevokeUserSessions
+	// Revoke all login sessions of the user. Administrator's own current session is not revoked.
+	// responses:
+	//   200: RevokeSessionsResponse
+	ctx := c.(UserContext)
+	userId, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "revoke sessions", &opOk, "user_id: %d", userId)
+
+	revoked, err := a.db.AuthStore.RevokeUserTokens(userId, ctx.TokenKey)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := RevokeSessionsResponse{Revoked: revoked}
+	a.auditValues(c, sessionAuditEvent(models.AuditAdminRevokeSession, userId, 0), nil, resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// sessionAuditEvent returns an event for revoking user's session, or all other sessions if sessionId is 0.
+func sessionAuditEvent(action string, userId int, sessionId int) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:     action,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(userId),
+		Success:    true,
+	}
+	if sessionId > 0 {
+		event.Message = "session " + strconv.Itoa(sessionId)
+	} else {
+		event.Message = "all other sessions"
+	}
+	return event
+}
+
+// revokeUserCredentials revokes all login sessions and access tokens of the user, e.g. after user's password
+// has been changed or the user has been deactivated. Session with exceptKey is not revoked, so that user
+// can keep using the session that made the change.
+func (a *Api) revokeUserCredentials(userId int, exceptKey string) error {
+	sessions, err := a.db.AuthStore.RevokeUserTokens(userId, exceptKey)
+	if err != nil {
+		return err
+	}
+	tokens, err := a.db.AuthStore.DeleteUserApiTokens(userId)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("revoked %d sessions and %d access tokens of user %d", sessions, tokens, userId)
+	return nil
+}
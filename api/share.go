@@ -0,0 +1,502 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// checkDocumentPermission ensures the user has at least the required permission to the document.
+// Documents the user has no access to are reported as not found.
+func (a *Api) checkDocumentPermission(userId int, docId string, required models.SharePermission) (models.SharePermission, error) {
+	permission, err := a.db.DocumentStore.GetDocumentPermission(userId, docId)
+	if err != nil {
+		return permission, err
+	}
+	if permission == models.PermissionNone {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "document not found"
+		return permission, e
+	}
+	if !permission.Allows(required) {
+		e := errors.ErrForbidden
+		e.ErrMsg = "insufficient permission to the document"
+		return permission, e
+	}
+	return permission, nil
+}
+
+// sameMetadata reports whether both lists contain the same key-value pairs, regardless of order.
+func sameMetadata(a, b []models.Metadata) bool {
+	pairs := make(map[[2]int]int, len(a))
+	for _, v := range a {
+		pairs[[2]int{v.KeyId, v.ValueId}] += 1
+	}
+	for _, v := range b {
+		pair := [2]int{v.KeyId, v.ValueId}
+		if pairs[pair] == 0 {
+			return false
+		}
+		pairs[pair] -= 1
+	}
+	for _, count := range pairs {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reindexSharedDocuments updates search indices after the readers of the documents have changed.
+// Unshared contains users that may have lost access to the documents.
+func (a *Api) reindexSharedDocuments(docIds []string, unshared []int) {
+	if len(docIds) == 0 {
+		return
+	}
+	for _, docId := range docIds {
+		for _, userId := range unshared {
+			err := a.search.UnshareDocument(docId, userId)
+			if err != nil {
+				logrus.Warningf("remove document %s from search index of user %d: %v", docId, userId, err)
+			}
+		}
+	}
+	err := a.db.JobStore.AddDocuments(0, docIds, models.ProcessFts)
+	if err != nil && !errors.Is(err, errors.ErrAlreadyExists) {
+		logrus.Warningf("schedule indexing shared documents: %v", err)
+		return
+	}
+	a.process.PullDocumentsToProcess()
+}
+
+// swagger:model DocumentShare
+type DocumentShareResponse struct {
+	Id         int    `json:"id"`
+	UserId     *int   `json:"user_id"`
+	UserName   string `json:"user_name,omitempty"`
+	GroupId    *int   `json:"group_id"`
+	GroupName  string `json:"group_name,omitempty"`
+	Permission string `json:"permission"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+func shareToResp(share *models.DocumentShare) DocumentShareResponse {
+	resp := DocumentShareResponse{
+		Id:         share.Id,
+		UserId:     share.UserId,
+		GroupId:    share.GroupId,
+		Permission: string(share.Permission),
+		CreatedAt:  share.CreatedAt.Unix() * 1000,
+		UpdatedAt:  share.UpdatedAt.Unix() * 1000,
+	}
+	if share.UserName != nil {
+		resp.UserName = *share.UserName
+	}
+	if share.GroupName != nil {
+		resp.GroupName = *share.GroupName
+	}
+	return resp
+}
+
+// DocumentShareRequest shares document with either a user or a group.
+// swagger:model DocumentShareRequest
+type DocumentShareRequest struct {
+	UserName   string `json:"user_name" valid:"optional"`
+	GroupId    int    `json:"group_id" valid:"optional"`
+	Permission string `json:"permission" valid:"required,in(read|edit)"`
+}
+
+func (a *Api) getDocumentShares(c echo.Context) error {
+	// swagger:route GET /api/v1/documents/{id}/shares Documents GetDocumentShares
+	// Get users and groups the document is shared with
+	// responses:
+	//   200: DocumentShare
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	_, err := a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	shares, err := a.db.DocumentStore.GetDocumentShares(id)
+	if err != nil {
+		return err
+	}
+	resp := make([]DocumentShareResponse, len(*shares))
+	for i := range *shares {
+		resp[i] = shareToResp(&(*shares)[i])
+	}
+	return resourceList(c, resp, len(resp))
+}
+
+func (a *Api) addDocumentShare(c echo.Context) error {
+	// swagger:route POST /api/v1/documents/{id}/shares Documents AddDocumentShare
+	// Share document with a user or a group. Sharing again updates the permission.
+	// responses:
+	//   200: DocumentShare
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	dto := &DocumentShareRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "share", &opOk, "document: %s, user: '%s', group: %d, permission: %s",
+		id, dto.UserName, dto.GroupId, dto.Permission)
+
+	if (dto.UserName == "") == (dto.GroupId == 0) {
+		e := errors.ErrInvalid
+		e.ErrMsg = "either user_name or group_id is required"
+		return e
+	}
+
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+
+	share := &models.DocumentShare{
+		DocumentId: id,
+		Permission: models.SharePermission(dto.Permission),
+	}
+	if dto.UserName != "" {
+		// respond the same way for unknown users and users that cannot be shared with,
+		// so that the endpoint does not reveal which usernames exist
+		userNotFound := errors.ErrRecordNotFound
+		userNotFound.ErrMsg = "user not found"
+		user, err := a.db.UserStore.GetUserByName(dto.UserName)
+		if err != nil {
+			if errors.Is(err, errors.ErrRecordNotFound) {
+				return userNotFound
+			}
+			return err
+		}
+		if user.Id == ctx.UserId || !user.IsActive {
+			return userNotFound
+		}
+		share.UserId = &user.Id
+		share.UserName = &user.Name
+	} else {
+		group, err := a.getMemberGroup(ctx.UserId, dto.GroupId)
+		if err != nil {
+			return err
+		}
+		share.GroupId = &group.Id
+		share.GroupName = &group.Name
+	}
+
+	err = a.db.DocumentStore.SetDocumentShare(share)
+	if err != nil {
+		return err
+	}
+	a.reindexSharedDocuments([]string{id}, nil)
+	opOk = true
+	resp := shareToResp(share)
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditDocumentShare,
+		Resource:   "document",
+		ResourceId: id,
+		Success:    true,
+	}, nil, resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// getMemberGroup returns the group if the user is a member of it.
+func (a *Api) getMemberGroup(userId, groupId int) (*models.UserGroup, error) {
+	groups, err := a.db.UserStore.GetUserGroups(userId)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range *groups {
+		if v.Id == groupId {
+			return &(*groups)[i], nil
+		}
+	}
+	e := errors.ErrRecordNotFound
+	e.ErrMsg = "group not found"
+	return nil, e
+}
+
+func (a *Api) deleteDocumentShare(c echo.Context) error {
+	// swagger:route DELETE /api/v1/documents/{id}/shares/{shareId} Documents DeleteDocumentShare
+	// Stop sharing document with a user or a group
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id := bindPathId(c)
+	shareId, err := bindPathInt(c, "shareId")
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudDocument(ctx.UserId, "unshare", &opOk, "document: %s, share: %d", id, shareId)
+
+	_, err = a.checkDocumentPermission(ctx.UserId, id, models.PermissionOwner)
+	if err != nil {
+		return err
+	}
+
+	share, err := a.db.DocumentStore.DeleteDocumentShare(id, shareId)
+	if err != nil {
+		return err
+	}
+
+	var unshared []int
+	if share.UserId != nil {
+		unshared = []int{*share.UserId}
+	} else if share.GroupId != nil {
+		group, err := a.db.UserStore.GetGroup(*share.GroupId)
+		if err != nil {
+			return err
+		}
+		unshared = group.Members
+	}
+	a.reindexSharedDocuments([]string{id}, unshared)
+	opOk = true
+	a.auditValues(c, &models.AuditEvent{
+		Action:     models.AuditDocumentUnshare,
+		Resource:   "document",
+		ResourceId: id,
+		Success:    true,
+	}, shareToResp(share), nil)
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// swagger:model UserGroup
+type UserGroupResponse struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Members   []int  `json:"members"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func groupsToResp(groups []models.UserGroup) []UserGroupResponse {
+	resp := make([]UserGroupResponse, len(groups))
+	for i, v := range groups {
+		resp[i] = UserGroupResponse{
+			Id:        v.Id,
+			Name:      v.Name,
+			Members:   v.Members,
+			CreatedAt: v.CreatedAt.Unix() * 1000,
+			UpdatedAt: v.UpdatedAt.Unix() * 1000,
+		}
+	}
+	return resp
+}
+
+// swagger:model UserGroupRequest
+type UserGroupRequest struct {
+	Name    string `json:"name" valid:"required,stringlength(1|100)"`
+	Members []int  `json:"members" valid:"-"`
+}
+
+func (a *Api) getGroups(c echo.Context) error {
+	// swagger:route GET /api/v1/groups Groups GetGroups
+	// Get groups the user is a member of
+	// responses:
+	//   200: UserGroup
+	ctx := c.(UserContext)
+	groups, err := a.db.UserStore.GetUserGroups(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	return resourceList(c, groupsToResp(*groups), len(*groups))
+}
+
+func (a *Api) adminGetGroups(c echo.Context) error {
+	// swagger:route GET /api/v1/admin/groups Admin AdminGetGroups
+	// Get all user groups
+	// responses:
+	//   200: UserGroup
+	groups, err := a.db.UserStore.GetGroups()
+	if err != nil {
+		return err
+	}
+	return resourceList(c, groupsToResp(*groups), len(*groups))
+}
+
+func (a *Api) adminGetGroup(c echo.Context) error {
+	// swagger:route GET /api/v1/admin/groups/{id} Admin AdminGetGroup
+	// Get user group
+	// responses:
+	//   200: UserGroup
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	group, err := a.db.UserStore.GetGroup(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, groupsToResp([]models.UserGroup{*group})[0])
+}
+
+func (a *Api) adminAddGroup(c echo.Context) error {
+	// swagger:route POST /api/v1/admin/groups Admin AdminAddGroup
+	// Create user group
+	// responses:
+	//   200: UserGroup
+	ctx := c.(UserContext)
+	dto := &UserGroupRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "add group", &opOk, "name: %s, members: %v", dto.Name, dto.Members)
+
+	group := &models.UserGroup{Name: dto.Name, Members: dto.Members}
+	err = a.db.UserStore.AddGroup(group)
+	if err != nil {
+		return err
+	}
+	group, err = a.db.UserStore.GetGroup(group.Id)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := groupsToResp([]models.UserGroup{*group})[0]
+	a.auditValues(c, groupAuditEvent(models.AuditAdminAddGroup, group.Id), nil, resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) adminUpdateGroup(c echo.Context) error {
+	// swagger:route PUT /api/v1/admin/groups/{id} Admin AdminUpdateGroup
+	// Update user group name and members
+	// responses:
+	//   200: UserGroup
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+	dto := &UserGroupRequest{}
+	err = unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "update group", &opOk, "group: %d, name: %s, members: %v",
+		id, dto.Name, dto.Members)
+
+	oldGroup, err := a.db.UserStore.GetGroup(id)
+	if err != nil {
+		return err
+	}
+	group := &models.UserGroup{Id: id, Name: dto.Name, Members: dto.Members}
+	err = a.db.UserStore.UpdateGroup(group)
+	if err != nil {
+		return err
+	}
+	group, err = a.db.UserStore.GetGroup(id)
+	if err != nil {
+		return err
+	}
+
+	members := make(map[int]bool, len(group.Members))
+	for _, v := range group.Members {
+		members[v] = true
+	}
+	removed := []int{}
+	for _, v := range oldGroup.Members {
+		if !members[v] {
+			removed = append(removed, v)
+		}
+	}
+	err = a.reindexGroupDocuments(id, removed)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	resp := groupsToResp([]models.UserGroup{*group})[0]
+	a.auditValues(c, groupAuditEvent(models.AuditAdminUpdateGroup, id),
+		groupsToResp([]models.UserGroup{*oldGroup})[0], resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) adminDeleteGroup(c echo.Context) error {
+	// swagger:route DELETE /api/v1/admin/groups/{id} Admin AdminDeleteGroup
+	// Delete user group. Documents shared with the group are no longer shared with its members.
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	id, err := bindPathIdInt(c)
+	if err != nil {
+		return err
+	}
+
+	opOk := false
+	defer logCrudAdminUsers(ctx.UserId, "delete group", &opOk, "group: %d", id)
+
+	group, err := a.db.UserStore.GetGroup(id)
+	if err != nil {
+		return err
+	}
+	docs, err := a.db.DocumentStore.GetGroupSharedDocuments(id)
+	if err != nil {
+		return err
+	}
+	err = a.db.UserStore.DeleteGroup(id)
+	if err != nil {
+		return err
+	}
+	docIds := make([]string, len(*docs))
+	for i, v := range *docs {
+		docIds[i] = v.Id
+	}
+	a.reindexSharedDocuments(docIds, group.Members)
+	opOk = true
+	a.auditValues(c, groupAuditEvent(models.AuditAdminDeleteGroup, id), groupsToResp([]models.UserGroup{*group})[0], nil)
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func groupAuditEvent(action string, groupId int) *models.AuditEvent {
+	return &models.AuditEvent{
+		Action:     action,
+		Resource:   "group",
+		ResourceId: strconv.Itoa(groupId),
+		Success:    true,
+	}
+}
+
+// reindexGroupDocuments reindexes documents shared with the group after its members have changed.
+func (a *Api) reindexGroupDocuments(groupId int, removedMembers []int) error {
+	docs, err := a.db.DocumentStore.GetGroupSharedDocuments(groupId)
+	if err != nil {
+		return err
+	}
+	docIds := make([]string, len(*docs))
+	for i, v := range *docs {
+		docIds[i] = v.Id
+	}
+	a.reindexSharedDocuments(docIds, removedMembers)
+	return nil
+}
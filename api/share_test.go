@@ -0,0 +1,67 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"testing"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func Test_sameMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []models.Metadata
+		b    []models.Metadata
+		want bool
+	}{
+		{name: "empty", want: true},
+		{
+			name: "different order",
+			a:    []models.Metadata{{KeyId: 1, ValueId: 2}, {KeyId: 3, ValueId: 4}},
+			b:    []models.Metadata{{KeyId: 3, ValueId: 4}, {KeyId: 1, ValueId: 2}},
+			want: true,
+		},
+		{
+			name: "value changed",
+			a:    []models.Metadata{{KeyId: 1, ValueId: 2}},
+			b:    []models.Metadata{{KeyId: 1, ValueId: 3}},
+			want: false,
+		},
+		{
+			name: "value added",
+			a:    []models.Metadata{{KeyId: 1, ValueId: 2}},
+			b:    []models.Metadata{{KeyId: 1, ValueId: 2}, {KeyId: 1, ValueId: 3}},
+			want: false,
+		},
+		{
+			name: "value removed",
+			a:    []models.Metadata{{KeyId: 1, ValueId: 2}, {KeyId: 1, ValueId: 3}},
+			b:    []models.Metadata{{KeyId: 1, ValueId: 3}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameMetadata(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -33,3 +33,12 @@ type metadataKeyValueResponse struct {
 	// in:body
 	Body []models.MetadataValue
 }
+
+// swagger:parameters GetMetadataKeyValues
+type metadataKeyValuesRequest struct {
+	// Set to 1 to return top-level values with their children nested in 'children'.
+	// Values are not paged in tree form.
+	// in: query
+	// required: false
+	Tree string `json:"tree"`
+}
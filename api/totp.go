@@ -0,0 +1,314 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+var totpCodeRegex = regexp.MustCompile(`^\d{6}$`)
+
+// getEnabledTotp returns user's two-factor authentication enrolment, or nil if user has not enabled it.
+func (a *Api) getEnabledTotp(userId int) (*models.UserTotp, error) {
+	totp, err := a.db.AuthStore.GetUserTotp(userId)
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !totp.Enabled {
+		return nil, nil
+	}
+	return totp, nil
+}
+
+// verifySecondFactor checks either the one-time password or one of the recovery codes.
+// Each code is accepted only once.
+func (a *Api) verifySecondFactor(totp *models.UserTotp, code string) (bool, error) {
+	if otp := models.NormalizeTotpCode(code); totpCodeRegex.MatchString(otp) {
+		step, ok := models.ValidateTotpCode(totp.Secret, otp, time.Now(), totp.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+		return a.db.AuthStore.UseTotpStep(totp.UserId, step)
+	}
+	if code == "" {
+		return false, nil
+	}
+	used, err := a.db.AuthStore.UseRecoveryCode(totp.UserId, models.HashRecoveryCode(code))
+	if used {
+		logrus.Infof("user %d used a recovery code", totp.UserId)
+	}
+	return used, err
+}
+
+// checkSecondFactor returns error if user has enabled two-factor authentication and the code is not valid.
+// Invalid code results in error of type errType.
+func (a *Api) checkSecondFactor(userId int, code string, errType errors.Error) error {
+	totp, err := a.getEnabledTotp(userId)
+	if err != nil || totp == nil {
+		return err
+	}
+	e := errType
+	if code == "" {
+		e.ErrMsg = "two-factor authentication code required"
+		return e
+	}
+	ok, err := a.verifySecondFactor(totp, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		e.ErrMsg = "invalid two-factor authentication code"
+		return e
+	}
+	return nil
+}
+
+// requireAdminTwoFactor returns error if administrators are required to use two-factor authentication
+// and the user has not enabled it.
+func (a *Api) requireAdminTwoFactor(userId int) error {
+	if !config.C.Api.RequireAdminTwoFactor {
+		return nil
+	}
+	totp, err := a.getEnabledTotp(userId)
+	if err != nil {
+		return err
+	}
+	if totp == nil {
+		e := errors.ErrForbidden
+		e.ErrMsg = "administrators must enable two-factor authentication"
+		return e
+	}
+	return nil
+}
+
+// swagger:model TwoFactorStatus
+type TwoFactorStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	// Required is true if user is required to enable two-factor authentication.
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// swagger:model TwoFactorSetup
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	// Uri is the otpauth uri to show as a QR code.
+	Uri string `json:"uri"`
+}
+
+// swagger:model TwoFactorEnableRequest
+type TwoFactorEnableRequest struct {
+	Code string `json:"code" valid:"required"`
+}
+
+// swagger:model RecoveryCodes
+type RecoveryCodesResponse struct {
+	// RecoveryCodes are only shown once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := models.GenerateRecoveryCodes(models.RecoveryCodesCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, v := range codes {
+		hashes[i] = models.HashRecoveryCode(v)
+	}
+	return codes, hashes, nil
+}
+
+func (a *Api) getTwoFactorStatus(c echo.Context) error {
+	// swagger:route GET /api/v1/auth/2fa Authentication GetTwoFactorStatus
+	// Get two-factor authentication status
+	// responses:
+	//   200: TwoFactorStatus
+	ctx := c.(UserContext)
+	totp, err := a.getEnabledTotp(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	resp := &TwoFactorStatusResponse{
+		Enabled:  totp != nil,
+		Required: ctx.Admin && config.C.Api.RequireAdminTwoFactor,
+	}
+	if totp != nil {
+		resp.RecoveryCodesRemaining, err = a.db.AuthStore.CountRecoveryCodes(ctx.UserId)
+		if err != nil {
+			return err
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *Api) setupTwoFactor(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/2fa/setup Authentication SetupTwoFactor
+	// Start two-factor authentication enrolment. Enrolment is enabled after user verifies a code.
+	// responses:
+	//   200: TwoFactorSetup
+	ctx := c.(UserContext)
+	totp, err := a.getEnabledTotp(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	if totp != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is already enabled"
+		return e
+	}
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "setup", &opOk, "")
+
+	secret, err := models.GenerateTotpSecret()
+	if err != nil {
+		return err
+	}
+	err = a.db.AuthStore.SetUserTotpSecret(ctx.UserId, secret)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	return c.JSON(http.StatusOK, &TwoFactorSetupResponse{
+		Secret: secret,
+		Uri:    models.TotpUri(secret, ctx.User.Name),
+	})
+}
+
+func (a *Api) enableTwoFactor(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/2fa/enable Authentication EnableTwoFactor
+	// Verify code and enable two-factor authentication. Returns the recovery codes.
+	// responses:
+	//   200: RecoveryCodes
+	ctx := c.(UserContext)
+	dto := &TwoFactorEnableRequest{}
+	err := unMarshalBody(c.Request(), dto)
+	if err != nil {
+		return err
+	}
+	totp, err := a.db.AuthStore.GetUserTotp(ctx.UserId)
+	if err != nil {
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			e := errors.ErrInvalid
+			e.ErrMsg = "two-factor authentication setup has not been started"
+			return e
+		}
+		return err
+	}
+	if totp.Enabled {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is already enabled"
+		return e
+	}
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "enable", &opOk, "")
+
+	step, ok := models.ValidateTotpCode(totp.Secret, dto.Code, time.Now(), 0)
+	if !ok {
+		e := errors.ErrInvalid
+		e.ErrMsg = "invalid code"
+		return e
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	err = a.db.AuthStore.EnableUserTotp(ctx.UserId, step, hashes)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.audit(c, twoFactorAuditEvent(models.AuditTwoFactorEnable, ctx.UserId))
+	return c.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (a *Api) regenerateRecoveryCodes(c echo.Context) error {
+	// swagger:route POST /api/v1/auth/2fa/recovery-codes Authentication RegenerateRecoveryCodes
+	// Replace recovery codes with new ones
+	// responses:
+	//   200: RecoveryCodes
+	ctx := c.(UserContext)
+	totp, err := a.getEnabledTotp(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	if totp == nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = "two-factor authentication is not enabled"
+		return e
+	}
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "regenerate recovery codes", &opOk, "")
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	err = a.db.AuthStore.SetRecoveryCodes(ctx.UserId, hashes)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.audit(c, twoFactorAuditEvent(models.AuditTwoFactorRecovery, ctx.UserId))
+	return c.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (a *Api) disableTwoFactor(c echo.Context) error {
+	// swagger:route DELETE /api/v1/auth/2fa Authentication DisableTwoFactor
+	// Disable two-factor authentication
+	// responses:
+	//   200:
+	ctx := c.(UserContext)
+	if ctx.Admin && config.C.Api.RequireAdminTwoFactor {
+		e := errors.ErrForbidden
+		e.ErrMsg = "administrators are required to use two-factor authentication"
+		return e
+	}
+	opOk := false
+	defer logCrudTwoFactor(ctx.UserId, "disable", &opOk, "")
+
+	err := a.db.AuthStore.DeleteUserTotp(ctx.UserId)
+	if err != nil {
+		return err
+	}
+	opOk = true
+	a.audit(c, twoFactorAuditEvent(models.AuditTwoFactorDisable, ctx.UserId))
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func twoFactorAuditEvent(action string, userId int) *models.AuditEvent {
+	return &models.AuditEvent{
+		Action:     action,
+		Resource:   "user",
+		ResourceId: strconv.Itoa(userId),
+		Success:    true,
+	}
+}
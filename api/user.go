@@ -21,8 +21,16 @@ package api
 import (
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
+	"time"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/mail"
 	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/process"
+	"tryffel.net/go/virtualpaper/search"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
 // swagger:model UserPreferences
@@ -39,6 +47,17 @@ type UserPreferences struct {
 	IsAdmin             bool       `json:"is_admin"`
 	StopWords           []string   `json:"stop_words"`
 	Synonyms            [][]string `json:"synonyms"`
+	DateLocale          string     `json:"date_locale"`
+	Timezone            string     `json:"timezone"`
+	FacetKeys           []string   `json:"facet_keys"`
+	// SearchSettings are user's ranking rules, typo tolerance and attribute weights.
+	SearchSettings models.SearchSettings `json:"search_settings"`
+	// SearchSettingsStatus tells whether search settings have been applied to the search index.
+	SearchSettingsStatus *search.SearchSettingsStatus `json:"search_settings_status,omitempty"`
+	// Notifications are the events that are mailed to user.
+	Notifications models.NotificationSettings `json:"notifications"`
+	// PendingEmail is the new email address waiting for verification, if any.
+	PendingEmail string `json:"pending_email"`
 }
 
 func (u *UserPreferences) copyUser(userPref *models.UserPreferences) {
@@ -53,6 +72,12 @@ func (u *UserPreferences) copyUser(userPref *models.UserPreferences) {
 	u.IsAdmin = userPref.IsAdmin
 	u.StopWords = userPref.StopWords
 	u.Synonyms = userPref.Synonyms
+	u.DateLocale = userPref.DateLocale
+	u.Timezone = userPref.Timezone
+	u.FacetKeys = userPref.FacetKeys
+	u.SearchSettings = userPref.SearchSettings
+	u.Notifications = userPref.Notifications
+	u.PendingEmail = userPref.PendingEmail
 }
 
 func (a *Api) getUserPreferences(c echo.Context) error {
@@ -92,6 +117,10 @@ func (a *Api) getUserPreferences(c echo.Context) error {
 
 	userPref := &UserPreferences{}
 	userPref.copyUser(preferences)
+	userPref.SearchSettingsStatus, err = a.search.GetUserSearchSettingsStatus(ctx.UserId)
+	if err != nil {
+		logrus.Warningf("get search settings status for user %d: %v", ctx.UserId, err)
+	}
 	return c.JSON(http.StatusOK, userPref)
 	//respOk(resp, userPref)
 }
@@ -100,10 +129,38 @@ func (a *Api) getUserPreferences(c echo.Context) error {
 type ReqUserPreferences struct {
 	StopWords []string   `json:"stop_words" valid:"optional"`
 	Synonyms  [][]string `json:"synonyms" valid:"optional"`
-	Email     string     `json:"email" valid:"email,optional"`
+	// Email is changed after user opens the verification link that is sent to the new address.
+	// Requires current_password.
+	Email string `json:"email" valid:"email,optional"`
+	// CurrentPassword is required when changing email or password.
+	CurrentPassword string `json:"current_password" valid:"optional"`
+	// NewPassword changes user's password. Other sessions and access tokens of the user are revoked.
+	NewPassword string `json:"new_password" valid:"optional"`
+	// Notifications select which events are mailed to user.
+	Notifications *models.NotificationSettings `json:"notifications" valid:"-"`
+	// DateLocale is the language of dates in documents: en, fi or de.
+	DateLocale *string `json:"date_locale" valid:"-"`
+	// Timezone is used for relative dates in search, e.g. Europe/Helsinki. Empty value means UTC.
+	Timezone *string `json:"timezone" valid:"-"`
+	// FacetKeys are the metadata keys and facets mimetype, year and month to count for search results.
+	// Empty list shows all facets.
+	FacetKeys *[]string `json:"facet_keys" valid:"-"`
+	// SearchSettings adjust ranking of search results. Settings are applied to the search index,
+	// which is rebuilt in the background, see search_settings_status.
+	SearchSettings *models.SearchSettings `json:"search_settings" valid:"-"`
 }
 
 func (a *Api) updateUserPreferences(c echo.Context) error {
+	// swagger:route PUT /api/v1/preferences/user Preferences UpdatePreferences
+	// Update user preferences
+	//
+	// Changing email or password requires current password.
+	// responses:
+	//   200: RespUserPreferences
+	//   304: RespNotModified
+	//   400: RespBadRequest
+	//   403: RespForbidden
+	//   500: RespInternalError
 	ctx := c.(UserContext)
 
 	dto := &ReqUserPreferences{}
@@ -116,22 +173,109 @@ func (a *Api) updateUserPreferences(c echo.Context) error {
 	if err != nil {
 		return fmt.Errorf("get user: %v", err)
 	}
+
+	emailChanged := dto.Email != "" && !strings.EqualFold(dto.Email, user.Email)
+	if emailChanged || dto.NewPassword != "" {
+		// check credentials before changing anything else
+		err = a.checkCurrentPassword(c, user, dto.CurrentPassword)
+		if err != nil {
+			return err
+		}
+	}
+	if dto.NewPassword != "" {
+		if err = ValidatePassword(dto.NewPassword); err != nil {
+			return err
+		}
+	}
+	if emailChanged {
+		existing, err := a.db.UserStore.GetUserByEmail(dto.Email)
+		if err == nil && existing != nil {
+			e := errors.ErrAlreadyExists
+			e.ErrMsg = "email is already in use"
+			return e
+		} else if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+			return err
+		}
+	}
+
 	attributeChanged := false
 	searchParamsChanged := false
+	passwordChanged := false
 	if len(dto.StopWords) > 0 || len(dto.Synonyms) > 0 {
 		searchParamsChanged = true
 		err = a.db.UserStore.UpdatePreferences(ctx.UserId, dto.StopWords, dto.Synonyms)
 		if err != nil {
 			return err
 		}
-
+	}
+	if dto.SearchSettings != nil {
+		err = dto.SearchSettings.Validate()
+		if err != nil {
+			return err
+		}
+		searchParamsChanged = true
+		err = a.db.UserStore.UpdateSearchSettings(ctx.UserId, *dto.SearchSettings)
+		if err != nil {
+			return err
+		}
+	}
+	if searchParamsChanged {
 		err = a.search.UpdateUserPreferences(ctx.UserId)
 		if err != nil {
 			return err
 		}
 	}
-	if dto.Email != "" {
-		user.Email = dto.Email
+	if emailChanged {
+		err = a.requestEmailChange(c, user, dto.Email)
+		if err != nil {
+			return err
+		}
+		attributeChanged = true
+	}
+	if dto.NewPassword != "" {
+		err = user.SetPassword(dto.NewPassword)
+		if err != nil {
+			return fmt.Errorf("set new password: %v", err)
+		}
+		passwordChanged = true
+		attributeChanged = true
+	}
+	if dto.Notifications != nil {
+		err = a.db.UserStore.UpdateNotificationSettings(ctx.UserId, *dto.Notifications)
+		if err != nil {
+			return err
+		}
+		attributeChanged = true
+	}
+	if dto.DateLocale != nil {
+		if !process.DateLocale(*dto.DateLocale).IsValid() {
+			e := errors.ErrInvalid
+			e.ErrMsg = "unsupported date locale: " + *dto.DateLocale
+			return e
+		}
+		err = a.db.UserStore.SetPreferenceValue(ctx.UserId, storage.PreferenceDateLocale, *dto.DateLocale)
+		if err != nil {
+			return err
+		}
+		attributeChanged = true
+	}
+	if dto.Timezone != nil {
+		if _, err := time.LoadLocation(*dto.Timezone); err != nil {
+			e := errors.ErrInvalid
+			e.ErrMsg = "unknown timezone: " + *dto.Timezone
+			return e
+		}
+		err = a.db.UserStore.SetPreferenceValue(ctx.UserId, storage.PreferenceTimezone, *dto.Timezone)
+		if err != nil {
+			return err
+		}
+		attributeChanged = true
+	}
+	if dto.FacetKeys != nil {
+		err = a.db.UserStore.UpdateFacetKeys(ctx.UserId, *dto.FacetKeys)
+		if err != nil {
+			return err
+		}
 		attributeChanged = true
 	}
 
@@ -143,8 +287,77 @@ func (a *Api) updateUserPreferences(c echo.Context) error {
 		}
 	}
 
+	if passwordChanged {
+		logrus.Warningf("user %d changed password", user.Id)
+		a.auditAuth(c, models.AuditPasswordChange, user.Id, user.Name, true, "")
+		err = a.revokeUserCredentials(user.Id, ctx.TokenKey)
+		if err != nil {
+			return fmt.Errorf("revoke other sessions: %v", err)
+		}
+	}
+
 	if !attributeChanged && !searchParamsChanged {
 		return c.String(http.StatusNotModified, "")
 	}
 	return a.getUserPreferences(c)
 }
+
+// checkCurrentPassword verifies user's password before changing credentials.
+// Credentials cannot be changed with access tokens or when users log in with single sign-on only.
+func (a *Api) checkCurrentPassword(c echo.Context, user *models.User, password string) error {
+	ctx := c.(UserContext)
+	if ctx.ApiToken != nil {
+		e := errors.ErrForbidden
+		e.ErrMsg = "access tokens cannot change email or password"
+		return e
+	}
+	if err := passwordLoginEnabled(); err != nil {
+		e := errors.ErrForbidden
+		e.ErrMsg = "email and password are managed by single sign-on"
+		return e
+	}
+
+	match := false
+	if password != "" {
+		ok, err := user.PasswordMatches(password)
+		if err != nil {
+			logrus.Warningf("compare password of user %d: %v", user.Id, err)
+		}
+		match = ok && err == nil
+	}
+	if !match {
+		a.auditAuth(c, models.AuditPasswordChange, user.Id, user.Name, false, "invalid current password")
+		e := errors.ErrForbidden
+		e.ErrMsg = "invalid current password"
+		return e
+	}
+	return nil
+}
+
+// requestEmailChange sends verification link to the new email. Email is changed in VerifyEmail.
+func (a *Api) requestEmailChange(c echo.Context, user *models.User, email string) error {
+	rawToken, hash, err := newPasswordToken()
+	if err != nil {
+		return fmt.Errorf("generate email verification token: %v", err)
+	}
+
+	token := &models.EmailVerificationToken{
+		UserId: user.Id,
+		Email:  email,
+		Token:  hash,
+	}
+	// token is valid for 24 hours
+	token.ExpiresAt = time.Now().Add(time.Hour * 24)
+	token.Update()
+	token.CreatedAt = token.UpdatedAt
+
+	err = a.db.UserStore.SetEmailVerificationToken(token)
+	if err != nil {
+		return fmt.Errorf("save email verification token: %v", err)
+	}
+
+	logrus.Infof("user %d requested changing email, verification token %d", user.Id, token.Id)
+	a.auditAuth(c, models.AuditEmailChangeRequest, user.Id, user.Name, true, "")
+	go mail.VerifyEmail(email, rawToken, token.Id)
+	return nil
+}
@@ -63,14 +63,7 @@ func getSortParams(req *http.Request, model models.Modeler) ([]storage.SortKey,
 		return sortKeys, nil
 	}
 
-	vars := req.Form
-
-	sortVar := vars.Get("sort")
-	sortOrder := vars.Get("order")
-
-	if strings.HasPrefix(sortVar, "[") && strings.HasSuffix(sortVar, "]") {
-		sortVar, sortOrder = parseSortParamArray(sortVar)
-	}
+	sortVar, sortOrder := sortParam(req)
 
 	for _, v := range model.SortAttributes() {
 		if sortVar == v {
@@ -98,6 +91,17 @@ func getSortParams(req *http.Request, model models.Modeler) ([]storage.SortKey,
 	return sortKeys, nil
 }
 
+// sortParam returns requested sort key and order. Request form must be parsed.
+func sortParam(req *http.Request) (string, string) {
+	sortVar := req.Form.Get("sort")
+	sortOrder := req.Form.Get("order")
+
+	if strings.HasPrefix(sortVar, "[") && strings.HasSuffix(sortVar, "]") {
+		sortVar, sortOrder = parseSortParamArray(sortVar)
+	}
+	return sortVar, sortOrder
+}
+
 func parseSortParamArray(s string) (string, string) {
 	s = strings.Trim(s, "[]\"")
 	s = strings.Replace(s, "\"", "", 2)
@@ -118,4 +122,8 @@ type UserContext struct {
 	UserId   int
 	User     *models.User
 	TokenKey string
+	// ApiToken is set when user authenticated with a personal access token instead of logging in.
+	ApiToken *models.ApiToken
+	// Impersonator is set when an administrator views the service as the user.
+	Impersonator *models.User
 }
@@ -37,7 +37,7 @@ var indexCmd = &cobra.Command{
 		}
 		defer db.Close()
 
-		engine, err := search.NewEngine(db, &config.C.Meilisearch)
+		engine, err := search.NewEngine(db, &config.C.Search, &config.C.Meilisearch)
 		if err != nil {
 			logrus.Fatalf("Init search engine: %v", err)
 		}
@@ -68,3 +68,55 @@ var indexCmd = &cobra.Command{
 		}
 	},
 }
+
+var migrateSearchIndexCmd = &cobra.Command{
+	Use:   "migrate-search-index",
+	Short: "Migrate search indices to the configured index mode",
+	Long: "Move documents from the indices of previous index mode (--from) into the indices of mode " +
+		"configured in meilisearch.index_mode. All documents are scheduled for re-indexing, which is run " +
+		"by the server. Old indices are deleted unless --keep-old is set.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		if migrateIndexFrom == "" {
+			logrus.Fatalf("previous index mode (--from) must be set")
+		}
+
+		engine, err := search.NewMeilisearch(db, &config.C.Meilisearch)
+		if err != nil {
+			logrus.Fatalf("Init search engine: %v", err)
+		}
+
+		err = engine.MigrateIndexMode(search.IndexMode(migrateIndexFrom), migrateIndexFromShards, migrateIndexKeepOld)
+		if err != nil {
+			logrus.Fatalf("migrate search index: %v", err)
+		}
+		logrus.Infof("Migrated search index from '%s' to '%s', documents are re-indexed by the server",
+			migrateIndexFrom, config.C.Meilisearch.IndexMode)
+	},
+}
+
+var migrateIndexFrom = ""
+var migrateIndexFromShards = 0
+var migrateIndexKeepOld = false
+
+func init() {
+	manageCmd.AddCommand(migrateSearchIndexCmd)
+	migrateSearchIndexCmd.PersistentFlags().StringVar(&migrateIndexFrom, "from", "",
+		"Previous index mode: user, shared or sharded")
+	migrateSearchIndexCmd.PersistentFlags().IntVar(&migrateIndexFromShards, "from-shards", 4,
+		"Number of shards in previous index mode, if sharded")
+	migrateSearchIndexCmd.PersistentFlags().BoolVar(&migrateIndexKeepOld, "keep-old", false,
+		"Do not delete old indices")
+}
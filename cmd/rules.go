@@ -0,0 +1,180 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+var exportRulesCmd = &cobra.Command{
+	Use:   "export-rules",
+	Short: "Export user's processing rules to file",
+	Long: "Export all processing rules of user to json or yaml file. " +
+		"Format is determined from file extension, unless --format is set.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		if rulesUserName == "" || rulesFile == "" {
+			logrus.Fatalf("username and file must be set")
+		}
+
+		user, err := db.UserStore.GetUserByName(rulesUserName)
+		if err != nil {
+			logrus.Fatalf("user not found: %v", err)
+		}
+
+		export, err := db.RuleStore.ExportRules(user.Id)
+		if err != nil {
+			logrus.Fatalf("export rules: %v", err)
+		}
+
+		var data []byte
+		if rulesFileFormat() == "yaml" {
+			data, err = yaml.Marshal(export)
+		} else {
+			data, err = json.MarshalIndent(export, "", "  ")
+		}
+		if err != nil {
+			logrus.Fatalf("encode rules: %v", err)
+		}
+
+		err = os.WriteFile(rulesFile, data, 0640)
+		if err != nil {
+			logrus.Fatalf("write file: %v", err)
+		}
+		logrus.Infof("Exported %d rules to %s", len(export.Rules), rulesFile)
+	},
+}
+
+var importRulesCmd = &cobra.Command{
+	Use:   "import-rules",
+	Short: "Import processing rules from file",
+	Long: "Import processing rules to user from json or yaml file. Metadata keys and values are matched by their names. " +
+		"Format is determined from file extension, unless --format is set.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+
+		if rulesUserName == "" || rulesFile == "" {
+			logrus.Fatalf("username and file must be set")
+		}
+
+		user, err := db.UserStore.GetUserByName(rulesUserName)
+		if err != nil {
+			logrus.Fatalf("user not found: %v", err)
+		}
+
+		data, err := os.ReadFile(rulesFile)
+		if err != nil {
+			logrus.Fatalf("read file: %v", err)
+		}
+
+		export := &models.RulesExport{}
+		if rulesFileFormat() == "yaml" {
+			err = yaml.Unmarshal(data, export)
+		} else {
+			err = json.Unmarshal(data, export)
+		}
+		if err != nil {
+			logrus.Fatalf("parse rules: %v", err)
+		}
+
+		opts := models.RuleImportOptions{
+			CreateMissingMetadata: rulesCreateMetadata,
+			OnConflict:            models.RuleConflictMode(rulesOnConflict),
+		}
+		result, err := db.RuleStore.ImportRules(user.Id, export, opts)
+		if err != nil {
+			if len(result.MissingKeys) > 0 || len(result.MissingValues) > 0 {
+				logrus.Errorf("missing metadata, use --create-metadata to create it")
+			}
+			logrus.Fatalf("import rules: %v", err)
+		}
+
+		logrus.Infof("Created rules: %s", strings.Join(result.Created, ", "))
+		logrus.Infof("Updated rules: %s", strings.Join(result.Updated, ", "))
+		logrus.Infof("Skipped rules: %s", strings.Join(result.Skipped, ", "))
+		if len(result.KeysCreated) > 0 || len(result.ValuesCreated) > 0 {
+			logrus.Infof("Created metadata: %s", strings.Join(append(result.KeysCreated, result.ValuesCreated...), ", "))
+		}
+	},
+}
+
+func rulesFileFormat() string {
+	if rulesFormat != "" {
+		return rulesFormat
+	}
+	ext := strings.ToLower(filepath.Ext(rulesFile))
+	if ext == ".yaml" || ext == ".yml" {
+		return "yaml"
+	}
+	return "json"
+}
+
+var rulesUserName = ""
+var rulesFile = ""
+var rulesFormat = ""
+var rulesCreateMetadata = false
+var rulesOnConflict = ""
+
+func init() {
+	manageCmd.AddCommand(exportRulesCmd)
+	manageCmd.AddCommand(importRulesCmd)
+
+	for _, v := range []*cobra.Command{exportRulesCmd, importRulesCmd} {
+		v.PersistentFlags().StringVarP(&rulesUserName, "username", "U", "", "Username")
+		v.PersistentFlags().StringVarP(&rulesFile, "file", "f", "", "Rules file")
+		v.PersistentFlags().StringVar(&rulesFormat, "format", "", "File format: json or yaml")
+	}
+	importRulesCmd.PersistentFlags().BoolVar(&rulesCreateMetadata, "create-metadata", false,
+		"Create metadata keys and values that do not exist")
+	importRulesCmd.PersistentFlags().StringVar(&rulesOnConflict, "on-conflict", string(models.RuleConflictSkip),
+		"What to do with rules that already exist with same name: skip, overwrite, fail")
+}
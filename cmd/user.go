@@ -22,6 +22,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
 	"tryffel.net/go/virtualpaper/api"
@@ -144,7 +145,7 @@ var addUserCmd = &cobra.Command{
 		}
 
 		logrus.Infof("init search engine index for new user")
-		_, err = search.NewEngine(db, &config.C.Meilisearch)
+		_, err = search.NewEngine(db, &config.C.Search, &config.C.Meilisearch)
 		if err != nil {
 			logrus.Fatalf("connect to search engine: %v", err)
 		}
@@ -201,6 +202,54 @@ var resetPwCMd = &cobra.Command{
 	},
 }
 
+var resetTwoFactorCmd = &cobra.Command{
+	Use:   "reset-2fa",
+	Short: "Disable user's two-factor authentication and delete recovery codes",
+	Run: func(cmd *cobra.Command, args []string) {
+		initConfig()
+		err := config.InitLogging()
+		if err != nil {
+			logrus.Fatalf("init log: %v", err)
+			return
+		}
+		defer config.DeinitLogging()
+
+		db, err := storage.NewDatabase(config.C.Database)
+		if err != nil {
+			logrus.Fatalf("Connect to database: %v", err)
+		}
+		defer db.Close()
+		if userName == "" {
+			userName, err = readUserInput("username", false)
+			if userName == "" {
+				logrus.Fatalf("username cannot be empty")
+			}
+		}
+
+		user, err := db.UserStore.GetUserByName(userName)
+		if err != nil {
+			logrus.Fatalf("user not found: %v", err)
+		}
+
+		err = db.AuthStore.DeleteUserTotp(user.Id)
+		if err != nil {
+			logrus.Fatalf("reset two-factor authentication: %v", err)
+		}
+		err = db.AuditStore.AddEvent(&models.AuditEvent{
+			ActorName:  "command line",
+			Action:     models.AuditAdminResetTwoFactor,
+			Resource:   "user",
+			ResourceId: strconv.Itoa(user.Id),
+			Success:    true,
+			Message:    "reset from command line",
+		})
+		if err != nil {
+			logrus.Errorf("store audit event: %v", err)
+		}
+		logrus.Infof("Two-factor authentication disabled for user %d (%s)", user.Id, user.Name)
+	},
+}
+
 var userName = ""
 var password = ""
 var optAdmin = ""
@@ -208,6 +257,7 @@ var optAdmin = ""
 func init() {
 	manageCmd.AddCommand(addUserCmd)
 	manageCmd.AddCommand(resetPwCMd)
+	manageCmd.AddCommand(resetTwoFactorCmd)
 
 	addUserCmd.PersistentFlags().StringVarP(&optAdmin, "admin", "a", "",
 		"Make user an administrator")
@@ -215,6 +265,8 @@ func init() {
 		"New username")
 	addUserCmd.PersistentFlags().StringVarP(&password, "password", "P", "",
 		"New password")
+	resetTwoFactorCmd.PersistentFlags().StringVarP(&userName, "username", "U", "",
+		"Username")
 }
 
 func init() {
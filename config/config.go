@@ -24,9 +24,11 @@ type Config struct {
 	Database    Database
 	Processing  Processing
 	Meilisearch Meilisearch
+	Search      Search
 	Mail        Mail
 	Logging     Logging
 	CronJobs    CronJobs
+	Oidc        Oidc
 }
 
 // Api contains http server config
@@ -42,6 +44,10 @@ type Api struct {
 
 	StaticContentPath     string
 	AuthRatelimitDisabled bool
+	// RequireAdminTwoFactor requires administrators to have two-factor authentication enabled.
+	RequireAdminTwoFactor bool
+	// MaxUsers is the maximum number of users. 0 means no limit.
+	MaxUsers int
 }
 
 func (a *Api) CorsHostList() string {
@@ -81,6 +87,19 @@ type Meilisearch struct {
 	Url    string
 	Index  string
 	ApiKey string
+	// IndexMode is either 'user' (one index per user), 'shared' (single index for all users)
+	// or 'sharded' (users are spread across Shards indices).
+	IndexMode string
+	// Shards is the number of indices to use in 'sharded' mode.
+	Shards int
+}
+
+// Search contains configuration for the full-text-search backend.
+type Search struct {
+	// Engine is either 'meilisearch' or 'local'.
+	Engine string
+	// LocalFallback enables local engine when Meilisearch is not available.
+	LocalFallback bool
 }
 
 // Mail contains configuration for sending mails.
@@ -108,6 +127,8 @@ type Logging struct {
 	HttpLogFile   string
 	LogFile       string
 	LogStdout     bool
+	// AuditRetentionDays is the number of days audit log events are kept. Negative value keeps events forever.
+	AuditRetentionDays int
 
 	httpLog *os.File
 	log     *os.File
@@ -119,6 +140,36 @@ type CronJobs struct {
 	Disabled bool
 }
 
+// Oidc contains configuration for single sign-on with an OpenID Connect identity provider.
+type Oidc struct {
+	Enabled bool
+	// Name of the identity provider to show to users.
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// RedirectUrl is the frontend page that identity provider redirects user to after authentication.
+	RedirectUrl string
+	Scopes      []string
+
+	// Claims to read user information from.
+	UsernameClaim string
+	EmailClaim    string
+	GroupsClaim   string
+	// AdminGroup grants administrator privileges to members of the group, if set.
+	AdminGroup string
+
+	// AutoCreateUsers creates users on their first login.
+	AutoCreateUsers bool
+	// DisablePasswordLogin disables logging in with local passwords.
+	DisablePasswordLogin bool
+}
+
+// PasswordLoginEnabled returns true if users are allowed to log in with passwords.
+func (o *Oidc) PasswordLoginEnabled() bool {
+	return !(o.Enabled && o.DisablePasswordLogin)
+}
+
 // ConfigFromViper initializes Config.C, reads all config values from viper and stores them to Config.C.
 func ConfigFromViper() error {
 
@@ -132,6 +183,8 @@ func ConfigFromViper() error {
 			StaticContentPath:     viper.GetString("api.static_content_path"),
 			TokenExpireSec:        viper.GetInt("api.token_expire_sec"),
 			AuthRatelimitDisabled: viper.GetBool("api.disable_auth_ratelimit"),
+			RequireAdminTwoFactor: viper.GetBool("api.require_admin_2fa"),
+			MaxUsers:              viper.GetInt("api.max_users"),
 		},
 
 		Database: Database{
@@ -155,9 +208,15 @@ func ConfigFromViper() error {
 			TesseractBin: viper.GetString("processing.tesseract_bin"),
 		},
 		Meilisearch: Meilisearch{
-			Url:    viper.GetString("meilisearch.url"),
-			Index:  viper.GetString("meilisearch.index"),
-			ApiKey: viper.GetString("meilisearch.apikey"),
+			Url:       viper.GetString("meilisearch.url"),
+			Index:     viper.GetString("meilisearch.index"),
+			ApiKey:    viper.GetString("meilisearch.apikey"),
+			IndexMode: viper.GetString("meilisearch.index_mode"),
+			Shards:    viper.GetInt("meilisearch.shards"),
+		},
+		Search: Search{
+			Engine:        viper.GetString("search.engine"),
+			LocalFallback: viper.GetBool("search.local_fallback"),
 		},
 		Mail: Mail{
 			Enabled:        false,
@@ -175,8 +234,25 @@ func ConfigFromViper() error {
 			HttpLogFile:   viper.GetString("logging.http_log_file"),
 			LogFile:       viper.GetString("logging.log_file"),
 			LogStdout:     viper.GetBool("logging.log_stdout"),
+
+			AuditRetentionDays: viper.GetInt("logging.audit_retention_days"),
 		},
 		CronJobs: CronJobs{Disabled: viper.GetBool("cronjobs.disabled")},
+		Oidc: Oidc{
+			Enabled:              viper.GetBool("oidc.enabled"),
+			Name:                 viper.GetString("oidc.name"),
+			Issuer:               viper.GetString("oidc.issuer"),
+			ClientId:             viper.GetString("oidc.client_id"),
+			ClientSecret:         viper.GetString("oidc.client_secret"),
+			RedirectUrl:          viper.GetString("oidc.redirect_url"),
+			Scopes:               viper.GetStringSlice("oidc.scopes"),
+			UsernameClaim:        viper.GetString("oidc.username_claim"),
+			EmailClaim:           viper.GetString("oidc.email_claim"),
+			GroupsClaim:          viper.GetString("oidc.groups_claim"),
+			AdminGroup:           viper.GetString("oidc.admin_group"),
+			AutoCreateUsers:      viper.GetBool("oidc.auto_create_users"),
+			DisablePasswordLogin: viper.GetBool("oidc.disable_password_login"),
+		},
 	}
 
 	var err error
@@ -209,6 +285,34 @@ func InitConfig() error {
 	C.Processing.TmpDir, inputChanged = setVar(C.Processing.TmpDir, defaultTmpDir)
 	C.Processing.DataDir, dataChanged = setVar(C.Processing.DataDir, "data")
 	C.Meilisearch.Index, indexChanged = setVar(C.Meilisearch.Index, "virtualpaper")
+	if C.Meilisearch.IndexMode == "" {
+		C.Meilisearch.IndexMode = "user"
+	}
+	if C.Search.Engine == "" {
+		C.Search.Engine = "meilisearch"
+	}
+	if C.Meilisearch.Shards <= 0 {
+		C.Meilisearch.Shards = 4
+	}
+
+	if C.Oidc.Name == "" {
+		C.Oidc.Name = "OpenID Connect"
+	}
+	if len(C.Oidc.Scopes) == 0 {
+		C.Oidc.Scopes = []string{"openid", "profile", "email"}
+	}
+	if C.Oidc.UsernameClaim == "" {
+		C.Oidc.UsernameClaim = "preferred_username"
+	}
+	if C.Oidc.EmailClaim == "" {
+		C.Oidc.EmailClaim = "email"
+	}
+	if C.Oidc.GroupsClaim == "" {
+		C.Oidc.GroupsClaim = "groups"
+	}
+	if C.Oidc.RedirectUrl == "" {
+		C.Oidc.RedirectUrl = strings.TrimSuffix(C.Api.PublicUrl, "/") + "/oidc/callback"
+	}
 
 	if C.Api.TokenExpireSec != 0 {
 		C.Api.TokenExpire = time.Second * time.Duration(C.Api.TokenExpireSec)
@@ -242,6 +346,10 @@ func InitConfig() error {
 	viper.Set("logging.http_log_file", C.Logging.HttpLogFile)
 	viper.Set("logging.log_file", C.Logging.LogFile)
 
+	if C.Logging.AuditRetentionDays == 0 {
+		C.Logging.AuditRetentionDays = 365
+	}
+
 	if C.Processing.MaxWorkers == 0 {
 		// use only half of available cpus
 		C.Processing.MaxWorkers = runtime.NumCPU() / 2
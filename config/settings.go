@@ -6,7 +6,7 @@ import (
 )
 
 const (
-	SchemaVersion = 15
+	SchemaVersion = 30
 )
 
 const (
@@ -15,6 +15,9 @@ const (
 
 	// MaxRulesToProcess is per-user absolute maximum number of rules to run for each document.
 	MaxRulesToProcess = 50
+
+	// MaxRuleTraces is the number of rule execution traces to keep for each document.
+	MaxRuleTraces = 10
 )
 
 // MaxRevords returns minimum of MaxRows and n, where n might be supplied from user input.
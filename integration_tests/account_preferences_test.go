@@ -0,0 +1,131 @@
+package integrationtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/h2non/baloo.v3"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type AccountPreferencesTest struct {
+	ApiTestSuite
+	user   *models.UserInfo
+	client *httpClient
+}
+
+func TestAccountPreferences(t *testing.T) {
+	suite.Run(t, new(AccountPreferencesTest))
+}
+
+const accountUserName = "account user"
+const accountPassword = "passwordlongenough"
+
+func (suite *AccountPreferencesTest) SetupTest() {
+	suite.Init()
+	clearTestUsersTables(suite.T(), suite.db)
+
+	data := &api.AdminAddUserRequest{
+		UserName: accountUserName,
+		Email:    "account@test.com",
+		Password: accountPassword,
+		Active:   true,
+	}
+	suite.user = AdminCreateUser(suite.T(), suite.adminHttp, data, 200)
+	token, _ := LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	suite.client = &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token)}
+}
+
+func (suite *AccountPreferencesTest) TearDownSuite() {
+	clearTestUsersTables(suite.T(), suite.db)
+	suite.ApiTestSuite.TearDownSuite()
+}
+
+func (suite *AccountPreferencesTest) getPreferences() *api.UserPreferences {
+	preferences := &api.UserPreferences{}
+	suite.client.Get("/api/v1/preferences/user").Expect(suite.T()).Json(suite.T(), preferences).e.Status(200).Done()
+	return preferences
+}
+
+func (suite *AccountPreferencesTest) TestChangePassword() {
+	newPassword := "anotherpasswordlongenough"
+	otherSession, _ := LoginRequest(suite.T(), accountUserName, accountPassword, 200)
+
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(), api.ReqUserPreferences{NewPassword: newPassword}).
+		Expect(suite.T()).e.Status(403).Done()
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(),
+		api.ReqUserPreferences{CurrentPassword: "invalid-password", NewPassword: newPassword}).
+		Expect(suite.T()).e.Status(403).Done()
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(),
+		api.ReqUserPreferences{CurrentPassword: accountPassword, NewPassword: "short"}).
+		Expect(suite.T()).e.Status(400).Done()
+	LoginRequest(suite.T(), accountUserName, accountPassword, 200)
+
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(),
+		api.ReqUserPreferences{CurrentPassword: accountPassword, NewPassword: newPassword}).
+		Expect(suite.T()).e.Status(200).Done()
+	LoginRequest(suite.T(), accountUserName, accountPassword, 401)
+	LoginRequest(suite.T(), accountUserName, newPassword, 200)
+
+	// current session is kept, other sessions are revoked
+	suite.getPreferences()
+	other := &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+otherSession)}
+	other.Get("/api/v1/preferences/user").Expect(suite.T()).e.Status(401).Done()
+}
+
+func (suite *AccountPreferencesTest) TestChangeEmail() {
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(), api.ReqUserPreferences{Email: "changed@test.com"}).
+		Expect(suite.T()).e.Status(403).Done()
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(),
+		api.ReqUserPreferences{CurrentPassword: accountPassword, Email: "changed@test.com"}).
+		Expect(suite.T()).e.Status(200).Done()
+
+	// email is not changed before it is verified
+	preferences := suite.getPreferences()
+	assert.Equal(suite.T(), "account@test.com", preferences.Email)
+	assert.Equal(suite.T(), "changed@test.com", preferences.PendingEmail)
+
+	// replace the token to know its plain value
+	rawToken := "email-verification-token-for-integration-test"
+	hash, err := bcrypt.GenerateFromPassword([]byte(rawToken), bcrypt.MinCost)
+	assert.Nil(suite.T(), err)
+	token := &models.EmailVerificationToken{
+		UserId:    suite.user.UserId,
+		Email:     "changed@test.com",
+		Token:     string(hash),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	token.Update()
+	token.CreatedAt = token.UpdatedAt
+	err = suite.db.UserStore.SetEmailVerificationToken(token)
+	assert.Nil(suite.T(), err)
+
+	suite.publicHttp.Post("/api/v1/auth/verify-email").Json(suite.T(), api.VerifyEmailRequest{Id: token.Id, Token: "invalid-token"}).
+		Expect(suite.T()).e.Status(403).Done()
+	suite.publicHttp.Post("/api/v1/auth/verify-email").Json(suite.T(), api.VerifyEmailRequest{Id: token.Id, Token: rawToken}).
+		Expect(suite.T()).e.Status(200).Done()
+
+	preferences = suite.getPreferences()
+	assert.Equal(suite.T(), "changed@test.com", preferences.Email)
+	assert.Equal(suite.T(), "", preferences.PendingEmail)
+
+	// token can be used only once
+	suite.publicHttp.Post("/api/v1/auth/verify-email").Json(suite.T(), api.VerifyEmailRequest{Id: token.Id, Token: rawToken}).
+		Expect(suite.T()).e.Status(403).Done()
+}
+
+func (suite *AccountPreferencesTest) TestNotifications() {
+	preferences := suite.getPreferences()
+	assert.Equal(suite.T(), models.DefaultNotificationSettings(), preferences.Notifications)
+
+	settings := models.NotificationSettings{LoginAlerts: false, ProcessingFailures: true, Digests: false}
+	suite.client.Put("/api/v1/preferences/user").Json(suite.T(), api.ReqUserPreferences{Notifications: &settings}).
+		Expect(suite.T()).e.Status(200).Done()
+
+	preferences = suite.getPreferences()
+	assert.Equal(suite.T(), settings, preferences.Notifications)
+}
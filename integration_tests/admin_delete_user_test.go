@@ -0,0 +1,136 @@
+package integrationtest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/h2non/baloo.v3"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type AdminDeleteUserTest struct {
+	ApiTestSuite
+}
+
+func TestAdminDeleteUser(t *testing.T) {
+	suite.Run(t, new(AdminDeleteUserTest))
+}
+
+func (suite *AdminDeleteUserTest) SetupTest() {
+	suite.Init()
+	clearTestUsersTables(suite.T(), suite.db)
+}
+
+func (suite *AdminDeleteUserTest) TearDownSuite() {
+	clearTestUsersTables(suite.T(), suite.db)
+	suite.ApiTestSuite.TearDownSuite()
+}
+
+func (suite *AdminDeleteUserTest) createUser() (*models.UserInfo, *httpClient) {
+	data := &api.AdminAddUserRequest{
+		UserName: "deleted user",
+		Password: "passwordlongenough",
+		Active:   true,
+	}
+	user := AdminCreateUser(suite.T(), suite.adminHttp, data, 200)
+	token, _ := LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	return user, &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token)}
+}
+
+func (suite *AdminDeleteUserTest) TestDeleteUser() {
+	user, client := suite.createUser()
+	client.Post("/api/v1/metadata/keys").Json(suite.T(), api.MetadataKeyRequest{Key: "deleted-key"}).
+		Expect(suite.T()).e.Status(200).Done()
+	url := "/api/v1/admin/users/" + strconv.Itoa(user.UserId)
+
+	summary := &models.UserDataSummary{}
+	suite.adminHttp.Delete(url).SetQueryParam("dry_run", "1").Expect(suite.T()).Json(suite.T(), summary).e.Status(200).Done()
+	assert.Equal(suite.T(), user.UserId, summary.UserId)
+	assert.Equal(suite.T(), 1, summary.MetadataKeys)
+	assert.Equal(suite.T(), 1, summary.Sessions)
+	// dry run does not change anything
+	client.Get("/api/v1/metadata/keys").Expect(suite.T()).e.Status(200).Done()
+
+	suite.userHttp.Delete(url).Expect(suite.T()).e.Status(401).Done()
+	suite.adminHttp.Delete(url).Expect(suite.T()).Json(suite.T(), summary).e.Status(200).Done()
+	client.Get("/api/v1/metadata/keys").Expect(suite.T()).e.Status(401).Done()
+
+	deleted := false
+	for i := 0; i < 50 && !deleted; i++ {
+		_, err := suite.db.UserStore.GetUserDataSummary(user.UserId)
+		deleted = err != nil
+		time.Sleep(time.Millisecond * 100)
+	}
+	assert.True(suite.T(), deleted, "user deleted")
+	assertUsersCount(&suite.ApiTestSuite, 2)
+
+	// result of the background deletion is audited
+	filter := map[string]string{"action": models.AuditAdminDeleteUserDone, "resource_id": strconv.Itoa(user.UserId)}
+	events := []api.AuditEventResponse{}
+	for i := 0; i < 50 && len(events) == 0; i++ {
+		events = getAuditEvents(suite.T(), suite.adminHttp, filter)
+		time.Sleep(time.Millisecond * 100)
+	}
+	if assert.Len(suite.T(), events, 1) {
+		assert.True(suite.T(), events[0].Success, events[0].Message)
+	}
+}
+
+func (suite *AdminDeleteUserTest) TestDeleteSelf() {
+	preferences := &models.UserPreferences{}
+	suite.adminHttp.Get("/api/v1/preferences/user").Expect(suite.T()).Json(suite.T(), preferences).e.Status(200).Done()
+	suite.adminHttp.Delete("/api/v1/admin/users/" + strconv.Itoa(preferences.UserId)).Expect(suite.T()).e.Status(400).Done()
+}
+
+func (suite *AdminDeleteUserTest) TestImpersonate() {
+	user, _ := suite.createUser()
+	url := "/api/v1/admin/users/" + strconv.Itoa(user.UserId) + "/impersonate"
+
+	suite.userHttp.Post(url).Json(suite.T(), api.ImpersonateRequest{}).Expect(suite.T()).e.Status(401).Done()
+	suite.adminHttp.Post(url).Json(suite.T(), api.ImpersonateRequest{Minutes: 120}).Expect(suite.T()).e.Status(400).Done()
+
+	session := &api.ImpersonateResponse{}
+	suite.adminHttp.Post(url).Json(suite.T(), api.ImpersonateRequest{Minutes: 5}).Expect(suite.T()).
+		Json(suite.T(), session).e.Status(200).Done()
+	assert.Equal(suite.T(), user.UserId, session.UserId)
+	assert.InDelta(suite.T(), time.Now().Add(time.Minute*5).UnixMilli(), session.ExpiresAt, 10000)
+
+	client := &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+session.Token)}
+	preferences := &models.UserPreferences{}
+	client.Get("/api/v1/preferences/user").Expect(suite.T()).Json(suite.T(), preferences).e.Status(200).Done()
+	assert.Equal(suite.T(), user.UserId, preferences.UserId)
+	assert.False(suite.T(), preferences.IsAdmin)
+
+	// session is read-only
+	client.Post("/api/v1/metadata/keys").Json(suite.T(), api.MetadataKeyRequest{Key: "key"}).
+		Expect(suite.T()).e.Status(403).Done()
+	client.Get("/api/v1/admin/users").Expect(suite.T()).e.Status(401).Done()
+
+	sessions := &[]api.SessionResponse{}
+	client.Get("/api/v1/auth/sessions").Expect(suite.T()).Json(suite.T(), sessions).e.Status(200).Done()
+	impersonated := 0
+	for _, v := range *sessions {
+		if v.Impersonated {
+			impersonated += 1
+		}
+	}
+	assert.Equal(suite.T(), 1, impersonated)
+
+	events := getAuditEvents(suite.T(), suite.adminHttp, map[string]string{
+		"action": models.AuditAdminImpersonate, "resource_id": strconv.Itoa(user.UserId)})
+	assert.Len(suite.T(), events, 1)
+
+	client.Post("/api/v1/auth/logout").Expect(suite.T()).e.Status(200).Done()
+	client.Get("/api/v1/preferences/user").Expect(suite.T()).e.Status(401).Done()
+}
+
+func (suite *AdminDeleteUserTest) TestImpersonateAdmin() {
+	preferences := &models.UserPreferences{}
+	suite.adminHttp.Get("/api/v1/preferences/user").Expect(suite.T()).Json(suite.T(), preferences).e.Status(200).Done()
+	suite.adminHttp.Post("/api/v1/admin/users/"+strconv.Itoa(preferences.UserId)+"/impersonate").
+		Json(suite.T(), api.ImpersonateRequest{}).Expect(suite.T()).e.Status(400).Done()
+}
@@ -0,0 +1,116 @@
+package integrationtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/h2non/baloo.v3"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type ApiTokenTest struct {
+	ApiTestSuite
+}
+
+func (suite *ApiTokenTest) SetupTest() {
+	suite.Init()
+	suite.db.Engine().MustExec("DELETE FROM api_tokens")
+}
+
+func (suite *ApiTokenTest) TearDownSuite() {
+	suite.db.Engine().MustExec("DELETE FROM api_tokens")
+	suite.ApiTestSuite.TearDownSuite()
+}
+
+func (suite *ApiTokenTest) TestScopes() {
+	token := AddApiToken(suite.T(), suite.userHttp, &api.ApiTokenRequest{
+		Name:   "script",
+		Scopes: []string{models.ScopeMetadataRead},
+	}, 200)
+	assert.NotEmpty(suite.T(), token.Token)
+	assert.Equal(suite.T(), []string{models.ScopeMetadataRead}, token.Scopes)
+
+	client := &httpClient{baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token.Token)}
+	GetMetadataKeys(suite.T(), client, 200, nil)
+	client.Post("/api/v1/metadata/keys").Json(suite.T(), map[string]string{"key": "test"}).Expect(suite.T()).e.Status(403).Done()
+	client.Get("/api/v1/documents").Expect(suite.T()).e.Status(403).Done()
+	client.Get("/api/v1/preferences/user").Expect(suite.T()).e.Status(403).Done()
+
+	tokens := GetApiTokens(suite.T(), suite.userHttp, 200)
+	assert.Len(suite.T(), tokens, 1)
+	assert.Empty(suite.T(), tokens[0].Token)
+	assert.NotZero(suite.T(), tokens[0].LastUsedAt)
+	assert.NotEmpty(suite.T(), tokens[0].LastUsedIp)
+
+	DeleteApiToken(suite.T(), suite.userHttp, token.Id, 200)
+	GetMetadataKeys(suite.T(), client, 401, nil)
+	assert.Len(suite.T(), GetApiTokens(suite.T(), suite.userHttp, 200), 0)
+}
+
+func (suite *ApiTokenTest) TestAdminScope() {
+	AddApiToken(suite.T(), suite.userHttp, &api.ApiTokenRequest{
+		Name:   "admin",
+		Scopes: []string{models.ScopeAdmin},
+	}, 403)
+	AddApiToken(suite.T(), suite.userHttp, &api.ApiTokenRequest{
+		Name:   "invalid",
+		Scopes: []string{"documents:delete"},
+	}, 400)
+
+	token := AddApiToken(suite.T(), suite.adminHttp, &api.ApiTokenRequest{
+		Name:          "admin",
+		Scopes:        []string{models.ScopeAdmin},
+		ExpiresInDays: 1,
+	}, 200)
+	assert.NotZero(suite.T(), token.ExpiresAt)
+	client := &httpClient{baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token.Token)}
+	client.Get("/api/v1/admin/users").Expect(suite.T()).e.Status(200).Done()
+	GetMetadataKeys(suite.T(), client, 403, nil)
+	// actions that require confirming password are not allowed with tokens
+	client.Delete("/api/v1/admin/users/1/sessions").Expect(suite.T()).e.Status(403).Done()
+}
+
+func (suite *ApiTokenTest) TestExpired() {
+	token := AddApiToken(suite.T(), suite.userHttp, &api.ApiTokenRequest{
+		Name:          "expired",
+		Scopes:        []string{models.ScopeMetadataRead},
+		ExpiresInDays: 1,
+	}, 200)
+	suite.db.Engine().MustExec("UPDATE api_tokens SET expires_at = NOW() - INTERVAL '1 day' WHERE id = $1", token.Id)
+
+	client := &httpClient{baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token.Token)}
+	GetMetadataKeys(suite.T(), client, 401, nil)
+}
+
+func TestApiToken(t *testing.T) {
+	suite.Run(t, new(ApiTokenTest))
+}
+
+func AddApiToken(t *testing.T, client *httpClient, data *api.ApiTokenRequest, wantHttpStatus int) *api.ApiTokenResponse {
+	dto := &api.ApiTokenResponse{}
+	req := client.Post("/api/v1/auth/tokens").Json(t, data).Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, dto).e.Status(200).Done()
+	} else {
+		req.e.Status(wantHttpStatus).Done()
+	}
+	return dto
+}
+
+func GetApiTokens(t *testing.T, client *httpClient, wantHttpStatus int) []api.ApiTokenResponse {
+	var dto []api.ApiTokenResponse
+	req := client.Get("/api/v1/auth/tokens").Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, &dto).e.Status(200).Done()
+	} else {
+		req.e.Status(wantHttpStatus).Done()
+	}
+	return dto
+}
+
+func DeleteApiToken(t *testing.T, client *httpClient, tokenId int, wantHttpStatus int) {
+	client.Delete(fmt.Sprintf("/api/v1/auth/tokens/%d", tokenId)).Expect(t).e.Status(wantHttpStatus).Done()
+}
@@ -0,0 +1,103 @@
+package integrationtest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	suite.Run(t, new(AuditLogTestSuite))
+}
+
+type AuditLogTestSuite struct {
+	ApiTestSuite
+}
+
+func (suite *AuditLogTestSuite) SetupTest() {
+	suite.Init()
+	clearDbDocumentTables(suite.T(), suite.db)
+	_ = insertTestDocuments(suite.T(), suite.db)
+	suite.db.Engine().MustExec("DELETE FROM audit_log WHERE 1=1")
+}
+
+func (suite *AuditLogTestSuite) TestLoginEvents() {
+	LoginRequest(suite.T(), UserName, "invalid-password", 401)
+	_, userId := LoginRequest(suite.T(), UserName, UserPassword, 200)
+
+	events := getAuditEvents(suite.T(), suite.adminHttp, map[string]string{"action": models.AuditLogin, "success": "false"})
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), UserName, events[0].ActorName)
+		assert.Nil(suite.T(), events[0].ActorId)
+		assert.NotEmpty(suite.T(), events[0].IpAddr)
+		assert.NotEmpty(suite.T(), events[0].RequestId)
+	}
+
+	events = getAuditEvents(suite.T(), suite.adminHttp, map[string]string{"action": models.AuditLogin, "success": "true"})
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), userId, *events[0].ActorId)
+	}
+}
+
+func (suite *AuditLogTestSuite) TestDeleteDocument() {
+	suite.userHttp.Delete("/api/v1/documents/" + testDocumentX86.Id).Expect(suite.T()).e.Status(200).Done()
+
+	events := getAuditEvents(suite.T(), suite.adminHttp, map[string]string{"resource_id": testDocumentX86.Id})
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), models.AuditDocumentDelete, events[0].Action)
+		assert.Equal(suite.T(), "document", events[0].Resource)
+		assert.Contains(suite.T(), string(events[0].OldValue), testDocumentX86.Name)
+		assert.JSONEq(suite.T(), "null", string(events[0].NewValue))
+	}
+}
+
+func (suite *AuditLogTestSuite) TestApiTokenEvents() {
+	suite.db.Engine().MustExec("DELETE FROM api_tokens")
+	token := AddApiToken(suite.T(), suite.userHttp, &api.ApiTokenRequest{
+		Name:   "audited",
+		Scopes: []string{models.ScopeMetadataRead},
+	}, 200)
+	DeleteApiToken(suite.T(), suite.userHttp, token.Id, 200)
+
+	events := getAuditEvents(suite.T(), suite.adminHttp, map[string]string{"resource": "api_token"})
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), models.AuditApiTokenDelete, events[0].Action)
+		assert.Contains(suite.T(), string(events[0].OldValue), "audited")
+		assert.Equal(suite.T(), models.AuditApiTokenAdd, events[1].Action)
+		assert.Contains(suite.T(), string(events[1].NewValue), "audited")
+		assert.NotContains(suite.T(), string(events[1].NewValue), token.Token)
+	}
+}
+
+func (suite *AuditLogTestSuite) TestShareEvents() {
+	docId := testDocumentX86.Id
+	share := shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "admin", Permission: "read"}, 200)
+	suite.userHttp.Delete("/api/v1/documents/" + docId + "/shares/" + strconv.Itoa(share.Id)).Expect(suite.T()).e.Status(200).Done()
+
+	events := getAuditEvents(suite.T(), suite.adminHttp, map[string]string{"resource_id": docId})
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), models.AuditDocumentUnshare, events[0].Action)
+		assert.Contains(suite.T(), string(events[0].OldValue), "admin")
+		assert.Equal(suite.T(), models.AuditDocumentShare, events[1].Action)
+		assert.Contains(suite.T(), string(events[1].NewValue), "read")
+	}
+}
+
+func (suite *AuditLogTestSuite) TestAuditLogRequiresAdmin() {
+	suite.userHttp.Get("/api/v1/admin/audit").Expect(suite.T()).e.Status(401).Done()
+	suite.adminHttp.Get("/api/v1/admin/audit").SetQueryParam("success", "maybe").Expect(suite.T()).e.Status(400).Done()
+}
+
+func getAuditEvents(t *testing.T, client *httpClient, filter map[string]string) []api.AuditEventResponse {
+	events := &[]api.AuditEventResponse{}
+	req := client.Get("/api/v1/admin/audit")
+	for k, v := range filter {
+		req = req.SetQueryParam(k, v)
+	}
+	req.Expect(t).Json(t, events).e.Status(200).Done()
+	return *events
+}
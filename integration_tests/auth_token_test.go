@@ -1,6 +1,7 @@
 package integrationtest
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/h2non/baloo.v3"
@@ -113,6 +114,90 @@ func (suite *AuthTokenTest) TestExpire() {
 	GetMetadataKeys(suite.T(), client, 401, nil)
 }
 
+func (suite *AuthTokenTest) TestSessions() {
+	data := &api.AdminAddUserRequest{
+		UserName:      "valid name",
+		Email:         "",
+		Password:      "passwordlongenough",
+		Active:        true,
+		Administrator: false,
+	}
+	user := AdminCreateUser(suite.T(), suite.adminHttp, data, 200)
+	firstToken, _ := LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	secondToken, _ := LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	first := &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+firstToken)}
+	second := &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+secondToken)}
+
+	sessions := GetSessions(suite.T(), first, 200)
+	assert.Len(suite.T(), sessions, 3)
+	current := 0
+	for _, v := range sessions {
+		if v.Current {
+			current += 1
+		}
+		assert.NotEmpty(suite.T(), v.IpAddr)
+	}
+	assert.Equal(suite.T(), 1, current)
+
+	// revoke second session
+	sessions = GetSessions(suite.T(), second, 200)
+	for _, v := range sessions {
+		if v.Current {
+			first.Delete(fmt.Sprintf("/api/v1/auth/sessions/%d", v.Id)).Expect(suite.T()).e.Status(200).Done()
+		}
+	}
+	GetMetadataKeys(suite.T(), second, 401, nil)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 2)
+
+	first.Delete("/api/v1/auth/sessions").Expect(suite.T()).e.Status(200).Done()
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 1)
+	GetMetadataKeys(suite.T(), first, 200, nil)
+
+	// other users cannot see or revoke the sessions
+	assert.Len(suite.T(), GetSessions(suite.T(), suite.userHttp, 200), 1)
+	sessions = GetSessions(suite.T(), first, 200)
+	suite.userHttp.Delete(fmt.Sprintf("/api/v1/auth/sessions/%d", sessions[0].Id)).Expect(suite.T()).e.Status(404).Done()
+
+	suite.adminHttp.Get(fmt.Sprintf("/api/v1/admin/users/%d/sessions", user.UserId)).Expect(suite.T()).e.Status(200).Done()
+	suite.userHttp.Get(fmt.Sprintf("/api/v1/admin/users/%d/sessions", user.UserId)).Expect(suite.T()).e.Status(401).Done()
+	suite.adminHttp.Delete(fmt.Sprintf("/api/v1/admin/users/%d/sessions", user.UserId)).Expect(suite.T()).e.Status(200).Done()
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 0)
+	GetMetadataKeys(suite.T(), first, 401, nil)
+}
+
+func (suite *AuthTokenTest) TestAdminUpdateRevokesSessions() {
+	data := &api.AdminAddUserRequest{
+		UserName:      "valid name",
+		Email:         "",
+		Password:      "passwordlongenough",
+		Active:        true,
+		Administrator: false,
+	}
+	user := AdminCreateUser(suite.T(), suite.adminHttp, data, 200)
+	LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 1)
+
+	update := &api.AdminUpdateUserRequest{
+		Email:         "test@mail.com",
+		Active:        true,
+		Administrator: false,
+	}
+	AdminUpdateUser(suite.T(), suite.adminHttp, user.UserId, update, 200)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 1)
+
+	update.Password = "anotherpasswordlongenough"
+	AdminUpdateUser(suite.T(), suite.adminHttp, user.UserId, update, 200)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 0)
+
+	LoginRequest(suite.T(), data.UserName, update.Password, 200)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 1)
+	update.Password = ""
+	update.Active = false
+	AdminUpdateUser(suite.T(), suite.adminHttp, user.UserId, update, 200)
+	assertAuthTokensCount(&suite.ApiTestSuite, user.UserId, 0)
+}
+
 func TestAuthTokenSuite(t *testing.T) {
 	suite.Run(t, new(AuthTokenTest))
 }
@@ -137,6 +222,17 @@ func LogoutRequest(t *testing.T, client *httpClient, wantHttpStatus int) {
 	client.Post("/api/v1/auth/logout").ExpectName(t, "logout", false).e.Status(wantHttpStatus).Done()
 }
 
+func GetSessions(t *testing.T, client *httpClient, wantHttpStatus int) []api.SessionResponse {
+	var dto []api.SessionResponse
+	req := client.Get("/api/v1/auth/sessions").Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, &dto).e.Status(200).Done()
+	} else {
+		req.e.Status(wantHttpStatus).Done()
+	}
+	return dto
+}
+
 func getUserTokens(suite *ApiTestSuite, userId int) *[]models.Token {
 	tokens := &[]models.Token{}
 	err := suite.db.Engine().Select(tokens, "SELECT * FROM auth_tokens WHERE user_id=$1", userId)
@@ -4,12 +4,14 @@ import (
 	"gopkg.in/h2non/baloo.v3"
 	"os"
 	"strings"
+	"tryffel.net/go/virtualpaper/search"
 )
 
 // loaded from env keys during startup
 var serverUrl = ""
 var dbHost = ""
 var meiliHost = ""
+var searchEngine = ""
 
 type httpTest struct {
 	client *baloo.Client
@@ -39,6 +41,7 @@ func initConfig() {
 	serverUrl = getEnv("SERVER_URL", "http://localhost:8000")
 	dbHost = getEnv("DATABASE_HOST", "localhost")
 	meiliHost = getEnv("MEILISEARCH_URL", "http://localhost:7700")
+	searchEngine = getEnv("SEARCH_ENGINE", search.EngineMeilisearch)
 	client = &httpTest{client: baloo.New(serverUrl)}
 }
 
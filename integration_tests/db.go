@@ -47,6 +47,7 @@ var dbProcessingRuleTables = []string{
 }
 
 var dbDocumentTables = []string{
+	"document_rule_traces",
 	"document_view_history",
 	"document_history",
 	"jobs",
@@ -112,6 +113,10 @@ func clearTestUsersTables(t *testing.T, db *storage.Database) {
 }
 
 func clearMeiliIndices(t *testing.T) {
+	if searchEngine == search.EngineLocal {
+		// server's local index is rebuilt automatically after documents are removed from the database.
+		return
+	}
 	db := GetDb()
 	defer closeDb(db, t)
 
@@ -121,7 +126,8 @@ func clearMeiliIndices(t *testing.T) {
 		ApiKey: "",
 	}
 
-	client, err := search.NewEngine(db, conf)
+	searchConf := &config.Search{Engine: searchEngine}
+	client, err := search.NewEngine(db, searchConf, conf)
 	if err != nil {
 		t.Error("connect to Meilisearch", err)
 	}
@@ -131,3 +131,26 @@ func (suite *DocumentHistoryTestSuite) TestChangeMetadata() {
 	assert.Equal(suite.T(), (*history)[4].OldValue, "")
 	assert.Equal(suite.T(), (*history)[4].NewValue, fmt.Sprintf(`{"key_id":%d,"value_id":%d}`, key2.Id, value3.Id))
 }
+
+func (suite *DocumentHistoryTestSuite) TestTrashAndRestore() {
+	trash := getTrashedDocuments(suite.T(), suite.userHttp, 200)
+	assert.Len(suite.T(), *trash, 0)
+	restoreDocument(suite.T(), suite.userHttp, testDocumentX86.Id, 400)
+
+	suite.db.Engine().MustExec("UPDATE documents SET deleted_at = NOW() WHERE id = $1", testDocumentX86.Id)
+	trash = getTrashedDocuments(suite.T(), suite.userHttp, 200)
+	if assert.Len(suite.T(), *trash, 1) {
+		assert.Equal(suite.T(), testDocumentX86.Id, (*trash)[0].Id)
+		assert.NotZero(suite.T(), (*trash)[0].DeletedAt)
+	}
+	assert.Len(suite.T(), *getTrashedDocuments(suite.T(), suite.adminHttp, 200), 0)
+	restoreDocument(suite.T(), suite.adminHttp, testDocumentX86.Id, 404)
+
+	doc := restoreDocument(suite.T(), suite.userHttp, testDocumentX86.Id, 200)
+	assert.Zero(suite.T(), doc.DeletedAt)
+	assert.Len(suite.T(), *getTrashedDocuments(suite.T(), suite.userHttp, 200), 0)
+
+	history := getDocumentHistory(suite.T(), suite.userHttp, testDocumentX86.Id, 200)
+	assert.Len(suite.T(), *history, 2)
+	assert.Equal(suite.T(), "restore", (*history)[1].Action)
+}
@@ -0,0 +1,109 @@
+package integrationtest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"tryffel.net/go/virtualpaper/api"
+)
+
+func TestDocumentLink(t *testing.T) {
+	suite.Run(t, new(DocumentLinkTestSuite))
+}
+
+type DocumentLinkTestSuite struct {
+	ApiTestSuite
+}
+
+func (suite *DocumentLinkTestSuite) SetupTest() {
+	suite.Init()
+	clearDbDocumentTables(suite.T(), suite.db)
+	_ = insertTestDocuments(suite.T(), suite.db)
+}
+
+func (suite *DocumentLinkTestSuite) TestPublicLink() {
+	docId := testDocumentX86.Id
+	expires := time.Now().Add(time.Hour).UnixMilli()
+	link := addDocumentLink(suite.T(), suite.userHttp, docId, api.DocumentLinkRequest{ExpiresAt: expires}, 200)
+	assert.NotEmpty(suite.T(), link.Token)
+	assert.False(suite.T(), link.PasswordProtected)
+
+	doc := &api.PublicDocumentResponse{}
+	suite.publicHttp.Get("/api/v1/public/links/"+link.Token).Expect(suite.T()).Json(suite.T(), doc).e.Status(200).Done()
+	assert.Equal(suite.T(), testDocumentX86.Name, doc.Name)
+	assert.Nil(suite.T(), doc.DownloadsLeft)
+	suite.publicHttp.Get("/api/v1/public/links/invalid").Expect(suite.T()).e.Status(404).Done()
+
+	links := &[]api.DocumentLinkResponse{}
+	suite.userHttp.Get("/api/v1/documents/"+docId+"/links").Expect(suite.T()).Json(suite.T(), links).e.Status(200).Done()
+	assert.Len(suite.T(), *links, 1)
+	assert.Empty(suite.T(), (*links)[0].Token)
+	assert.NotZero(suite.T(), (*links)[0].LastAccessedAt)
+
+	access := &[]api.DocumentLinkAccessResponse{}
+	suite.userHttp.Get("/api/v1/documents/"+docId+"/links/"+strconv.Itoa(link.Id)+"/access").Expect(suite.T()).
+		Json(suite.T(), access).e.Status(200).Done()
+	assert.Len(suite.T(), *access, 1)
+	assert.Equal(suite.T(), "view", (*access)[0].Action)
+	assert.True(suite.T(), (*access)[0].Success)
+
+	// other users cannot manage links
+	suite.adminHttp.Get("/api/v1/documents/" + docId + "/links").Expect(suite.T()).e.Status(404).Done()
+	suite.adminHttp.Delete("/api/v1/documents/" + docId + "/links/" + strconv.Itoa(link.Id)).Expect(suite.T()).e.Status(404).Done()
+
+	suite.userHttp.Delete("/api/v1/documents/" + docId + "/links/" + strconv.Itoa(link.Id)).Expect(suite.T()).e.Status(200).Done()
+	suite.publicHttp.Get("/api/v1/public/links/" + link.Token).Expect(suite.T()).e.Status(404).Done()
+}
+
+func (suite *DocumentLinkTestSuite) TestPasswordProtectedLink() {
+	docId := testDocumentX86.Id
+	expires := time.Now().Add(time.Hour).UnixMilli()
+	link := addDocumentLink(suite.T(), suite.userHttp, docId,
+		api.DocumentLinkRequest{ExpiresAt: expires, Password: "secret", MaxDownloads: 2}, 200)
+	assert.True(suite.T(), link.PasswordProtected)
+
+	url := "/api/v1/public/links/" + link.Token
+	suite.publicHttp.Get(url).Expect(suite.T()).e.Status(403).Done()
+	suite.publicHttp.Get(url).SetHeader(api.LinkPasswordHeader, "wrong").Expect(suite.T()).e.Status(403).Done()
+	// password is not accepted in the url
+	suite.publicHttp.Get(url).SetQueryParam("password", "secret").Expect(suite.T()).e.Status(403).Done()
+
+	doc := &api.PublicDocumentResponse{}
+	suite.publicHttp.Get(url).SetHeader(api.LinkPasswordHeader, "secret").Expect(suite.T()).Json(suite.T(), doc).e.Status(200).Done()
+	assert.Equal(suite.T(), 2, *doc.DownloadsLeft)
+
+	doc = &api.PublicDocumentResponse{}
+	suite.publicHttp.Post(url).Json(suite.T(), api.PublicLinkRequest{Password: "secret"}).Expect(suite.T()).
+		Json(suite.T(), doc).e.Status(200).Done()
+	suite.publicHttp.Post(url).Json(suite.T(), api.PublicLinkRequest{Password: "wrong"}).Expect(suite.T()).e.Status(403).Done()
+
+	access := &[]api.DocumentLinkAccessResponse{}
+	suite.userHttp.Get("/api/v1/documents/"+docId+"/links/"+strconv.Itoa(link.Id)+"/access").Expect(suite.T()).
+		Json(suite.T(), access).e.Status(200).Done()
+	assert.Len(suite.T(), *access, 6)
+}
+
+func (suite *DocumentLinkTestSuite) TestInvalidLink() {
+	docId := testDocumentX86.Id
+	addDocumentLink(suite.T(), suite.userHttp, docId, api.DocumentLinkRequest{}, 400)
+	addDocumentLink(suite.T(), suite.userHttp, docId,
+		api.DocumentLinkRequest{ExpiresAt: time.Now().Add(-time.Hour).UnixMilli()}, 400)
+	addDocumentLink(suite.T(), suite.userHttp, docId,
+		api.DocumentLinkRequest{ExpiresAt: time.Now().AddDate(2, 0, 0).UnixMilli()}, 400)
+	addDocumentLink(suite.T(), suite.adminHttp, docId,
+		api.DocumentLinkRequest{ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, 404)
+}
+
+func addDocumentLink(t *testing.T, client *httpClient, docId string, dto api.DocumentLinkRequest, wantHttpStatus int) *api.DocumentLinkResponse {
+	link := &api.DocumentLinkResponse{}
+	req := client.Post("/api/v1/documents/"+docId+"/links").Json(t, dto).Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, link).e.Status(200).Done()
+		return link
+	}
+	req.e.Status(wantHttpStatus).Done()
+	return nil
+}
@@ -0,0 +1,126 @@
+package integrationtest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func TestDocumentShare(t *testing.T) {
+	suite.Run(t, new(DocumentShareTestSuite))
+}
+
+type DocumentShareTestSuite struct {
+	ApiTestSuite
+}
+
+func (suite *DocumentShareTestSuite) SetupTest() {
+	suite.Init()
+	clearDbDocumentTables(suite.T(), suite.db)
+	suite.db.Engine().MustExec("DELETE FROM user_groups WHERE 1=1")
+	_ = insertTestDocuments(suite.T(), suite.db)
+}
+
+func (suite *DocumentShareTestSuite) TearDownSuite() {
+	suite.db.Engine().MustExec("DELETE FROM user_groups WHERE 1=1")
+	suite.ApiTestSuite.TearDownSuite()
+}
+
+func (suite *DocumentShareTestSuite) TestShareWithUser() {
+	docId := testDocumentX86.Id
+	getDocument(suite.T(), suite.adminHttp, docId, 404)
+
+	share := shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "admin", Permission: "read"}, 200)
+	doc := getDocument(suite.T(), suite.adminHttp, docId, 200)
+	assert.True(suite.T(), doc.Shared)
+	assert.Equal(suite.T(), "read", doc.Permission)
+	getDocumentHistory(suite.T(), suite.adminHttp, docId, 200)
+	suite.adminHttp.Get("/api/v1/documents/" + docId + "/content").Expect(suite.T()).e.Status(200).Done()
+
+	docs := &[]api.DocumentResponse{}
+	suite.adminHttp.Get("/api/v1/documents").Expect(suite.T()).Json(suite.T(), docs).e.Status(200).Done()
+	assert.Len(suite.T(), *docs, 1)
+	assert.Equal(suite.T(), docId, (*docs)[0].Id)
+
+	// read permission does not allow editing
+	doc.Name = "edited by admin"
+	updateDocument(suite.T(), suite.adminHttp, doc, 403)
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "admin", Permission: "edit"}, 200)
+	updateDocument(suite.T(), suite.adminHttp, doc, 200)
+
+	// metadata belongs to the owner
+	doc.Metadata = append(doc.Metadata, models.Metadata{KeyId: 1, ValueId: 1})
+	updateDocument(suite.T(), suite.adminHttp, doc, 403)
+	doc = getDocument(suite.T(), suite.userHttp, docId, 200)
+	assert.Equal(suite.T(), "edited by admin", doc.Name)
+	assert.False(suite.T(), doc.Shared)
+	assert.Equal(suite.T(), "owner", doc.Permission)
+
+	// only owner can manage shares or delete the document
+	suite.adminHttp.Get("/api/v1/documents/" + docId + "/shares").Expect(suite.T()).e.Status(403).Done()
+	suite.adminHttp.Delete("/api/v1/documents/" + docId).Expect(suite.T()).e.Status(403).Done()
+
+	shares := &[]api.DocumentShareResponse{}
+	suite.userHttp.Get("/api/v1/documents/"+docId+"/shares").Expect(suite.T()).Json(suite.T(), shares).e.Status(200).Done()
+	assert.Len(suite.T(), *shares, 1)
+	assert.Equal(suite.T(), share.Id, (*shares)[0].Id)
+	assert.Equal(suite.T(), "admin", (*shares)[0].UserName)
+	assert.Equal(suite.T(), "edit", (*shares)[0].Permission)
+
+	suite.userHttp.Delete("/api/v1/documents/" + docId + "/shares/" + strconv.Itoa(share.Id)).Expect(suite.T()).e.Status(200).Done()
+	getDocument(suite.T(), suite.adminHttp, docId, 404)
+}
+
+func (suite *DocumentShareTestSuite) TestShareInvalid() {
+	docId := testDocumentX86.Id
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "admin", Permission: "owner"}, 400)
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "user", Permission: "read"}, 404)
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{Permission: "read"}, 400)
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{UserName: "no-such-user", Permission: "read"}, 404)
+	shareDocument(suite.T(), suite.adminHttp, docId, api.DocumentShareRequest{UserName: "admin", Permission: "read"}, 404)
+}
+
+func (suite *DocumentShareTestSuite) TestShareWithGroup() {
+	docId := testDocumentX86.Id
+	user, err := suite.db.UserStore.GetUserByName("user")
+	assert.NoError(suite.T(), err)
+	admin, err := suite.db.UserStore.GetUserByName("admin")
+	assert.NoError(suite.T(), err)
+
+	group := &api.UserGroupResponse{}
+	suite.adminHttp.Post("/api/v1/admin/groups").Json(suite.T(), api.UserGroupRequest{Name: "team", Members: []int{user.Id, admin.Id}}).
+		Expect(suite.T()).Json(suite.T(), group).e.Status(200).Done()
+	assert.ElementsMatch(suite.T(), []int{user.Id, admin.Id}, group.Members)
+	suite.userHttp.Get("/api/v1/admin/groups").Expect(suite.T()).e.Status(401).Done()
+
+	groups := &[]api.UserGroupResponse{}
+	suite.userHttp.Get("/api/v1/groups").Expect(suite.T()).Json(suite.T(), groups).e.Status(200).Done()
+	assert.Len(suite.T(), *groups, 1)
+
+	shareDocument(suite.T(), suite.userHttp, docId, api.DocumentShareRequest{GroupId: group.Id, Permission: "read"}, 200)
+	getDocument(suite.T(), suite.adminHttp, docId, 200)
+
+	suite.adminHttp.Put("/api/v1/admin/groups/"+strconv.Itoa(group.Id)).Json(suite.T(), api.UserGroupRequest{Name: "team", Members: []int{user.Id}}).
+		Expect(suite.T()).e.Status(200).Done()
+	getDocument(suite.T(), suite.adminHttp, docId, 404)
+
+	suite.adminHttp.Delete("/api/v1/admin/groups/" + strconv.Itoa(group.Id)).Expect(suite.T()).e.Status(200).Done()
+	shares := &[]api.DocumentShareResponse{}
+	suite.userHttp.Get("/api/v1/documents/"+docId+"/shares").Expect(suite.T()).Json(suite.T(), shares).e.Status(200).Done()
+	assert.Len(suite.T(), *shares, 0)
+}
+
+func shareDocument(t *testing.T, client *httpClient, docId string, dto api.DocumentShareRequest, wantHttpStatus int) *api.DocumentShareResponse {
+	share := &api.DocumentShareResponse{}
+	req := client.Post("/api/v1/documents/"+docId+"/shares").Json(t, dto).Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, share).e.Status(200).Done()
+		return share
+	}
+	req.e.Status(wantHttpStatus).Done()
+	return nil
+}
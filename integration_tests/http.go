@@ -73,6 +73,10 @@ func (h *httpRequest) SetQueryParam(key, value string) *httpRequest {
 	return &httpRequest{h.req.SetQuery(key, value)}
 }
 
+func (h *httpRequest) SetHeader(key, value string) *httpRequest {
+	return &httpRequest{h.req.SetHeader(key, value)}
+}
+
 func (h *httpResponse) Json(t *testing.T, data interface{}) *httpResponse {
 	return &httpResponse{h.e.AssertFunc(readBodyFunc(t, data))}
 }
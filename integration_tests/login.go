@@ -161,7 +161,15 @@ func DoAdminLogin(t *testing.T) {
 }
 
 func LoginRequest(t *testing.T, userName, password string, wantCode int) (string, int) {
-	data := api.LoginRequest{Username: userName, Password: password}
+	return loginRequest(t, api.LoginRequest{Username: userName, Password: password}, wantCode)
+}
+
+// LoginWithCodeRequest logs in with two-factor authentication code.
+func LoginWithCodeRequest(t *testing.T, userName, password, code string, wantCode int) (string, int) {
+	return loginRequest(t, api.LoginRequest{Username: userName, Password: password, Code: code}, wantCode)
+}
+
+func loginRequest(t *testing.T, data api.LoginRequest, wantCode int) (string, int) {
 	c := &httpClient{client: client.client}
 	resp := c.Post("/api/v1/auth/login").Json(t, data).ExpectName(t, "login", false)
 
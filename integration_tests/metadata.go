@@ -73,6 +73,7 @@ func AddMetadataValue(t *testing.T, client *httpClient, keyId int, value *models
 		MatchDocuments: value.MatchDocuments,
 		MatchType:      string(value.MatchType),
 		MatchFilter:    value.MatchFilter,
+		ParentId:       int(value.ParentId),
 	}
 
 	req := client.Post("/api/v1/metadata/keys/"+strconv.Itoa(keyId)+"/values").Json(t, dto)
@@ -87,3 +88,23 @@ func AddMetadataValue(t *testing.T, client *httpClient, keyId int, value *models
 	}
 	return nil
 }
+
+func UpdateMetadataValue(t *testing.T, client *httpClient, keyId int, value *models.MetadataValue, wantHttpStatus int) {
+	dto := &api.MetadataValueRequest{
+		Value:          value.Value,
+		Comment:        value.Comment,
+		MatchDocuments: value.MatchDocuments,
+		MatchType:      string(value.MatchType),
+		MatchFilter:    value.MatchFilter,
+		ParentId:       int(value.ParentId),
+	}
+	req := client.Put("/api/v1/metadata/keys/"+strconv.Itoa(keyId)+"/values/"+strconv.Itoa(value.Id)).Json(t, dto)
+	req.req.Expect(t).Status(wantHttpStatus).Done()
+}
+
+func GetMetadataValueTree(t *testing.T, client *httpClient, keyId int) []*models.MetadataValue {
+	req := client.Get("/api/v1/metadata/keys/"+strconv.Itoa(keyId)+"/values").SetQueryParam("tree", "1")
+	dto := []*models.MetadataValue{}
+	req.Expect(t).Json(t, &dto).e.Status(200).Done()
+	return dto
+}
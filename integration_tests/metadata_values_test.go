@@ -77,6 +77,48 @@ func (suite *MetadataValueSuite) TestInvalidValues() {
 	}, 400)
 }
 
+func (suite *MetadataValueSuite) TestTypedValues() {
+	key := suite.keys["test"]
+	key.ValueType = models.MetadataValueMoney
+	UpdateMetadataKey(suite.T(), suite.userHttp, 200, key)
+
+	value := AddMetadataValue(suite.T(), suite.userHttp, key.Id, &models.MetadataValue{Value: "12,5 eur"}, 200)
+	assert.Equal(suite.T(), "12.50 EUR", value.Value, "money value is normalized")
+	AddMetadataValue(suite.T(), suite.userHttp, key.Id, &models.MetadataValue{Value: "a lot"}, 400)
+
+	// author has text values
+	author := suite.keys["author"]
+	author.ValueType = models.MetadataValueNumber
+	UpdateMetadataKey(suite.T(), suite.userHttp, 400, author)
+}
+
+func (suite *MetadataValueSuite) TestHierarchy() {
+	key := suite.keys["test"]
+	acme := AddMetadataValue(suite.T(), suite.userHttp, key.Id, &models.MetadataValue{Value: "acme"}, 200)
+	website := AddMetadataValue(suite.T(), suite.userHttp, key.Id,
+		&models.MetadataValue{Value: "website", ParentId: models.IntId(acme.Id)}, 200)
+	AddMetadataValue(suite.T(), suite.userHttp, key.Id,
+		&models.MetadataValue{Value: "invoices", ParentId: models.IntId(website.Id)}, 200)
+
+	// parent must be under the same key
+	AddMetadataValue(suite.T(), suite.userHttp, suite.keys["author"].Id,
+		&models.MetadataValue{Value: "invalid", ParentId: models.IntId(acme.Id)}, 400)
+
+	// no cycles
+	acme.ParentId = models.IntId(website.Id)
+	UpdateMetadataValue(suite.T(), suite.userHttp, key.Id, acme, 400)
+	acme.ParentId = models.IntId(acme.Id)
+	UpdateMetadataValue(suite.T(), suite.userHttp, key.Id, acme, 400)
+
+	tree := GetMetadataValueTree(suite.T(), suite.userHttp, key.Id)
+	if assert.Len(suite.T(), tree, 1) && assert.Len(suite.T(), tree[0].Children, 1) {
+		assert.Equal(suite.T(), "acme", tree[0].Path)
+		if assert.Len(suite.T(), tree[0].Children[0].Children, 1) {
+			assert.Equal(suite.T(), "acme/website/invoices", tree[0].Children[0].Children[0].Path)
+		}
+	}
+}
+
 func initMetadataKeyValues(t *testing.T, client *httpClient) (map[string]*models.MetadataKey, map[string]map[string]*models.MetadataValue) {
 	keys := make(map[string]*models.MetadataKey)
 	keys["author"] = AddMetadataKey(t, client, "author", "document author", 200)
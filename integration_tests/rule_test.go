@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"testing"
 	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/process"
 )
 
@@ -107,10 +108,32 @@ func (suite *RuleTestSuite) TestCreateRule() {
 
 	rule.Name = "invalid action"
 	rule.Actions[0].Action = "name"
-	// TODO: should fail
-	addRule(suite.T(), suite.userHttp, rule, 200, "invalid rule: bad action name")
+	addRule(suite.T(), suite.userHttp, rule, 400, "invalid rule: bad action name")
+
+	rule.Name = "trash document"
+	rule.Actions[0].Action = "document_trash"
+	addRule(suite.T(), suite.userHttp, rule, 200, "valid rule: trash document")
+
+	rule.Name = "stop processing"
+	rule.Actions[0].Action = "rules_stop"
+	addRule(suite.T(), suite.userHttp, rule, 200, "valid rule: stop processing")
+
+	rule.Name = "link documents without metadata"
+	rule.Actions[0].Action = "documents_link"
+	addRule(suite.T(), suite.userHttp, rule, 400, "invalid rule: link documents without metadata")
+
+	rule.Name = "add to collection without name"
+	rule.Actions[0].Action = "collection_add"
+	value := rule.Actions[0].Value
+	rule.Actions[0].Value = " "
+	addRule(suite.T(), suite.userHttp, rule, 400, "invalid rule: add to collection without name")
+
+	rule.Name = "add to collection"
+	rule.Actions[0].Value = "invoices"
+	addRule(suite.T(), suite.userHttp, rule, 200, "valid rule: add to collection")
 
 	rule.Actions[0].Action = "name_set"
+	rule.Actions[0].Value = value
 
 	actions := rule.Actions
 	conditions := rule.Conditions
@@ -356,6 +379,126 @@ func (suite *RuleTestSuite) TestRuleTesting() {
 	assert.Equal(suite.T(), true, ruleTest.Match)
 }
 
+func (suite *RuleTestSuite) TestExportImportRules() {
+	rule := &api.Rule{
+		Name:    "exported rule",
+		Enabled: true,
+		Order:   1,
+		Mode:    "match_all",
+		Conditions: []api.RuleCondition{
+			{
+				Enabled:       true,
+				ConditionType: "name_contains",
+				Value:         "invoice",
+			},
+		},
+		Actions: []api.RuleAction{
+			{
+				Enabled: true,
+				Action:  "description_append",
+				Value:   "invoice",
+			},
+		},
+	}
+	addRule(suite.T(), suite.userHttp, rule, 200, "add rule")
+
+	export := exportRules(suite.T(), suite.userHttp, 200)
+	assert.Equal(suite.T(), models.RuleExportVersion, export.Version)
+	if assert.Len(suite.T(), export.Rules, 1) {
+		assert.Equal(suite.T(), "exported rule", export.Rules[0].Name)
+		assert.Equal(suite.T(), "match_all", export.Rules[0].Mode)
+	}
+
+	// admin does not have the metadata
+	export.Rules[0].Actions = append(export.Rules[0].Actions, models.RuleActionExport{
+		Enabled:       true,
+		Action:        "metadata_add",
+		MetadataKey:   "imported-key",
+		MetadataValue: "imported-value",
+	})
+	importRules(suite.T(), suite.adminHttp, export, nil, 400)
+	result := importRules(suite.T(), suite.adminHttp, export, map[string]string{"create_metadata": "1"}, 200)
+	assert.Equal(suite.T(), []string{"exported rule"}, result.Created)
+	assert.Equal(suite.T(), []string{"imported-key"}, result.KeysCreated)
+
+	// conflicting names
+	result = importRules(suite.T(), suite.adminHttp, export, nil, 200)
+	assert.Equal(suite.T(), []string{"exported rule"}, result.Skipped)
+	importRules(suite.T(), suite.adminHttp, export, map[string]string{"on_conflict": "fail"}, 400)
+	result = importRules(suite.T(), suite.adminHttp, export, map[string]string{"on_conflict": "overwrite"}, 200)
+	assert.Equal(suite.T(), []string{"exported rule"}, result.Updated)
+
+	rules := getRules(suite.T(), suite.adminHttp, 200, nil)
+	assert.Len(suite.T(), *rules, 1)
+
+	// overwritten rule keeps its order, even if another rule has the imported order
+	second := export.Rules[0]
+	second.Name = "second rule"
+	second.Order = 2
+	result = importRules(suite.T(), suite.adminHttp, &models.RulesExport{Version: export.Version, Rules: []models.RuleExport{second}}, nil, 200)
+	assert.Equal(suite.T(), []string{"second rule"}, result.Created)
+
+	export.Rules[0].Order = 2
+	export.Rules[0].Description = "overwritten"
+	result = importRules(suite.T(), suite.adminHttp, export, map[string]string{"on_conflict": "overwrite"}, 200)
+	assert.Equal(suite.T(), []string{"exported rule"}, result.Updated)
+
+	rules = getRules(suite.T(), suite.adminHttp, 200, nil)
+	if assert.Len(suite.T(), *rules, 2) {
+		assert.Equal(suite.T(), "exported rule", (*rules)[0].Name)
+		assert.Equal(suite.T(), "overwritten", (*rules)[0].Description)
+		assert.Equal(suite.T(), "second rule", (*rules)[1].Name)
+	}
+}
+
+func (suite *RuleTestSuite) TestRuleVersions() {
+	rule := &api.Rule{
+		Name:    "versioned rule",
+		Enabled: true,
+		Order:   1,
+		Mode:    "match_all",
+		Conditions: []api.RuleCondition{
+			{
+				Enabled:       true,
+				ConditionType: "name_contains",
+				Value:         "invoice",
+			},
+		},
+		Actions: []api.RuleAction{
+			{
+				Enabled: true,
+				Action:  "description_append",
+				Value:   "invoice",
+			},
+		},
+	}
+	created := addRule(suite.T(), suite.userHttp, rule, 200, "add rule")
+	assert.Equal(suite.T(), 1, created.Version)
+
+	rule.Id = created.Id
+	rule.Name = "versioned rule 2"
+	updated := updateRule(suite.T(), suite.userHttp, rule, 200, "update rule")
+	assert.Equal(suite.T(), 2, updated.Version)
+
+	versions := &[]api.RuleVersion{}
+	suite.userHttp.Get(fmt.Sprintf("/api/v1/processing/rules/%d/versions", rule.Id)).Expect(suite.T()).Json(suite.T(), versions).e.Status(200).Done()
+	if assert.Len(suite.T(), *versions, 2) {
+		assert.Equal(suite.T(), 2, (*versions)[0].Version)
+		assert.Equal(suite.T(), "versioned rule", (*versions)[1].Rule.Name)
+	}
+
+	changes := &[]models.RuleChange{}
+	suite.userHttp.Get(fmt.Sprintf("/api/v1/processing/rules/%d/versions/1/diff", rule.Id)).Expect(suite.T()).Json(suite.T(), changes).e.Status(200).Done()
+	assert.Equal(suite.T(), []models.RuleChange{{Field: "name", OldValue: "versioned rule", NewValue: "versioned rule 2"}}, *changes)
+
+	restored := &api.Rule{}
+	suite.userHttp.Post(fmt.Sprintf("/api/v1/processing/rules/%d/versions/1/restore", rule.Id)).Expect(suite.T()).Json(suite.T(), restored).e.Status(200).Done()
+	assert.Equal(suite.T(), "versioned rule", restored.Name)
+	assert.Equal(suite.T(), 3, restored.Version)
+
+	suite.adminHttp.Get(fmt.Sprintf("/api/v1/processing/rules/%d/versions/1", rule.Id)).Expect(suite.T()).e.Status(404).Done()
+}
+
 func addRule(t *testing.T, client *httpClient, rule *api.Rule, expectStatus int, name string) *api.Rule {
 	data := &api.Rule{}
 	req := client.Post("/api/v1/processing/rules").Json(t, rule).ExpectName(t, name, false)
@@ -407,6 +550,31 @@ func deleteRule(t *testing.T, client *httpClient, id int, wantHttpStatus int) {
 	req.Expect(t).e.Status(wantHttpStatus).Done()
 }
 
+func exportRules(t *testing.T, client *httpClient, wantHttpStatus int) *models.RulesExport {
+	req := client.Get("/api/v1/processing/rules/export")
+	dto := &models.RulesExport{}
+	if wantHttpStatus == 200 {
+		req.Expect(t).Json(t, dto).e.Status(200).Done()
+		return dto
+	}
+	req.Expect(t).e.Status(wantHttpStatus).Done()
+	return nil
+}
+
+func importRules(t *testing.T, client *httpClient, export *models.RulesExport, params map[string]string, wantHttpStatus int) *models.RuleImportResult {
+	req := client.Post("/api/v1/processing/rules/import").Json(t, export)
+	for k, v := range params {
+		req = req.SetQueryParam(k, v)
+	}
+	dto := &models.RuleImportResult{}
+	if wantHttpStatus == 200 {
+		req.Expect(t).Json(t, dto).e.Status(200).Done()
+		return dto
+	}
+	req.Expect(t).e.Status(wantHttpStatus).Done()
+	return nil
+}
+
 func testRule(t *testing.T, client *httpClient, ruleId int, docId string, wantHttpStatus int) *process.RuleTestResult {
 	req := client.Put(fmt.Sprintf("/api/v1/processing/rules/%d/test", ruleId)).Json(t, api.RuleTest{DocumentId: docId})
 	if wantHttpStatus == 200 {
@@ -0,0 +1,108 @@
+package integrationtest
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"tryffel.net/go/virtualpaper/api"
+)
+
+type SavedSearchSuite struct {
+	ApiTestSuite
+}
+
+func TestSavedSearches(t *testing.T) {
+	suite.Run(t, new(SavedSearchSuite))
+}
+
+func (suite *SavedSearchSuite) SetupTest() {
+	suite.Init()
+	clearDbDocumentTables(suite.T(), suite.db)
+	suite.db.Engine().MustExec("DELETE FROM saved_searches WHERE 1=1")
+}
+
+func (suite *SavedSearchSuite) TestCrud() {
+	invoices := addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{
+		Name:  "unpaid invoices",
+		Query: "type:invoice paid:false",
+		Sort:  "date",
+		Order: "desc",
+	}, 200)
+	assert.NotZero(suite.T(), invoices.Id)
+	assert.Equal(suite.T(), "date", invoices.Sort)
+	assert.Equal(suite.T(), "desc", invoices.Order)
+
+	receipts := addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{
+		Name:   "receipts",
+		Query:  "type:receipt",
+		Pinned: true,
+	}, 200)
+
+	// same name
+	addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{Name: "receipts", Query: "receipt"}, 400)
+
+	searches := getSavedSearches(suite.T(), suite.userHttp, 200)
+	assert.Len(suite.T(), searches, 2)
+	assert.Equal(suite.T(), receipts.Id, searches[0].Id, "pinned search is listed first")
+
+	// other users can't see the search
+	assert.Len(suite.T(), getSavedSearches(suite.T(), suite.adminHttp, 200), 0)
+	suite.adminHttp.Get(fmt.Sprintf("/api/v1/searches/%d", invoices.Id)).Expect(suite.T()).e.Status(404).Done()
+
+	updated := updateSavedSearch(suite.T(), suite.userHttp, invoices.Id, &api.SavedSearchRequest{
+		Name:   "invoices",
+		Query:  "type:invoice",
+		Digest: true,
+	}, 200)
+	assert.Equal(suite.T(), "invoices", updated.Name)
+	assert.True(suite.T(), updated.Digest)
+	assert.Equal(suite.T(), "asc", updated.Order)
+
+	count := &api.SavedSearchCountResponse{}
+	suite.userHttp.Get(fmt.Sprintf("/api/v1/searches/%d/count", invoices.Id)).Expect(suite.T()).Json(suite.T(), count).e.Status(200).Done()
+	assert.Equal(suite.T(), 0, count.Count)
+
+	suite.userHttp.Delete(fmt.Sprintf("/api/v1/searches/%d", invoices.Id)).Expect(suite.T()).e.Status(200).Done()
+	suite.userHttp.Delete(fmt.Sprintf("/api/v1/searches/%d", invoices.Id)).Expect(suite.T()).e.Status(404).Done()
+	assert.Len(suite.T(), getSavedSearches(suite.T(), suite.userHttp, 200), 1)
+}
+
+func (suite *SavedSearchSuite) TestInvalid() {
+	addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{Name: "", Query: "invoice"}, 400)
+	addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{Name: "sort", Query: "invoice", Sort: "owner"}, 400)
+	addSavedSearch(suite.T(), suite.userHttp, &api.SavedSearchRequest{Name: "query", Query: "(type:invoice"}, 400)
+}
+
+func addSavedSearch(t *testing.T, client *httpClient, search *api.SavedSearchRequest, wantHttpStatus int) *api.SavedSearchResponse {
+	req := client.Post("/api/v1/searches").Json(t, search)
+	if wantHttpStatus == 200 {
+		dto := &api.SavedSearchResponse{}
+		req.Expect(t).Json(t, dto).e.Status(200).Done()
+		return dto
+	}
+	req.Expect(t).e.Status(wantHttpStatus).Done()
+	return nil
+}
+
+func updateSavedSearch(t *testing.T, client *httpClient, id int, search *api.SavedSearchRequest, wantHttpStatus int) *api.SavedSearchResponse {
+	req := client.Put(fmt.Sprintf("/api/v1/searches/%d", id)).Json(t, search)
+	if wantHttpStatus == 200 {
+		dto := &api.SavedSearchResponse{}
+		req.Expect(t).Json(t, dto).e.Status(200).Done()
+		return dto
+	}
+	req.Expect(t).e.Status(wantHttpStatus).Done()
+	return nil
+}
+
+func getSavedSearches(t *testing.T, client *httpClient, wantHttpStatus int) []api.SavedSearchResponse {
+	req := client.Get("/api/v1/searches")
+	if wantHttpStatus == 200 {
+		dto := []api.SavedSearchResponse{}
+		req.Expect(t).Json(t, &dto).e.Status(200).Done()
+		return dto
+	}
+	req.Expect(t).e.Status(wantHttpStatus).Done()
+	return nil
+}
@@ -157,6 +157,25 @@ func (suite *DocumentSearchSuite) TestSearchByDate() {
 	assertDocInDocs(suite.T(), suite.docs["text-2"].Id, &docs, action)
 }
 
+func (suite *DocumentSearchSuite) TestSort() {
+	filter := map[string]string{"q": "lorem"}
+
+	docs := searchDocuments(suite.T(), suite.userHttp, filter, 1, 10, "date", "ASC", 200)
+	if assert.Equal(suite.T(), 2, len(docs)) {
+		assert.Equal(suite.T(), suite.docs["text-2"].Id, docs[0].Id, "oldest first")
+	}
+
+	docs = searchDocuments(suite.T(), suite.userHttp, filter, 1, 10, "date", "DESC", 200)
+	if assert.Equal(suite.T(), 2, len(docs)) {
+		assert.Equal(suite.T(), suite.docs["text-1"].Id, docs[0].Id, "newest first")
+	}
+
+	docs = searchDocuments(suite.T(), suite.userHttp, filter, 2, 1, "relevance", "DESC", 200)
+	assert.Equal(suite.T(), 1, len(docs), "second page")
+
+	searchDocuments(suite.T(), suite.userHttp, filter, 1, 10, "owner", "ASC", 400)
+}
+
 func waitIndexingReady(t *testing.T, client *httpClient, timeoutSec int) {
 	startTs := time.Now()
 	for {
@@ -184,7 +203,6 @@ func searchDocuments(t *testing.T, client *httpClient, filter interface{}, page
 	}
 
 	req := &httpRequest{client.Get("/api/v1/documents").
-		// TODO: sort probably works incorrectly
 		Sort(sort, order).Page(page, perPage).
 		req.
 		SetQuery("filter", string(b))}
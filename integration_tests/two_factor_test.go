@@ -0,0 +1,90 @@
+package integrationtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/h2non/baloo.v3"
+	"tryffel.net/go/virtualpaper/api"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type TwoFactorTest struct {
+	ApiTestSuite
+}
+
+func (suite *TwoFactorTest) SetupTest() {
+	suite.Init()
+	clearTestUsersTables(suite.T(), suite.db)
+	deleteAuthTokens(&suite.ApiTestSuite)
+}
+
+func (suite *TwoFactorTest) TearDownSuite() {
+	clearTestUsersTables(suite.T(), suite.db)
+	deleteAuthTokens(&suite.ApiTestSuite)
+	suite.ApiTestSuite.TearDownSuite()
+}
+
+func (suite *TwoFactorTest) TestEnableAndLogin() {
+	data := &api.AdminAddUserRequest{
+		UserName: "valid name",
+		Password: "passwordlongenough",
+		Active:   true,
+	}
+	AdminCreateUser(suite.T(), suite.adminHttp, data, 200)
+	token, _ := LoginRequest(suite.T(), data.UserName, data.Password, 200)
+	client := &httpClient{client: baloo.New(serverUrl).SetHeader("Authorization", "Bearer "+token)}
+
+	status := &api.TwoFactorStatusResponse{}
+	client.Get("/api/v1/auth/2fa").Expect(suite.T()).Json(suite.T(), status).e.Status(200).Done()
+	assert.False(suite.T(), status.Enabled)
+
+	setup := &api.TwoFactorSetupResponse{}
+	client.Post("/api/v1/auth/2fa/setup").Expect(suite.T()).Json(suite.T(), setup).e.Status(200).Done()
+	assert.NotEmpty(suite.T(), setup.Secret)
+	assert.Contains(suite.T(), setup.Uri, "otpauth://totp/")
+
+	// login does not require code before enrolment is verified
+	LoginRequest(suite.T(), data.UserName, data.Password, 200)
+
+	client.Post("/api/v1/auth/2fa/enable").Json(suite.T(), api.TwoFactorEnableRequest{Code: "000000"}).
+		Expect(suite.T()).e.Status(400).Done()
+
+	step := models.TotpStep(time.Now())
+	code, _ := models.TotpCode(setup.Secret, step)
+	codes := &api.RecoveryCodesResponse{}
+	client.Post("/api/v1/auth/2fa/enable").Json(suite.T(), api.TwoFactorEnableRequest{Code: code}).
+		Expect(suite.T()).Json(suite.T(), codes).e.Status(200).Done()
+	assert.Len(suite.T(), codes.RecoveryCodes, models.RecoveryCodesCount)
+
+	LoginRequest(suite.T(), data.UserName, data.Password, 401)
+	LoginWithCodeRequest(suite.T(), data.UserName, data.Password, "000000", 401)
+	// code has already been used
+	LoginWithCodeRequest(suite.T(), data.UserName, data.Password, code, 401)
+
+	nextCode, _ := models.TotpCode(setup.Secret, step+1)
+	LoginWithCodeRequest(suite.T(), data.UserName, data.Password, nextCode, 200)
+
+	LoginWithCodeRequest(suite.T(), data.UserName, data.Password, codes.RecoveryCodes[0], 200)
+	LoginWithCodeRequest(suite.T(), data.UserName, data.Password, codes.RecoveryCodes[0], 401)
+
+	client.Get("/api/v1/auth/2fa").Expect(suite.T()).Json(suite.T(), status).e.Status(200).Done()
+	assert.True(suite.T(), status.Enabled)
+	assert.Equal(suite.T(), models.RecoveryCodesCount-1, status.RecoveryCodesRemaining)
+
+	// confirmation requires the code too
+	client.Post("/api/v1/auth/confirm").Json(suite.T(), api.AuthConfirmationRequest{Password: data.Password}).
+		Expect(suite.T()).e.Status(403).Done()
+	client.Post("/api/v1/auth/confirm").
+		Json(suite.T(), api.AuthConfirmationRequest{Password: data.Password, Code: codes.RecoveryCodes[1]}).
+		Expect(suite.T()).e.Status(200).Done()
+
+	client.Delete("/api/v1/auth/2fa").Expect(suite.T()).e.Status(200).Done()
+	LoginRequest(suite.T(), data.UserName, data.Password, 200)
+}
+
+func TestTwoFactor(t *testing.T) {
+	suite.Run(t, new(TwoFactorTest))
+}
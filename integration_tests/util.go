@@ -29,3 +29,25 @@ func getDocumentWithVisit(t *testing.T, client *httpClient, id string, wantHttpS
 		return nil
 	}
 }
+
+func getTrashedDocuments(t *testing.T, client *httpClient, wantHttpStatus int) *[]api.DocumentResponse {
+	docs := &[]api.DocumentResponse{}
+	req := client.Get("/api/v1/documents/trash").Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, docs).e.Status(200).Done()
+		return docs
+	}
+	req.e.Status(wantHttpStatus).Done()
+	return nil
+}
+
+func restoreDocument(t *testing.T, client *httpClient, id string, wantHttpStatus int) *api.DocumentResponse {
+	doc := &api.DocumentResponse{}
+	req := client.Post("/api/v1/documents/" + id + "/restore").Expect(t)
+	if wantHttpStatus == 200 {
+		req.Json(t, doc).e.Status(200).Done()
+		return doc
+	}
+	req.e.Status(wantHttpStatus).Done()
+	return nil
+}
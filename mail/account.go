@@ -0,0 +1,56 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mail
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// VerifyEmail sends the link that confirms user's new email address.
+func VerifyEmail(email string, token string, tokenId int) {
+	textFmt := `Verify email address for Virtualpaper
+
+Someone requested changing the email address of a Virtualpaper account to this address.
+To confirm the change click the link: %s
+If you did not request the change, no further actions are required.
+`
+
+	link := fmt.Sprintf("%s/#/auth/verify-email?token=%s&id=%d", config.C.Api.PublicUrl, token, tokenId)
+	text := fmt.Sprintf(textFmt, link)
+
+	err := SendMail("Verify email address for Virtualpaper", text, email)
+	if err != nil {
+		logrus.Errorf("send email verification for %s: %v", email, err)
+		return
+	}
+	logrus.Infof("email verification link sent for email %s", email)
+}
+
+// EmailChanged notifies the old address that account's email has been changed.
+func EmailChanged(oldEmail, newEmail string) error {
+	text := fmt.Sprintf(`Email address changed for Virtualpaper
+
+The email address of your Virtualpaper account has been changed to %s.
+If you did not make this change, contact your administrator.
+`, newEmail)
+	return SendMail("Email address changed for Virtualpaper", text, oldEmail)
+}
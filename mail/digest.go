@@ -0,0 +1,54 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mail
+
+import (
+	"fmt"
+	"strings"
+
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// DigestDocument is a document listed in saved search digest.
+type DigestDocument struct {
+	Id   string
+	Name string
+}
+
+// SearchDigest contains documents that newly match a saved search.
+type SearchDigest struct {
+	Name      string
+	Query     string
+	Documents []DigestDocument
+}
+
+// SendSearchDigest sends a mail listing new documents for each of the user's saved searches.
+func SendSearchDigest(email string, digests []SearchDigest) error {
+	text := &strings.Builder{}
+	text.WriteString("Daily digest of saved searches in Virtualpaper\n")
+	count := 0
+	for _, digest := range digests {
+		count += len(digest.Documents)
+		fmt.Fprintf(text, "\n%s (%s): %d new documents\n", digest.Name, digest.Query, len(digest.Documents))
+		for _, doc := range digest.Documents {
+			fmt.Fprintf(text, "- %s: %s/#/documents/%s/show\n", doc.Name, config.C.Api.PublicUrl, doc.Id)
+		}
+	}
+	return SendMail(fmt.Sprintf("%d new documents in saved searches", count), text.String(), email)
+}
@@ -0,0 +1,36 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mail
+
+import (
+	"fmt"
+
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// ProcessingFailed notifies the owner of the document that processing the document failed.
+func ProcessingFailed(email string, documentId string, documentName string, reason string) error {
+	text := fmt.Sprintf(`Processing document failed in Virtualpaper
+
+Document: %s
+Reason: %s
+Link: %s/#/documents/%s/show
+`, documentName, reason, config.C.Api.PublicUrl, documentId)
+	return SendMail("Processing document failed", text, email)
+}
@@ -0,0 +1,147 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// Scopes of personal access tokens. Scope 'resource:*' grants all scopes of the resource.
+const (
+	ScopeDocumentsRead   = "documents:read"
+	ScopeDocumentsWrite  = "documents:write"
+	ScopeDocumentsUpload = "documents:upload"
+	ScopeMetadataRead    = "metadata:read"
+	ScopeMetadataWrite   = "metadata:write"
+	ScopeRulesRead       = "rules:read"
+	ScopeRulesWrite      = "rules:write"
+	ScopeAdmin           = "admin:*"
+)
+
+var AllTokenScopes = []string{
+	ScopeDocumentsRead,
+	ScopeDocumentsWrite,
+	ScopeDocumentsUpload,
+	ScopeMetadataRead,
+	ScopeMetadataWrite,
+	ScopeRulesRead,
+	ScopeRulesWrite,
+	ScopeAdmin,
+}
+
+// ApiTokenPrefix starts every personal access token, which distinguishes them from login tokens.
+const ApiTokenPrefix = "vp_"
+
+// TokenScopes is a list of scopes, stored as comma-separated text.
+type TokenScopes []string
+
+func (s TokenScopes) Value() (driver.Value, error) {
+	return strings.Join(s, ","), nil
+}
+
+func (s *TokenScopes) Scan(src interface{}) error {
+	text, ok := src.(string)
+	if !ok {
+		if src != nil {
+			return fmt.Errorf("unknown type: %v", src)
+		}
+	}
+	*s = TokenScopes{}
+	if text != "" {
+		*s = strings.Split(text, ",")
+	}
+	return nil
+}
+
+// ApiToken is a long-lived personal access token for scripts and integrations.
+// Only hash of the token is stored, and the token is shown to user only when it is created.
+type ApiToken struct {
+	Id     int    `db:"id"`
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	// Prefix is the beginning of the token that helps user to recognize the token.
+	Prefix string      `db:"prefix"`
+	Hash   string      `db:"token_hash"`
+	Scopes TokenScopes `db:"scopes"`
+	// ExpiresAt is null if token does not expire.
+	ExpiresAt  *time.Time `db:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	LastUsedIp string     `db:"last_used_ip"`
+	Timestamp
+}
+
+// Init generates a new token and returns it. Token cannot be recovered afterwards.
+func (t *ApiToken) Init() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("generate token: %v", err)
+	}
+	token := ApiTokenPrefix + hex.EncodeToString(bytes)
+	t.Prefix = token[:len(ApiTokenPrefix)+6]
+	t.Hash = HashApiToken(token)
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+	return token, nil
+}
+
+// HashApiToken returns the hash that token is stored with.
+func HashApiToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (t *ApiToken) HasExpired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+// HasScope returns true if token grants the scope.
+func (t *ApiToken) HasScope(scope string) bool {
+	resource := strings.Split(scope, ":")[0]
+	for _, v := range t.Scopes {
+		if v == scope || v == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTokenScopes checks that there is at least one scope and all scopes exist.
+func ValidateTokenScopes(scopes []string) error {
+	e := errors.ErrInvalid
+	if len(scopes) == 0 {
+		e.ErrMsg = "token must have at least one scope"
+		return e
+	}
+	for _, v := range scopes {
+		if !containsString(AllTokenScopes, v) {
+			e.ErrMsg = "unknown scope: " + v
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApiToken_Init(t *testing.T) {
+	token := &ApiToken{}
+	key, err := token.Init()
+	if err != nil {
+		t.Fatalf("init token: %v", err)
+	}
+	if !strings.HasPrefix(key, ApiTokenPrefix) {
+		t.Errorf("token does not start with prefix: %s", key)
+	}
+	if !strings.HasPrefix(key, token.Prefix) {
+		t.Errorf("token prefix does not match: %s, %s", token.Prefix, key)
+	}
+	if token.Hash != HashApiToken(key) || token.Hash == key {
+		t.Errorf("token hash does not match")
+	}
+
+	other := &ApiToken{}
+	otherKey, _ := other.Init()
+	if otherKey == key {
+		t.Errorf("tokens are not unique")
+	}
+}
+
+func TestApiToken_HasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes TokenScopes
+		scope  string
+		want   bool
+	}{
+		{"exact", TokenScopes{ScopeDocumentsRead}, ScopeDocumentsRead, true},
+		{"other scope", TokenScopes{ScopeDocumentsRead}, ScopeDocumentsWrite, false},
+		{"other resource", TokenScopes{ScopeMetadataRead, ScopeRulesWrite}, ScopeDocumentsRead, false},
+		{"wildcard", TokenScopes{"documents:*"}, ScopeDocumentsUpload, true},
+		{"admin", TokenScopes{ScopeAdmin}, ScopeAdmin, true},
+		{"admin does not grant others", TokenScopes{ScopeAdmin}, ScopeDocumentsRead, false},
+		{"no scopes", TokenScopes{}, ScopeDocumentsRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &ApiToken{Scopes: tt.scopes}
+			if got := token.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenScopes_Scan(t *testing.T) {
+	scopes := TokenScopes{}
+	if err := scopes.Scan("documents:read,metadata:write"); err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 2 || scopes[0] != ScopeDocumentsRead || scopes[1] != ScopeMetadataWrite {
+		t.Errorf("invalid scopes: %v", scopes)
+	}
+	if err := scopes.Scan(""); err != nil || len(scopes) != 0 {
+		t.Errorf("empty scopes: %v, %v", scopes, err)
+	}
+}
+
+func TestValidateTokenScopes(t *testing.T) {
+	if err := ValidateTokenScopes([]string{ScopeDocumentsRead, ScopeAdmin}); err != nil {
+		t.Errorf("valid scopes: %v", err)
+	}
+	if err := ValidateTokenScopes(nil); err == nil {
+		t.Errorf("empty scopes must fail")
+	}
+	if err := ValidateTokenScopes([]string{"documents:delete"}); err == nil {
+		t.Errorf("unknown scope must fail")
+	}
+}
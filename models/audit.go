@@ -0,0 +1,104 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit log actions.
+const (
+	AuditLogin                 = "auth.login"
+	AuditConfirmAuthentication = "auth.confirm"
+	AuditPasswordResetRequest  = "auth.password_reset_request"
+	AuditPasswordReset         = "auth.password_reset"
+	AuditPasswordChange        = "auth.password_change"
+	AuditEmailChangeRequest    = "auth.email_change_request"
+	AuditEmailChange           = "auth.email_change"
+	AuditApiTokenAdd           = "auth.api_token_add"
+	AuditApiTokenDelete        = "auth.api_token_delete"
+	AuditTwoFactorEnable       = "auth.2fa_enable"
+	AuditTwoFactorDisable      = "auth.2fa_disable"
+	AuditTwoFactorRecovery     = "auth.2fa_recovery_codes"
+	AuditSessionRevoke         = "auth.session_revoke"
+	AuditAdminAddUser          = "admin.user_add"
+	AuditAdminUpdateUser       = "admin.user_update"
+	AuditAdminDeleteUser       = "admin.user_delete"
+	AuditAdminDeleteUserDone   = "admin.user_delete_done"
+	AuditAdminImpersonate      = "admin.user_impersonate"
+	AuditAdminResetTwoFactor   = "admin.user_2fa_reset"
+	AuditAdminRevokeSession    = "admin.user_session_revoke"
+	AuditAdminAddGroup         = "admin.group_add"
+	AuditAdminUpdateGroup      = "admin.group_update"
+	AuditAdminDeleteGroup      = "admin.group_delete"
+	AuditRuleAdd               = "rule.add"
+	AuditRuleUpdate            = "rule.update"
+	AuditRuleDelete            = "rule.delete"
+	AuditRuleRestore           = "rule.restore"
+	AuditRuleImport            = "rule.import"
+	AuditMetadataKeyDelete     = "metadata.key_delete"
+	AuditMetadataValueDelete   = "metadata.value_delete"
+	AuditDocumentBulkEdit      = "document.bulk_edit"
+	AuditDocumentDelete        = "document.delete"
+	AuditDocumentDownload      = "document.download"
+	AuditDocumentShare         = "document.share"
+	AuditDocumentUnshare       = "document.unshare"
+)
+
+// AuditEvent is a single entry in the audit log. Old and new values are stored as json.
+type AuditEvent struct {
+	Id        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	// ActorId is null if actor is not known, e.g. failed login with unknown username.
+	ActorId    *int    `db:"actor_id"`
+	ActorName  string  `db:"actor_name"`
+	Action     string  `db:"action"`
+	Resource   string  `db:"resource"`
+	ResourceId string  `db:"resource_id"`
+	Success    bool    `db:"success"`
+	IpAddr     string  `db:"ip_address"`
+	RequestId  string  `db:"request_id"`
+	OldValue   *string `db:"old_value"`
+	NewValue   *string `db:"new_value"`
+	Message    string  `db:"message"`
+}
+
+// SetValues stores old and new values as json. Nil values are not stored.
+func (e *AuditEvent) SetValues(oldValue, newValue interface{}) error {
+	var err error
+	e.OldValue, err = auditJson(oldValue)
+	if err != nil {
+		return err
+	}
+	e.NewValue, err = auditJson(newValue)
+	return err
+}
+
+func auditJson(value interface{}) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	return &text, nil
+}
@@ -0,0 +1,40 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import "testing"
+
+func TestAuditEvent_SetValues(t *testing.T) {
+	event := &AuditEvent{}
+	err := event.SetValues(nil, map[string]string{"name": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.OldValue != nil {
+		t.Errorf("old value should be nil, got %s", *event.OldValue)
+	}
+	if event.NewValue == nil || *event.NewValue != `{"name":"test"}` {
+		t.Errorf("unexpected new value: %v", event.NewValue)
+	}
+
+	err = event.SetValues(func() {}, nil)
+	if err == nil {
+		t.Errorf("expected error for value that cannot be serialized")
+	}
+}
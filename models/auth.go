@@ -17,6 +17,8 @@ type Token struct {
 	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
 	LastSeen      time.Time `json:"last_seen" db:"last_seen"`
 	LastConfirmed time.Time `json:"last_confirmed" db:"last_confirmed"`
+	// ImpersonatorId is the administrator that uses the session to view the service as the user.
+	ImpersonatorId *int `json:"impersonator_id" db:"impersonator_id"`
 }
 
 func (t *Token) Init() error {
@@ -42,6 +44,11 @@ func (t *Token) HasExpired() bool {
 	return t.ExpiresAt.Before(time.Now())
 }
 
+// IsImpersonation returns true if the session is used by an administrator to view the service as the user.
+func (t *Token) IsImpersonation() bool {
+	return t.ImpersonatorId != nil
+}
+
 func (t *Token) ConfirmationExpired() bool {
 	if t.LastConfirmed.IsZero() {
 		return true
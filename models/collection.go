@@ -0,0 +1,32 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+// Collection is a named group of user's documents. Documents are added to collections by processing rules.
+type Collection struct {
+	Id     int    `db:"id"`
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	// DocumentCount is the number of documents in the collection, excluding trashed documents.
+	DocumentCount int `db:"document_count"`
+	Timestamp
+}
+
+// MaxCollectionNameLength is the maximum length of collection name.
+const MaxCollectionNameLength = 100
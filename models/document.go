@@ -47,10 +47,20 @@ type Document struct {
 	Date        time.Time `db:"date"`
 	Metadata    []Metadata
 	Tags        []Tag
+	// SearchMatch is set when document is a search result.
+	SearchMatch *DocumentSearchMatch
 
 	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
+// DocumentSearchMatch tells which part of the document content matched the search query.
+type DocumentSearchMatch struct {
+	// Chunk is the index of the matched content chunk, starting from 0.
+	Chunk int `json:"chunk"`
+	// Page is the page where matched chunk starts, starting from 1.
+	Page int `json:"page"`
+}
+
 // Init initializes new document. It ensures document has valid uuid assigned to it.
 func (d *Document) Init() {
 	if d.Id == "" {
@@ -142,6 +152,27 @@ type Metadata struct {
 	Key     string `db:"key" json:"key"`
 	ValueId int    `db:"value_id" json:"value_id"`
 	Value   string `db:"value" json:"value"`
+	// ValueType is the value type of the key.
+	ValueType MetadataValueType `db:"value_type" json:"value_type,omitempty"`
+	// Path is the value prefixed with its parent values, e.g. 'acme/website/invoices'.
+	Path string `db:"path" json:"path,omitempty"`
+}
+
+// MetadataPathSeparator separates parent values from their children in value paths.
+const MetadataPathSeparator = "/"
+
+// Paths returns the path of the value and the paths of all its ancestors, starting from the top-level value.
+// E.g. path 'acme/website' returns ['acme', 'acme/website']. If path is not set, value is returned.
+func (m Metadata) Paths() []string {
+	if m.Path == "" {
+		return []string{m.Value}
+	}
+	parts := strings.Split(m.Path, MetadataPathSeparator)
+	paths := make([]string, len(parts))
+	for i := range parts {
+		paths[i] = strings.Join(parts[:i+1], MetadataPathSeparator)
+	}
+	return paths
 }
 
 type MetadataKey struct {
@@ -150,6 +181,10 @@ type MetadataKey struct {
 	Key       string    `db:"key" json:"key"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	Comment   string    `db:"comment" json:"comment"`
+	// SingleValue allows only one value of the key to be matched automatically for a document.
+	SingleValue bool `db:"single_value" json:"single_value"`
+	// ValueType restricts values of the key, see MetadataValueType.
+	ValueType MetadataValueType `db:"value_type" json:"value_type"`
 }
 
 func MetadataDiff(id string, userId int, original, updated *[]Metadata) []DocumentHistory {
@@ -245,6 +280,37 @@ type MetadataValue struct {
 	MatchDocuments bool             `db:"match_documents" json:"match_documents"`
 	MatchType      MetadataRuleType `db:"match_type" json:"match_type"`
 	MatchFilter    string           `db:"match_filter" json:"match_filter"`
+
+	// KeySingleValue is MetadataKey.SingleValue of the value's key.
+	KeySingleValue bool `db:"key_single_value" json:"-"`
+
+	// ParentId is the parent value under the same key. Top-level values have no parent.
+	ParentId IntId `db:"parent_id" json:"parent_id"`
+	// Path is the value prefixed with its parent values, e.g. 'acme/website/invoices'.
+	Path string `db:"path" json:"path"`
+	// Children are only set when values are returned as a tree.
+	Children []*MetadataValue `db:"-" json:"children,omitempty"`
+}
+
+// MetadataValueTree arranges values to a tree by their parents and returns the top-level values.
+// Values whose parent is not in values are returned as top-level values.
+func MetadataValueTree(values []MetadataValue) []*MetadataValue {
+	byId := make(map[int]*MetadataValue, len(values))
+	for i := range values {
+		values[i].Children = []*MetadataValue{}
+		byId[values[i].Id] = &values[i]
+	}
+	roots := []*MetadataValue{}
+	for i := range values {
+		value := &values[i]
+		parent, ok := byId[int(value.ParentId)]
+		if ok && parent != value {
+			parent.Children = append(parent.Children, value)
+		} else {
+			roots = append(roots, value)
+		}
+	}
+	return roots
 }
 
 func (m *MetadataValue) Update() {}
@@ -271,6 +337,9 @@ type DocumentHistory struct {
 	UserId     int       `db:"user_id" json:"user_id"`
 	User       string    `db:"user" json:"user"`
 	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	// RuleId and RuleVersion are set if the change was made by a processing rule.
+	RuleId      IntId `db:"rule_id" json:"rule_id"`
+	RuleVersion IntId `db:"rule_version" json:"rule_version"`
 }
 
 func (dh *DocumentHistory) Update() {}
@@ -326,6 +395,12 @@ func (d *Document) Diff(newDocument *Document, userId int) ([]DocumentHistory, e
 	if d.Content != d2.Content {
 		addHistoryItem("content", d.Content, d2.Content)
 	}
+
+	if !d.DeletedAt.Valid && d2.DeletedAt.Valid {
+		addHistoryItem("trash", "", strconv.Itoa(int(d2.DeletedAt.Time.Unix())))
+	} else if d.DeletedAt.Valid && !d2.DeletedAt.Valid {
+		addHistoryItem("restore", strconv.Itoa(int(d.DeletedAt.Time.Unix())), "")
+	}
 	return history, nil
 }
 
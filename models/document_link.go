@@ -0,0 +1,117 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DocumentLinkMaxDuration is the longest time a public link can be valid for.
+const DocumentLinkMaxDuration = time.Hour * 24 * 365
+
+// Actions of document link access log.
+const (
+	LinkAccessView     = "view"
+	LinkAccessPreview  = "preview"
+	LinkAccessDownload = "download"
+)
+
+// DocumentLink is a public link that gives access to a single document without authentication.
+type DocumentLink struct {
+	Id         int    `db:"id"`
+	DocumentId string `db:"document_id"`
+	// UserId is the user who created the link.
+	UserId int `db:"user_id"`
+	// Prefix is the beginning of the token that helps user to recognize the link.
+	Prefix string `db:"prefix"`
+	Hash   string `db:"token_hash"`
+	// PasswordHash is empty if link is not password protected.
+	PasswordHash string    `db:"password_hash"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	// MaxDownloads is null if downloads are not limited.
+	MaxDownloads   *int       `db:"max_downloads"`
+	DownloadCount  int        `db:"download_count"`
+	LastAccessedAt *time.Time `db:"last_accessed_at"`
+	Timestamp
+}
+
+// Init generates a new link token and returns it. Token cannot be recovered afterwards.
+func (l *DocumentLink) Init() (string, error) {
+	bytes := make([]byte, 24)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("generate token: %v", err)
+	}
+	token := hex.EncodeToString(bytes)
+	l.Prefix = token[:6]
+	l.Hash = HashApiToken(token)
+	l.CreatedAt = time.Now()
+	l.UpdatedAt = l.CreatedAt
+	return token, nil
+}
+
+// SetPassword protects the link with password.
+func (l *DocumentLink) SetPassword(password string) error {
+	if len(password) > 150 {
+		return fmt.Errorf("password must be maximum of 150 characters")
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	l.PasswordHash = string(bytes)
+	return nil
+}
+
+func (l *DocumentLink) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+// PasswordMatches returns true if link has no password or the password matches.
+func (l *DocumentLink) PasswordMatches(password string) bool {
+	if !l.HasPassword() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(password)) == nil
+}
+
+func (l *DocumentLink) HasExpired() bool {
+	return l.ExpiresAt.Before(time.Now())
+}
+
+// DownloadsLeft returns false if the link has reached its download limit.
+func (l *DocumentLink) DownloadsLeft() bool {
+	return l.MaxDownloads == nil || l.DownloadCount < *l.MaxDownloads
+}
+
+// DocumentLinkAccess is a single access to a public link.
+type DocumentLinkAccess struct {
+	Id        int       `db:"id"`
+	LinkId    int       `db:"link_id"`
+	Action    string    `db:"action"`
+	Success   bool      `db:"success"`
+	IpAddr    string    `db:"ip_address"`
+	UserAgent string    `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+}
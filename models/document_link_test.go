@@ -0,0 +1,68 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDocumentLink(t *testing.T) {
+	link := &DocumentLink{ExpiresAt: time.Now().Add(time.Hour)}
+	token, err := link.Init()
+	if err != nil {
+		t.Fatalf("init link: %v", err)
+	}
+	if len(token) != 48 || link.Prefix != token[:6] {
+		t.Errorf("invalid token '%s' or prefix '%s'", token, link.Prefix)
+	}
+	if link.Hash != HashApiToken(token) {
+		t.Errorf("token hash does not match")
+	}
+	if link.HasExpired() {
+		t.Errorf("link should not be expired")
+	}
+	if !link.DownloadsLeft() {
+		t.Errorf("link without limit should have downloads left")
+	}
+
+	if link.HasPassword() || !link.PasswordMatches("") {
+		t.Errorf("link without password should match any password")
+	}
+	if err := link.SetPassword("secret"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if !link.HasPassword() || link.PasswordMatches("") || link.PasswordMatches("wrong") {
+		t.Errorf("password should not match")
+	}
+	if !link.PasswordMatches("secret") {
+		t.Errorf("password should match")
+	}
+
+	max := 2
+	link.MaxDownloads = &max
+	link.DownloadCount = 2
+	if link.DownloadsLeft() {
+		t.Errorf("download limit reached")
+	}
+	link.ExpiresAt = time.Now().Add(-time.Minute)
+	if !link.HasExpired() {
+		t.Errorf("link should be expired")
+	}
+}
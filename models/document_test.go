@@ -176,3 +176,29 @@ func TestDocument_Metadata_Diff(t *testing.T) {
 		})
 	}
 }
+
+func TestMetadata_Paths(t *testing.T) {
+	assert.Equal(t, []string{"acme"}, Metadata{Value: "acme"}.Paths())
+	assert.Equal(t, []string{"acme", "acme/website", "acme/website/invoices"},
+		Metadata{Value: "invoices", Path: "acme/website/invoices"}.Paths())
+}
+
+func TestMetadataValueTree(t *testing.T) {
+	values := []MetadataValue{
+		{Id: 1, Value: "acme"},
+		{Id: 2, Value: "website", ParentId: 1},
+		{Id: 3, Value: "invoices", ParentId: 2},
+		{Id: 4, Value: "orphan", ParentId: 10},
+		{Id: 5, Value: "blog", ParentId: 1},
+	}
+	roots := MetadataValueTree(values)
+	if assert.Len(t, roots, 2) {
+		assert.Equal(t, "acme", roots[0].Value)
+		assert.Equal(t, "orphan", roots[1].Value)
+		if assert.Len(t, roots[0].Children, 2) {
+			assert.Equal(t, "website", roots[0].Children[0].Value)
+			assert.Equal(t, "blog", roots[0].Children[1].Value)
+			assert.Equal(t, "invoices", roots[0].Children[0].Children[0].Value)
+		}
+	}
+}
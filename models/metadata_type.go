@@ -0,0 +1,177 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// MetadataValueType defines how values of a metadata key are validated, indexed and compared.
+// Values of typed keys are stored as text in canonical form and indexed as numbers.
+type MetadataValueType string
+
+const (
+	MetadataValueText    MetadataValueType = "text"
+	MetadataValueNumber  MetadataValueType = "number"
+	MetadataValueDate    MetadataValueType = "date"
+	MetadataValueMoney   MetadataValueType = "money"
+	MetadataValueBoolean MetadataValueType = "boolean"
+)
+
+var AllMetadataValueTypes = []MetadataValueType{
+	MetadataValueText,
+	MetadataValueNumber,
+	MetadataValueDate,
+	MetadataValueMoney,
+	MetadataValueBoolean,
+}
+
+// MetadataDateLayout is the format of date values.
+const MetadataDateLayout = "2006-01-02"
+
+func (t MetadataValueType) String() string {
+	return string(t)
+}
+
+func (t MetadataValueType) Valid() bool {
+	for _, v := range AllMetadataValueTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Typed returns true if values are compared as numbers instead of text.
+func (t MetadataValueType) Typed() bool {
+	return t != "" && t != MetadataValueText
+}
+
+var moneySymbols = map[string]string{
+	"€": "EUR",
+	"$": "USD",
+	"£": "GBP",
+}
+
+var regexMoney = regexp.MustCompile(`^([A-Za-z]{3}|[€$£])?\s*(-?[\d\s.,]+?)\s*([A-Za-z]{3}|[€$£])?$`)
+
+// parseNumber accepts both '.' and ',' as decimal separator and ignores spaces between digits.
+// If both separators are present, the last one is the decimal separator and the other one
+// separates thousands, e.g. '1.234,56' and '1,234.56'. A separator that repeats alone is a
+// thousands separator, e.g. '1.234.567'.
+func parseNumber(value string) (float64, bool) {
+	value = strings.ReplaceAll(value, " ", "")
+	decimal, thousands := ".", ","
+	if strings.LastIndex(value, ",") > strings.LastIndex(value, ".") {
+		decimal, thousands = ",", "."
+	}
+	if strings.Count(value, decimal) > 1 {
+		if strings.Contains(value, thousands) {
+			// e.g. '1,234,56.7'
+			return 0, false
+		}
+		decimal, thousands = "", decimal
+	}
+	value = strings.ReplaceAll(value, thousands, "")
+	if decimal != "" {
+		value = strings.Replace(value, decimal, ".", 1)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil && !math.IsInf(number, 0) && !math.IsNaN(number)
+}
+
+// parseValue returns value as a number and in canonical text form.
+func (t MetadataValueType) parseValue(value string) (float64, string, bool) {
+	value = strings.TrimSpace(value)
+	switch t {
+	case MetadataValueNumber:
+		number, ok := parseNumber(value)
+		return number, strconv.FormatFloat(number, 'f', -1, 64), ok
+	case MetadataValueMoney:
+		match := regexMoney.FindStringSubmatch(value)
+		if match == nil || (match[1] != "" && match[3] != "") {
+			return 0, "", false
+		}
+		amount, ok := parseNumber(match[2])
+		currency := strings.ToUpper(match[1] + match[3])
+		if code, found := moneySymbols[currency]; found {
+			currency = code
+		}
+		text := strconv.FormatFloat(amount, 'f', 2, 64)
+		if currency != "" {
+			text += " " + currency
+		}
+		return amount, text, ok
+	case MetadataValueDate:
+		date, err := time.Parse(MetadataDateLayout, value)
+		return float64(date.Unix()), date.Format(MetadataDateLayout), err == nil
+	case MetadataValueBoolean:
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			return 1, "true", true
+		case "false", "no", "0":
+			return 0, "false", true
+		}
+		return 0, "", false
+	default:
+		return 0, value, true
+	}
+}
+
+// NormalizeValue validates the value and returns it in canonical form, e.g. money as '120.50 EUR'.
+func (t MetadataValueType) NormalizeValue(value string) (string, error) {
+	_, text, ok := t.parseValue(value)
+	if !ok {
+		return "", t.invalidValue(value)
+	}
+	return text, nil
+}
+
+// NumericValue returns the value as a number. Dates are seconds since epoch at midnight UTC,
+// same as document date, booleans are 1 or 0 and money is the amount without currency.
+func (t MetadataValueType) NumericValue(value string) (float64, error) {
+	if !t.Typed() {
+		return 0, t.invalidValue(value)
+	}
+	number, _, ok := t.parseValue(value)
+	if !ok {
+		return 0, t.invalidValue(value)
+	}
+	return number, nil
+}
+
+func (t MetadataValueType) invalidValue(value string) error {
+	e := errors.ErrInvalid
+	switch t {
+	case MetadataValueDate:
+		e.ErrMsg = fmt.Sprintf("invalid date '%s', must be in format YYYY-MM-DD", value)
+	case MetadataValueText, "":
+		e.ErrMsg = fmt.Sprintf("text value '%s' cannot be compared as number", value)
+	default:
+		e.ErrMsg = fmt.Sprintf("invalid %s '%s'", t, value)
+	}
+	return e
+}
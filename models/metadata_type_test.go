@@ -0,0 +1,96 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetadataValueType_NormalizeValue(t *testing.T) {
+	tests := []struct {
+		valueType MetadataValueType
+		value     string
+		want      string
+		wantErr   bool
+	}{
+		{MetadataValueText, "any text", "any text", false},
+		{MetadataValueNumber, "12", "12", false},
+		{MetadataValueNumber, "12,5", "12.5", false},
+		{MetadataValueNumber, "1 200.25", "1200.25", false},
+		{MetadataValueNumber, "1.234,56", "1234.56", false},
+		{MetadataValueNumber, "1,234.56", "1234.56", false},
+		{MetadataValueNumber, "1.234.567", "1234567", false},
+		{MetadataValueNumber, "1,234,567.5", "1234567.5", false},
+		{MetadataValueNumber, "1.234,56.7", "", true},
+		{MetadataValueNumber, "1,2,3.4.5", "", true},
+		{MetadataValueNumber, "inf", "", true},
+		{MetadataValueNumber, "twelve", "", true},
+		{MetadataValueMoney, "120", "120.00", false},
+		{MetadataValueMoney, "120,5 eur", "120.50 EUR", false},
+		{MetadataValueMoney, "€ 12.30", "12.30 EUR", false},
+		{MetadataValueMoney, "$1,200.99", "1200.99 USD", false},
+		{MetadataValueMoney, "1.234,56 €", "1234.56 EUR", false},
+		{MetadataValueMoney, "EUR 1.234.567,8", "1234567.80 EUR", false},
+		{MetadataValueMoney, "EUR 12 USD", "", true},
+		{MetadataValueMoney, "a lot", "", true},
+		{MetadataValueDate, "2023-01-31", "2023-01-31", false},
+		{MetadataValueDate, "31.1.2023", "", true},
+		{MetadataValueBoolean, "Yes", "true", false},
+		{MetadataValueBoolean, "0", "false", false},
+		{MetadataValueBoolean, "maybe", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.valueType)+" "+tt.value, func(t *testing.T) {
+			got, err := tt.valueType.NormalizeValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataValueType_NumericValue(t *testing.T) {
+	tests := []struct {
+		valueType MetadataValueType
+		value     string
+		want      float64
+		wantErr   bool
+	}{
+		{MetadataValueNumber, "-3.5", -3.5, false},
+		{MetadataValueMoney, "99.90 EUR", 99.9, false},
+		{MetadataValueDate, "2023-06-14", float64(time.Date(2023, 6, 14, 0, 0, 0, 0, time.UTC).Unix()), false},
+		{MetadataValueBoolean, "true", 1, false},
+		{MetadataValueText, "12", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.valueType)+" "+tt.value, func(t *testing.T) {
+			got, err := tt.valueType.NumericValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NumericValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NumericValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,9 +19,12 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"tryffel.net/go/virtualpaper/errors"
 )
@@ -68,6 +71,8 @@ type Rule struct {
 	Enabled     bool                   `db:"enabled"`
 	Order       int                    `db:"rule_order"`
 	Mode        RuleConditionMatchType `db:"mode"`
+	// Version is increased on every update.
+	Version int `db:"version"`
 	Timestamp
 
 	Conditions []*RuleCondition
@@ -82,7 +87,19 @@ func (r *Rule) Validate() error {
 				isErr.ErrMsg = fmt.Sprintf("condition %d: %s", i+1, isErr.ErrMsg)
 				return isErr
 			} else {
-				err = fmt.Errorf("condition %d: %v", i, err)
+				err = fmt.Errorf("condition %d: %v", i+1, err)
+			}
+			return err
+		}
+	}
+	for i, v := range r.Actions {
+		err := v.Validate()
+		if err != nil {
+			if isErr, ok := err.(errors.Error); ok {
+				isErr.ErrMsg = fmt.Sprintf("action %d: %s", i+1, isErr.ErrMsg)
+				return isErr
+			} else {
+				err = fmt.Errorf("action %d: %v", i+1, err)
 			}
 			return err
 		}
@@ -118,6 +135,11 @@ const (
 	RuleConditionMetadataCount         RuleConditionType = "metadata_count"
 	RuleConditionMetadataCountLessThan RuleConditionType = "metadata_count_less_than"
 	RuleConditionMetadataCountMoreThan RuleConditionType = "metadata_count_more_than"
+
+	// RuleConditionMetadataValueLessThan and RuleConditionMetadataValueMoreThan compare values of
+	// a typed metadata key, e.g. money or date.
+	RuleConditionMetadataValueLessThan RuleConditionType = "metadata_value_less_than"
+	RuleConditionMetadataValueMoreThan RuleConditionType = "metadata_value_more_than"
 )
 
 var AllConditionTypes = []RuleConditionType{
@@ -142,6 +164,8 @@ var AllConditionTypes = []RuleConditionType{
 	RuleConditionMetadataCount,
 	RuleConditionMetadataCountLessThan,
 	RuleConditionMetadataCountMoreThan,
+	RuleConditionMetadataValueLessThan,
+	RuleConditionMetadataValueMoreThan,
 }
 
 type RuleCondition struct {
@@ -218,6 +242,13 @@ func (r *RuleCondition) Validate() error {
 		}
 	}
 
+	if r.IsValueComparison() {
+		if r.MetadataKey == 0 || r.Value == "" {
+			err.ErrMsg = "must have metadata key and value to compare defined"
+			return err
+		}
+	}
+
 	if r.ConditionType == RuleConditionDateIs {
 		if r.DateFmt == "" {
 			err.ErrMsg = "date format (date_fmt) cannot be empty"
@@ -231,6 +262,11 @@ func (r *RuleCondition) Validate() error {
 	return nil
 }
 
+// IsValueComparison returns true if condition compares values of a typed metadata key.
+func (r *RuleCondition) IsValueComparison() bool {
+	return r.ConditionType == RuleConditionMetadataValueLessThan || r.ConditionType == RuleConditionMetadataValueMoreThan
+}
+
 func (r *RuleCondition) HasMetadata() bool {
 	return r.MetadataKey > 0 && r.MetadataValue > 0
 }
@@ -249,8 +285,31 @@ const (
 	RuleActionAddMetadata       RuleActionType = "metadata_add"
 	RuleActionRemoveMetadata    RuleActionType = "metadata_remove"
 	RuleActionSetDate           RuleActionType = "date_set"
+
+	// RuleActionLinkDocuments links document with all other documents that have given metadata key-value.
+	RuleActionLinkDocuments RuleActionType = "documents_link"
+	// RuleActionTrashDocument moves document to trash.
+	RuleActionTrashDocument RuleActionType = "document_trash"
+	// RuleActionStopProcessing stops evaluating any rules that come after current rule.
+	RuleActionStopProcessing RuleActionType = "rules_stop"
+	// RuleActionAddToCollection adds document to the collection named by value. Collection is created if needed.
+	RuleActionAddToCollection RuleActionType = "collection_add"
 )
 
+var AllActionTypes = []RuleActionType{
+	RuleActionSetName,
+	RuleActionAppendName,
+	RuleActionSetDescription,
+	RuleActionAppendDescription,
+	RuleActionAddMetadata,
+	RuleActionRemoveMetadata,
+	RuleActionSetDate,
+	RuleActionLinkDocuments,
+	RuleActionTrashDocument,
+	RuleActionStopProcessing,
+	RuleActionAddToCollection,
+}
+
 type RuleAction struct {
 	Id      int  `db:"id"`
 	RuleId  int  `db:"rule_id"`
@@ -264,6 +323,44 @@ type RuleAction struct {
 	MetadataValue     IntId          `db:"metadata_value"`
 	MetadataKeyName   Text           `db:"metadata_key_name"`
 	MetadataValueName Text           `db:"metadata_value_name"`
+	// MetadataAncestors are the parent values of MetadataValue, which are added together with the value.
+	MetadataAncestors []int `db:"-" json:"-"`
+}
+
+func (r *RuleAction) Validate() error {
+	err := errors.ErrInvalid
+
+	validType := false
+	for _, v := range AllActionTypes {
+		if r.Action == v {
+			validType = true
+			break
+		}
+	}
+
+	if !validType {
+		err.ErrMsg = fmt.Sprintf("invalid action type: %s", r.Action)
+		return err
+	}
+
+	if r.Action == RuleActionLinkDocuments {
+		if r.MetadataKey == 0 || r.MetadataValue == 0 {
+			err.ErrMsg = "must have metadata key and value defined"
+			return err
+		}
+	}
+	if r.Action == RuleActionAddToCollection {
+		name := strings.TrimSpace(r.Value)
+		if name == "" {
+			err.ErrMsg = "must have collection name defined"
+			return err
+		}
+		if len(name) > MaxCollectionNameLength {
+			err.ErrMsg = fmt.Sprintf("collection name must be at most %d characters", MaxCollectionNameLength)
+			return err
+		}
+	}
+	return nil
 }
 
 type MetadataRuleType string
@@ -272,3 +369,235 @@ const (
 	MetadataMatchExact MetadataRuleType = "exact"
 	MetadataMatchRegex MetadataRuleType = "regex"
 )
+
+// RuleExportVersion is the current version of the portable rule format.
+const RuleExportVersion = 1
+
+// RulesExport is a portable set of rules that can be copied between users and instances.
+// Metadata is referenced by key and value names instead of ids.
+type RulesExport struct {
+	Version int          `json:"version" yaml:"version"`
+	Rules   []RuleExport `json:"rules" yaml:"rules"`
+}
+
+type RuleExport struct {
+	Name        string                `json:"name" yaml:"name"`
+	Description string                `json:"description" yaml:"description"`
+	Enabled     bool                  `json:"enabled" yaml:"enabled"`
+	Order       int                   `json:"order" yaml:"order"`
+	Mode        string                `json:"mode" yaml:"mode"`
+	Conditions  []RuleConditionExport `json:"conditions" yaml:"conditions"`
+	Actions     []RuleActionExport    `json:"actions" yaml:"actions"`
+}
+
+type RuleConditionExport struct {
+	Enabled         bool   `json:"enabled" yaml:"enabled"`
+	CaseInsensitive bool   `json:"case_insensitive" yaml:"case_insensitive"`
+	Inverted        bool   `json:"inverted_match" yaml:"inverted_match"`
+	ConditionType   string `json:"condition_type" yaml:"condition_type"`
+	IsRegex         bool   `json:"is_regex" yaml:"is_regex"`
+	Value           string `json:"value" yaml:"value"`
+	DateFmt         string `json:"date_fmt,omitempty" yaml:"date_fmt,omitempty"`
+	MetadataKey     string `json:"metadata_key,omitempty" yaml:"metadata_key,omitempty"`
+	MetadataValue   string `json:"metadata_value,omitempty" yaml:"metadata_value,omitempty"`
+}
+
+type RuleActionExport struct {
+	Enabled       bool   `json:"enabled" yaml:"enabled"`
+	OnCondition   bool   `json:"on_condition" yaml:"on_condition"`
+	Action        string `json:"action" yaml:"action"`
+	Value         string `json:"value" yaml:"value"`
+	MetadataKey   string `json:"metadata_key,omitempty" yaml:"metadata_key,omitempty"`
+	MetadataValue string `json:"metadata_value,omitempty" yaml:"metadata_value,omitempty"`
+}
+
+// ToExport returns portable representation of the rule.
+// Rule conditions and actions must have metadata names loaded.
+func (r *Rule) ToExport() RuleExport {
+	export := RuleExport{
+		Name:        r.Name,
+		Description: r.Description,
+		Enabled:     r.Enabled,
+		Order:       r.Order,
+		Mode:        r.Mode.String(),
+		Conditions:  make([]RuleConditionExport, len(r.Conditions)),
+		Actions:     make([]RuleActionExport, len(r.Actions)),
+	}
+
+	for i, v := range r.Conditions {
+		export.Conditions[i] = RuleConditionExport{
+			Enabled:         v.Enabled,
+			CaseInsensitive: v.CaseInsensitive,
+			Inverted:        v.Inverted,
+			ConditionType:   v.ConditionType.String(),
+			IsRegex:         v.IsRegex,
+			Value:           v.Value,
+			DateFmt:         v.DateFmt,
+			MetadataKey:     v.MetadataKeyName.String(),
+			MetadataValue:   v.MetadataValueName.String(),
+		}
+	}
+	for i, v := range r.Actions {
+		export.Actions[i] = RuleActionExport{
+			Enabled:       v.Enabled,
+			OnCondition:   v.OnCondition,
+			Action:        v.Action.String(),
+			Value:         v.Value,
+			MetadataKey:   v.MetadataKeyName.String(),
+			MetadataValue: v.MetadataValueName.String(),
+		}
+	}
+	return export
+}
+
+// RuleConflictMode defines how to handle imported rule that has same name as existing rule.
+type RuleConflictMode string
+
+const (
+	// RuleConflictSkip keeps the existing rule and skips imported rule.
+	RuleConflictSkip RuleConflictMode = "skip"
+	// RuleConflictOverwrite replaces existing rule with imported rule.
+	RuleConflictOverwrite RuleConflictMode = "overwrite"
+	// RuleConflictFail aborts the import.
+	RuleConflictFail RuleConflictMode = "fail"
+)
+
+type RuleImportOptions struct {
+	// CreateMissingMetadata creates metadata keys and values that don't exist yet.
+	// If false, missing metadata fails the import.
+	CreateMissingMetadata bool
+	OnConflict            RuleConflictMode
+}
+
+type RuleImportResult struct {
+	Created       []string `json:"created"`
+	Updated       []string `json:"updated"`
+	Skipped       []string `json:"skipped"`
+	Conflicts     []string `json:"conflicts"`
+	KeysCreated   []string `json:"keys_created"`
+	ValuesCreated []string `json:"values_created"`
+	MissingKeys   []string `json:"missing_keys"`
+	MissingValues []string `json:"missing_values"`
+}
+
+// RuleVersion is an immutable copy of the rule, stored every time rule is created or updated.
+type RuleVersion struct {
+	Id        int          `db:"id" json:"id"`
+	RuleId    int          `db:"rule_id" json:"rule_id"`
+	UserId    int          `db:"user_id" json:"user_id"`
+	User      string       `db:"user" json:"user"`
+	Version   int          `db:"version" json:"version"`
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+	Content   RuleSnapshot `db:"content" json:"-"`
+}
+
+// RuleSnapshot is the rule content stored as json.
+type RuleSnapshot Rule
+
+func (r RuleSnapshot) Value() (driver.Value, error) {
+	return json.Marshal(Rule(r))
+}
+
+func (r *RuleSnapshot) Scan(src interface{}) error {
+	if src == nil {
+		*r = RuleSnapshot{}
+		return nil
+	}
+	rule := Rule{}
+	var err error
+	switch data := src.(type) {
+	case []byte:
+		err = json.Unmarshal(data, &rule)
+	case string:
+		err = json.Unmarshal([]byte(data), &rule)
+	default:
+		return fmt.Errorf("unknown type: %v", src)
+	}
+	*r = RuleSnapshot(rule)
+	return err
+}
+
+// RuleChange is a single difference between two rule versions.
+type RuleChange struct {
+	Field    string `json:"field"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// Diff returns changes from r to newRule. Conditions and actions are compared by their position.
+func (r *Rule) Diff(newRule *Rule) []RuleChange {
+	changes := make([]RuleChange, 0)
+	addChange := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, RuleChange{Field: field, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+
+	addChange("name", r.Name, newRule.Name)
+	addChange("description", r.Description, newRule.Description)
+	addChange("enabled", fmt.Sprint(r.Enabled), fmt.Sprint(newRule.Enabled))
+	addChange("order", fmt.Sprint(r.Order), fmt.Sprint(newRule.Order))
+	addChange("mode", r.Mode.String(), newRule.Mode.String())
+
+	for i := 0; i < len(r.Conditions) || i < len(newRule.Conditions); i++ {
+		oldValue, newValue := "", ""
+		if i < len(r.Conditions) {
+			oldValue = r.Conditions[i].String()
+		}
+		if i < len(newRule.Conditions) {
+			newValue = newRule.Conditions[i].String()
+		}
+		addChange(fmt.Sprintf("condition %d", i+1), oldValue, newValue)
+	}
+	for i := 0; i < len(r.Actions) || i < len(newRule.Actions); i++ {
+		oldValue, newValue := "", ""
+		if i < len(r.Actions) {
+			oldValue = r.Actions[i].String()
+		}
+		if i < len(newRule.Actions) {
+			newValue = newRule.Actions[i].String()
+		}
+		addChange(fmt.Sprintf("action %d", i+1), oldValue, newValue)
+	}
+	return changes
+}
+
+func ruleMetadataString(keyId, valueId IntId, keyName, valueName Text) string {
+	if keyId == 0 {
+		return ""
+	}
+	key := keyName.String()
+	if key == "" {
+		key = fmt.Sprint(keyId)
+	}
+	if valueId == 0 {
+		return key
+	}
+	value := valueName.String()
+	if value == "" {
+		value = fmt.Sprint(valueId)
+	}
+	return key + ":" + value
+}
+
+// String returns human-readable description of the condition.
+func (r *RuleCondition) String() string {
+	text := fmt.Sprintf("%s '%s'", r.ConditionType, r.Value)
+	if metadata := ruleMetadataString(r.MetadataKey, r.MetadataValue, r.MetadataKeyName, r.MetadataValueName); metadata != "" {
+		text += ", metadata: " + metadata
+	}
+	if r.DateFmt != "" {
+		text += ", date format: " + r.DateFmt
+	}
+	return fmt.Sprintf("%s (enabled: %v, regex: %v, case insensitive: %v, inverted: %v)",
+		text, r.Enabled, r.IsRegex, r.CaseInsensitive, r.Inverted)
+}
+
+// String returns human-readable description of the action.
+func (r *RuleAction) String() string {
+	text := fmt.Sprintf("%s '%s'", r.Action, r.Value)
+	if metadata := ruleMetadataString(r.MetadataKey, r.MetadataValue, r.MetadataKeyName, r.MetadataValueName); metadata != "" {
+		text += ", metadata: " + metadata
+	}
+	return fmt.Sprintf("%s (enabled: %v, on condition: %v)", text, r.Enabled, r.OnCondition)
+}
@@ -0,0 +1,87 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRule_Diff(t *testing.T) {
+	oldRule := &Rule{
+		Name:    "rule",
+		Enabled: true,
+		Mode:    RuleMatchAll,
+		Conditions: []*RuleCondition{
+			{Enabled: true, ConditionType: RuleConditionNameIs, Value: "invoice"},
+		},
+		Actions: []*RuleAction{
+			{Enabled: true, Action: RuleActionAddMetadata, MetadataKey: 1, MetadataValue: 2, MetadataKeyName: "category", MetadataValueName: "bills"},
+		},
+	}
+
+	newRule := &Rule{
+		Name:    "rule",
+		Enabled: false,
+		Mode:    RuleMatchAll,
+		Conditions: []*RuleCondition{
+			{Enabled: true, ConditionType: RuleConditionNameIs, Value: "invoice"},
+			{Enabled: true, ConditionType: RuleConditionContentContains, Value: "total"},
+		},
+	}
+
+	want := []RuleChange{
+		{Field: "enabled", OldValue: "true", NewValue: "false"},
+		{Field: "condition 2", OldValue: "", NewValue: "content_contains 'total' (enabled: true, regex: false, case insensitive: false, inverted: false)"},
+		{Field: "action 1", OldValue: "metadata_add '', metadata: category:bills (enabled: true, on condition: false)", NewValue: ""},
+	}
+
+	got := oldRule.Diff(newRule)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+
+	if got := oldRule.Diff(oldRule); len(got) != 0 {
+		t.Errorf("Diff() with same rule = %v, want no changes", got)
+	}
+}
+
+func TestRule_Validate_actions(t *testing.T) {
+	rule := &Rule{
+		Actions: []*RuleAction{
+			{Enabled: true, Action: RuleActionTrashDocument},
+			{Enabled: true, Action: RuleActionAddToCollection, Value: "  "},
+		},
+	}
+	err := rule.Validate()
+	if err == nil || !strings.Contains(err.Error(), "action 2: must have collection name defined") {
+		t.Errorf("Validate() error = %v, want error for action 2", err)
+	}
+
+	rule.Actions[1].Value = strings.Repeat("a", MaxCollectionNameLength+1)
+	if err := rule.Validate(); err == nil {
+		t.Errorf("Validate() expected error for too long collection name")
+	}
+
+	rule.Actions[1].Value = "invoices"
+	if err := rule.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
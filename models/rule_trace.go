@@ -0,0 +1,105 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RuleTrace records a single run of processing rules for a document:
+// which rules were evaluated, which conditions matched and what the actions changed.
+type RuleTrace struct {
+	Id         int       `db:"id" json:"id"`
+	DocumentId string    `db:"document_id" json:"document_id"`
+	UserId     int       `db:"user_id" json:"-"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	TookMs     int       `db:"took_ms" json:"took_ms"`
+
+	Trace RuleTraceContent `db:"trace" json:"trace"`
+}
+
+// RuleTraceContent is stored as json.
+type RuleTraceContent struct {
+	// MatchedMetadata contains metadata values that were matched automatically
+	// with metadata value's match_documents setting, before any rule was run.
+	MatchedMetadata []RuleTraceMetadata `json:"matched_metadata"`
+	Rules           []*RuleTraceRule    `json:"rules"`
+}
+
+func (r RuleTraceContent) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *RuleTraceContent) Scan(src interface{}) error {
+	if src == nil {
+		*r = RuleTraceContent{}
+		return nil
+	}
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, r)
+	case string:
+		return json.Unmarshal([]byte(data), r)
+	default:
+		return fmt.Errorf("unknown type: %v", src)
+	}
+}
+
+type RuleTraceMetadata struct {
+	KeyId       int              `json:"key_id"`
+	ValueId     int              `json:"value_id"`
+	Value       string           `json:"value"`
+	MatchType   MetadataRuleType `json:"match_type"`
+	MatchFilter string           `json:"match_filter"`
+	// Score is the confidence of the match, in range (0, 1].
+	Score float64 `json:"score"`
+}
+
+type RuleTraceRule struct {
+	RuleId  int    `json:"rule_id"`
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	// Skipped is set if rule was not evaluated, e.g. rule has no conditions.
+	Skipped bool `json:"skipped"`
+	// Stopped is set if rule stopped processing rest of the rules.
+	Stopped    bool                  `json:"stopped"`
+	Error      string                `json:"error"`
+	Conditions []*RuleTraceCondition `json:"conditions"`
+	Actions    []*RuleTraceAction    `json:"actions"`
+}
+
+type RuleTraceCondition struct {
+	ConditionId   int               `json:"condition_id"`
+	ConditionType RuleConditionType `json:"condition_type"`
+	Enabled       bool              `json:"enabled"`
+	Matched       bool              `json:"matched"`
+	Error         string            `json:"error"`
+}
+
+type RuleTraceAction struct {
+	ActionId int            `json:"action_id"`
+	Action   RuleActionType `json:"action"`
+	Enabled  bool           `json:"enabled"`
+	Error    string         `json:"error"`
+	// Changes lists human-readable changes the action made to the document.
+	Changes []string `json:"changes"`
+}
@@ -0,0 +1,41 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"time"
+)
+
+// SavedSearch is a search query that user has stored for later use.
+type SavedSearch struct {
+	Id     int    `db:"id"`
+	UserId int    `db:"user_id"`
+	Name   string `db:"name"`
+	Query  string `db:"query"`
+	// SortKey is the document attribute to sort results with, empty for relevance.
+	SortKey  string `db:"sort_key"`
+	SortDesc bool   `db:"sort_desc"`
+	// Pinned searches are shown on the dashboard.
+	Pinned bool `db:"pinned"`
+	// Digest enables daily mail of documents that match the search since the last digest.
+	Digest bool `db:"digest"`
+	// DigestAt is the time of last digest run, null if digest has not been run yet.
+	DigestAt *time.Time `db:"digest_at"`
+	Timestamp
+}
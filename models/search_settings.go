@@ -0,0 +1,105 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"fmt"
+
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// SearchRankingRules are the ranking rules that user can reorder or leave out.
+// Explicit sort is always applied first and newest documents are preferred last.
+var SearchRankingRules = []string{"words", "typo", "proximity", "attribute", "exactness"}
+
+// SearchAttributes are the document attributes that user can weight in search.
+var SearchAttributes = []string{"name", "description", "content", "file_name", "metadata", "tags"}
+
+// MaxSearchAttributeWeight is the maximum weight of a search attribute.
+const MaxSearchAttributeWeight = 10
+
+// SearchSettings adjust how user's search results are ranked. Empty settings use defaults.
+type SearchSettings struct {
+	// RankingRules are the ranking rules in order of importance, see SearchRankingRules.
+	RankingRules []string `json:"ranking_rules"`
+	// TypoTolerance, if set, overrides default typo tolerance.
+	TypoTolerance *SearchTypoTolerance `json:"typo_tolerance"`
+	// AttributeWeights weights search attributes. Attributes with higher weight rank higher,
+	// e.g. name above content. Weight 0 excludes the attribute from search.
+	// Attributes without weight rank below weighted attributes.
+	AttributeWeights map[string]int `json:"attribute_weights"`
+}
+
+type SearchTypoTolerance struct {
+	Enabled bool `json:"enabled"`
+	// MinWordSizeOneTypo is the minimum length of a word to accept one typo.
+	MinWordSizeOneTypo int `json:"min_word_size_one_typo"`
+	// MinWordSizeTwoTypos is the minimum length of a word to accept two typos.
+	MinWordSizeTwoTypos int `json:"min_word_size_two_typos"`
+}
+
+// IsDefault returns true if settings do not change any defaults.
+func (s *SearchSettings) IsDefault() bool {
+	return len(s.RankingRules) == 0 && s.TypoTolerance == nil && len(s.AttributeWeights) == 0
+}
+
+func (s *SearchSettings) Validate() error {
+	e := errors.ErrInvalid
+	seen := map[string]bool{}
+	for _, rule := range s.RankingRules {
+		if !containsString(SearchRankingRules, rule) {
+			e.ErrMsg = fmt.Sprintf("unknown ranking rule: %s", rule)
+			return e
+		}
+		if seen[rule] {
+			e.ErrMsg = fmt.Sprintf("duplicate ranking rule: %s", rule)
+			return e
+		}
+		seen[rule] = true
+	}
+
+	if typo := s.TypoTolerance; typo != nil && typo.Enabled {
+		if typo.MinWordSizeOneTypo < 1 || typo.MinWordSizeTwoTypos < typo.MinWordSizeOneTypo ||
+			typo.MinWordSizeTwoTypos > 50 {
+			e.ErrMsg = "typo tolerance word sizes must be 1 <= one typo <= two typos <= 50"
+			return e
+		}
+	}
+
+	for attribute, weight := range s.AttributeWeights {
+		if !containsString(SearchAttributes, attribute) {
+			e.ErrMsg = fmt.Sprintf("unknown search attribute: %s", attribute)
+			return e
+		}
+		if weight < 0 || weight > MaxSearchAttributeWeight {
+			e.ErrMsg = fmt.Sprintf("attribute weight must be between 0 and %d", MaxSearchAttributeWeight)
+			return e
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import "testing"
+
+func TestSearchSettings_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings SearchSettings
+		wantErr  bool
+	}{
+		{"default", SearchSettings{}, false},
+		{"ranking rules", SearchSettings{RankingRules: []string{"attribute", "words"}}, false},
+		{"unknown ranking rule", SearchSettings{RankingRules: []string{"date:desc"}}, true},
+		{"duplicate ranking rule", SearchSettings{RankingRules: []string{"words", "words"}}, true},
+		{"typo tolerance", SearchSettings{TypoTolerance: &SearchTypoTolerance{Enabled: true, MinWordSizeOneTypo: 4, MinWordSizeTwoTypos: 8}}, false},
+		{"typo tolerance disabled", SearchSettings{TypoTolerance: &SearchTypoTolerance{Enabled: false}}, false},
+		{"typo word sizes reversed", SearchSettings{TypoTolerance: &SearchTypoTolerance{Enabled: true, MinWordSizeOneTypo: 8, MinWordSizeTwoTypos: 4}}, true},
+		{"attribute weights", SearchSettings{AttributeWeights: map[string]int{"name": 10, "content": 0}}, false},
+		{"unknown attribute", SearchSettings{AttributeWeights: map[string]int{"hash": 1}}, true},
+		{"weight too large", SearchSettings{AttributeWeights: map[string]int{"name": 11}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.settings.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
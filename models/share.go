@@ -0,0 +1,73 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+// SharePermission is the access level a user has to a document.
+type SharePermission string
+
+const (
+	PermissionNone  SharePermission = ""
+	PermissionRead  SharePermission = "read"
+	PermissionEdit  SharePermission = "edit"
+	PermissionOwner SharePermission = "owner"
+)
+
+func (p SharePermission) level() int {
+	switch p {
+	case PermissionRead:
+		return 1
+	case PermissionEdit:
+		return 2
+	case PermissionOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// IsValidShare returns true if permission can be granted with a share.
+func (p SharePermission) IsValidShare() bool {
+	return p == PermissionRead || p == PermissionEdit
+}
+
+// Allows returns true if permission p grants at least the required permission.
+func (p SharePermission) Allows(required SharePermission) bool {
+	return p.level() > 0 && p.level() >= required.level()
+}
+
+// UserGroup is a named group of users that documents can be shared with.
+type UserGroup struct {
+	Timestamp
+	Id      int    `db:"id" json:"id"`
+	Name    string `db:"name" json:"name"`
+	Members []int  `db:"-" json:"members"`
+}
+
+// DocumentShare grants a user or all members of a group access to a document.
+// Exactly one of UserId and GroupId is set.
+type DocumentShare struct {
+	Timestamp
+	Id         int             `db:"id" json:"id"`
+	DocumentId string          `db:"document_id" json:"document_id"`
+	UserId     *int            `db:"user_id" json:"user_id"`
+	UserName   *string         `db:"user_name" json:"user_name"`
+	GroupId    *int            `db:"group_id" json:"group_id"`
+	GroupName  *string         `db:"group_name" json:"group_name"`
+	Permission SharePermission `db:"permission" json:"permission"`
+}
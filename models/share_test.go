@@ -0,0 +1,58 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import "testing"
+
+func TestSharePermission_Allows(t *testing.T) {
+	tests := []struct {
+		permission SharePermission
+		required   SharePermission
+		want       bool
+	}{
+		{PermissionNone, PermissionRead, false},
+		{PermissionNone, PermissionNone, false},
+		{PermissionRead, PermissionRead, true},
+		{PermissionRead, PermissionEdit, false},
+		{PermissionEdit, PermissionRead, true},
+		{PermissionEdit, PermissionEdit, true},
+		{PermissionEdit, PermissionOwner, false},
+		{PermissionOwner, PermissionOwner, true},
+		{PermissionOwner, PermissionRead, true},
+		{SharePermission("admin"), PermissionRead, false},
+	}
+	for _, tt := range tests {
+		if got := tt.permission.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.permission, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestSharePermission_IsValidShare(t *testing.T) {
+	for _, p := range []SharePermission{PermissionRead, PermissionEdit} {
+		if !p.IsValidShare() {
+			t.Errorf("%q should be valid", p)
+		}
+	}
+	for _, p := range []SharePermission{PermissionNone, PermissionOwner, "write"} {
+		if p.IsValidShare() {
+			t.Errorf("%q should not be valid", p)
+		}
+	}
+}
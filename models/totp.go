@@ -0,0 +1,165 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Time-based one-time passwords (RFC 6238) with the parameters that authenticator applications support by default.
+const (
+	TotpPeriod      = 30
+	TotpDigits      = 6
+	TotpIssuer      = "Virtualpaper"
+	TotpSecretBytes = 20
+	// TotpSkew is the number of periods before and after the current one that are accepted to allow for clock drift.
+	TotpSkew = 1
+
+	RecoveryCodesCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// UserTotp is user's two-factor authentication enrolment. Secret is set when user starts the enrolment, and
+// the enrolment is enabled only after user has verified a code generated with the secret.
+type UserTotp struct {
+	UserId    int        `db:"user_id"`
+	Secret    string     `db:"secret"`
+	Enabled   bool       `db:"enabled"`
+	EnabledAt *time.Time `db:"enabled_at"`
+	// LastUsedStep is the last time step that was used for authenticating. Codes cannot be reused.
+	LastUsedStep int64 `db:"last_used_step"`
+	Timestamp
+}
+
+// GenerateTotpSecret returns a new random base32-encoded secret.
+func GenerateTotpSecret() (string, error) {
+	bytes := make([]byte, TotpSecretBytes)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("generate secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// TotpUri returns the otpauth uri that authenticator applications read from a QR code.
+func TotpUri(secret string, accountName string) string {
+	label := url.PathEscape(TotpIssuer + ":" + accountName)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TotpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TotpDigits))
+	params.Set("period", fmt.Sprint(TotpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TotpStep returns the time step at given time.
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+// TotpCode returns the code for the time step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decode secret: %v", err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TotpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TotpDigits, value%mod), nil
+}
+
+// NormalizeTotpCode removes whitespace and dashes that user may have entered between the digits.
+func NormalizeTotpCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// ValidateTotpCode checks the code against the time steps around given time. Steps up to lastUsedStep are not
+// accepted, so that each code can only be used once. It returns the matching step.
+func ValidateTotpCode(secret string, code string, t time.Time, lastUsedStep int64) (int64, bool) {
+	code = NormalizeTotpCode(code)
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+	current := TotpStep(t)
+	for step := current - TotpSkew; step <= current+TotpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		want, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n single-use recovery codes, formatted as 'xxxxx-xxxxx'.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		bytes := make([]byte, 5)
+		_, err := rand.Read(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("generate recovery code: %v", err)
+		}
+		code := hex.EncodeToString(bytes)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash that recovery code is stored with.
+// Input is normalized, so that user can enter the code case-insensitively and without the dash.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
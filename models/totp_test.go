@@ -0,0 +1,112 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret "12345678901234567890" from RFC 6238 test vectors.
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TotpCode(rfcTotpSecret, TotpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TotpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTotpCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TotpStep(now)
+	code, _ := TotpCode(rfcTotpSecret, step)
+	previous, _ := TotpCode(rfcTotpSecret, step-1)
+	old, _ := TotpCode(rfcTotpSecret, step-3)
+
+	if got, ok := ValidateTotpCode(rfcTotpSecret, code, now, 0); !ok || got != step {
+		t.Errorf("valid code not accepted")
+	}
+	if _, ok := ValidateTotpCode(rfcTotpSecret, code[:3]+" "+code[3:], now, 0); !ok {
+		t.Errorf("code with whitespace not accepted")
+	}
+	if _, ok := ValidateTotpCode(rfcTotpSecret, code[:3]+"-"+code[3:], now, 0); !ok {
+		t.Errorf("code with dash not accepted")
+	}
+	if got, ok := ValidateTotpCode(rfcTotpSecret, previous, now, 0); !ok || got != step-1 {
+		t.Errorf("code within skew not accepted")
+	}
+	if _, ok := ValidateTotpCode(rfcTotpSecret, old, now, 0); ok {
+		t.Errorf("old code accepted")
+	}
+	if _, ok := ValidateTotpCode(rfcTotpSecret, code, now, step); ok {
+		t.Errorf("used code accepted")
+	}
+	if _, ok := ValidateTotpCode(rfcTotpSecret, "", now, 0); ok {
+		t.Errorf("empty code accepted")
+	}
+}
+
+func TestGenerateTotpSecret(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TotpCode(secret, 1); err != nil {
+		t.Errorf("generated secret is invalid: %v", err)
+	}
+	uri := TotpUri(secret, "user")
+	if !strings.HasPrefix(uri, "otpauth://totp/Virtualpaper:user?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("invalid uri: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodesCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodesCount {
+		t.Fatalf("invalid number of codes: %d", len(codes))
+	}
+	if codes[0] == codes[1] {
+		t.Errorf("codes are not unique")
+	}
+	hash := HashRecoveryCode(codes[0])
+	if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) != hash {
+		t.Errorf("normalized code does not match")
+	}
+}
@@ -80,6 +80,34 @@ type UserPreferences struct {
 	IsAdmin       bool       `json:"is_admin" db:"is_admin"`
 	StopWords     []string   `json:"stop_words""`
 	Synonyms      [][]string `json:"synonyms"`
+	DateLocale    string     `json:"date_locale"`
+	Timezone      string     `json:"timezone"`
+	FacetKeys     []string   `json:"facet_keys"`
+	// SearchSettings adjust ranking of search results.
+	SearchSettings SearchSettings `json:"search_settings"`
+	// Notifications control which events are mailed to user.
+	Notifications NotificationSettings `json:"notifications"`
+	// PendingEmail is the new email address that is waiting for verification, if any.
+	PendingEmail string `json:"pending_email"`
+}
+
+// NotificationSettings control which notifications are mailed to user.
+type NotificationSettings struct {
+	// LoginAlerts sends a mail every time user logs in.
+	LoginAlerts bool `json:"login_alerts"`
+	// ProcessingFailures sends a mail when processing user's document fails.
+	ProcessingFailures bool `json:"processing_failures"`
+	// Digests sends the daily digests of saved searches.
+	Digests bool `json:"digests"`
+}
+
+// DefaultNotificationSettings returns the settings for users that have not changed them.
+func DefaultNotificationSettings() NotificationSettings {
+	return NotificationSettings{
+		LoginAlerts:        true,
+		ProcessingFailures: false,
+		Digests:            true,
+	}
 }
 
 type UserInfo struct {
@@ -98,6 +126,24 @@ type UserInfo struct {
 	TotalDocumentsIndexed int  `json:"documents_indexed_count"`
 }
 
+// UserDataSummary is the amount of data user has. It is shown before the user is deleted.
+type UserDataSummary struct {
+	UserId           int    `json:"user_id" db:"user_id"`
+	UserName         string `json:"user_name" db:"user_name"`
+	Documents        int    `json:"documents_count" db:"documents_count"`
+	DocumentsSize    int64  `json:"documents_size" db:"documents_size"`
+	MetadataKeys     int    `json:"metadata_keys_count" db:"metadata_keys_count"`
+	MetadataValues   int    `json:"metadata_values_count" db:"metadata_values_count"`
+	Rules            int    `json:"rules_count" db:"rules_count"`
+	SavedSearches    int    `json:"saved_searches_count" db:"saved_searches_count"`
+	Sessions         int    `json:"sessions_count" db:"sessions_count"`
+	ApiTokens        int    `json:"api_tokens_count" db:"api_tokens_count"`
+	DocumentShares   int    `json:"document_shares_count" db:"document_shares_count"`
+	DocumentLinks    int    `json:"document_links_count" db:"document_links_count"`
+	GroupMemberships int    `json:"group_memberships_count" db:"group_memberships_count"`
+	Collections      int    `json:"collections_count" db:"collections_count"`
+}
+
 type PasswordResetToken struct {
 	Timestamp
 	Id        int       `db:"id"`
@@ -125,14 +171,53 @@ func (p *PasswordResetToken) Validate() error {
 }
 
 func (p *PasswordResetToken) TokenMatches(token string) (bool, error) {
+	return hashedTokenMatches(p.Token, token)
+}
+
+// EmailVerificationToken is a pending change of user's email.
+// Email is changed once user opens the link sent to the new address.
+type EmailVerificationToken struct {
+	Timestamp
+	Id        int       `db:"id"`
+	UserId    int       `db:"user_id"`
+	Email     string    `db:"email"`
+	Token     string    `db:"token"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func (e *EmailVerificationToken) HasExpired() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
+func (e *EmailVerificationToken) Validate() error {
+	if e.UserId == 0 {
+		return fmt.Errorf("no userid")
+	}
+	if e.Email == "" {
+		return fmt.Errorf("no email")
+	}
+	if len(e.Token) < 20 {
+		return fmt.Errorf("token is too short")
+	}
+	if e.HasExpired() {
+		return fmt.Errorf("token has expired")
+	}
+	return nil
+}
+
+func (e *EmailVerificationToken) TokenMatches(token string) (bool, error) {
+	return hashedTokenMatches(e.Token, token)
+}
+
+// hashedTokenMatches compares plain token to its bcrypt hash.
+func hashedTokenMatches(hashed, token string) (bool, error) {
 	if token == "" {
 		return false, fmt.Errorf("empty token")
 	}
-	if p.Token == "" {
+	if hashed == "" {
 		return false, fmt.Errorf("password not set")
 	}
-	hash := []byte(p.Token)
-	err := bcrypt.CompareHashAndPassword(hash, []byte(token))
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(token))
 	if err == nil {
 		return true, nil
 	}
@@ -0,0 +1,67 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("verification-token"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := &EmailVerificationToken{
+		UserId:    1,
+		Email:     "user@test.com",
+		Token:     string(hash),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := token.Validate(); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+
+	match, err := token.TokenMatches("verification-token")
+	if err != nil || !match {
+		t.Errorf("token does not match: %v", err)
+	}
+	match, err = token.TokenMatches("invalid-token")
+	if err != nil || match {
+		t.Errorf("invalid token matches: %v", err)
+	}
+	if _, err = token.TokenMatches(""); err == nil {
+		t.Errorf("empty token must return error")
+	}
+
+	token.Email = ""
+	if err := token.Validate(); err == nil {
+		t.Errorf("token without email must be invalid")
+	}
+	token.Email = "user@test.com"
+	token.ExpiresAt = time.Now().Add(-time.Minute)
+	if !token.HasExpired() {
+		t.Errorf("token has expired")
+	}
+	if err := token.Validate(); err == nil {
+		t.Errorf("expired token must be invalid")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// keyRefreshInterval limits how often keys are fetched when the token is signed with an unknown key.
+const keyRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// keySet contains provider's signing keys. Keys are refreshed when a token is signed with an unknown key,
+// which happens when provider rotates its keys.
+type keySet struct {
+	url     string
+	getJson func(url string, dto interface{}) error
+
+	lock      sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	refreshed time.Time
+}
+
+func newKeySet(url string, getJson func(url string, dto interface{}) error) *keySet {
+	return &keySet{
+		url:     url,
+		getJson: getJson,
+		keys:    map[string]*rsa.PublicKey{},
+	}
+}
+
+func (k *keySet) get(kid string) (*rsa.PublicKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if key := k.find(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(k.refreshed) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	err := k.refresh()
+	if err != nil {
+		return nil, err
+	}
+	if key := k.find(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// find returns the key with kid. If kid is empty, provider is expected to have only one key.
+func (k *keySet) find(kid string) *rsa.PublicKey {
+	if kid == "" && len(k.keys) == 1 {
+		for _, v := range k.keys {
+			return v
+		}
+	}
+	return k.keys[kid]
+}
+
+func (k *keySet) refresh() error {
+	body := &struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	k.refreshed = time.Now()
+	err := k.getJson(k.url, body)
+	if err != nil {
+		return fmt.Errorf("get signing keys: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, v := range body.Keys {
+		if v.Kty != "RSA" || (v.Use != "" && v.Use != "sig") {
+			continue
+		}
+		key, err := parseRsaKey(v)
+		if err != nil {
+			return fmt.Errorf("parse key %s: %v", v.Kid, err)
+		}
+		keys[v.Kid] = key
+	}
+	k.keys = keys
+	return nil
+}
+
+func parseRsaKey(key jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %v", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
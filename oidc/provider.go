@@ -0,0 +1,333 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package oidc implements OpenID Connect authorization code flow with PKCE for logging in
+with an external identity provider.
+*/
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/patrickmn/go-cache"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+)
+
+// loginTimeout is the time user has to authenticate with the identity provider.
+const loginTimeout = time.Minute * 10
+
+// Claims are the verified claims of an id token.
+type Claims map[string]interface{}
+
+// String returns the claim as string, or empty if the claim does not exist.
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Bool returns the claim as boolean. Some providers return booleans as strings.
+func (c Claims) Bool(name string) bool {
+	switch value := c[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// Strings returns the claim as a list of strings. Single string value is returned as a list.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if text, ok := v.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// metadata is the provider configuration from the discovery document.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// pendingLogin is the state of a login that has been started but not finished yet.
+type pendingLogin struct {
+	nonce        string
+	codeVerifier string
+	// binding is kept in the browser that started the login, so that login cannot be finished in another browser.
+	binding string
+}
+
+// Provider performs logins with the identity provider. Provider metadata is discovered on first login.
+type Provider struct {
+	conf   *config.Oidc
+	client *http.Client
+
+	lock     sync.Mutex
+	metadata *metadata
+	keys     *keySet
+
+	pending *cache.Cache
+}
+
+func NewProvider(conf *config.Oidc) *Provider {
+	return &Provider{
+		conf:    conf,
+		client:  &http.Client{Timeout: time.Second * 10},
+		pending: cache.New(loginTimeout, time.Minute),
+	}
+}
+
+func (p *Provider) getMetadata() (*metadata, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	discoveryUrl := strings.TrimSuffix(p.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	data := &metadata{}
+	err := p.getJson(discoveryUrl, data)
+	if err != nil {
+		return nil, fmt.Errorf("discover provider: %v", err)
+	}
+	if strings.TrimSuffix(data.Issuer, "/") != strings.TrimSuffix(p.conf.Issuer, "/") {
+		return nil, fmt.Errorf("issuer does not match: want %s, got %s", p.conf.Issuer, data.Issuer)
+	}
+	if data.AuthorizationEndpoint == "" || data.TokenEndpoint == "" || data.JwksUri == "" {
+		return nil, fmt.Errorf("provider metadata is missing endpoints")
+	}
+	p.metadata = data
+	p.keys = newKeySet(data.JwksUri, p.getJson)
+	return data, nil
+}
+
+func (p *Provider) getJson(url string, dto interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dto)
+}
+
+// StartLogin returns the url to redirect user to for authentication, and a binding that must be stored
+// in user's browser, e.g. in a cookie, and given to FinishLogin.
+func (p *Provider) StartLogin() (string, string, error) {
+	meta, err := p.getMetadata()
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	login := &pendingLogin{}
+	login.nonce, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	login.codeVerifier, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	login.binding, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	p.pending.Set(state, login, cache.DefaultExpiration)
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.conf.ClientId)
+	params.Set("redirect_uri", p.conf.RedirectUrl)
+	params.Set("scope", strings.Join(p.conf.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", login.nonce)
+	params.Set("code_challenge", codeChallenge(login.codeVerifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + params.Encode(), login.binding, nil
+}
+
+// FinishLogin exchanges the authorization code for tokens and returns the verified claims of the id token.
+// Each state can be used only once, and only with the binding that StartLogin returned with it.
+func (p *Provider) FinishLogin(code, state, binding string) (Claims, error) {
+	e := errors.ErrUnauthorized
+	e.ErrMsg = "login has expired or is invalid, please try again"
+	value, found := p.pending.Get(state)
+	if !found {
+		return nil, e
+	}
+	p.pending.Delete(state)
+	login := value.(*pendingLogin)
+	if subtle.ConstantTimeCompare([]byte(login.binding), []byte(binding)) != 1 {
+		e.Err = fmt.Errorf("login was started in another browser")
+		return nil, e
+	}
+
+	meta, err := p.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+	rawToken, err := p.exchange(meta, code, login.codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return p.verifyIdToken(meta, rawToken, login.nonce)
+}
+
+func (p *Provider) exchange(meta *metadata, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectUrl)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.conf.ClientId)
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientId), url.QueryEscape(p.conf.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := &struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(body)
+	if err != nil {
+		return "", fmt.Errorf("exchange code: decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		e := errors.ErrUnauthorized
+		e.ErrMsg = "identity provider rejected the login"
+		e.Err = fmt.Errorf("status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+		return "", e
+	}
+	if body.IdToken == "" {
+		return "", fmt.Errorf("exchange code: no id_token in response")
+	}
+	return body.IdToken, nil
+}
+
+func (p *Provider) verifyIdToken(meta *metadata, rawToken string, nonce string) (Claims, error) {
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unsupported signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.get(kid)
+	})
+	e := errors.ErrUnauthorized
+	e.ErrMsg = "invalid id token"
+	if err != nil {
+		e.Err = err
+		return nil, e
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, e
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		e.Err = fmt.Errorf("no expiration time")
+		return nil, e
+	}
+	if !claims.VerifyIssuer(meta.Issuer, true) {
+		e.Err = fmt.Errorf("invalid issuer")
+		return nil, e
+	}
+	if !Claims(claims).hasAudience(p.conf.ClientId) {
+		e.Err = fmt.Errorf("invalid audience")
+		return nil, e
+	}
+	if claims["nonce"] != nonce {
+		e.Err = fmt.Errorf("invalid nonce")
+		return nil, e
+	}
+	if Claims(claims).String("sub") == "" {
+		e.Err = fmt.Errorf("no subject")
+		return nil, e
+	}
+	return Claims(claims), nil
+}
+
+func (c Claims) hasAudience(audience string) bool {
+	for _, v := range c.Strings("aud") {
+		if v == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("generate random string: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// codeChallenge returns the S256 PKCE challenge for the verifier.
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,246 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"tryffel.net/go/virtualpaper/config"
+)
+
+// mockProvider is a minimal identity provider that issues id tokens for a single authorization code.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// claims to add to id token
+	claims jwt.MapClaims
+
+	challenge string
+	nonce     string
+}
+
+const mockCode = "valid-code"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, claims: jwt.MapClaims{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		user, password, _ := r.BasicAuth()
+		if r.Form.Get("code") != mockCode || codeChallenge(r.Form.Get("code_verifier")) != m.challenge ||
+			user != "client" || password != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken(nil)})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockProvider) idToken(override jwt.MapClaims) string {
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   "client",
+		"sub":   "1234",
+		"nonce": m.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	for k, v := range override {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed
+}
+
+// authorize reads the authorization request parameters that provider would receive.
+func (m *mockProvider) authorize(loginUrl string) string {
+	parsed, err := url.Parse(loginUrl)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if !strings.HasPrefix(loginUrl, m.server.URL+"/authorize?") {
+		m.t.Fatalf("invalid authorization url: %s", loginUrl)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "client" {
+		m.t.Errorf("invalid authorization request: %s", loginUrl)
+	}
+	m.challenge = query.Get("code_challenge")
+	m.nonce = query.Get("nonce")
+	return query.Get("state")
+}
+
+func newTestProvider(m *mockProvider) *Provider {
+	return NewProvider(&config.Oidc{
+		Enabled:      true,
+		Issuer:       m.server.URL,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		RedirectUrl:  "http://localhost/oidc/callback",
+		Scopes:       []string{"openid", "profile"},
+	})
+}
+
+func TestProvider_Login(t *testing.T) {
+	m := newMockProvider(t)
+	defer m.server.Close()
+	m.claims = jwt.MapClaims{"preferred_username": "user", "groups": []string{"users", "admins"}}
+	p := newTestProvider(m)
+
+	loginUrl, binding, err := p.StartLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := m.authorize(loginUrl)
+
+	claims, err := p.FinishLogin(mockCode, state, binding)
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	if claims.String("sub") != "1234" || claims.String("preferred_username") != "user" {
+		t.Errorf("invalid claims: %v", claims)
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[1] != "admins" {
+		t.Errorf("invalid groups: %v", groups)
+	}
+
+	// state can be used only once
+	_, err = p.FinishLogin(mockCode, state, binding)
+	if err == nil {
+		t.Errorf("state was accepted twice")
+	}
+}
+
+func TestProvider_LoginInvalidCode(t *testing.T) {
+	m := newMockProvider(t)
+	defer m.server.Close()
+	p := newTestProvider(m)
+
+	loginUrl, binding, err := p.StartLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := m.authorize(loginUrl)
+	if _, err = p.FinishLogin("invalid-code", state, binding); err == nil {
+		t.Errorf("invalid code accepted")
+	}
+	if _, err = p.FinishLogin(mockCode, "invalid-state", binding); err == nil {
+		t.Errorf("invalid state accepted")
+	}
+
+	// login started in another browser
+	loginUrl, _, err = p.StartLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state = m.authorize(loginUrl)
+	if _, err = p.FinishLogin(mockCode, state, binding); err == nil {
+		t.Errorf("login with another binding accepted")
+	}
+}
+
+func TestProvider_verifyIdToken(t *testing.T) {
+	m := newMockProvider(t)
+	defer m.server.Close()
+	p := newTestProvider(m)
+	meta, err := p.getMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nonce = "nonce"
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": m.server.URL, "aud": "client", "sub": "1234", "nonce": "nonce",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "key-1"
+	forgedToken, _ := forged.SignedString(otherKey)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", m.idToken(nil), false},
+		{"audience list", m.idToken(jwt.MapClaims{"aud": []string{"other", "client"}}), false},
+		{"invalid audience", m.idToken(jwt.MapClaims{"aud": "other"}), true},
+		{"invalid issuer", m.idToken(jwt.MapClaims{"iss": "http://example.com"}), true},
+		{"invalid nonce", m.idToken(jwt.MapClaims{"nonce": "other"}), true},
+		{"expired", m.idToken(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), true},
+		{"no expiration", m.idToken(jwt.MapClaims{"exp": nil}), true},
+		{"no subject", m.idToken(jwt.MapClaims{"sub": ""}), true},
+		{"invalid signature", forgedToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.verifyIdToken(meta, tt.token, "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyIdToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
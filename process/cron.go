@@ -6,21 +6,27 @@ import (
 	"github.com/sirupsen/logrus"
 	"time"
 	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/mail"
+	"tryffel.net/go/virtualpaper/search"
 	"tryffel.net/go/virtualpaper/storage"
 )
 
 type CronJobs struct {
-	c  *cron.Cron
-	db *storage.Database
+	c      *cron.Cron
+	db     *storage.Database
+	search search.Engine
 
 	removeExpiredPasswordPresets cron.EntryID
 	removeExpiredAuthTokens      cron.EntryID
+	savedSearchDigest            cron.EntryID
+	removeOldAuditEvents         cron.EntryID
 }
 
-func NewCron(db *storage.Database) (*CronJobs, error) {
+func NewCron(db *storage.Database, engine search.Engine) (*CronJobs, error) {
 	cj := &CronJobs{
-		c:  cron.New(),
-		db: db,
+		c:      cron.New(),
+		db:     db,
+		search: engine,
 	}
 	var err error
 	cj.removeExpiredPasswordPresets, err = cj.c.AddFunc("*/15 * * * *", cj.JobRemoveExpiredPasswordResets)
@@ -31,6 +37,14 @@ func NewCron(db *storage.Database) (*CronJobs, error) {
 	if err != nil {
 		return cj, fmt.Errorf("create removeExpiredAuthTokens job: %v", err)
 	}
+	cj.savedSearchDigest, err = cj.c.AddFunc("0 6 * * *", cj.JobSavedSearchDigest)
+	if err != nil {
+		return cj, fmt.Errorf("create savedSearchDigest job: %v", err)
+	}
+	cj.removeOldAuditEvents, err = cj.c.AddFunc("30 3 * * *", cj.JobRemoveOldAuditEvents)
+	if err != nil {
+		return cj, fmt.Errorf("create removeOldAuditEvents job: %v", err)
+	}
 	return cj, nil
 }
 
@@ -84,6 +98,14 @@ func (c *CronJobs) JobRemoveExpiredPasswordResets() {
 	} else {
 		logCronOp(action, true).Debugf("deleted %d tokens", count)
 	}
+
+	action = "remove expired email verification tokens"
+	count, err = c.db.UserStore.DeleteExpiredEmailVerificationTokens()
+	if err != nil {
+		logCronOp(action, false).Error(err)
+	} else {
+		logCronOp(action, true).Debugf("deleted %d tokens", count)
+	}
 }
 
 func (c *CronJobs) JobRemoveExpiredAuthTokens() {
@@ -96,3 +118,34 @@ func (c *CronJobs) JobRemoveExpiredAuthTokens() {
 		logCronOp(action, true).Debugf("deleted %d tokens", count)
 	}
 }
+
+func (c *CronJobs) JobSavedSearchDigest() {
+	defer c.recover()
+	action := "send saved search digests"
+	if !mail.MailEnabled() {
+		logCronOp(action, true).Debugf("mail not configured, skip")
+		return
+	}
+	count, err := runSearchDigests(c.db, c.search, time.Now())
+	if err != nil {
+		logCronOp(action, false).Error(err)
+	} else {
+		logCronOp(action, true).Debugf("sent %d digests", count)
+	}
+}
+
+func (c *CronJobs) JobRemoveOldAuditEvents() {
+	defer c.recover()
+	action := "remove old audit events"
+	days := config.C.Logging.AuditRetentionDays
+	if days < 0 {
+		logCronOp(action, true).Debugf("audit events are kept forever, skip")
+		return
+	}
+	count, err := c.db.AuditStore.DeleteEventsBefore(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logCronOp(action, false).Error(err)
+	} else {
+		logCronOp(action, true).Debugf("deleted %d events", count)
+	}
+}
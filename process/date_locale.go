@@ -0,0 +1,139 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DateLocale defines language of dates to extract from documents. Localized month names are translated
+// to english before parsing the date, which allows using english month names in date format.
+type DateLocale string
+
+const (
+	DateLocaleDefault DateLocale = ""
+	DateLocaleEnglish DateLocale = "en"
+	DateLocaleFinnish DateLocale = "fi"
+	DateLocaleGerman  DateLocale = "de"
+)
+
+var DateLocales = []DateLocale{DateLocaleEnglish, DateLocaleFinnish, DateLocaleGerman}
+
+// IsValid returns true if locale is supported.
+func (d DateLocale) IsValid() bool {
+	if d == DateLocaleDefault {
+		return true
+	}
+	for _, v := range DateLocales {
+		if d == v {
+			return true
+		}
+	}
+	return false
+}
+
+type monthName struct {
+	re    *regexp.Regexp
+	month time.Month
+}
+
+func newMonthNames(names map[string]time.Month) []monthName {
+	months := make([]monthName, 0, len(names))
+	for pattern, month := range names {
+		months = append(months, monthName{
+			// \b only supports ascii, thus match letter boundaries explicitly.
+			re:    regexp.MustCompile(`(?i)(^|[^\p{L}])(?:` + pattern + `)\.?([^\p{L}]|$)`),
+			month: month,
+		})
+	}
+	return months
+}
+
+// Finnish month names are in nominative, genitive and partitive forms, e.g. 'tammikuu', 'tammikuun', 'tammikuuta'.
+var finnishMonths = newMonthNames(map[string]time.Month{
+	"tammikuu(?:n|ta)?|tammi":   time.January,
+	"helmikuu(?:n|ta)?|helmi":   time.February,
+	"maaliskuu(?:n|ta)?|maalis": time.March,
+	"huhtikuu(?:n|ta)?|huhti":   time.April,
+	"toukokuu(?:n|ta)?|touko":   time.May,
+	"kesäkuu(?:n|ta)?|kesä":     time.June,
+	"heinäkuu(?:n|ta)?|heinä":   time.July,
+	"elokuu(?:n|ta)?|elo":       time.August,
+	"syyskuu(?:n|ta)?|syys":     time.September,
+	"lokakuu(?:n|ta)?|loka":     time.October,
+	"marraskuu(?:n|ta)?|marras": time.November,
+	"joulukuu(?:n|ta)?|joulu":   time.December,
+})
+
+var germanMonths = newMonthNames(map[string]time.Month{
+	"januar|jänner|jan":  time.January,
+	"februar|feb":        time.February,
+	"märz|mär|mrz":       time.March,
+	"april|apr":          time.April,
+	"mai":                time.May,
+	"juni|jun":           time.June,
+	"juli|jul":           time.July,
+	"august|aug":         time.August,
+	"september|sept|sep": time.September,
+	"oktober|okt":        time.October,
+	"november|nov":       time.November,
+	"dezember|dez":       time.December,
+})
+
+// localeDateLayouts are tried in order if the date does not match condition's date format.
+var localeDateLayouts = map[DateLocale][]string{
+	DateLocaleFinnish: {"2.1.2006", "02.01.2006", "2. January 2006", "2 January 2006"},
+	DateLocaleGerman:  {"2.1.2006", "02.01.2006", "2. January 2006", "2 January 2006"},
+}
+
+// translateMonthNames replaces localized month names in text with english month names.
+func translateMonthNames(text string, locale DateLocale) string {
+	var months []monthName
+	switch locale {
+	case DateLocaleFinnish:
+		months = finnishMonths
+	case DateLocaleGerman:
+		months = germanMonths
+	default:
+		return text
+	}
+
+	for _, v := range months {
+		text = v.re.ReplaceAllString(text, "${1}"+v.month.String()+"${2}")
+	}
+	return text
+}
+
+// parseLocaleDate parses date from text with given layout. If locale is set, localized month names
+// are translated and locale's common date layouts are tried in case layout does not match.
+func parseLocaleDate(layout, text string, locale DateLocale) (time.Time, error) {
+	text = strings.TrimSpace(translateMonthNames(text, locale))
+	date, err := time.Parse(layout, text)
+	if err == nil {
+		return date, nil
+	}
+	for _, v := range localeDateLayouts[locale] {
+		if date, layoutErr := time.Parse(v, text); layoutErr == nil {
+			return date, nil
+		}
+	}
+	return date, err
+}
@@ -0,0 +1,63 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"testing"
+)
+
+func Test_parseLocaleDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  string
+		text    string
+		locale  DateLocale
+		want    string
+		wantErr bool
+	}{
+		{"finnish partitive", "2. January 2006", "12. tammikuuta 2023", DateLocaleFinnish, "2023-01-12", false},
+		{"finnish nominative", "January 2006", "Kesäkuu 2022", DateLocaleFinnish, "2022-06-01", false},
+		{"german", "2. January 2006", "3. März 2021", DateLocaleGerman, "2021-03-03", false},
+		{"german abbreviation", "2 Jan 2006", "24 Dez. 2020", DateLocaleGerman, "2020-12-24", false},
+		{"dotted date fallback", "2006-01-02", "24.12.2020", DateLocaleGerman, "2020-12-24", false},
+		{"dotted date single digits", "2006-01-02", "1.2.2020", DateLocaleFinnish, "2020-02-01", false},
+		{"no locale", "2006-01-02", "24.12.2020", DateLocaleDefault, "", true},
+		{"locale does not apply", "2. January 2006", "3. März 2021", DateLocaleFinnish, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLocaleDate(tt.layout, tt.text, tt.locale)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLocaleDate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Format("2006-01-02") != tt.want {
+				t.Errorf("parseLocaleDate() = %v, want %v", got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+}
+
+func Test_translateMonthNames(t *testing.T) {
+	got := translateMonthNames("Eräpäivä 5. helmikuuta, maksettu elokuussa", DateLocaleFinnish)
+	want := "Eräpäivä 5. February, maksettu elokuussa"
+	if got != want {
+		t.Errorf("translateMonthNames() = %v, want %v", got, want)
+	}
+}
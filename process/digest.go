@@ -0,0 +1,152 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/mail"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/search"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// removeHighlight removes search match highlighting from document name.
+var removeHighlight = strings.NewReplacer("<em>", "", "</em>", "")
+
+// maxDigestDocuments limits how many matching documents are tracked per saved search.
+const maxDigestDocuments = 5000
+
+// runSearchDigests mails each user the documents that have started matching their saved searches
+// since the last run. On first run of a search, its current results are only recorded.
+// Users that do not receive digest mails are skipped, and matches are recorded only after
+// the mail has been sent, so that a failed mail is retried on the next run.
+// Returns number of mails sent.
+func runSearchDigests(db *storage.Database, engine search.Engine, now time.Time) (int, error) {
+	searches, err := db.SearchStore.GetDigestSearches()
+	if err != nil {
+		return 0, fmt.Errorf("get saved searches: %v", err)
+	}
+
+	userSearches := map[int][]models.SavedSearch{}
+	userIds := []int{}
+	for _, savedSearch := range searches {
+		if _, ok := userSearches[savedSearch.UserId]; !ok {
+			userIds = append(userIds, savedSearch.UserId)
+		}
+		userSearches[savedSearch.UserId] = append(userSearches[savedSearch.UserId], savedSearch)
+	}
+
+	sent := 0
+	for _, userId := range userIds {
+		user, err := db.UserStore.GetUser(userId)
+		if err != nil {
+			logrus.Errorf("get user %d for saved search digest: %v", userId, err)
+			continue
+		}
+		if !user.IsActive || user.Email == "" {
+			continue
+		}
+		notifications, err := db.UserStore.GetNotificationSettings(userId)
+		if err != nil {
+			logrus.Errorf("get notification settings for user %d: %v", userId, err)
+			continue
+		}
+		if !notifications.Digests {
+			continue
+		}
+
+		userDigests := []mail.SearchDigest{}
+		matches := map[int][]string{}
+		for _, savedSearch := range userSearches[userId] {
+			documents, ids, err := searchDigest(db, engine, &savedSearch)
+			if err != nil {
+				logrus.Errorf("saved search digest for search %d: %v", savedSearch.Id, err)
+				continue
+			}
+			matches[savedSearch.Id] = ids
+			if len(documents) > 0 {
+				userDigests = append(userDigests, mail.SearchDigest{
+					Name:      savedSearch.Name,
+					Query:     savedSearch.Query,
+					Documents: documents,
+				})
+			}
+		}
+
+		if len(userDigests) > 0 {
+			err = mail.SendSearchDigest(user.Email, userDigests)
+			if err != nil {
+				logrus.Errorf("send saved search digest to user %d: %v", userId, err)
+				continue
+			}
+			sent += 1
+		}
+		for searchId, ids := range matches {
+			err = db.SearchStore.SetDigestDocuments(searchId, ids, now)
+			if err != nil {
+				logrus.Errorf("save saved search digest for search %d: %v", searchId, err)
+			}
+		}
+	}
+	return sent, nil
+}
+
+// searchDigest returns documents that match the search now but did not match on the last run,
+// and ids of all documents that match the search now.
+func searchDigest(db *storage.Database, engine search.Engine, savedSearch *models.SavedSearch) ([]mail.DigestDocument, []string, error) {
+	previous, err := db.SearchStore.GetDigestDocuments(savedSearch.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	matched := make(map[string]bool, len(previous))
+	for _, v := range previous {
+		matched[v] = true
+	}
+
+	sort := storage.SortKey{Key: savedSearch.SortKey, Order: savedSearch.SortDesc}
+	paging := storage.Paging{Offset: 0, Limit: config.MaxRows}
+	ids := []string{}
+	newDocuments := []mail.DigestDocument{}
+	for paging.Offset < maxDigestDocuments {
+		docs, total, err := engine.SearchDocuments(savedSearch.UserId, savedSearch.Query, sort, paging)
+		if err != nil {
+			return nil, nil, fmt.Errorf("search: %v", err)
+		}
+		for _, doc := range docs {
+			ids = append(ids, doc.Id)
+			if !matched[doc.Id] {
+				newDocuments = append(newDocuments, mail.DigestDocument{Id: doc.Id, Name: removeHighlight.Replace(doc.Name)})
+			}
+		}
+		paging.Offset += paging.Limit
+		if len(docs) == 0 || paging.Offset >= total {
+			break
+		}
+	}
+
+	if savedSearch.DigestAt == nil {
+		return nil, ids, nil
+	}
+	return newDocuments, ids, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"tryffel.net/go/virtualpaper/config"
 	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/mail"
 	"tryffel.net/go/virtualpaper/models"
 	"tryffel.net/go/virtualpaper/search"
 	"tryffel.net/go/virtualpaper/storage"
@@ -18,7 +19,7 @@ import (
 type fpConfig struct {
 	id           int
 	db           *storage.Database
-	search       *search.Engine
+	search       search.Engine
 	usePdfToText bool
 	useOcr       bool
 	usePandoc    bool
@@ -136,6 +137,7 @@ func (fp *fileProcessor) cancelDocumentProcessing(reason string) error {
 		if err != nil {
 			logrus.Errorf("cancel document processing: %v", err)
 		}
+		documentName := fp.document.Name
 		now := time.Now()
 		errDescription := fmt.Sprintf("(Processing error at %s: %s)", now.Format(time.ANSIC), reason)
 
@@ -159,11 +161,41 @@ func (fp *fileProcessor) cancelDocumentProcessing(reason string) error {
 		if err != nil {
 			return fmt.Errorf("update document: %v", err)
 		}
+		notifyProcessingFailure(fp.db, fp.document.UserId, fp.document.Id, documentName, reason)
 		fp.document = nil
 	}
 	return nil
 }
 
+// notifyProcessingFailure mails the owner of the document, if they have enabled notifications for processing failures.
+func notifyProcessingFailure(db *storage.Database, userId int, documentId, documentName, reason string) {
+	if !mail.MailEnabled() {
+		return
+	}
+	notifications, err := db.UserStore.GetNotificationSettings(userId)
+	if err != nil {
+		logrus.Errorf("get notification settings for user %d: %v", userId, err)
+		return
+	}
+	if !notifications.ProcessingFailures {
+		return
+	}
+	user, err := db.UserStore.GetUser(userId)
+	if err != nil {
+		logrus.Errorf("get user %d: %v", userId, err)
+		return
+	}
+	if !user.IsActive || user.Email == "" {
+		return
+	}
+	go func() {
+		err := mail.ProcessingFailed(user.Email, documentId, documentName, reason)
+		if err != nil {
+			logrus.Errorf("send processing failure notification to user %d: %v", userId, err)
+		}
+	}()
+}
+
 func (fp *fileProcessor) process(op fileOp) {
 	if op.document == nil && op.file != "" {
 		fp.file = op.file
@@ -748,39 +780,100 @@ func (fp *fileProcessor) runRules() error {
 		defer fp.completeProcessingStep(process, job)
 	}
 
+	startedAt := time.Now()
+	trace := &models.RuleTrace{
+		DocumentId: fp.document.Id,
+		UserId:     fp.document.UserId,
+		Trace: models.RuleTraceContent{
+			MatchedMetadata: []models.RuleTraceMetadata{},
+			Rules:           []*models.RuleTraceRule{},
+		},
+	}
+	defer fp.saveRuleTrace(trace, startedAt)
+
 	metadataValues, err := fp.db.MetadataStore.GetUserValuesWithMatching(fp.document.UserId)
 	if err != nil {
 		logrus.Errorf("get metadata values with matching for user %d: %v", fp.document.UserId, err)
 	} else if len(*metadataValues) != 0 {
-		err = matchMetadata(fp.document, metadataValues)
+		trace.Trace.MatchedMetadata, err = matchMetadata(fp.document, metadataValues)
+	}
+
+	dateLocale, localeErr := fp.db.UserStore.GetPreferenceValue(fp.document.UserId, storage.PreferenceDateLocale)
+	if localeErr != nil && !errors.Is(localeErr, errors.ErrRecordNotFound) {
+		logrus.Warningf("get date locale for user %d: %v", fp.document.UserId, localeErr)
 	}
 
+	// ruleChanges tracks which rule made the latest change to each document field
+	ruleChanges := map[string]*models.Rule{}
 	for i, rule := range rules {
 		logrus.Debugf("(%d.) run user rule %d", i, rule.Id)
+		ruleTrace := &models.RuleTraceRule{
+			RuleId:     rule.Id,
+			Name:       rule.Name,
+			Conditions: []*models.RuleTraceCondition{},
+			Actions:    []*models.RuleTraceAction{},
+		}
+		trace.Trace.Rules = append(trace.Trace.Rules, ruleTrace)
 
 		if len(rule.Actions) == 0 {
 			logrus.Debugf("rule %d does not have actions, skip rule", rule.Id)
+			ruleTrace.Skipped = true
 			continue
 		}
 
 		if len(rule.Conditions) == 0 {
 			logrus.Debugf("rule %d does not have conditions, skip rule", rule.Id)
+			ruleTrace.Skipped = true
 			continue
 		}
 
 		runner := NewDocumentRule(fp.document, rule)
+		runner.DateLocale = DateLocale(dateLocale)
+		runner.trace = ruleTrace
 		match, err := runner.Match()
 		if err != nil {
 			logrus.Errorf("match rule (%d): %v", rule.Id, err)
+			ruleTrace.Error = err.Error()
 		}
+		ruleTrace.Matched = match
 		if !match {
 			logrus.Debugf("document %s does not match rule: %d", fp.document.Id, rule.Id)
 		} else {
 
 			logrus.Debugf("document %s matches rule %d, run actions", fp.document.Id, rule.Id)
+			before := *fp.document
 			err = runner.RunActions()
 			if err != nil {
 				logrus.Errorf("rule (%d) actions: %v", rule.Id, err)
+				ruleTrace.Error = err.Error()
+			}
+			changes, diffErr := before.Diff(fp.document, storage.UserIdInternal)
+			if diffErr != nil {
+				logrus.Errorf("rule (%d) diff document: %v", rule.Id, diffErr)
+			}
+			for _, v := range changes {
+				ruleChanges[v.Action] = rule
+			}
+
+			if len(runner.linkMetadata) > 0 {
+				linkErr := fp.linkDocumentsByMetadata(runner.linkMetadata)
+				if linkErr != nil {
+					logrus.Errorf("rule (%d) link documents: %v", rule.Id, linkErr)
+					ruleTrace.Error = linkErr.Error()
+				}
+			}
+
+			for _, name := range runner.collections {
+				collectionErr := fp.db.CollectionStore.AddDocumentToCollection(fp.document.UserId, name, fp.document.Id)
+				if collectionErr != nil {
+					logrus.Errorf("rule (%d) add document to collection: %v", rule.Id, collectionErr)
+					ruleTrace.Error = collectionErr.Error()
+				}
+			}
+
+			if runner.stop {
+				logrus.Debugf("rule %d stops processing rules for document %s", rule.Id, fp.document.Id)
+				break
 			}
 		}
 	}
@@ -792,7 +885,7 @@ func (fp *fileProcessor) runRules() error {
 		job.Status = models.JobFinished
 	}
 
-	err = fp.db.DocumentStore.Update(storage.UserIdInternal, fp.document)
+	err = fp.db.DocumentStore.UpdateByRules(storage.UserIdInternal, fp.document, ruleChanges)
 	if err != nil {
 		logrus.Errorf("update document (%s) after rules: %v", fp.document.Id, err)
 	}
@@ -817,6 +910,52 @@ func (fp *fileProcessor) runRules() error {
 	return nil
 }
 
+// saveRuleTrace stores the trace of running rules for the document.
+func (fp *fileProcessor) saveRuleTrace(trace *models.RuleTrace, startedAt time.Time) {
+	trace.TookMs = int(time.Since(startedAt).Milliseconds())
+	err := fp.db.RuleStore.AddRuleTrace(trace)
+	if err != nil {
+		logrus.Errorf("save rule trace for document %s: %v", trace.DocumentId, err)
+	}
+}
+
+// linkDocumentsByMetadata links current document with all documents that have any of the given key-values.
+// Existing links are preserved.
+func (fp *fileProcessor) linkDocumentsByMetadata(metadata []models.Metadata) error {
+	linked, err := fp.db.MetadataStore.GetLinkedDocuments(fp.document.UserId, fp.document.Id)
+	if err != nil {
+		return fmt.Errorf("get existing linked documents: %v", err)
+	}
+
+	docs := make([]string, 0, len(linked))
+	existing := make(map[string]bool, len(linked))
+	for _, v := range linked {
+		docs = append(docs, v.DocumentId)
+		existing[v.DocumentId] = true
+	}
+
+	added := 0
+	for _, v := range metadata {
+		ids, err := fp.db.MetadataStore.GetDocumentsByKeyValue(fp.document.UserId, v.KeyId, v.ValueId)
+		if err != nil {
+			return fmt.Errorf("get documents by metadata: %v", err)
+		}
+		for _, id := range ids {
+			if id == fp.document.Id || existing[id] {
+				continue
+			}
+			docs = append(docs, id)
+			existing[id] = true
+			added += 1
+		}
+	}
+
+	if added == 0 {
+		return nil
+	}
+	return fp.db.MetadataStore.UpdateLinkedDocuments(storage.UserIdInternal, fp.document.Id, docs)
+}
+
 func (fp *fileProcessor) indexSearchContent() error {
 	if fp.document == nil {
 		return errors.New("no document")
@@ -862,6 +1001,18 @@ func (fp *fileProcessor) indexSearchContent() error {
 		return errors.New("no search engine available")
 	}
 
+	if fp.document.DeletedAt.Valid {
+		// trashed documents are not searchable
+		err = fp.search.DeleteDocument(fp.document.Id, fp.document.UserId)
+		if err != nil {
+			job.Message += "; " + err.Error()
+			job.Status = models.JobFailure
+		} else {
+			job.Status = models.JobFinished
+		}
+		return nil
+	}
+
 	err = fp.search.IndexDocuments(&[]models.Document{*fp.document}, fp.document.UserId)
 	if err != nil {
 		job.Message += "; " + err.Error()
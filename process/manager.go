@@ -34,7 +34,7 @@ type Manager struct {
 	running    bool
 	reportChan chan TaskReport
 	db         *storage.Database
-	search     *search.Engine
+	search     search.Engine
 
 	tasks    []*fileProcessor
 	numtasks int
@@ -45,7 +45,7 @@ type Manager struct {
 	runFunctimer   *time.Timer
 }
 
-func NewManager(database *storage.Database, search *search.Engine) (*Manager, error) {
+func NewManager(database *storage.Database, search search.Engine) (*Manager, error) {
 	manager := &Manager{
 		lock:           &sync.RWMutex{},
 		reportChan:     make(chan TaskReport, 10),
@@ -20,6 +20,7 @@ package process
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -34,7 +35,19 @@ import (
 type DocumentRule struct {
 	Rule     *models.Rule
 	Document *models.Document
-	date     time.Time
+	// DateLocale is the language used when extracting dates from the document.
+	DateLocale DateLocale
+	date       time.Time
+
+	// linkMetadata contains key-values that document needs to be linked by after running actions.
+	// Linking requires database access, thus it is done by the caller.
+	linkMetadata []models.Metadata
+	// collections are the names of collections that document needs to be added to after running actions.
+	collections []string
+	// stop instructs caller to not run any rules after this rule.
+	stop bool
+	// trace, if set, records the conditions and actions evaluated.
+	trace *models.RuleTraceRule
 }
 
 type RuleTestResult struct {
@@ -65,6 +78,7 @@ func (d *DocumentRule) Match() (bool, error) {
 	for i, condition := range d.Rule.Conditions {
 		if !condition.Enabled {
 			logrus.Debugf("rule %d - condition: %d (id:%d), %s is disabled", d.Rule.Id, condition.Id, i+1, condition.ConditionType)
+			d.traceCondition(condition, false, nil)
 			continue
 		}
 
@@ -84,6 +98,8 @@ func (d *DocumentRule) Match() (bool, error) {
 			ok, err = d.extractDates(condition, time.Now(), nil)
 		} else if strings.HasPrefix(condText, "metadata_count") {
 			ok, err = d.hasMetadataCount(condition)
+		} else if condition.IsValueComparison() {
+			ok, err = d.compareMetadataValue(condition)
 		} else if condition.ConditionType == models.RuleConditionMetadataHasKey {
 			ok = d.hasMetadataKey(condition)
 		} else if condition.ConditionType == models.RuleConditionMetadataHasKeyValue {
@@ -91,15 +107,18 @@ func (d *DocumentRule) Match() (bool, error) {
 		} else {
 			err := errors.ErrInternalError
 			err.ErrMsg = "unknown condition type: " + condText
+			d.traceCondition(condition, false, err)
 			return false, err
 		}
 		if err != nil {
+			d.traceCondition(condition, false, err)
 			return false, fmt.Errorf("evaluate condition: %v", err)
 		}
 
 		if condition.Inverted {
 			ok = !ok
 		}
+		d.traceCondition(condition, ok, nil)
 
 		if ok {
 			hasMatch = true
@@ -179,6 +198,8 @@ func (d *DocumentRule) MatchTest() *RuleTestResult {
 
 		} else if strings.HasPrefix(condText, "metadata_count") {
 			ok, err = d.hasMetadataCount(condition)
+		} else if condition.IsValueComparison() {
+			ok, err = d.compareMetadataValue(condition)
 		} else if condition.ConditionType == models.RuleConditionMetadataHasKey {
 			ok = d.hasMetadataKey(condition)
 		} else if condition.ConditionType == models.RuleConditionMetadataHasKeyValue {
@@ -289,6 +310,31 @@ func (d *DocumentRule) hasMetadataCount(condition *models.RuleCondition) (bool,
 	}
 }
 
+// compareMetadataValue returns true if any value of the typed metadata key is less or more than condition value.
+// Metadata added by actions of earlier rules is not compared, since their values are not known.
+func (d *DocumentRule) compareMetadataValue(condition *models.RuleCondition) (bool, error) {
+	for _, v := range d.Document.Metadata {
+		if v.KeyId != int(condition.MetadataKey) || !v.ValueType.Typed() {
+			continue
+		}
+		value, err := v.ValueType.NumericValue(v.Value)
+		if err != nil {
+			continue
+		}
+		limit, err := v.ValueType.NumericValue(condition.Value)
+		if err != nil {
+			return false, err
+		}
+		if condition.ConditionType == models.RuleConditionMetadataValueLessThan && value < limit {
+			return true, nil
+		}
+		if condition.ConditionType == models.RuleConditionMetadataValueMoreThan && value > limit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // Try to extract all dates from the document.
 // In case there are multiple dates found, prioritice:
 // 1. a future date that has most matches
@@ -327,7 +373,7 @@ func (d *DocumentRule) extractDates(condition *models.RuleCondition, now time.Ti
 	}
 
 	for _, v := range matches {
-		date, err := time.Parse(condition.DateFmt, v)
+		date, err := parseLocaleDate(condition.DateFmt, v, d.DateLocale)
 		if err != nil {
 			logrus.Debugf("text %s does not match date fmt %s", v, condition.DateFmt)
 			if logger != nil {
@@ -385,9 +431,14 @@ func (d *DocumentRule) RunActions() error {
 	for i, action := range d.Rule.Actions {
 		if !action.Enabled {
 			logrus.Infof("rule %d action: %d (id:%d), type: %s disabled", d.Rule.Id, i, action.Id, action.Action)
+			d.traceAction(action, nil, nil)
 			continue
 		}
 		logrus.Infof("run rule %d action: %d (id:%d), type: %s", d.Rule.Id, i, action.Id, action.Action)
+		var before *documentSnapshot
+		if d.trace != nil {
+			before = newDocumentSnapshot(d.Document)
+		}
 		switch action.Action {
 		case models.RuleActionSetName:
 			actionError = d.setName(action)
@@ -399,24 +450,156 @@ func (d *DocumentRule) RunActions() error {
 			actionError = d.appendDescription(action)
 		case models.RuleActionAddMetadata:
 			actionError = addMetadata(d.Document, int(action.MetadataKey), int(action.MetadataValue))
+			// value implies all its parent values
+			for _, parent := range action.MetadataAncestors {
+				addMetadata(d.Document, int(action.MetadataKey), parent)
+			}
 		case models.RuleActionRemoveMetadata:
 			removeMetadata(d.Document, int(action.MetadataKey), int(action.MetadataValue))
 		case models.RuleActionSetDate:
 			actionError = d.setDate(action)
+		case models.RuleActionLinkDocuments:
+			actionError = d.linkDocuments(action)
+		case models.RuleActionTrashDocument:
+			actionError = d.trashDocument(action)
+		case models.RuleActionStopProcessing:
+			d.stop = true
+		case models.RuleActionAddToCollection:
+			actionError = d.addToCollection(action)
 		default:
 			e := errors.ErrInternalError
 			e.ErrMsg = fmt.Sprintf("unknown action type: %v", action.Action)
 			actionError = e
 		}
 
+		d.traceAction(action, before, actionError)
 		if actionError != nil {
 			err = fmt.Errorf("action (%d): %v", action.Id, actionError)
 			actionError = nil
 		}
 	}
+	if d.trace != nil {
+		d.trace.Stopped = d.stop
+	}
 	return err
 }
 
+func (d *DocumentRule) traceCondition(condition *models.RuleCondition, matched bool, err error) {
+	if d.trace == nil {
+		return
+	}
+	item := &models.RuleTraceCondition{
+		ConditionId:   condition.Id,
+		ConditionType: condition.ConditionType,
+		Enabled:       condition.Enabled,
+		Matched:       matched,
+	}
+	if err != nil {
+		item.Error = err.Error()
+	}
+	d.trace.Conditions = append(d.trace.Conditions, item)
+}
+
+func (d *DocumentRule) traceAction(action *models.RuleAction, before *documentSnapshot, err error) {
+	if d.trace == nil {
+		return
+	}
+	item := &models.RuleTraceAction{
+		ActionId: action.Id,
+		Action:   action.Action,
+		Enabled:  action.Enabled,
+		Changes:  []string{},
+	}
+	if err != nil {
+		item.Error = err.Error()
+	}
+	if before != nil {
+		item.Changes = before.changes(d.Document, action)
+	}
+	if action.Action == models.RuleActionLinkDocuments && err == nil {
+		item.Changes = append(item.Changes, fmt.Sprintf("link documents with metadata %s",
+			metadataName(action, int(action.MetadataKey), int(action.MetadataValue))))
+	}
+	if action.Action == models.RuleActionStopProcessing {
+		item.Changes = append(item.Changes, "stop processing rules")
+	}
+	if action.Action == models.RuleActionAddToCollection && err == nil {
+		item.Changes = append(item.Changes, fmt.Sprintf("add to collection %s", strings.TrimSpace(action.Value)))
+	}
+	d.trace.Actions = append(d.trace.Actions, item)
+}
+
+// documentSnapshot holds document fields that actions are able to change.
+type documentSnapshot struct {
+	name        string
+	description string
+	date        time.Time
+	deleted     bool
+	metadata    []models.Metadata
+}
+
+func newDocumentSnapshot(doc *models.Document) *documentSnapshot {
+	snapshot := &documentSnapshot{
+		name:        doc.Name,
+		description: doc.Description,
+		date:        doc.Date,
+		deleted:     doc.DeletedAt.Valid,
+		metadata:    make([]models.Metadata, len(doc.Metadata)),
+	}
+	copy(snapshot.metadata, doc.Metadata)
+	return snapshot
+}
+
+// changes returns human-readable list of changes between snapshot and the document.
+func (s *documentSnapshot) changes(doc *models.Document, action *models.RuleAction) []string {
+	changes := []string{}
+	if s.name != doc.Name {
+		changes = append(changes, fmt.Sprintf("name: '%s' -> '%s'", s.name, doc.Name))
+	}
+	if s.description != doc.Description {
+		changes = append(changes, fmt.Sprintf("description: '%s' -> '%s'", s.description, doc.Description))
+	}
+	if !s.date.Equal(doc.Date) {
+		changes = append(changes, fmt.Sprintf("date: %s -> %s", s.date.Format("2006-01-02"), doc.Date.Format("2006-01-02")))
+	}
+	if !s.deleted && doc.DeletedAt.Valid {
+		changes = append(changes, "moved to trash")
+	}
+
+	hasMetadata := func(list []models.Metadata, m models.Metadata) bool {
+		for _, v := range list {
+			if v.KeyId == m.KeyId && v.ValueId == m.ValueId {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range doc.Metadata {
+		if !hasMetadata(s.metadata, v) {
+			changes = append(changes, "metadata added: "+metadataName(action, v.KeyId, v.ValueId))
+		}
+	}
+	for _, v := range s.metadata {
+		if !hasMetadata(doc.Metadata, v) {
+			changes = append(changes, "metadata removed: "+metadataName(action, v.KeyId, v.ValueId))
+		}
+	}
+	return changes
+}
+
+// metadataName formats metadata key-value. Use names from action if they match the ids.
+func metadataName(action *models.RuleAction, keyId, valueId int) string {
+	key := strconv.Itoa(keyId)
+	value := strconv.Itoa(valueId)
+	if int(action.MetadataKey) == keyId && action.MetadataKeyName != "" {
+		key = action.MetadataKeyName.String()
+		if int(action.MetadataValue) == valueId && action.MetadataValueName != "" {
+			value = action.MetadataValueName.String()
+		}
+	}
+	return key + ":" + value
+}
+
 func (d *DocumentRule) setName(action *models.RuleAction) error {
 	d.Document.Name = action.Value
 	return nil
@@ -496,7 +679,49 @@ func (d *DocumentRule) setDate(action *models.RuleAction) error {
 	return nil
 }
 
-func matchTextAllowTypo(match, text string, matchPrefix, matchIs bool) (bool, error) {
+func (d *DocumentRule) linkDocuments(action *models.RuleAction) error {
+	if action.MetadataKey == 0 || action.MetadataValue == 0 {
+		e := errors.ErrInvalid
+		e.ErrMsg = "metadata key and value are required for linking documents"
+		return e
+	}
+	for _, v := range d.linkMetadata {
+		if v.KeyId == int(action.MetadataKey) && v.ValueId == int(action.MetadataValue) {
+			return nil
+		}
+	}
+	d.linkMetadata = append(d.linkMetadata, models.Metadata{
+		KeyId:   int(action.MetadataKey),
+		ValueId: int(action.MetadataValue),
+	})
+	return nil
+}
+
+func (d *DocumentRule) addToCollection(action *models.RuleAction) error {
+	name := strings.TrimSpace(action.Value)
+	if name == "" {
+		e := errors.ErrInvalid
+		e.ErrMsg = "collection name is required"
+		return e
+	}
+	for _, v := range d.collections {
+		if v == name {
+			return nil
+		}
+	}
+	d.collections = append(d.collections, name)
+	return nil
+}
+
+func (d *DocumentRule) trashDocument(action *models.RuleAction) error {
+	if !d.Document.DeletedAt.Valid {
+		d.Document.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	return nil
+}
+
+// allowedTypos returns how many typos are allowed when matching text.
+func allowedTypos(match string) int {
 	// max typos affect greatly the number of false positives, so try to be conservative with them..
 	maxTypos := 0
 	if len(match) > 30 {
@@ -507,7 +732,11 @@ func matchTextAllowTypo(match, text string, matchPrefix, matchIs bool) (bool, er
 	} else if len(match) > 10 {
 		maxTypos = 1
 	}
+	return maxTypos
+}
 
+func matchTextAllowTypo(match, text string, matchPrefix, matchIs bool) (bool, error) {
+	maxTypos := allowedTypos(match)
 	if maxTypos == 0 {
 		if matchPrefix {
 			return strings.HasPrefix(text, match), nil
@@ -522,11 +751,18 @@ func matchTextAllowTypo(match, text string, matchPrefix, matchIs bool) (bool, er
 }
 
 func matchTextByDistance(match, text string, maxTypos int, matchPrefix, matchIs bool) (bool, error) {
+	_, found := matchTextDistance(match, text, maxTypos, matchPrefix, matchIs)
+	return found, nil
+}
+
+// matchTextDistance returns whether text contains match with at most maxTypos typos,
+// and the number of typos in the found match.
+func matchTextDistance(match, text string, maxTypos int, matchPrefix, matchIs bool) (int, bool) {
 	if len(match) < 2 || len(text) < 2 {
-		return false, nil
+		return 0, false
 	}
 	if len(match) > len(text) {
-		return false, nil
+		return 0, false
 	}
 
 	// compare match and text, allowing maxTypos of difference between texts.
@@ -538,17 +774,17 @@ func matchTextByDistance(match, text string, maxTypos int, matchPrefix, matchIs
 	for i, r := range textRunes {
 		if matchIs && matchIndex == len(matchRunes)-1 && matchIndex < len(matchRunes)-1 {
 			// text continues after match
-			return false, nil
+			return 0, false
 		}
 
 		if matchIs && matchIndex == len(matchRunes)-1 && i < len(textRunes)-1 {
 			// match sequence completed, but there's still text left, no match
-			return false, nil
+			return 0, false
 		}
 
 		if matchIndex >= len(matchRunes)-1 {
 			// found match
-			return true, nil
+			return typos, true
 		}
 		if matchIndex > 0 {
 			// inside match sequence
@@ -583,68 +819,133 @@ func matchTextByDistance(match, text string, maxTypos int, matchPrefix, matchIs
 
 		if matchPrefix && matchIndex == 0 && i > 0 {
 			// match didn't start from beginning
-			return false, nil
+			return 0, false
 		}
 	}
-	return false, nil
+	return 0, false
 }
 
-func matchMetadata(document *models.Document, values *[]models.MetadataValue) error {
+// matchMetadata adds metadata values that match the document. Each match is scored by its position,
+// frequency and exactness. For keys that allow only a single value, only the best-scoring value is added,
+// and only if document does not already have a value for the key. It returns the values that were added.
+func matchMetadata(document *models.Document, values *[]models.MetadataValue) ([]models.RuleTraceMetadata, error) {
 	logrus.Debugf("match metadata keys for doc: %s, %d rules", document.Id, len(*values))
+	matched := []models.RuleTraceMetadata{}
+	// best candidate for each single-value key
+	singleValues := map[int]models.RuleTraceMetadata{}
+
 	for _, v := range *values {
-		match, err := documentMatchesFilter(document, v.MatchType, v.MatchFilter)
+		score, err := scoreMetadataMatch(document, v.MatchType, v.MatchFilter)
 		if err != nil {
 			logrus.Debugf("automatic metadata rule, filter error: %v", err)
 			continue
 		}
-		if match != "" {
+		if score == 0 {
+			continue
+		}
+		item := models.RuleTraceMetadata{
+			KeyId:       v.KeyId,
+			ValueId:     v.Id,
+			Value:       v.Value,
+			MatchType:   v.MatchType,
+			MatchFilter: v.MatchFilter,
+			Score:       score,
+		}
+		if !v.KeySingleValue {
 			addMetadata(document, v.KeyId, v.Id)
+			matched = append(matched, item)
+		} else if best, ok := singleValues[v.KeyId]; !ok || score > best.Score {
+			singleValues[v.KeyId] = item
 		}
 	}
-	return nil
+
+	for keyId, v := range singleValues {
+		if documentHasMetadataKey(document, keyId) {
+			logrus.Debugf("document %s already has value for single-value key %d, skip matched value %d",
+				document.Id, keyId, v.ValueId)
+			continue
+		}
+		addMetadata(document, v.KeyId, v.ValueId)
+		matched = append(matched, v)
+	}
+	return matched, nil
+}
+
+func documentHasMetadataKey(document *models.Document, keyId int) bool {
+	for _, v := range document.Metadata {
+		if v.KeyId == keyId {
+			return true
+		}
+	}
+	return false
 }
 
-var reRegexHasSubMatch = regexp.MustCompile("\\(.+\\)")
+// scoreMetadataMatch returns confidence score in range (0, 1] for how well the filter matches document.
+// Score 0 means there is no match. The score is weighted by:
+// frequency of the matches, position of the first match (earlier is better) and
+// exactness of the match (the more typos, the lower the score).
+func scoreMetadataMatch(document *models.Document, ruleType models.MetadataRuleType, filter string) (float64, error) {
+	text := document.Content
+	var positions [][]int
+	exactScore := 1.0
 
-func documentMatchesFilter(document *models.Document, ruleType models.MetadataRuleType, filter string) (string, error) {
 	if ruleType == models.MetadataMatchExact {
-		lowerContent := strings.ToLower(document.Content)
-		lowerRule := strings.ToLower(filter)
-		contains, err := matchTextAllowTypo(lowerRule, lowerContent, false, false)
-		if contains {
-			return lowerRule, err
-		} else {
-			return "", err
+		text = strings.ToLower(text)
+		lowerFilter := strings.ToLower(filter)
+		if lowerFilter == "" {
+			return 0, nil
 		}
-	} else if ruleType == models.MetadataMatchRegex {
-		// if regex captures submatch, return first submatch (not the match itself),
-		// else return regex match
-		re, err := regexp.Compile(filter)
-		if err != nil {
-			return "", fmt.Errorf("invalid regex: %v", err)
+		for offset := 0; ; {
+			i := strings.Index(text[offset:], lowerFilter)
+			if i < 0 {
+				break
+			}
+			positions = append(positions, []int{offset + i, offset + i + len(lowerFilter)})
+			offset += i + len(lowerFilter)
 		}
-
-		if reRegexHasSubMatch.MatchString(filter) {
-			matches := re.FindStringSubmatch(document.Content)
-			if len(matches) == 0 {
-				return "", nil
+		if len(positions) == 0 {
+			maxTypos := allowedTypos(lowerFilter)
+			if maxTypos == 0 {
+				return 0, nil
 			}
-			if len(matches) == 1 {
-				return "", nil
+			typos, found := matchTextDistance(lowerFilter, text, maxTypos, false, false)
+			if !found {
+				return 0, nil
 			}
-
-			if len(matches) == 2 {
-				return matches[1], nil
-			} else {
-				logrus.Debugf("more than 1 regex matches, pick first. regex: %s doc. %s, matches: %v",
-					filter, document.Id, matches)
-				return matches[1], nil
+			if typos < 1 {
+				// the match is not exact, even if the typos cancelled out.
+				typos = 1
 			}
-		} else {
-			match := re.FindString(filter)
-			return match, nil
+			exactScore = 1 - float64(typos)/float64(maxTypos+1)
+		}
+	} else if ruleType == models.MetadataMatchRegex {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return 0, fmt.Errorf("invalid regex: %v", err)
+		}
+		positions = re.FindAllStringIndex(text, -1)
+		if len(positions) == 0 {
+			return 0, nil
 		}
 	} else {
-		return "", fmt.Errorf("unknown rule type: %s", ruleType)
+		return 0, fmt.Errorf("unknown rule type: %s", ruleType)
+	}
+
+	const maxFrequency = 5
+	frequency := len(positions)
+	if frequency == 0 {
+		// typo match, exact position is unknown
+		frequency = 1
 	}
+	if frequency > maxFrequency {
+		frequency = maxFrequency
+	}
+	frequencyScore := float64(frequency) / maxFrequency
+
+	positionScore := 0.0
+	if len(positions) > 0 && len(text) > 0 {
+		positionScore = 1 - float64(positions[0][0])/float64(len(text))
+	}
+
+	return 0.4*frequencyScore + 0.3*positionScore + 0.3*exactScore, nil
 }
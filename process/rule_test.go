@@ -429,6 +429,33 @@ func TestDocumentRule_matchTextByDistance(t *testing.T) {
 	}
 }
 
+func Test_scoreMetadataMatch(t *testing.T) {
+	score := func(content string) float64 {
+		doc := &models.Document{Content: content}
+		got, err := scoreMetadataMatch(doc, models.MetadataMatchExact, "Electricity invoice number")
+		if err != nil {
+			t.Fatalf("scoreMetadataMatch() error = %v", err)
+		}
+		return got
+	}
+
+	exact := score("monthly electricity invoice number 12")
+	oneTypo := score("monthly electricity invoise number 12")
+	twoTypos := score("monthly electricity invoise numbar 12")
+	noMatch := score("monthly water bill")
+
+	if !(exact > oneTypo && oneTypo > twoTypos && twoTypos > noMatch) {
+		t.Errorf("scores should decrease with typos: exact %v, one typo %v, two typos %v, no match %v",
+			exact, oneTypo, twoTypos, noMatch)
+	}
+	if noMatch != 0 {
+		t.Errorf("no match should score 0, got %v", noMatch)
+	}
+	if frequent := score("electricity invoice number, ELECTRICITY INVOICE NUMBER"); frequent <= exact {
+		t.Errorf("multiple matches should score higher: %v <= %v", frequent, exact)
+	}
+}
+
 func BenchmarkDocumentRule_matchTextByDistance_shorttext(b *testing.B) {
 	// 66 words to search from.
 	match := "a short match"
@@ -744,3 +771,323 @@ func TestDocumentRule_extractDates(t *testing.T) {
 		})
 	}
 }
+
+func TestDocumentRule_RunActions_linkTrashStop(t *testing.T) {
+	doc := &models.Document{
+		Id:       "1234",
+		UserId:   1,
+		Name:     "a Test Document.5",
+		Metadata: []models.Metadata{{KeyId: 10, ValueId: 15}},
+	}
+
+	rule := &models.Rule{
+		Id:     1,
+		UserId: 1,
+		Mode:   models.RuleMatchAll,
+		Actions: []*models.RuleAction{
+			{
+				Enabled:       true,
+				Action:        models.RuleActionLinkDocuments,
+				MetadataKey:   10,
+				MetadataValue: 15,
+			},
+			{
+				Enabled:       true,
+				Action:        models.RuleActionLinkDocuments,
+				MetadataKey:   10,
+				MetadataValue: 15,
+			},
+			{
+				Enabled:       false,
+				Action:        models.RuleActionLinkDocuments,
+				MetadataKey:   11,
+				MetadataValue: 16,
+			},
+			{
+				Enabled: true,
+				Action:  models.RuleActionTrashDocument,
+			},
+			{
+				Enabled: true,
+				Action:  models.RuleActionStopProcessing,
+			},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule)
+	err := dc.RunActions()
+	if err != nil {
+		t.Errorf("runActions() error = %v", err)
+		return
+	}
+
+	wantLinks := []models.Metadata{{KeyId: 10, ValueId: 15}}
+	if !reflect.DeepEqual(dc.linkMetadata, wantLinks) {
+		t.Errorf("runActions(), linkMetadata = %v, want %v", dc.linkMetadata, wantLinks)
+	}
+
+	if !doc.DeletedAt.Valid {
+		t.Errorf("runActions(), document not moved to trash")
+	}
+
+	if !dc.stop {
+		t.Errorf("runActions(), stop processing not set")
+	}
+}
+
+func TestDocumentRule_RunActions_addToCollection(t *testing.T) {
+	doc := &models.Document{Id: "1234", UserId: 1}
+	rule := &models.Rule{
+		Id: 1,
+		Actions: []*models.RuleAction{
+			{Enabled: true, Action: models.RuleActionAddToCollection, Value: " invoices "},
+			{Enabled: true, Action: models.RuleActionAddToCollection, Value: "invoices"},
+			{Enabled: false, Action: models.RuleActionAddToCollection, Value: "disabled"},
+			{Enabled: true, Action: models.RuleActionAddToCollection, Value: "2023"},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule)
+	err := dc.RunActions()
+	if err != nil {
+		t.Errorf("runActions() error = %v", err)
+		return
+	}
+	want := []string{"invoices", "2023"}
+	if !reflect.DeepEqual(dc.collections, want) {
+		t.Errorf("runActions(), collections = %v, want %v", dc.collections, want)
+	}
+
+	rule.Actions = []*models.RuleAction{{Enabled: true, Action: models.RuleActionAddToCollection}}
+	dc = NewDocumentRule(doc, rule)
+	err = dc.RunActions()
+	if err == nil {
+		t.Errorf("runActions() expected error when collection name is empty")
+	}
+	if len(dc.collections) != 0 {
+		t.Errorf("runActions(), collections = %v, want empty", dc.collections)
+	}
+}
+
+func TestDocumentRule_RunActions_linkWithoutMetadata(t *testing.T) {
+	doc := &models.Document{Id: "1234", UserId: 1}
+	rule := &models.Rule{
+		Id: 1,
+		Actions: []*models.RuleAction{
+			{
+				Enabled: true,
+				Action:  models.RuleActionLinkDocuments,
+			},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule)
+	err := dc.RunActions()
+	if err == nil {
+		t.Errorf("runActions() expected error when linking without metadata")
+	}
+	if len(dc.linkMetadata) != 0 {
+		t.Errorf("runActions(), linkMetadata = %v, want empty", dc.linkMetadata)
+	}
+	if dc.stop {
+		t.Errorf("runActions(), stop processing set without action")
+	}
+}
+
+func TestDocumentRule_trace(t *testing.T) {
+	doc := &models.Document{
+		Id:       "1234",
+		UserId:   1,
+		Name:     "invoice",
+		Metadata: []models.Metadata{{KeyId: 10, ValueId: 15}},
+	}
+
+	rule := &models.Rule{
+		Id:     1,
+		UserId: 1,
+		Mode:   models.RuleMatchAll,
+		Conditions: []*models.RuleCondition{
+			{
+				Id:            1,
+				Enabled:       true,
+				ConditionType: models.RuleConditionNameIs,
+				Value:         "invoice",
+			},
+			{
+				Id:            2,
+				Enabled:       false,
+				ConditionType: models.RuleConditionNameIs,
+				Value:         "other",
+			},
+		},
+		Actions: []*models.RuleAction{
+			{
+				Id:                1,
+				Enabled:           true,
+				Action:            models.RuleActionAddMetadata,
+				MetadataKey:       11,
+				MetadataValue:     16,
+				MetadataKeyName:   "category",
+				MetadataValueName: "bills",
+			},
+			{
+				Id:            2,
+				Enabled:       true,
+				Action:        models.RuleActionRemoveMetadata,
+				MetadataKey:   10,
+				MetadataValue: 15,
+			},
+			{
+				Id:      3,
+				Enabled: true,
+				Action:  models.RuleActionAppendName,
+				Value:   " 2023",
+			},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule)
+	dc.trace = &models.RuleTraceRule{}
+	match, err := dc.Match()
+	if err != nil || !match {
+		t.Fatalf("Match() = %v, %v", match, err)
+	}
+	err = dc.RunActions()
+	if err != nil {
+		t.Fatalf("RunActions() error = %v", err)
+	}
+
+	wantConditions := []*models.RuleTraceCondition{
+		{ConditionId: 1, ConditionType: models.RuleConditionNameIs, Enabled: true, Matched: true},
+		{ConditionId: 2, ConditionType: models.RuleConditionNameIs, Enabled: false, Matched: false},
+	}
+	if !reflect.DeepEqual(dc.trace.Conditions, wantConditions) {
+		t.Errorf("trace conditions = %v, want %v", dc.trace.Conditions, wantConditions)
+	}
+
+	wantChanges := [][]string{
+		{"metadata added: category:bills"},
+		{"metadata removed: 10:15"},
+		{"name: 'invoice' -> 'invoice 2023'"},
+	}
+	if len(dc.trace.Actions) != len(wantChanges) {
+		t.Fatalf("trace actions = %d, want %d", len(dc.trace.Actions), len(wantChanges))
+	}
+	for i, v := range dc.trace.Actions {
+		if !reflect.DeepEqual(v.Changes, wantChanges[i]) {
+			t.Errorf("action %d changes = %v, want %v", i, v.Changes, wantChanges[i])
+		}
+	}
+}
+
+func Test_matchMetadata(t *testing.T) {
+	doc := &models.Document{
+		Id:      "1234",
+		Content: "Invoice from acme corp. Contact globex for support. Acme corp, 2023.",
+	}
+	values := &[]models.MetadataValue{
+		{Id: 1, KeyId: 1, Value: "acme", MatchType: models.MetadataMatchExact, MatchFilter: "acme corp", KeySingleValue: true},
+		{Id: 2, KeyId: 1, Value: "globex", MatchType: models.MetadataMatchExact, MatchFilter: "globex", KeySingleValue: true},
+		{Id: 3, KeyId: 1, Value: "initech", MatchType: models.MetadataMatchExact, MatchFilter: "initech", KeySingleValue: true},
+		{Id: 4, KeyId: 2, Value: "invoice", MatchType: models.MetadataMatchRegex, MatchFilter: "(?i)invoice"},
+		{Id: 5, KeyId: 2, Value: "support", MatchType: models.MetadataMatchExact, MatchFilter: "support"},
+	}
+
+	matched, err := matchMetadata(doc, values)
+	if err != nil {
+		t.Fatalf("matchMetadata() error = %v", err)
+	}
+	if len(matched) != 3 {
+		t.Errorf("matched values = %d, want 3", len(matched))
+	}
+
+	want := []models.Metadata{{KeyId: 2, ValueId: 4}, {KeyId: 2, ValueId: 5}, {KeyId: 1, ValueId: 1}}
+	if !reflect.DeepEqual(doc.Metadata, want) {
+		t.Errorf("document metadata = %v, want %v", doc.Metadata, want)
+	}
+
+	// single value key already has a value
+	doc.Metadata = []models.Metadata{{KeyId: 1, ValueId: 2}}
+	_, err = matchMetadata(doc, values)
+	if err != nil {
+		t.Fatalf("matchMetadata() error = %v", err)
+	}
+	want = []models.Metadata{{KeyId: 1, ValueId: 2}, {KeyId: 2, ValueId: 4}, {KeyId: 2, ValueId: 5}}
+	if !reflect.DeepEqual(doc.Metadata, want) {
+		t.Errorf("document metadata = %v, want %v", doc.Metadata, want)
+	}
+}
+
+func TestDocumentRule_compareMetadataValue(t *testing.T) {
+	doc := &models.Document{
+		Id: "1234",
+		Metadata: []models.Metadata{
+			{KeyId: 1, ValueId: 10, Value: "120.50 EUR", ValueType: models.MetadataValueMoney},
+			{KeyId: 2, ValueId: 20, Value: "2023-06-30", ValueType: models.MetadataValueDate},
+			{KeyId: 3, ValueId: 30, Value: "500", ValueType: models.MetadataValueText},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		conditionType models.RuleConditionType
+		key           int
+		value         string
+		want          bool
+		wantErr       bool
+	}{
+		{"money more than", models.RuleConditionMetadataValueMoreThan, 1, "100", true, false},
+		{"money less than", models.RuleConditionMetadataValueLessThan, 1, "100", false, false},
+		{"date before", models.RuleConditionMetadataValueLessThan, 2, "2023-07-01", true, false},
+		{"date after", models.RuleConditionMetadataValueMoreThan, 2, "2023-07-01", false, false},
+		{"text key is not compared", models.RuleConditionMetadataValueMoreThan, 3, "100", false, false},
+		{"missing key", models.RuleConditionMetadataValueMoreThan, 4, "100", false, false},
+		{"invalid value", models.RuleConditionMetadataValueMoreThan, 2, "july", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &models.Rule{
+				Mode: models.RuleMatchAll,
+				Conditions: []*models.RuleCondition{{
+					Enabled:       true,
+					ConditionType: tt.conditionType,
+					MetadataKey:   models.IntId(tt.key),
+					Value:         tt.value,
+				}},
+			}
+			dc := NewDocumentRule(doc, rule)
+			got, err := dc.Match()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentRule_RunActions_addMetadataAncestors(t *testing.T) {
+	doc := &models.Document{Id: "1234", UserId: 1, Metadata: []models.Metadata{{KeyId: 1, ValueId: 10}}}
+	rule := &models.Rule{
+		Id: 1,
+		Actions: []*models.RuleAction{
+			{
+				Enabled:           true,
+				Action:            models.RuleActionAddMetadata,
+				MetadataKey:       1,
+				MetadataValue:     12,
+				MetadataAncestors: []int{11, 10},
+			},
+		},
+	}
+
+	dc := NewDocumentRule(doc, rule)
+	if err := dc.RunActions(); err != nil {
+		t.Fatalf("runActions(): %v", err)
+	}
+	want := []models.Metadata{{KeyId: 1, ValueId: 10}, {KeyId: 1, ValueId: 12}, {KeyId: 1, ValueId: 11}}
+	if !reflect.DeepEqual(doc.Metadata, want) {
+		t.Errorf("runActions() metadata = %v, want %v", doc.Metadata, want)
+	}
+}
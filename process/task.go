@@ -29,13 +29,13 @@ type Task struct {
 	idle    bool
 	id      int
 	db      *storage.Database
-	search  *search.Engine
+	search  search.Engine
 	report  *chan TaskReport
 
 	runFunc func()
 }
 
-func newTask(id int, db *storage.Database, search *search.Engine) *Task {
+func newTask(id int, db *storage.Database, search search.Engine) *Task {
 	task := &Task{
 		id:     id,
 		lock:   &sync.RWMutex{},
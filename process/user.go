@@ -0,0 +1,98 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// DeleteUser deletes user and all the user's data: database records, document files, previews and
+// search index. Documents that were shared with the user are re-indexed to remove the user from them.
+// Failing to remove files or search index does not stop the deletion, but the failures are returned
+// as an error once the user has been deleted.
+func (m *Manager) DeleteUser(userId int) error {
+	docIds, err := m.db.UserStore.GetUserDocumentIds(userId)
+	if err != nil {
+		return fmt.Errorf("get user documents: %v", err)
+	}
+	sharedIds, err := m.db.UserStore.GetDocumentIdsSharedWithUser(userId)
+	if err != nil {
+		return fmt.Errorf("get documents shared with user: %v", err)
+	}
+	logrus.Infof("delete user %d with %d documents", userId, len(docIds))
+	failures := []string{}
+
+	// search index is cleared by the documents and their readers in the database,
+	// remove them before database records.
+	err = m.search.DeleteDocuments(userId)
+	if err != nil {
+		logrus.Warningf("delete documents of user %d from search index: %v", userId, err)
+		failures = append(failures, fmt.Sprintf("delete search index: %v", err))
+	}
+
+	err = m.db.UserStore.DeleteUser(userId)
+	if err != nil {
+		return fmt.Errorf("delete user from database: %v", err)
+	}
+
+	failedFiles := 0
+	for _, docId := range docIds {
+		err = removeDocumentFiles(docId)
+		if err != nil {
+			logrus.Warningf("delete files of document %s: %v", docId, err)
+			failedFiles += 1
+		}
+	}
+	if failedFiles > 0 {
+		failures = append(failures, fmt.Sprintf("delete files of %d documents", failedFiles))
+	}
+
+	if len(sharedIds) > 0 {
+		err = m.db.JobStore.AddDocuments(0, sharedIds, models.ProcessFts)
+		if err != nil && !errors.Is(err, errors.ErrAlreadyExists) {
+			logrus.Warningf("schedule indexing documents shared with user %d: %v", userId, err)
+			failures = append(failures, fmt.Sprintf("schedule indexing shared documents: %v", err))
+		} else {
+			m.PullDocumentsToProcess()
+		}
+	}
+	logrus.Infof("deleted user %d, failed to delete files of %d documents", userId, failedFiles)
+	if len(failures) > 0 {
+		return fmt.Errorf("user deleted with errors: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// removeDocumentFiles removes document file and its preview. Files that do not exist are skipped.
+func removeDocumentFiles(docId string) error {
+	for _, path := range []string{storage.PreviewPath(docId), storage.DocumentPath(docId)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
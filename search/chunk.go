@@ -0,0 +1,110 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Meilisearch only indexes first 1000 words of each attribute. Long content is split into overlapping chunks
+// that are indexed as separate records. Overlap ensures phrases at chunk boundaries are searchable.
+const (
+	ChunkWords        = 800
+	ChunkOverlapWords = 100
+)
+
+// contentChunk is part of document content.
+type contentChunk struct {
+	Index   int
+	Content string
+	// Page is the page in the document where the chunk starts, starting from 1.
+	Page int
+}
+
+var regexWord = regexp.MustCompile(`\S+`)
+
+// page markers added by tesseract ocr: first page does not have marker, second page has '(Page 1)'.
+var regexOcrPage = regexp.MustCompile(`(?i)\(Page (\d+)\)`)
+
+// chunkContent splits content into chunks of size words, consecutive chunks sharing overlap words.
+// Content shorter than size is returned as single chunk.
+func chunkContent(content string, size, overlap int) []contentChunk {
+	words := regexWord.FindAllStringIndex(content, -1)
+	if len(words) <= size {
+		return []contentChunk{{Index: 0, Content: content, Page: 1}}
+	}
+
+	step := size - overlap
+	if step <= 0 {
+		step = size
+	}
+
+	chunks := make([]contentChunk, 0, len(words)/step+1)
+	for start := 0; ; start += step {
+		end := start + size
+		if end > len(words) {
+			end = len(words)
+		}
+		startPos := words[start][0]
+		chunks = append(chunks, contentChunk{
+			Index:   len(chunks),
+			Content: content[startPos:words[end-1][1]],
+			Page:    pageAt(content, startPos),
+		})
+		if end == len(words) {
+			break
+		}
+	}
+	return chunks
+}
+
+// pageAt returns page number at given position in the content. Pages are separated either with form feeds
+// (pdftotext) or with page markers (ocr).
+func pageAt(content string, pos int) int {
+	before := content[:pos]
+	page := strings.Count(before, "\f") + 1
+
+	markers := regexOcrPage.FindAllStringSubmatch(before, -1)
+	if len(markers) > 0 {
+		ocrPage, err := strconv.Atoi(markers[len(markers)-1][1])
+		if err == nil && ocrPage+1 > page {
+			page = ocrPage + 1
+		}
+	}
+	return page
+}
+
+// chunkId returns the primary key of the chunk in the search index.
+func chunkId(documentId string, chunk int) string {
+	return documentId + "_" + strconv.Itoa(chunk)
+}
+
+// chunkOfWord returns the first chunk that contains the word at given index.
+func chunkOfWord(word, size, overlap int) int {
+	step := size - overlap
+	if step <= 0 {
+		step = size
+	}
+	if word < size {
+		return 0
+	}
+	return (word-size)/step + 1
+}
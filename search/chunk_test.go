@@ -0,0 +1,95 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testWords(from, to int) []string {
+	words := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		words = append(words, "w"+strconv.Itoa(i))
+	}
+	return words
+}
+
+func Test_chunkContent(t *testing.T) {
+	short := "a short document"
+	chunks := chunkContent(short, 10, 2)
+	if len(chunks) != 1 || chunks[0].Content != short || chunks[0].Page != 1 {
+		t.Errorf("short content: %v", chunks)
+	}
+
+	content := strings.Join(testWords(0, 25), " ")
+	chunks = chunkContent(content, 10, 2)
+	want := []string{
+		strings.Join(testWords(0, 10), " "),
+		strings.Join(testWords(8, 18), " "),
+		strings.Join(testWords(16, 25), " "),
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i, v := range chunks {
+		if v.Index != i {
+			t.Errorf("chunk %d index = %d", i, v.Index)
+		}
+		if v.Content != want[i] {
+			t.Errorf("chunk %d = %s, want %s", i, v.Content, want[i])
+		}
+	}
+
+	// overlapping words belong to the first chunk
+	if got := chunkOfWord(9, 10, 2); got != 0 {
+		t.Errorf("chunkOfWord(9) = %d, want 0", got)
+	}
+	if got := chunkOfWord(10, 10, 2); got != 1 {
+		t.Errorf("chunkOfWord(10) = %d, want 1", got)
+	}
+	if got := chunkOfWord(18, 10, 2); got != 2 {
+		t.Errorf("chunkOfWord(18) = %d, want 2", got)
+	}
+}
+
+func Test_chunkContent_pages(t *testing.T) {
+	pages := []string{
+		strings.Join(testWords(0, 8), " "),
+		strings.Join(testWords(8, 16), " "),
+		strings.Join(testWords(16, 24), " "),
+	}
+	content := strings.Join(pages, "\f")
+	chunks := chunkContent(content, 10, 2)
+	wantPages := []int{1, 2, 3}
+	for i, v := range chunks {
+		if v.Page != wantPages[i] {
+			t.Errorf("chunk %d page = %d, want %d", i, v.Page, wantPages[i])
+		}
+	}
+
+	ocr := "first page\n\n(Page 1)\n\nsecond page\n\n(Page 2)\n\nthird page"
+	if got := pageAt(ocr, strings.Index(ocr, "third")); got != 3 {
+		t.Errorf("ocr page = %d, want 3", got)
+	}
+	if got := pageAt(ocr, 0); got != 1 {
+		t.Errorf("ocr page = %d, want 1", got)
+	}
+}
@@ -15,27 +15,33 @@ import (
 	"tryffel.net/go/virtualpaper/storage"
 )
 
-// Engine is as search engine that uses Meilisearch to provide full-text-search
+// Meilisearch is a search engine that uses Meilisearch to provide full-text-search
 // across documents.
-type Engine struct {
+type Meilisearch struct {
 	client *meilisearch.Client
 	db     *storage.Database
 	Url    string
 	ApiKey string
+	layout indexLayout
 }
 
-func NewEngine(db *storage.Database, conf *config.Meilisearch) (*Engine, error) {
-	engine := &Engine{
+func NewMeilisearch(db *storage.Database, conf *config.Meilisearch) (*Meilisearch, error) {
+	layout, err := newIndexLayout(IndexMode(conf.IndexMode), conf.Shards)
+	if err != nil {
+		return nil, err
+	}
+	engine := &Meilisearch{
 		Url:    conf.Url,
 		ApiKey: conf.ApiKey,
 		db:     db,
+		layout: layout,
 	}
-	err := engine.connect()
+	err = engine.connect()
 	return engine, err
 }
 
 // connect creates a connection to meilisearch instance and initializes index if neccessary.
-func (e *Engine) connect() error {
+func (e *Meilisearch) connect() error {
 	logrus.Infof("connect to meilisearch at %s", e.Url)
 	e.client = meilisearch.NewClient(meilisearch.ClientConfig{
 		Host:    e.Url,
@@ -50,36 +56,76 @@ func (e *Engine) connect() error {
 	return e.ensureIndexExists()
 }
 
-func indexName(userid int) string {
-	return "virtualpaper-" + strconv.Itoa(userid)
-}
-
-var indexNameToUserIdRegex = regexp.MustCompile("virtualpaper-(.+)")
+var indexNameToUserIdRegex = regexp.MustCompile(`^virtualpaper-(\d+)$`)
 
-func (e *Engine) ensureIndexExists() error {
-	logrus.Debugf("ensure meilisearch indices exist")
+func (e *Meilisearch) ensureIndexExists() error {
+	logrus.Debugf("ensure meilisearch indices exist (mode: %s)", e.layout.mode)
 	users, err := e.db.UserStore.GetUsers()
 	if err != nil {
 		return fmt.Errorf("get users: %v", err)
 	}
 
-	for _, v := range *users {
-		err = e.AddUserIndex(v.Id)
+	userIds := make([]int, len(*users))
+	for i, v := range *users {
+		userIds[i] = v.Id
+	}
+
+	for _, index := range e.layout.indexNames(userIds) {
+		reindex, err := e.createIndex(index)
 		if err != nil {
-			logrus.Errorf("error checking & creating index for user %d: %v", v.Id, err)
+			logrus.Errorf("error checking & creating index %s: %v", index, err)
+			continue
+		}
+		if reindex {
+			e.reindex(index)
 		}
-
 	}
 	return nil
 }
 
-func (e *Engine) UpdateUserPreferences(userId int) error {
+// indexUserId returns the user of a single user's index.
+func indexUserId(index string) (int, bool) {
+	match := indexNameToUserIdRegex.FindStringSubmatch(index)
+	if len(match) != 2 {
+		return 0, false
+	}
+	userId, err := strconv.Atoi(match[1])
+	return userId, err == nil
+}
+
+// reindex schedules re-indexing all documents that belong to the index.
+func (e *Meilisearch) reindex(index string) {
+	userId := 0
+	if !e.layout.multiTenant() {
+		var ok bool
+		userId, ok = indexUserId(index)
+		if !ok {
+			return
+		}
+	}
+	err := e.db.JobStore.ForceProcessing(userId, "", models.ProcessFts)
+	if err != nil {
+		logrus.Errorf("schedule re-indexing documents for index %s: %v", index, err)
+	}
+}
+
+func (e *Meilisearch) UpdateUserPreferences(userId int) error {
+
+	if e.layout.multiTenant() {
+		// synonyms are stored with the documents and stop words are applied when searching,
+		// so the documents need to be re-indexed.
+		err := e.db.JobStore.ForceProcessing(userId, "", models.ProcessFts)
+		if err != nil {
+			return fmt.Errorf("schedule re-indexing: %v", err)
+		}
+		return nil
+	}
 
 	preferences, err := e.db.UserStore.GetUserPreferences(userId)
 	if err != nil {
 		return fmt.Errorf("get preferences: %v", err)
 	}
-	index := indexName(userId)
+	index := e.layout.indexName(userId)
 
 	_, err = e.client.Index(index).UpdateStopWords(&preferences.StopWords)
 	if err != nil {
@@ -91,10 +137,10 @@ func (e *Engine) UpdateUserPreferences(userId int) error {
 	if err != nil {
 		return fmt.Errorf("update synonyms: %v", err)
 	}
-	return nil
+	return e.updateSearchSettings(index, preferences.SearchSettings)
 }
 
-func (e *Engine) ping() error {
+func (e *Meilisearch) ping() error {
 	v, err := e.client.GetVersion()
 	if err != nil {
 		if strings.Contains(err.Error(), "connection refused") {
@@ -108,56 +154,242 @@ func (e *Engine) ping() error {
 }
 
 // IndexDocuments sends documents to meilisearch for indexing
-func (e *Engine) IndexDocuments(docs *[]models.Document, userId int) error {
-	data := make([]map[string]interface{}, len(*docs))
+func (e *Meilisearch) IndexDocuments(docs *[]models.Document, userId int) error {
+	synonyms := map[string][]string{}
+	if e.layout.multiTenant() {
+		_, userSynonyms, err := e.db.UserStore.GetSearchPreferences(userId)
+		if err != nil {
+			return fmt.Errorf("get synonyms: %v", err)
+		}
+		synonyms = buildSynonyms(userSynonyms)
+	}
+
+	docIds := make([]string, len(*docs))
 	for i, v := range *docs {
+		docIds[i] = v.Id
+	}
+	// shared documents are stored in the index of each user that can read them
+	readers, err := e.db.DocumentStore.GetDocumentReaders(docIds)
+	if err != nil {
+		return fmt.Errorf("get document readers: %v", err)
+	}
+
+	data := map[string][]map[string]interface{}{}
+	indexDocIds := map[string][]string{}
+	chunkCounts := make(map[string]int, len(*docs))
+	for _, v := range *docs {
+		docReaders := readers[v.Id]
+		if len(docReaders) == 0 {
+			docReaders = []int{v.UserId}
+		}
+		indices := e.readerIndices(docReaders)
 
 		tags := make([]string, len(v.Tags))
 		for tagI, tag := range v.Tags {
 			tags[tagI] = tag.Key
 		}
 
-		metadata := make([]string, len(v.Metadata))
-		for metadataI, v := range v.Metadata {
-			key := normalizeMetadataKey(v.Key)
-			value := normalizeMetadataValue(v.Value)
-			metadata[metadataI] = key + ":" + value
+		metadata := make([]string, 0, len(v.Metadata))
+		// typed values are indexed as numbers to allow comparing them
+		numbers := map[string][]float64{}
+		seenMetadata := make(map[string]bool, len(v.Metadata))
+		for _, v := range v.Metadata {
+			for _, entry := range metadataEntries(v) {
+				if !seenMetadata[entry] {
+					seenMetadata[entry] = true
+					metadata = append(metadata, entry)
+				}
+			}
+			if number, err := v.ValueType.NumericValue(v.Value); err == nil {
+				numbers[metadataNumKey(v.KeyId)] = append(numbers[metadataNumKey(v.KeyId)], number)
+			}
+		}
+
+		var docSynonyms []string
+		if e.layout.multiTenant() {
+			docSynonyms = expandSynonyms(synonyms, v.Name, v.Description, v.Content)
 		}
 
-		data[i] = map[string]interface{}{
-			"document_id": v.Id,
-			"user_id":     v.UserId,
-			"name":        v.Name,
-			"file_name":   v.Filename,
-			"content":     v.Content,
-			"hash":        v.Hash,
-			"created_at":  v.CreatedAt.Unix(),
-			"updated_at":  v.UpdatedAt.Unix(),
-			"tags":        tags,
-			"metadata":    metadata,
-			"date":        v.Date.Unix(),
-			"description": v.Description,
-			"mimetype":    v.Mimetype,
+		chunks := chunkContent(v.Content, ChunkWords, ChunkOverlapWords)
+		chunkCounts[v.Id] = len(chunks)
+		for _, chunk := range chunks {
+			record := map[string]interface{}{
+				"id":           chunkId(v.Id, chunk.Index),
+				"document_id":  v.Id,
+				"chunk":        chunk.Index,
+				"chunks":       len(chunks),
+				"page":         chunk.Page,
+				"user_id":      docReaders,
+				"name":         v.Name,
+				"file_name":    v.Filename,
+				"content":      chunk.Content,
+				"hash":         v.Hash,
+				"created_at":   v.CreatedAt.Unix(),
+				"updated_at":   v.UpdatedAt.Unix(),
+				"tags":         tags,
+				"metadata":     metadata,
+				"metadata_num": numbers,
+				"date":         v.Date.Unix(),
+				"description":  v.Description,
+				"mimetype":     v.Mimetype,
+				FacetYear:      facetYear(v.Date),
+				FacetMonth:     facetMonth(v.Date),
+			}
+			if docSynonyms != nil {
+				record["synonyms"] = docSynonyms
+			}
+			for _, index := range indices {
+				data[index] = append(data[index], record)
+			}
+		}
+		for _, index := range indices {
+			indexDocIds[index] = append(indexDocIds[index], v.Id)
 		}
 	}
 
-	_, err := e.client.Index(indexName(userId)).UpdateDocuments(data)
+	for index, records := range data {
+		oldChunks, err := e.indexedChunks(index, indexDocIds[index])
+		if err != nil {
+			return err
+		}
+		_, err = e.client.Index(index).UpdateDocuments(records)
+		if err != nil {
+			return fmt.Errorf("index documents: %v", err)
+		}
+
+		staleChunks := []string{}
+		for docId, n := range oldChunks {
+			for i := chunkCounts[docId]; i < n; i++ {
+				staleChunks = append(staleChunks, chunkId(docId, i))
+			}
+		}
+		if len(staleChunks) > 0 {
+			_, err = e.client.Index(index).DeleteDocuments(staleChunks)
+			if err != nil {
+				return fmt.Errorf("delete old chunks: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// readerIndices returns the distinct indices of the users.
+func (e *Meilisearch) readerIndices(userIds []int) []string {
+	seen := map[string]bool{}
+	indices := []string{}
+	for _, v := range userIds {
+		index := e.layout.indexName(v)
+		if !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+// indexedChunks returns the number of chunks each document currently has in the index.
+func (e *Meilisearch) indexedChunks(index string, docIds []string) (map[string]int, error) {
+	chunks := make(map[string]int, len(docIds))
+	if len(docIds) == 0 {
+		return chunks, nil
+	}
+
+	quoted := make([]string, len(docIds))
+	for i, v := range docIds {
+		quoted[i] = `"` + v + `"`
+	}
+	// every chunk stores the number of chunks, first chunk is enough
+	filter := firstChunkFilter(fmt.Sprintf("document_id IN [%s]", strings.Join(quoted, ", ")))
+
+	res, err := e.client.Index(index).Search("", &meilisearch.SearchRequest{
+		Limit:                int64(len(docIds)),
+		AttributesToRetrieve: []string{"document_id", "chunks"},
+		Filter:               filter,
+		PlaceholderSearch:    true,
+	})
 	if err != nil {
-		return fmt.Errorf("index documents: %v", err)
+		return chunks, fmt.Errorf("get indexed chunks: %v", err)
+	}
+	for _, v := range res.Hits {
+		if hit, ok := v.(map[string]interface{}); ok {
+			chunks[getString("document_id", hit)] = getInt("chunks", hit)
+		}
+	}
+	return chunks, nil
+}
+
+// documentChunkIds returns ids of all chunks of the documents.
+func documentChunkIds(chunks map[string]int) []string {
+	ids := []string{}
+	for docId, n := range chunks {
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			ids = append(ids, chunkId(docId, i))
+		}
 	}
+	return ids
+}
 
+// DeleteDocument removes all chunks of the document from the index of its owner
+// and the indices of the users the document is shared with.
+func (e *Meilisearch) DeleteDocument(docId string, userId int) error {
+	readers, err := e.db.DocumentStore.GetDocumentReaders([]string{docId})
+	if err != nil {
+		return fmt.Errorf("get document readers: %v", err)
+	}
+	for _, index := range e.readerIndices(append(readers[docId], userId)) {
+		err = e.deleteIndexedDocument(index, docId)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (e *Engine) DeleteDocument(docId string, userId int) error {
+// UnshareDocument removes the document from the index of the user, unless the index
+// is shared with other users that can still read the document.
+func (e *Meilisearch) UnshareDocument(docId string, userId int) error {
+	index := e.layout.indexName(userId)
+	readers, err := e.db.DocumentStore.GetDocumentReaders([]string{docId})
+	if err != nil {
+		return fmt.Errorf("get document readers: %v", err)
+	}
+	for _, v := range readers[docId] {
+		if v == userId {
+			return nil
+		}
+		if e.layout.indexName(v) == index {
+			// user_id of the records is updated when the document is indexed again.
+			return nil
+		}
+	}
+	return e.deleteIndexedDocument(index, docId)
+}
+
+func (e *Meilisearch) deleteIndexedDocument(index string, docId string) error {
+	return e.deleteIndexedDocuments(index, []string{docId})
+}
+
+// deleteIndexedDocuments removes all chunks of the documents from the index.
+func (e *Meilisearch) deleteIndexedDocuments(index string, docIds []string) error {
+	chunks, err := e.indexedChunks(index, docIds)
+	if err != nil {
+		return err
+	}
+	for _, v := range docIds {
+		if _, ok := chunks[v]; !ok {
+			chunks[v] = 1
+		}
+	}
 
-	_, err := e.client.Index(indexName(userId)).DeleteDocument(docId)
+	_, err = e.client.Index(index).DeleteDocuments(documentChunkIds(chunks))
 	if err != nil {
 		return fmt.Errorf("delete documents: %v", err)
 	}
 	return nil
 }
-
 func buildSynonyms(synonyms [][]string) map[string][]string {
 	output := map[string][]string{}
 	for _, tuple := range synonyms {
@@ -189,7 +421,7 @@ func buildSynonyms(synonyms [][]string) map[string][]string {
 	return output
 }
 
-func (e *Engine) GetHealth() (string, bool, error) {
+func (e *Meilisearch) GetHealth() (string, bool, error) {
 	if e.client.IsHealthy() {
 		return "available", true, nil
 	}
@@ -205,14 +437,7 @@ func (e *Engine) GetHealth() (string, bool, error) {
 	return resp.Status, false, err
 }
 
-type EngineStatus struct {
-	Ok      bool   `json:"engine_ok"`
-	Status  string `json:"status"`
-	Version string `json:"version"`
-	Name    string `json:"name"`
-}
-
-func (e *Engine) GetStatus() (*EngineStatus, error) {
+func (e *Meilisearch) GetStatus() (*EngineStatus, error) {
 	status := &EngineStatus{}
 	status.Name = "Meilisearch"
 
@@ -233,14 +458,8 @@ func (e *Engine) GetStatus() (*EngineStatus, error) {
 
 }
 
-type UserIndexStatus struct {
-	UserId       int  `json:"user_id"`
-	NumDocuments int  `json:"documents_count"`
-	Indexing     bool `json:"indexing"`
-}
-
-func (e *Engine) GetUserIndexStatus(userId int) (UserIndexStatus, error) {
-	stats, err := e.client.Index(indexName(userId)).GetStats()
+func (e *Meilisearch) GetUserIndexStatus(userId int) (UserIndexStatus, error) {
+	stats, err := e.client.Index(e.layout.indexName(userId)).GetStats()
 	if err != nil {
 		return UserIndexStatus{}, err
 	}
@@ -249,11 +468,28 @@ func (e *Engine) GetUserIndexStatus(userId int) (UserIndexStatus, error) {
 		NumDocuments: int(stats.NumberOfDocuments),
 		Indexing:     stats.IsIndexing,
 	}
+	if e.layout.multiTenant() {
+		stat.NumDocuments, err = e.countUserDocuments(userId)
+	}
 	return stat, err
 }
 
+// countUserDocuments returns number of user's documents in a multi-tenant index.
+func (e *Meilisearch) countUserDocuments(userId int) (int, error) {
+	res, err := e.client.Index(e.layout.indexName(userId)).Search("", &meilisearch.SearchRequest{
+		HitsPerPage:       1,
+		Page:              1,
+		Filter:            e.layout.userFilter(userId, firstChunkFilter("")),
+		PlaceholderSearch: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("count documents: %v", err)
+	}
+	return int(res.TotalHits), nil
+}
+
 // GetUserIndicesStatus returns list of indices, total db size in bytes and possible error.
-func (e *Engine) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error) {
+func (e *Meilisearch) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error) {
 	var err errors.Error
 	stats, meiliErr := e.client.GetStats()
 	if meiliErr != nil {
@@ -264,12 +500,34 @@ func (e *Engine) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error)
 		return map[int]*UserIndexStatus{}, 0, err
 	}
 
+	if e.layout.multiTenant() {
+		users, dbErr := e.db.UserStore.GetUsers()
+		if dbErr != nil {
+			return map[int]*UserIndexStatus{}, stats.DatabaseSize, dbErr
+		}
+		indices := make(map[int]*UserIndexStatus, len(*users))
+		for _, v := range *users {
+			status := &UserIndexStatus{UserId: v.Id}
+			if index, ok := stats.Indexes[e.layout.indexName(v.Id)]; ok {
+				status.Indexing = index.IsIndexing
+			}
+			count, countErr := e.countUserDocuments(v.Id)
+			if countErr != nil {
+				logrus.Warningf("count documents for user %d: %v", v.Id, countErr)
+			}
+			status.NumDocuments = count
+			indices[v.Id] = status
+		}
+		return indices, stats.DatabaseSize, nil
+	}
+
 	indices := make(map[int]*UserIndexStatus, len(stats.Indexes))
 	counter := 0
 	for i, v := range stats.Indexes {
 		user := indexNameToUserIdRegex.FindStringSubmatch(i)
 		if len(user) != 2 {
-			logrus.Warningf("not virtualpaper index: %s, skipping", user)
+			logrus.Warningf("not virtualpaper index: %s, skipping", i)
+			continue
 		}
 		userId, err := strconv.Atoi(user[1])
 		if err != nil {
@@ -287,21 +545,72 @@ func (e *Engine) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error)
 	return indices, stats.DatabaseSize, nil
 }
 
-func (e *Engine) DeleteDocuments(userId int) error {
-	index := indexName(userId)
-	_, err := e.client.Index(index).DeleteAllDocuments()
+// deleteBatchSize is the number of documents to delete from the index at once.
+const deleteBatchSize = 100
+
+// DeleteDocuments removes documents the user owns from the indices of the user and the users
+// the documents are shared with. Documents are listed from the database, since in multi-tenant
+// indices user_id contains all readers and cannot be used to find the owner's documents.
+// With per-user indices the user's index is cleared too.
+func (e *Meilisearch) DeleteDocuments(userId int) error {
+	docIds, err := e.db.UserStore.GetUserDocumentIds(userId)
+	if err != nil {
+		return fmt.Errorf("get user's documents: %v", err)
+	}
+
+	for start := 0; start < len(docIds); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(docIds) {
+			end = len(docIds)
+		}
+		batch := docIds[start:end]
+		readers, err := e.db.DocumentStore.GetDocumentReaders(batch)
+		if err != nil {
+			return fmt.Errorf("get document readers: %v", err)
+		}
+		indexDocs := map[string][]string{}
+		for _, docId := range batch {
+			for _, index := range e.readerIndices(append(readers[docId], userId)) {
+				indexDocs[index] = append(indexDocs[index], docId)
+			}
+		}
+		for index, ids := range indexDocs {
+			err = e.deleteIndexedDocuments(index, ids)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.layout.multiTenant() {
+		return nil
+	}
+	_, err = e.client.Index(e.layout.indexName(userId)).DeleteAllDocuments()
 	if err != nil {
 		return fmt.Errorf("delete index: %v", err)
 	}
 	return nil
 }
 
-func (e *Engine) AddUserIndex(userId int) error {
-	index := indexName(userId)
+// AddUserIndex ensures the index for the user exists.
+func (e *Meilisearch) AddUserIndex(userId int) error {
+	index := e.layout.indexName(userId)
+	reindex, err := e.createIndex(index)
+	if reindex {
+		e.reindex(index)
+	}
+	return err
+}
+
+// createIndex creates index if it does not exist. Indices created before documents were split into chunks
+// use document_id as the primary key, and they are re-created. Indices that are missing facet attributes
+// are re-configured. Returns true if documents need to be re-indexed.
+func (e *Meilisearch) createIndex(index string) (bool, error) {
 	indexExists := false
+	reindex := false
 	logrus.Debugf("ensure meilisearch index %s exists", index)
 	var err error
-	_, err = e.client.GetIndex(index)
+	info, err := e.client.GetIndex(index)
 	if err != nil {
 		if e, ok := err.(*meilisearch.Error); ok {
 			if e.StatusCode == 404 {
@@ -313,50 +622,180 @@ func (e *Engine) AddUserIndex(userId int) error {
 		indexExists = true
 	}
 	if err != nil {
-		return fmt.Errorf("get indexes: %v", err)
-	}
-
-	if !indexExists {
-		logrus.Warningf("Creating new meilisearch index '%s'", index)
-		_, err = e.client.CreateIndex(&meilisearch.IndexConfig{
-			Uid:        index,
-			PrimaryKey: "document_id",
-		})
-
-		fields := &[]string{
-			"document_id",
-			"user_id",
-			"name",
-			"file_name",
-			"content",
-			"hash",
-			"created_at",
-			"updated_at",
-			"tags",
-			"metadata",
-			"date",
-			"description",
-			"tags",
-			"metadata_key",
-			"metadata_value",
-			"mimetype",
-		}
-		_, err = e.client.Index(index).UpdateFilterableAttributes(fields)
+		return false, fmt.Errorf("get indexes: %v", err)
+	}
+
+	if indexExists && info.PrimaryKey != "" && info.PrimaryKey != "id" {
+		logrus.Warningf("Re-creating meilisearch index '%s' to index document chunks", index)
+		_, err = e.client.DeleteIndex(index)
 		if err != nil {
-			logrus.Errorf("meilisearch set filterable attributes: %v", err)
+			return false, fmt.Errorf("delete index: %v", err)
 		}
+		indexExists = false
+		reindex = true
+	}
 
-		_, err = e.client.Index(index).UpdateSortableAttributes(fields)
-		if err != nil {
-			logrus.Errorf("meilisearch set sortable attributes: %v", err)
+	if indexExists {
+		return e.upgradeIndex(index)
+	}
+
+	logrus.Warningf("Creating new meilisearch index '%s'", index)
+	_, err = e.client.CreateIndex(&meilisearch.IndexConfig{
+		Uid:        index,
+		PrimaryKey: "id",
+	})
+	if err != nil {
+		return reindex, fmt.Errorf("create index: %v", err)
+	}
+	e.configureIndex(index)
+	return reindex, nil
+}
+
+// upgradeIndex updates settings of an existing index. Returns true if documents need to be re-indexed.
+func (e *Meilisearch) upgradeIndex(index string) (bool, error) {
+	filterable, err := e.client.Index(index).GetFilterableAttributes()
+	if err != nil {
+		return false, fmt.Errorf("get filterable attributes: %v", err)
+	}
+	// attributes added after the index was created
+	for _, attribute := range []string{FacetMonth, "metadata_num"} {
+		found := false
+		for _, v := range *filterable {
+			found = found || v == attribute
 		}
-		_, err = e.client.Index(index).UpdateSearchableAttributes(fields)
-		if err != nil {
-			logrus.Errorf("meilisearch set searchable attributes: %v", err)
+		if !found {
+			logrus.Warningf("Updating meilisearch index '%s' settings for new attribute '%s'", index, attribute)
+			e.configureIndex(index)
+			return true, nil
+		}
+	}
+
+	rules, err := e.client.Index(index).GetRankingRules()
+	if err != nil {
+		return false, fmt.Errorf("get ranking rules: %v", err)
+	}
+	if strings.Join(*rules, ",") != strings.Join(searchRankingRules(e.indexSettings(index)), ",") {
+		logrus.Warningf("Updating meilisearch index '%s' ranking rules", index)
+		e.updateRankingRules(index)
+	}
+
+	pagination, err := e.client.Index(index).GetPagination()
+	if err != nil {
+		return false, fmt.Errorf("get pagination: %v", err)
+	}
+	if pagination.MaxTotalHits != maxTotalHits {
+		logrus.Warningf("Updating meilisearch index '%s' pagination", index)
+		e.updatePagination(index)
+	}
+	return false, nil
+}
+
+// maxTotalHits is the maximum number of documents a search can page through and count exactly.
+// Meilisearch defaults to 1000.
+const maxTotalHits = 100000
+
+func (e *Meilisearch) updatePagination(index string) {
+	_, err := e.client.Index(index).UpdatePagination(&meilisearch.Pagination{MaxTotalHits: maxTotalHits})
+	if err != nil {
+		logrus.Errorf("meilisearch set pagination: %v", err)
+	}
+}
+
+// meiliRankingRules sorts by the requested sort before relevance, so that explicit sort always applies.
+// Relevance is used when search has no sort, and ties are sorted newest first.
+var meiliRankingRules = []string{"sort", "words", "typo", "proximity", "attribute", "exactness", "date:desc"}
+
+func (e *Meilisearch) updateRankingRules(index string) {
+	rules := searchRankingRules(e.indexSettings(index))
+	_, err := e.client.Index(index).UpdateRankingRules(&rules)
+	if err != nil {
+		logrus.Errorf("meilisearch set ranking rules: %v", err)
+	}
+}
+
+// configureIndex sets searchable, filterable and sortable attributes and pagination for the index.
+func (e *Meilisearch) configureIndex(index string) {
+	fields := append([]string{}, meiliSearchableAttributes...)
+	filterable := append(fields, "chunk", FacetYear, FacetMonth, "metadata_num")
+	_, err := e.client.Index(index).UpdateFilterableAttributes(&filterable)
+	if err != nil {
+		logrus.Errorf("meilisearch set filterable attributes: %v", err)
+	}
+
+	_, err = e.client.Index(index).UpdateSortableAttributes(&fields)
+	if err != nil {
+		logrus.Errorf("meilisearch set sortable attributes: %v", err)
+	}
+	searchable := e.searchableAttributes(e.indexSettings(index))
+	_, err = e.client.Index(index).UpdateSearchableAttributes(&searchable)
+	if err != nil {
+		logrus.Errorf("meilisearch set searchable attributes: %v", err)
+	}
+	// return only the best matching chunk for each document
+	_, err = e.client.Index(index).UpdateDistinctAttribute("document_id")
+	if err != nil {
+		logrus.Errorf("meilisearch set distinct attribute: %v", err)
+	}
+	e.updateRankingRules(index)
+	e.updatePagination(index)
+}
+
+// MigrateIndexMode moves documents from indices of the previous index mode into indices of the current mode.
+// All documents are scheduled for re-indexing and, unless keepOld is set, indices that are not used
+// by the current mode are deleted. Searches return partial results until the re-indexing has finished.
+func (e *Meilisearch) MigrateIndexMode(from IndexMode, fromShards int, keepOld bool) error {
+	old, err := newIndexLayout(from, fromShards)
+	if err != nil {
+		return err
+	}
+	if old == e.layout {
+		return fmt.Errorf("search engine is already using index mode '%s'", from)
+	}
+
+	users, err := e.db.UserStore.GetUsers()
+	if err != nil {
+		return fmt.Errorf("get users: %v", err)
+	}
+	userIds := make([]int, len(*users))
+	for i, v := range *users {
+		userIds[i] = v.Id
+	}
+
+	if !e.layout.multiTenant() {
+		for _, v := range userIds {
+			err = e.UpdateUserPreferences(v)
+			if err != nil {
+				logrus.Errorf("set search preferences for user %d: %v", v, err)
+			}
 		}
 	}
+
+	logrus.Infof("schedule all documents for re-indexing")
+	err = e.db.JobStore.ForceProcessing(0, "", models.ProcessFts)
 	if err != nil {
-		return fmt.Errorf("create index: %v", err)
+		return fmt.Errorf("schedule re-indexing: %v", err)
+	}
+
+	if keepOld {
+		return nil
+	}
+
+	current := map[string]bool{}
+	for _, v := range e.layout.indexNames(userIds) {
+		current[v] = true
+	}
+	for _, v := range old.indexNames(userIds) {
+		if current[v] {
+			continue
+		}
+		logrus.Warningf("delete meilisearch index '%s'", v)
+		_, err = e.client.DeleteIndex(v)
+		if err != nil {
+			if meiliErr, ok := err.(*meilisearch.Error); ok && meiliErr.StatusCode == 404 {
+				continue
+			}
+			return fmt.Errorf("delete index %s: %v", v, err)
+		}
 	}
 	return nil
 }
@@ -19,8 +19,19 @@
 package search
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/meilisearch/meilisearch-go"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
 func Test_buildSynonyms(t *testing.T) {
@@ -68,3 +79,100 @@ func Test_buildSynonyms(t *testing.T) {
 		})
 	}
 }
+
+// fakeMeilisearch serves chunk searches and records deleted chunks by index.
+type fakeMeilisearch struct {
+	lock    sync.Mutex
+	chunks  map[string]int
+	filters []string
+	deleted map[string][]string
+}
+
+var fakeIdRegex = regexp.MustCompile(`"([^"]+)"`)
+
+func (f *fakeMeilisearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	index := parts[1]
+	switch parts[len(parts)-1] {
+	case "search":
+		body := struct {
+			Filter string `json:"filter"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.filters = append(f.filters, body.Filter)
+		hits := []map[string]interface{}{}
+		for _, match := range fakeIdRegex.FindAllStringSubmatch(body.Filter, -1) {
+			if n, ok := f.chunks[match[1]]; ok {
+				hits = append(hits, map[string]interface{}{"document_id": match[1], "chunks": n})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"hits": hits})
+	case "delete-batch":
+		ids := []string{}
+		json.NewDecoder(r.Body).Decode(&ids)
+		f.deleted[index] = append(f.deleted[index], ids...)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"taskUid": 1})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMeilisearch_DeleteDocuments(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// user 1 owns documents a and b, b is shared with user 2.
+	mock.ExpectQuery(`SELECT id FROM documents WHERE user_id = \$1`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("a").AddRow("b"))
+	mock.ExpectQuery(`SELECT id AS document_id, user_id FROM documents`).
+		WillReturnRows(sqlmock.NewRows([]string{"document_id", "user_id"}).
+			AddRow("a", 1).AddRow("b", 1).AddRow("b", 2))
+
+	fake := &fakeMeilisearch{
+		// c is shared with user 1 by another user and must stay in the index.
+		chunks:  map[string]int{"a": 2, "b": 1, "c": 3},
+		deleted: map[string][]string{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	layout, err := newIndexLayout(IndexModeSharded, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &Meilisearch{
+		client: meilisearch.NewClient(meilisearch.ClientConfig{Host: server.URL}),
+		db:     db,
+		layout: layout,
+	}
+	err = engine.DeleteDocuments(1)
+	if err != nil {
+		t.Fatalf("DeleteDocuments() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	for _, v := range fake.deleted {
+		sort.Strings(v)
+	}
+	want := map[string][]string{
+		shardIndexPrefix + "1": {"a_0", "a_1", "b_0"},
+		shardIndexPrefix + "0": {"b_0"},
+	}
+	if !reflect.DeepEqual(fake.deleted, want) {
+		t.Errorf("DeleteDocuments() deleted = %v, want %v", fake.deleted, want)
+	}
+	if len(fake.filters) == 0 {
+		t.Errorf("DeleteDocuments() did not search indexed chunks")
+	}
+	for _, v := range fake.filters {
+		if strings.Contains(v, "user_id") || !strings.Contains(v, "chunk = 0") {
+			t.Errorf("chunks must be searched by document id only, got filter: %s", v)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// dateKeywords are periods relative to current date. Weeks start on Monday.
+// Week, month and year are rolling periods ending today.
+var dateKeywords = []string{
+	"today", "yesterday",
+	"this-week", "last-week",
+	"this-month", "last-month",
+	"this-year", "last-year",
+	"week", "month", "year",
+}
+
+var regexYear = regexp.MustCompile(`^(\d{4})$`)
+var regexYearMonth = regexp.MustCompile(`^(\d{4}-\d{1,2})$`)
+var regexDate = regexp.MustCompile(`^(\d{4}-\d{1,2}-\d{1,2})$`)
+
+// userNow returns current time in user's timezone.
+func userNow(db *storage.Database, userId int) time.Time {
+	location, err := db.UserStore.GetUserLocation(userId)
+	if err != nil {
+		logrus.Warningf("get timezone for user %d: %v", userId, err)
+	}
+	return time.Now().In(location)
+}
+
+// calendarDate returns the date of t in its own location as midnight UTC,
+// which is how document dates are stored.
+func calendarDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// datePeriod parses a single period: keyword, year, year-month or date.
+// Start is inclusive and end is exclusive.
+func datePeriod(token string, now time.Time) (time.Time, time.Time, bool) {
+	today := calendarDate(now)
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	yearStart := time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+
+	switch token {
+	case "today":
+		return today, today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, true
+	case "this-week":
+		return weekStart, weekStart.AddDate(0, 0, 7), true
+	case "last-week":
+		return weekStart.AddDate(0, 0, -7), weekStart, true
+	case "this-month":
+		return monthStart, monthStart.AddDate(0, 1, 0), true
+	case "last-month":
+		return monthStart.AddDate(0, -1, 0), monthStart, true
+	case "this-year":
+		return yearStart, yearStart.AddDate(1, 0, 0), true
+	case "last-year":
+		return yearStart.AddDate(-1, 0, 0), yearStart, true
+	case "week":
+		return today.AddDate(0, 0, -7), today, true
+	case "month":
+		return today.AddDate(0, -1, 0), today, true
+	case "year":
+		return today.AddDate(-1, 0, 0), today, true
+	}
+
+	layout := ""
+	addYears, addMonths, addDays := 0, 0, 0
+	if regexYear.MatchString(token) {
+		addYears = 1
+		layout = "2006"
+	} else if regexYearMonth.MatchString(token) {
+		addMonths = 1
+		layout = "2006-1"
+	} else if regexDate.MatchString(token) {
+		addDays = 1
+		layout = "2006-1-2"
+	} else {
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := time.Parse(layout, token)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.AddDate(addYears, addMonths, addDays), true
+}
+
+// dateRange parses date expression into a range. Either limit can be zero for open-ended range.
+// Supported expressions, where X and Y are periods (see datePeriod):
+// X, X..Y, X.., ..Y, X|Y, X|, >X, >=X, <X, <=X.
+func dateRange(token string, now time.Time) (time.Time, time.Time, bool) {
+	var after, before time.Time
+	var ok bool
+
+	periodStart := func(period string) time.Time {
+		start, _, valid := datePeriod(period, now)
+		ok = ok && valid
+		return start
+	}
+	periodEnd := func(period string) time.Time {
+		_, end, valid := datePeriod(period, now)
+		ok = ok && valid
+		return end
+	}
+
+	ok = true
+	switch {
+	case strings.HasPrefix(token, ">="):
+		after = periodStart(token[2:])
+	case strings.HasPrefix(token, ">"):
+		after = periodEnd(token[1:])
+	case strings.HasPrefix(token, "<="):
+		before = periodEnd(token[2:])
+	case strings.HasPrefix(token, "<"):
+		before = periodStart(token[1:])
+	case strings.Contains(token, ".."):
+		parts := strings.SplitN(token, "..", 2)
+		if parts[0] == "" && parts[1] == "" {
+			return after, before, false
+		}
+		if parts[0] != "" {
+			after = periodStart(parts[0])
+		}
+		if parts[1] != "" {
+			before = periodEnd(parts[1])
+		}
+	case strings.Contains(token, "|"):
+		parts := strings.SplitN(token, "|", 2)
+		if parts[1] == "" {
+			parts[1] = "today"
+		}
+		after = periodStart(parts[0])
+		before = periodEnd(parts[1])
+	default:
+		after, before, ok = datePeriod(token, now)
+	}
+	if !ok || (!after.IsZero() && !before.IsZero() && !after.Before(before)) {
+		return time.Time{}, time.Time{}, false
+	}
+	return after, before, true
+}
+
+// matchDate validates date filter. Returned range is in calendar dates, see calendarDate.
+func matchDate(token string, now time.Time) (valueMatchStatus, string, time.Time, time.Time) {
+	if token == "" {
+		return valueMatchStatusIncomplete, "", time.Time{}, time.Time{}
+	}
+	after, before, ok := dateRange(token, now)
+	if !ok {
+		return valueMatchStatusInvalid, "", time.Time{}, time.Time{}
+	}
+	return valueMatchStatusOk, token, after, before
+}
+
+// suggestDate returns date expressions that contain the token.
+func suggestDate(token string, now time.Time) []string {
+	suggestions := []string{}
+
+	dateCandidates := []string{
+		now.Format("2006"),
+		now.Format("2006-1"),
+		now.Format("2006-1-2"),
+		now.AddDate(-2, 0, 0).Format("2006") + "..",
+		now.AddDate(-2, 0, 0).Format("2006") + "..today",
+		now.AddDate(-5, 0, 0).Format("2006") + ".." + now.AddDate(-1, 0, 0).Format("2006"),
+		">" + now.AddDate(0, -6, 0).Format("2006-1"),
+		"<" + now.Format("2006"),
+	}
+
+	if token == "" {
+		return append(append(suggestions, dateKeywords...), dateCandidates...)
+	}
+
+	// suggest the end of the range after the operator
+	prefix := ""
+	for _, operator := range []string{"..", "|", ">=", "<=", ">", "<"} {
+		if i := strings.LastIndex(token, operator); i >= 0 {
+			prefix = token[:i+len(operator)]
+			token = token[i+len(operator):]
+			break
+		}
+	}
+	if prefix != "" && token == "" {
+		for _, v := range dateKeywords {
+			suggestions = append(suggestions, prefix+v)
+		}
+		return suggestions
+	}
+
+	for _, v := range dateKeywords {
+		if strings.Contains(v, token) {
+			suggestions = append(suggestions, prefix+v)
+		}
+	}
+	return suggestions
+}
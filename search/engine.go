@@ -0,0 +1,108 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+const (
+	EngineMeilisearch = "meilisearch"
+	EngineLocal       = "local"
+)
+
+// Engine provides full-text-search over users' documents.
+// All engines support the same query language, see parseFilter.
+type Engine interface {
+	// IndexDocuments adds or updates documents in user's index.
+	IndexDocuments(docs *[]models.Document, userId int) error
+	// DeleteDocument removes single document from user's index.
+	DeleteDocument(docId string, userId int) error
+	// UnshareDocument removes a document that is no longer shared with the user from user's index.
+	UnshareDocument(docId string, userId int) error
+	// DeleteDocuments removes all documents the user owns from search, including the copies in
+	// the indices of the users they are shared with. It must be called before the documents
+	// are deleted from the database.
+	DeleteDocuments(userId int) error
+	// SearchDocuments searches user's documents. It returns documents and total number of hits.
+	SearchDocuments(userId int, query string, sort storage.SortKey, paging storage.Paging) ([]*models.Document, int, error)
+	// SearchFacets counts facet values of the documents matching the query.
+	SearchFacets(userId int, query string) (*SearchFacets, error)
+	// SuggestSearch returns suggestions for completing the query.
+	SuggestSearch(userId int, query string) (*QuerySuggestions, error)
+	// AddUserIndex ensures user's index exists.
+	AddUserIndex(userId int) error
+	// UpdateUserPreferences applies user's synonyms, stop words and search settings.
+	UpdateUserPreferences(userId int) error
+	// GetUserSearchSettingsStatus tells whether user's search settings have been applied.
+	GetUserSearchSettingsStatus(userId int) (*SearchSettingsStatus, error)
+
+	GetHealth() (string, bool, error)
+	GetStatus() (*EngineStatus, error)
+	GetUserIndexStatus(userId int) (UserIndexStatus, error)
+	// GetUserIndicesStatus returns status for each user, total index size in bytes and possible error.
+	GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error)
+}
+
+type EngineStatus struct {
+	Ok      bool   `json:"engine_ok"`
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Name    string `json:"name"`
+}
+
+type UserIndexStatus struct {
+	UserId       int  `json:"user_id"`
+	NumDocuments int  `json:"documents_count"`
+	Indexing     bool `json:"indexing"`
+}
+
+// NewEngine creates the search engine that is configured. If Meilisearch is configured with local fallback,
+// local engine is used whenever Meilisearch cannot be reached.
+func NewEngine(db *storage.Database, conf *config.Search, meiliConf *config.Meilisearch) (Engine, error) {
+	switch conf.Engine {
+	case EngineLocal:
+		return NewLocalEngine(db), nil
+	case EngineMeilisearch, "":
+	default:
+		return nil, fmt.Errorf("invalid search engine: '%s'", conf.Engine)
+	}
+
+	meili, err := NewMeilisearch(db, meiliConf)
+	if !conf.LocalFallback {
+		if err != nil {
+			return nil, err
+		}
+		return meili, nil
+	}
+	if meili == nil {
+		return nil, err
+	}
+	fallback := newFallbackEngine(meili, NewLocalEngine(db))
+	if err != nil {
+		logrus.Errorf("%v, using local search engine until Meilisearch is available", err)
+		fallback.setUnavailable(err)
+	}
+	return fallback, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Facets that are counted in addition to metadata keys.
+const (
+	FacetMimetype = "mimetype"
+	FacetYear     = "year"
+	FacetMonth    = "month"
+)
+
+// MaxFacetValues is the maximum number of values returned for each facet.
+const MaxFacetValues = 20
+
+// FacetValue is the number of matching documents that have the value.
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchFacets contains number of matching documents for each facet value, most common values first.
+type SearchFacets struct {
+	// Metadata is keyed by metadata key.
+	Metadata map[string][]FacetValue `json:"metadata"`
+	Mimetype []FacetValue            `json:"mimetype"`
+	Year     []FacetValue            `json:"year"`
+	Month    []FacetValue            `json:"month"`
+}
+
+func facetYear(date time.Time) string {
+	return date.UTC().Format("2006")
+}
+
+func facetMonth(date time.Time) string {
+	return date.UTC().Format("2006-01")
+}
+
+// facetCounter counts facet values. Keys are the user's facet keys, if empty all facets are counted.
+type facetCounter struct {
+	keys     map[string]bool
+	metadata map[string]map[string]int
+	mimetype map[string]int
+	year     map[string]int
+	month    map[string]int
+}
+
+func newFacetCounter(keys []string) *facetCounter {
+	c := &facetCounter{
+		metadata: map[string]map[string]int{},
+		mimetype: map[string]int{},
+		year:     map[string]int{},
+		month:    map[string]int{},
+	}
+	if len(keys) > 0 {
+		c.keys = make(map[string]bool, len(keys))
+		for _, v := range keys {
+			c.keys[strings.ToLower(normalizeMetadataKey(v))] = true
+		}
+	}
+	return c
+}
+
+func (c *facetCounter) enabled(key string) bool {
+	return c.keys == nil || c.keys[key]
+}
+
+// attributes returns the index attributes that are needed for counting the facets.
+func (c *facetCounter) attributes() []string {
+	attributes := []string{"metadata"}
+	for _, v := range []string{FacetMimetype, FacetYear, FacetMonth} {
+		if c.enabled(v) {
+			attributes = append(attributes, v)
+		}
+	}
+	return attributes
+}
+
+// add adds count to the value of the facet. Metadata is given in the indexed 'key:value' form.
+func (c *facetCounter) add(facet, value string, count int) {
+	if value == "" || count == 0 {
+		return
+	}
+	switch facet {
+	case "metadata":
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || !c.enabled(strings.ToLower(parts[0])) {
+			return
+		}
+		if c.metadata[parts[0]] == nil {
+			c.metadata[parts[0]] = map[string]int{}
+		}
+		c.metadata[parts[0]][parts[1]] += count
+	case FacetMimetype:
+		if c.enabled(FacetMimetype) {
+			c.mimetype[value] += count
+		}
+	case FacetYear:
+		if c.enabled(FacetYear) {
+			c.year[value] += count
+		}
+	case FacetMonth:
+		if c.enabled(FacetMonth) {
+			c.month[value] += count
+		}
+	}
+}
+
+func (c *facetCounter) result() *SearchFacets {
+	facets := &SearchFacets{
+		Metadata: make(map[string][]FacetValue, len(c.metadata)),
+		Mimetype: sortFacetValues(c.mimetype),
+		Year:     sortFacetValues(c.year),
+		Month:    sortFacetValues(c.month),
+	}
+	for key, values := range c.metadata {
+		facets.Metadata[key] = sortFacetValues(values)
+	}
+	return facets
+}
+
+func sortFacetValues(values map[string]int) []FacetValue {
+	output := make([]FacetValue, 0, len(values))
+	for value, count := range values {
+		output = append(output, FacetValue{Value: value, Count: count})
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Count != output[j].Count {
+			return output[i].Count > output[j].Count
+		}
+		return output[i].Value < output[j].Value
+	})
+	if len(output) > MaxFacetValues {
+		output = output[:MaxFacetValues]
+	}
+	return output
+}
@@ -0,0 +1,90 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_facetCounter(t *testing.T) {
+	counter := newFacetCounter(nil)
+	counter.add("metadata", "category:bills", 2)
+	counter.add("metadata", "category:receipts", 3)
+	counter.add("metadata", "company:Power_company", 1)
+	counter.add("metadata", "invalid", 1)
+	counter.add(FacetMimetype, "application/pdf", 4)
+	counter.add(FacetYear, "2022", 1)
+	counter.add(FacetMonth, "2022-03", 1)
+	counter.add(FacetMonth, "", 1)
+
+	want := &SearchFacets{
+		Metadata: map[string][]FacetValue{
+			"category": {{Value: "receipts", Count: 3}, {Value: "bills", Count: 2}},
+			"company":  {{Value: "Power_company", Count: 1}},
+		},
+		Mimetype: []FacetValue{{Value: "application/pdf", Count: 4}},
+		Year:     []FacetValue{{Value: "2022", Count: 1}},
+		Month:    []FacetValue{{Value: "2022-03", Count: 1}},
+	}
+	if got := counter.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("result() = %v, want %v", got, want)
+	}
+}
+
+func Test_facetCounter_keys(t *testing.T) {
+	counter := newFacetCounter([]string{"Category", "year"})
+	if got := counter.attributes(); !reflect.DeepEqual(got, []string{"metadata", FacetYear}) {
+		t.Errorf("attributes() = %v", got)
+	}
+	counter.add("metadata", "category:bills", 1)
+	counter.add("metadata", "company:Power_company", 1)
+	counter.add(FacetMimetype, "application/pdf", 1)
+	counter.add(FacetYear, "2022", 1)
+
+	want := &SearchFacets{
+		Metadata: map[string][]FacetValue{"category": {{Value: "bills", Count: 1}}},
+		Mimetype: []FacetValue{},
+		Year:     []FacetValue{{Value: "2022", Count: 1}},
+		Month:    []FacetValue{},
+	}
+	if got := counter.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("result() = %v, want %v", got, want)
+	}
+}
+
+func Test_sortFacetValues(t *testing.T) {
+	values := map[string]int{}
+	for i := 0; i < MaxFacetValues+5; i++ {
+		values[fmt.Sprintf("value-%02d", i)] = 1
+	}
+	values["common"] = 10
+
+	got := sortFacetValues(values)
+	if len(got) != MaxFacetValues {
+		t.Fatalf("sortFacetValues() returned %d values, want %d", len(got), MaxFacetValues)
+	}
+	if got[0] != (FacetValue{Value: "common", Count: 10}) {
+		t.Errorf("first value = %v, want most common", got[0])
+	}
+	if got[1].Value != "value-00" || got[MaxFacetValues-1].Value != fmt.Sprintf("value-%02d", MaxFacetValues-2) {
+		t.Errorf("values with equal count are not sorted by value: %v", got)
+	}
+}
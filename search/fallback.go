@@ -0,0 +1,245 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// fallbackRetryInterval is the minimum interval for trying to reconnect to Meilisearch.
+const fallbackRetryInterval = time.Second * 30
+
+// fallbackEngine uses Meilisearch when it is available and local engine otherwise.
+// Documents that were changed while Meilisearch was not available are re-indexed after it is available again.
+type fallbackEngine struct {
+	primary *Meilisearch
+	local   *LocalEngine
+
+	lock         sync.Mutex
+	available    bool
+	checkedAt    time.Time
+	pendingUsers map[int]bool
+}
+
+func newFallbackEngine(primary *Meilisearch, local *LocalEngine) *fallbackEngine {
+	return &fallbackEngine{
+		primary:      primary,
+		local:        local,
+		available:    true,
+		pendingUsers: map[int]bool{},
+	}
+}
+
+// isUnavailableError returns true if the error is caused by not being able to communicate with Meilisearch.
+func isUnavailableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "MeilisearchCommunicationError") || strings.Contains(msg, "MeilisearchTimeoutError") ||
+		strings.Contains(msg, "cannot connect to meilisearch")
+}
+
+func (f *fallbackEngine) setUnavailable(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.available {
+		logrus.Errorf("meilisearch not available, using local search engine: %v", err)
+	}
+	f.available = false
+	f.checkedAt = time.Now()
+}
+
+// primaryAvailable returns true if Meilisearch can be used. If it was not available previously,
+// reconnecting is attempted at most once in fallbackRetryInterval.
+func (f *fallbackEngine) primaryAvailable() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.available {
+		return true
+	}
+	if time.Since(f.checkedAt) < fallbackRetryInterval {
+		return false
+	}
+	f.checkedAt = time.Now()
+	err := f.primary.connect()
+	if err != nil {
+		logrus.Debugf("meilisearch still not available: %v", err)
+		return false
+	}
+
+	logrus.Infof("meilisearch is available again, re-index documents changed meanwhile")
+	for userId := range f.pendingUsers {
+		err = f.primary.db.JobStore.ForceProcessing(userId, "", models.ProcessFts)
+		if err != nil {
+			logrus.Errorf("schedule re-indexing documents for user %d: %v", userId, err)
+			continue
+		}
+		delete(f.pendingUsers, userId)
+	}
+	f.available = true
+	return true
+}
+
+// write applies change to both engines. Changes that could not be sent to Meilisearch are sent
+// once it is available again.
+func (f *fallbackEngine) write(userId int, primary func() error, local func() error) error {
+	err := local()
+	if err != nil {
+		logrus.Warningf("local search engine: %v", err)
+	}
+	if f.primaryAvailable() {
+		err = primary()
+		if !isUnavailableError(err) {
+			return err
+		}
+		f.setUnavailable(err)
+	}
+	f.lock.Lock()
+	if userId != 0 {
+		f.pendingUsers[userId] = true
+	}
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fallbackEngine) IndexDocuments(docs *[]models.Document, userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.IndexDocuments(docs, userId) },
+		func() error { return f.local.IndexDocuments(docs, userId) })
+}
+
+func (f *fallbackEngine) DeleteDocument(docId string, userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.DeleteDocument(docId, userId) },
+		func() error { return f.local.DeleteDocument(docId, userId) })
+}
+
+func (f *fallbackEngine) UnshareDocument(docId string, userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.UnshareDocument(docId, userId) },
+		func() error { return f.local.UnshareDocument(docId, userId) })
+}
+
+func (f *fallbackEngine) DeleteDocuments(userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.DeleteDocuments(userId) },
+		func() error { return f.local.DeleteDocuments(userId) })
+}
+
+func (f *fallbackEngine) AddUserIndex(userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.AddUserIndex(userId) },
+		func() error { return f.local.AddUserIndex(userId) })
+}
+
+func (f *fallbackEngine) UpdateUserPreferences(userId int) error {
+	return f.write(userId,
+		func() error { return f.primary.UpdateUserPreferences(userId) },
+		func() error { return f.local.UpdateUserPreferences(userId) })
+}
+
+func (f *fallbackEngine) GetUserSearchSettingsStatus(userId int) (*SearchSettingsStatus, error) {
+	if f.primaryAvailable() {
+		status, err := f.primary.GetUserSearchSettingsStatus(userId)
+		if !isUnavailableError(err) {
+			return status, err
+		}
+		f.setUnavailable(err)
+	}
+	return f.local.GetUserSearchSettingsStatus(userId)
+}
+
+func (f *fallbackEngine) SearchDocuments(userId int, query string, sort storage.SortKey, paging storage.Paging) ([]*models.Document, int, error) {
+	if f.primaryAvailable() {
+		docs, n, err := f.primary.SearchDocuments(userId, query, sort, paging)
+		if !isUnavailableError(err) {
+			return docs, n, err
+		}
+		f.setUnavailable(err)
+	}
+	return f.local.SearchDocuments(userId, query, sort, paging)
+}
+
+func (f *fallbackEngine) SearchFacets(userId int, query string) (*SearchFacets, error) {
+	if f.primaryAvailable() {
+		facets, err := f.primary.SearchFacets(userId, query)
+		if !isUnavailableError(err) {
+			return facets, err
+		}
+		f.setUnavailable(err)
+	}
+	return f.local.SearchFacets(userId, query)
+}
+
+func (f *fallbackEngine) SuggestSearch(userId int, query string) (*QuerySuggestions, error) {
+	return f.local.SuggestSearch(userId, query)
+}
+
+func (f *fallbackEngine) GetHealth() (string, bool, error) {
+	if f.primaryAvailable() {
+		status, ok, err := f.primary.GetHealth()
+		if ok {
+			return status, ok, err
+		}
+	}
+	return "fallback to local search engine", true, nil
+}
+
+func (f *fallbackEngine) GetStatus() (*EngineStatus, error) {
+	if f.primaryAvailable() {
+		status, err := f.primary.GetStatus()
+		if err == nil && status.Ok {
+			return status, nil
+		}
+	}
+	status, err := f.local.GetStatus()
+	if status != nil {
+		status.Name = "Local (Meilisearch not available)"
+	}
+	return status, err
+}
+
+func (f *fallbackEngine) GetUserIndexStatus(userId int) (UserIndexStatus, error) {
+	if f.primaryAvailable() {
+		status, err := f.primary.GetUserIndexStatus(userId)
+		if !isUnavailableError(err) {
+			return status, err
+		}
+		f.setUnavailable(err)
+	}
+	return f.local.GetUserIndexStatus(userId)
+}
+
+func (f *fallbackEngine) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error) {
+	if f.primaryAvailable() {
+		status, size, err := f.primary.GetUserIndicesStatus()
+		if !isUnavailableError(err) {
+			return status, size, err
+		}
+		f.setUnavailable(err)
+	}
+	return f.local.GetUserIndicesStatus()
+}
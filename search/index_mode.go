@@ -0,0 +1,161 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// IndexMode defines how documents are distributed across Meilisearch indices.
+type IndexMode string
+
+const (
+	// IndexModeUser creates one index for each user. Users' synonyms and stop words are
+	// configured as index settings. Meilisearch limits the number of indices, which limits the number of users.
+	IndexModeUser IndexMode = "user"
+	// IndexModeShared stores all documents in a single index and filters searches by user_id.
+	IndexModeShared IndexMode = "shared"
+	// IndexModeSharded distributes users across a fixed number of indices and filters searches by user_id.
+	IndexModeSharded IndexMode = "sharded"
+)
+
+var IndexModes = []IndexMode{IndexModeUser, IndexModeShared, IndexModeSharded}
+
+func (m IndexMode) IsValid() bool {
+	for _, v := range IndexModes {
+		if m == v {
+			return true
+		}
+	}
+	return false
+}
+
+const indexPrefix = "virtualpaper-"
+const sharedIndexName = indexPrefix + "shared"
+const shardIndexPrefix = indexPrefix + "shard-"
+
+// indexLayout maps users to index names.
+type indexLayout struct {
+	mode   IndexMode
+	shards int
+}
+
+func newIndexLayout(mode IndexMode, shards int) (indexLayout, error) {
+	if mode == "" {
+		mode = IndexModeUser
+	}
+	if !mode.IsValid() {
+		return indexLayout{}, fmt.Errorf("invalid index mode: '%s'", mode)
+	}
+	if mode == IndexModeSharded && shards <= 0 {
+		return indexLayout{}, fmt.Errorf("number of shards must be positive, got %d", shards)
+	}
+	return indexLayout{mode: mode, shards: shards}, nil
+}
+
+// multiTenant returns true if index contains documents from multiple users.
+// In that case searches must be filtered by user and user preferences cannot be stored as index settings.
+func (l indexLayout) multiTenant() bool {
+	return l.mode != IndexModeUser
+}
+
+func (l indexLayout) indexName(userId int) string {
+	switch l.mode {
+	case IndexModeShared:
+		return sharedIndexName
+	case IndexModeSharded:
+		return shardIndexPrefix + strconv.Itoa(userId%l.shards)
+	default:
+		return indexPrefix + strconv.Itoa(userId)
+	}
+}
+
+// indexNames returns the distinct indices the users are stored in, sorted by name.
+func (l indexLayout) indexNames(userIds []int) []string {
+	names := map[string]bool{}
+	for _, v := range userIds {
+		names[l.indexName(v)] = true
+	}
+	if l.mode == IndexModeSharded {
+		// users can be added later, make sure all shards exist.
+		for i := 0; i < l.shards; i++ {
+			names[shardIndexPrefix+strconv.Itoa(i)] = true
+		}
+	}
+	output := make([]string, 0, len(names))
+	for name := range names {
+		output = append(output, name)
+	}
+	sort.Strings(output)
+	return output
+}
+
+// userFilter returns the filter that limits results to the user's documents, if needed.
+func (l indexLayout) userFilter(userId int, filter string) string {
+	if !l.multiTenant() {
+		return filter
+	}
+	userFilter := fmt.Sprintf("user_id = %d", userId)
+	if filter == "" {
+		return userFilter
+	}
+	return userFilter + " AND (" + filter + ")"
+}
+
+// expandSynonyms returns synonyms of the words found in text. Shared indices cannot have per-user synonyms,
+// so instead the synonyms are stored with the document, which makes it match any of the synonyms.
+func expandSynonyms(synonyms map[string][]string, text ...string) []string {
+	if len(synonyms) == 0 {
+		return []string{}
+	}
+
+	content := strings.ToLower(strings.Join(text, " "))
+	words := map[string]bool{}
+	for _, v := range strings.FieldsFunc(content, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		words[v] = true
+	}
+
+	found := map[string]bool{}
+	for word, wordSynonyms := range synonyms {
+		word = strings.ToLower(word)
+		if strings.Contains(word, " ") {
+			if !strings.Contains(content, word) {
+				continue
+			}
+		} else if !words[word] {
+			continue
+		}
+		for _, v := range wordSynonyms {
+			found[strings.ToLower(v)] = true
+		}
+	}
+
+	output := make([]string, 0, len(found))
+	for v := range found {
+		output = append(output, v)
+	}
+	sort.Strings(output)
+	return output
+}
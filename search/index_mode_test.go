@@ -0,0 +1,110 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_indexLayout(t *testing.T) {
+	user, _ := newIndexLayout("", 0)
+	shared, _ := newIndexLayout(IndexModeShared, 0)
+	sharded, _ := newIndexLayout(IndexModeSharded, 3)
+
+	if got := user.indexName(5); got != "virtualpaper-5" {
+		t.Errorf("user index name = %s", got)
+	}
+	if got := shared.indexName(5); got != "virtualpaper-shared" {
+		t.Errorf("shared index name = %s", got)
+	}
+	if got := sharded.indexName(5); got != "virtualpaper-shard-2" {
+		t.Errorf("sharded index name = %s", got)
+	}
+
+	if got := user.indexNames([]int{1, 2}); !reflect.DeepEqual(got, []string{"virtualpaper-1", "virtualpaper-2"}) {
+		t.Errorf("user index names = %v", got)
+	}
+	if got := shared.indexNames([]int{1, 2}); !reflect.DeepEqual(got, []string{"virtualpaper-shared"}) {
+		t.Errorf("shared index names = %v", got)
+	}
+	want := []string{"virtualpaper-shard-0", "virtualpaper-shard-1", "virtualpaper-shard-2"}
+	if got := sharded.indexNames([]int{1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("sharded index names = %v", got)
+	}
+
+	if got := user.userFilter(1, "name=\"a\""); got != "name=\"a\"" {
+		t.Errorf("user filter = %s", got)
+	}
+	if got := shared.userFilter(1, ""); got != "user_id = 1" {
+		t.Errorf("shared filter = %s", got)
+	}
+	if got := shared.userFilter(1, "a OR b"); got != "user_id = 1 AND (a OR b)" {
+		t.Errorf("shared filter = %s", got)
+	}
+
+	if _, err := newIndexLayout("invalid", 0); err == nil {
+		t.Errorf("expected error for invalid mode")
+	}
+	if _, err := newIndexLayout(IndexModeSharded, 0); err == nil {
+		t.Errorf("expected error for zero shards")
+	}
+}
+
+func Test_searchQuery_removeStopWords(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		stopWords []string
+		want      string
+	}{
+		{"no stop words", "invoice 2022", nil, "invoice 2022"},
+		{"remove", "the invoice of car", []string{"the", "of"}, "invoice car"},
+		{"case insensitive", "The Invoice", []string{"the"}, "invoice"},
+		{"keep phrases", `"the end" of`, []string{"the", "of"}, `"the end"`},
+		{"only stop words", "the", []string{"the"}, "the"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, err := parseFilter(tt.query, testNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qs.removeStopWords(tt.stopWords)
+			if qs.Query != tt.want {
+				t.Errorf("removeStopWords() = %v, want %v", qs.Query, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandSynonyms(t *testing.T) {
+	synonyms := buildSynonyms([][]string{{"car", "automobile"}, {"ice cream", "gelato"}, {"bill", "invoice"}})
+
+	got := expandSynonyms(synonyms, "Car repair", "receipt for ice cream")
+	want := []string{"automobile", "gelato"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandSynonyms() = %v, want %v", got, want)
+	}
+
+	got = expandSynonyms(synonyms, "cartoon")
+	if len(got) != 0 {
+		t.Errorf("expandSynonyms() = %v, want empty", got)
+	}
+}
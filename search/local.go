@@ -0,0 +1,588 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+// localCropLength is the maximum number of words returned in document content, same as with Meilisearch.
+const localCropLength = 1000
+
+// field weights for ranking local search results.
+const (
+	localWeightName        = 4
+	localWeightMetadata    = 3
+	localWeightDescription = 2
+	localWeightContent     = 1
+)
+
+// LocalEngine is an embedded full-text-search engine that does not need any external services.
+// It keeps an in-memory index for each user. The index is built from the database on first search
+// and rebuilt whenever user's documents have been changed without the engine knowing about it.
+type LocalEngine struct {
+	db   *storage.Database
+	lock sync.RWMutex
+	// users are the indices by user, guarded by lock.
+	users map[int]*localIndex
+	// builds are the locks for building each user's index.
+	builds map[int]*sync.Mutex
+}
+
+func NewLocalEngine(db *storage.Database) *LocalEngine {
+	logrus.Infof("using local search engine")
+	return &LocalEngine{
+		db:     db,
+		users:  map[int]*localIndex{},
+		builds: map[int]*sync.Mutex{},
+	}
+}
+
+type localIndex struct {
+	state     storage.DocumentsState
+	documents map[string]*localDocument
+	stopWords []string
+	synonyms  map[string][]string
+}
+
+type localDocument struct {
+	document models.Document
+	// lowercase fields for filtering
+	name        string
+	description string
+	content     string
+	text        string
+	metadata    map[string]bool
+	// numeric values of typed metadata by key id
+	numbers map[int][]float64
+	terms   map[string]int
+	size    int64
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func newLocalDocument(doc *models.Document) *localDocument {
+	d := &localDocument{
+		document:    *doc,
+		name:        strings.ToLower(doc.Name),
+		description: strings.ToLower(doc.Description),
+		content:     strings.ToLower(doc.Content),
+		metadata:    make(map[string]bool, len(doc.Metadata)),
+		numbers:     map[int][]float64{},
+		terms:       map[string]int{},
+	}
+	d.text = strings.Join([]string{d.name, d.description, d.content}, " ")
+	d.size = int64(len(doc.Name) + len(doc.Description) + len(doc.Content))
+
+	addTerms := func(text string, weight int) {
+		for _, v := range tokenize(text) {
+			d.terms[v] += weight
+		}
+	}
+	addTerms(doc.Name, localWeightName)
+	addTerms(doc.Filename, localWeightContent)
+	addTerms(doc.Description, localWeightDescription)
+	addTerms(doc.Content, localWeightContent)
+	for _, v := range doc.Metadata {
+		for _, entry := range metadataEntries(v) {
+			d.metadata[strings.ToLower(entry)] = true
+		}
+		if number, err := v.ValueType.NumericValue(v.Value); err == nil {
+			d.numbers[v.KeyId] = append(d.numbers[v.KeyId], number)
+		}
+		addTerms(v.Key+" "+v.Value, localWeightMetadata)
+	}
+	return d
+}
+
+func statesEqual(a, b storage.DocumentsState) bool {
+	return a.Count == b.Count && a.Deleted == b.Deleted && a.UpdatedAt.Valid == b.UpdatedAt.Valid &&
+		a.UpdatedAt.Time.Equal(b.UpdatedAt.Time)
+}
+
+// userIndex returns up-to-date index for the user. Index is built without holding the engine lock,
+// so that building one user's index does not block searching and indexing for other users.
+func (e *LocalEngine) userIndex(userId int) (*localIndex, error) {
+	state, err := e.db.DocumentStore.GetDocumentsState(userId)
+	if err != nil {
+		return nil, err
+	}
+	if index, ok := e.currentIndex(userId, state); ok {
+		return index, nil
+	}
+
+	build := e.buildLock(userId)
+	build.Lock()
+	defer build.Unlock()
+	// another request may have built the index while waiting
+	if index, ok := e.currentIndex(userId, state); ok {
+		return index, nil
+	}
+
+	logrus.Debugf("build local search index for user %d", userId)
+	docs, err := e.db.DocumentStore.GetSearchDocuments(userId)
+	if err != nil {
+		return nil, err
+	}
+	index := &localIndex{
+		state:     state,
+		documents: make(map[string]*localDocument, len(*docs)),
+	}
+	for i := range *docs {
+		index.documents[(*docs)[i].Id] = newLocalDocument(&(*docs)[i])
+	}
+	err = e.loadPreferences(userId, index)
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.users[userId] = index
+	return index, nil
+}
+
+// currentIndex returns user's index if it matches the state of the documents.
+func (e *LocalEngine) currentIndex(userId int, state storage.DocumentsState) (*localIndex, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	index, ok := e.users[userId]
+	if ok && statesEqual(index.state, state) {
+		return index, true
+	}
+	return nil, false
+}
+
+// buildLock returns the lock that allows only one build of the user's index at a time.
+func (e *LocalEngine) buildLock(userId int) *sync.Mutex {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	lock, ok := e.builds[userId]
+	if !ok {
+		lock = &sync.Mutex{}
+		e.builds[userId] = lock
+	}
+	return lock
+}
+
+func (e *LocalEngine) loadPreferences(userId int, index *localIndex) error {
+	stopWords, synonyms, err := e.db.UserStore.GetSearchPreferences(userId)
+	if err != nil {
+		return err
+	}
+	index.stopWords = stopWords
+	index.synonyms = buildSynonyms(synonyms)
+	return nil
+}
+
+// refreshState marks the index to be up-to-date with the database after applying changes to it.
+// Must be called with lock held.
+func (e *LocalEngine) refreshState(userId int, index *localIndex) {
+	state, err := e.db.DocumentStore.GetDocumentsState(userId)
+	if err != nil {
+		logrus.Warningf("get documents state for user %d: %v", userId, err)
+		// force rebuilding on next search
+		state = storage.DocumentsState{Count: -1}
+	}
+	index.state = state
+}
+
+// dropSharedIndices removes indices of other users that contain any of the documents,
+// which causes them to be built again on next search. Must be called with lock held.
+func (e *LocalEngine) dropSharedIndices(userId int, docIds ...string) {
+	for id, index := range e.users {
+		if id == userId {
+			continue
+		}
+		for _, docId := range docIds {
+			if _, ok := index.documents[docId]; ok {
+				delete(e.users, id)
+				break
+			}
+		}
+	}
+}
+
+func (e *LocalEngine) IndexDocuments(docs *[]models.Document, userId int) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	docIds := make([]string, len(*docs))
+	for i, v := range *docs {
+		docIds[i] = v.Id
+	}
+	e.dropSharedIndices(userId, docIds...)
+	index, ok := e.users[userId]
+	if !ok {
+		// index is built from the database when it is needed.
+		return nil
+	}
+	for i := range *docs {
+		doc := &(*docs)[i]
+		if doc.DeletedAt.Valid {
+			delete(index.documents, doc.Id)
+		} else {
+			index.documents[doc.Id] = newLocalDocument(doc)
+		}
+	}
+	e.refreshState(userId, index)
+	return nil
+}
+
+func (e *LocalEngine) DeleteDocument(docId string, userId int) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.dropSharedIndices(userId, docId)
+	index, ok := e.users[userId]
+	if !ok {
+		return nil
+	}
+	delete(index.documents, docId)
+	e.refreshState(userId, index)
+	return nil
+}
+
+func (e *LocalEngine) UnshareDocument(docId string, userId int) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	index, ok := e.users[userId]
+	if !ok {
+		return nil
+	}
+	delete(index.documents, docId)
+	e.refreshState(userId, index)
+	return nil
+}
+
+func (e *LocalEngine) DeleteDocuments(userId int) error {
+	docIds, err := e.db.UserStore.GetUserDocumentIds(userId)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.dropSharedIndices(userId, docIds...)
+	delete(e.users, userId)
+	return nil
+}
+
+func (e *LocalEngine) AddUserIndex(userId int) error {
+	return nil
+}
+
+func (e *LocalEngine) UpdateUserPreferences(userId int) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	index, ok := e.users[userId]
+	if !ok {
+		return nil
+	}
+	return e.loadPreferences(userId, index)
+}
+
+// GetUserSearchSettingsStatus returns unsupported status, local engine ranks results with fixed weights.
+func (e *LocalEngine) GetUserSearchSettingsStatus(userId int) (*SearchSettingsStatus, error) {
+	return &SearchSettingsStatus{Supported: false}, nil
+}
+
+func (e *LocalEngine) SuggestSearch(userId int, query string) (*QuerySuggestions, error) {
+	return suggestUserSearch(e.db, userId, query), nil
+}
+
+type localHit struct {
+	document *localDocument
+	score    int
+}
+
+func (e *LocalEngine) SearchDocuments(userId int, query string, sort storage.SortKey, paging storage.Paging) ([]*models.Document, int, error) {
+	qs, err := parseUserFilter(e.db, userId, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index, err := e.userIndex(userId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	qs.removeStopWords(index.stopWords)
+	hits := make([]localHit, 0, len(index.documents))
+	for _, v := range index.documents {
+		score, ok := localDocumentScore(v, qs, index.synonyms)
+		if !ok {
+			continue
+		}
+		hits = append(hits, localHit{document: v, score: score})
+	}
+
+	terms := []string{}
+	for _, v := range qs.Terms {
+		if !v.Negated {
+			terms = append(terms, tokenize(v.Text)...)
+		}
+	}
+	sortLocalHits(hits, sort, len(terms) > 0)
+
+	total := len(hits)
+	if paging.Offset >= len(hits) {
+		return []*models.Document{}, total, nil
+	}
+	hits = hits[paging.Offset:]
+	if paging.Limit > 0 && len(hits) > paging.Limit {
+		hits = hits[:paging.Limit]
+	}
+
+	docs := make([]*models.Document, len(hits))
+	for i, v := range hits {
+		source := v.document.document
+		docs[i] = &models.Document{
+			Id:          source.Id,
+			Name:        highlightTerms(source.Name, terms),
+			Content:     cropWords(source.Content, localCropLength),
+			Description: source.Description,
+			Date:        source.Date,
+			Mimetype:    source.Mimetype,
+		}
+		if len(terms) > 0 {
+			docs[i].SearchMatch = localSearchMatch(v.document, terms)
+		}
+	}
+	return docs, total, nil
+}
+
+func (e *LocalEngine) SearchFacets(userId int, query string) (*SearchFacets, error) {
+	qs, err := parseUserFilter(e.db, userId, query)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := e.db.UserStore.GetFacetKeys(userId)
+	if err != nil {
+		logrus.Warningf("get facet keys for user %d: %v", userId, err)
+	}
+	index, err := e.userIndex(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	qs.removeStopWords(index.stopWords)
+	counter := newFacetCounter(keys)
+	for _, v := range index.documents {
+		if _, ok := localDocumentScore(v, qs, index.synonyms); ok {
+			localCountFacets(counter, &v.document)
+		}
+	}
+	return counter.result(), nil
+}
+
+// localCountFacets adds document's facet values to the counter, same as they are indexed in Meilisearch.
+func localCountFacets(counter *facetCounter, doc *models.Document) {
+	seen := make(map[string]bool, len(doc.Metadata))
+	for _, v := range doc.Metadata {
+		for _, value := range metadataEntries(v) {
+			if !seen[value] {
+				seen[value] = true
+				counter.add("metadata", value, 1)
+			}
+		}
+	}
+	counter.add(FacetMimetype, doc.Mimetype, 1)
+	counter.add(FacetYear, facetYear(doc.Date), 1)
+	counter.add(FacetMonth, facetMonth(doc.Date), 1)
+}
+
+// localSearchMatch finds the chunk and page of the first matching term, same as they would be in Meilisearch.
+func localSearchMatch(doc *localDocument, terms []string) *models.DocumentSearchMatch {
+	pos := -1
+	for _, term := range terms {
+		if i := strings.Index(doc.content, term); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return &models.DocumentSearchMatch{Chunk: 0, Page: 1}
+	}
+	word := len(regexWord.FindAllStringIndex(doc.content[:pos], -1))
+	return &models.DocumentSearchMatch{
+		Chunk: chunkOfWord(word, ChunkWords, ChunkOverlapWords),
+		Page:  pageAt(doc.content, pos),
+	}
+}
+
+// localDocumentScore returns relevance of the document and whether it matches the query at all.
+// Document must match the filter and all terms that are not negated. Last term is matched as prefix,
+// since user might still be typing it.
+func localDocumentScore(doc *localDocument, qs *searchQuery, synonyms map[string][]string) (int, bool) {
+	if qs.Filter != nil && !localMatchFilter(qs.Filter, doc) {
+		return 0, false
+	}
+	total := 0
+	for i, term := range qs.Terms {
+		score := localTermScore(doc, term, synonyms, i == len(qs.Terms)-1 && !term.Negated)
+		if term.Negated {
+			if score > 0 {
+				return 0, false
+			}
+			continue
+		}
+		if score == 0 {
+			return 0, false
+		}
+		total += score
+	}
+	return total, true
+}
+
+func sortLocalHits(hits []localHit, sortKey storage.SortKey, relevance bool) {
+	desc := sortKey.Order
+	var less func(a, b *localDocument) bool
+	switch sortKey.Key {
+	case "name":
+		less = func(a, b *localDocument) bool { return a.name < b.name }
+	case "description":
+		less = func(a, b *localDocument) bool { return a.description < b.description }
+	case "date":
+		less = func(a, b *localDocument) bool { return a.document.Date.Before(b.document.Date) }
+	case "created_at":
+		less = func(a, b *localDocument) bool { return a.document.CreatedAt.Before(b.document.CreatedAt) }
+	case "updated_at":
+		less = func(a, b *localDocument) bool { return a.document.UpdatedAt.Before(b.document.UpdatedAt) }
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if less != nil {
+			x, y := a.document, b.document
+			if desc {
+				x, y = y, x
+			}
+			if less(x, y) {
+				return true
+			}
+			if less(y, x) {
+				return false
+			}
+		} else if relevance && a.score != b.score {
+			return a.score > b.score
+		}
+		// newest first, then by id for stable order
+		if !a.document.document.Date.Equal(b.document.document.Date) {
+			return a.document.document.Date.After(b.document.document.Date)
+		}
+		return a.document.document.Id < b.document.document.Id
+	})
+}
+
+// highlightTerms wraps words that match any of the terms with <em>, same as Meilisearch does.
+func highlightTerms(text string, terms []string) string {
+	if len(terms) == 0 {
+		return text
+	}
+	words := strings.Split(text, " ")
+	for i, word := range words {
+		for _, token := range tokenize(word) {
+			matched := false
+			for _, term := range terms {
+				if strings.HasPrefix(token, term) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				words[i] = "<em>" + word + "</em>"
+				break
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func cropWords(text string, maxWords int) string {
+	words := strings.Fields(text)
+	if len(words) <= maxWords {
+		return text
+	}
+	return strings.Join(words[:maxWords], " ")
+}
+
+func (e *LocalEngine) GetHealth() (string, bool, error) {
+	return "available", true, nil
+}
+
+func (e *LocalEngine) GetStatus() (*EngineStatus, error) {
+	return &EngineStatus{
+		Ok:      true,
+		Status:  "available",
+		Version: config.Version,
+		Name:    "Local",
+	}, nil
+}
+
+func (e *LocalEngine) GetUserIndexStatus(userId int) (UserIndexStatus, error) {
+	state, err := e.db.DocumentStore.GetDocumentsState(userId)
+	if err != nil {
+		return UserIndexStatus{}, err
+	}
+	return UserIndexStatus{
+		UserId:       userId,
+		NumDocuments: state.Count - state.Deleted,
+		Indexing:     false,
+	}, nil
+}
+
+func (e *LocalEngine) GetUserIndicesStatus() (map[int]*UserIndexStatus, int64, error) {
+	users, err := e.db.UserStore.GetUsers()
+	if err != nil {
+		return map[int]*UserIndexStatus{}, 0, err
+	}
+	indices := make(map[int]*UserIndexStatus, len(*users))
+	for _, v := range *users {
+		status, err := e.GetUserIndexStatus(v.Id)
+		if err != nil {
+			return indices, 0, err
+		}
+		indices[v.Id] = &status
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	var size int64
+	for _, index := range e.users {
+		for _, doc := range index.documents {
+			size += doc.size
+		}
+	}
+	return indices, size, nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"strings"
+)
+
+// localMatchFilter evaluates the query filter against the document.
+func localMatchFilter(node queryNode, doc *localDocument) bool {
+	switch n := node.(type) {
+	case *queryAnd:
+		for _, v := range n.Nodes {
+			if !localMatchFilter(v, doc) {
+				return false
+			}
+		}
+		return true
+	case *queryOr:
+		for _, v := range n.Nodes {
+			if localMatchFilter(v, doc) {
+				return true
+			}
+		}
+		return false
+	case *queryNot:
+		return !localMatchFilter(n.Node, doc)
+	case *queryField:
+		switch n.Field {
+		case queryFieldName:
+			return strings.Contains(doc.name, n.Value)
+		case queryFieldDescription:
+			return strings.Contains(doc.description, n.Value)
+		case queryFieldContent:
+			return strings.Contains(doc.content, n.Value)
+		default:
+			key := strings.ToLower(normalizeMetadataKey(n.Key))
+			value := strings.ToLower(normalizeMetadataValue(n.Value))
+			return doc.metadata[key+":"+value]
+		}
+	case *queryDate:
+		date := doc.document.Date
+		switch n.Field {
+		case "created_at":
+			date = doc.document.CreatedAt
+		case "updated_at":
+			date = doc.document.UpdatedAt
+		}
+		after, before := n.bounds()
+		if !after.IsZero() && date.Before(after) {
+			return false
+		}
+		if !before.IsZero() && !date.Before(before) {
+			return false
+		}
+		return true
+	case *queryCompare:
+		for _, value := range doc.numbers[n.KeyId] {
+			match := len(n.Conditions) > 0
+			for _, condition := range n.Conditions {
+				match = match && condition.match(value)
+			}
+			if match {
+				return true
+			}
+		}
+		return false
+	case *queryText:
+		return localTermScore(doc, searchTerm{Text: n.Text, Phrase: n.Phrase}, nil, false) > 0
+	}
+	return false
+}
+
+// localTermScore returns how well the term matches the document, 0 if it does not match.
+// Term matches also if any of its synonyms match.
+func localTermScore(doc *localDocument, term searchTerm, synonyms map[string][]string, prefix bool) int {
+	text := strings.ToLower(term.Text)
+	if term.Phrase || len(tokenize(text)) != 1 {
+		return strings.Count(doc.text, text)
+	}
+	text = tokenize(text)[0]
+
+	candidates := append([]string{text}, synonyms[text]...)
+	score := 0
+	for _, candidate := range candidates {
+		if strings.Contains(candidate, " ") {
+			score += strings.Count(doc.text, candidate)
+			continue
+		}
+		score += doc.terms[candidate] * 2
+		if prefix {
+			for word, count := range doc.terms {
+				if word != candidate && strings.HasPrefix(word, candidate) {
+					score += count
+				}
+			}
+		}
+	}
+	return score
+}
@@ -0,0 +1,281 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"tryffel.net/go/virtualpaper/models"
+	"tryffel.net/go/virtualpaper/storage"
+)
+
+func testLocalDocuments() []*localDocument {
+	docs := []models.Document{
+		{
+			Id:      "1",
+			Name:    "Electricity bill",
+			Content: "Invoice for electricity, total 120 eur",
+			Date:    time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC),
+			Metadata: []models.Metadata{
+				{Key: "category", Value: "bills"},
+				{Key: "company", Value: "Power company"},
+			},
+		},
+		{
+			Id:      "2",
+			Name:    "Car insurance",
+			Content: "Insurance policy for the automobile",
+			Date:    time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC),
+			Metadata: []models.Metadata{
+				{Key: "category", Value: "insurance"},
+			},
+		},
+		{
+			Id:          "3",
+			Name:        "Receipt",
+			Description: "groceries",
+			Content:     "Milk, bread and ice cream",
+			Date:        time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC),
+			Metadata: []models.Metadata{
+				{Key: "category", Value: "receipts"},
+				{Key: "company", Value: "Power company"},
+			},
+		},
+	}
+	output := make([]*localDocument, len(docs))
+	for i := range docs {
+		output[i] = newLocalDocument(&docs[i])
+	}
+	return output
+}
+
+func localSearch(t *testing.T, query string, sort storage.SortKey) []string {
+	qs, err := parseFilter(query, testNow)
+	if err != nil {
+		t.Fatalf("parse filter: %v", err)
+	}
+	synonyms := buildSynonyms([][]string{{"car", "automobile"}})
+	hits := []localHit{}
+	for _, v := range testLocalDocuments() {
+		score, ok := localDocumentScore(v, qs, synonyms)
+		if ok {
+			hits = append(hits, localHit{document: v, score: score})
+		}
+	}
+	sortLocalHits(hits, sort, len(qs.Terms) > 0)
+	ids := make([]string, len(hits))
+	for i, v := range hits {
+		ids[i] = v.document.document.Id
+	}
+	return ids
+}
+
+func TestLocalEngine_search(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		sort  storage.SortKey
+		want  []string
+	}{
+		{"all documents newest first", "", storage.SortKey{}, []string{"3", "1", "2"}},
+		{"text", "invoice", storage.SortKey{}, []string{"1"}},
+		{"prefix", "electr", storage.SortKey{}, []string{"1"}},
+		{"all terms must match", "insurance bread", storage.SortKey{}, []string{}},
+		{"synonym", "automobile", storage.SortKey{}, []string{"2"}},
+		{"metadata", "category:bills", storage.SortKey{}, []string{"1"}},
+		{"metadata with space", `company:"power company"`, storage.SortKey{}, []string{"3", "1"}},
+		{"metadata or", "category:bills or category:insurance", storage.SortKey{}, []string{"1", "2"}},
+		{"metadata not", `company:"power company" and not category:bills`, storage.SortKey{}, []string{"3"}},
+		{"parentheses", `(category:bills or category:receipts) and company:"power company"`, storage.SortKey{}, []string{"3", "1"}},
+		{"date", "date:2022", storage.SortKey{}, []string{"1"}},
+		{"date range", "date:2021|2022", storage.SortKey{}, []string{"1", "2"}},
+		{"name", "name:receipt", storage.SortKey{}, []string{"3"}},
+		{"negated term", "company -receipt", storage.SortKey{}, []string{"1"}},
+		{"phrase", `"ice cream"`, storage.SortKey{}, []string{"3"}},
+		{"phrase not matching", `"cream ice"`, storage.SortKey{}, []string{}},
+		{"text and metadata or", "power (category:bills or category:insurance)", storage.SortKey{}, []string{"1"}},
+		{"not group", "not (category:bills or category:receipts)", storage.SortKey{}, []string{"2"}},
+		{"sort by name", "", storage.SortKey{Key: "name"}, []string{"2", "1", "3"}},
+		{"sort by relevance", "power", storage.SortKey{Key: SortRelevance}, []string{"3", "1"}},
+		{"sort by date desc", "", storage.SortKey{Key: "date", Order: true}, []string{"3", "1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localSearch(t, tt.query, tt.sort); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_highlightTerms(t *testing.T) {
+	if got := highlightTerms("Car insurance 2022", []string{"insur"}); got != "Car <em>insurance</em> 2022" {
+		t.Errorf("highlightTerms() = %s", got)
+	}
+}
+
+func Test_localCountFacets(t *testing.T) {
+	qs, err := parseFilter(`company:"power company"`, testNow)
+	if err != nil {
+		t.Fatalf("parse filter: %v", err)
+	}
+	counter := newFacetCounter(nil)
+	for _, v := range testLocalDocuments() {
+		if _, ok := localDocumentScore(v, qs, nil); ok {
+			localCountFacets(counter, &v.document)
+		}
+	}
+	got := counter.result()
+	want := map[string][]FacetValue{
+		"category": {{Value: "bills", Count: 1}, {Value: "receipts", Count: 1}},
+		"company":  {{Value: "Power_company", Count: 2}},
+	}
+	if !reflect.DeepEqual(got.Metadata, want) {
+		t.Errorf("metadata facets = %v, want %v", got.Metadata, want)
+	}
+	if wantYears := []FacetValue{{Value: "2022", Count: 1}, {Value: "2023", Count: 1}}; !reflect.DeepEqual(got.Year, wantYears) {
+		t.Errorf("year facets = %v, want %v", got.Year, wantYears)
+	}
+	if wantMonths := []FacetValue{{Value: "2022-03", Count: 1}, {Value: "2023-01", Count: 1}}; !reflect.DeepEqual(got.Month, wantMonths) {
+		t.Errorf("month facets = %v, want %v", got.Month, wantMonths)
+	}
+}
+
+func TestLocalEngine_searchTypedMetadata(t *testing.T) {
+	docs := []models.Document{
+		{Id: "1", Metadata: []models.Metadata{{KeyId: 1, Key: "amount", Value: "120.00 EUR", ValueType: models.MetadataValueMoney}}},
+		{Id: "2", Metadata: []models.Metadata{{KeyId: 1, Key: "amount", Value: "80.00 EUR", ValueType: models.MetadataValueMoney}}},
+		{Id: "3", Metadata: []models.Metadata{{KeyId: 2, Key: "due", Value: "2023-12-31", ValueType: models.MetadataValueDate}}},
+	}
+	keys := []models.MetadataKey{
+		{Id: 1, Key: "amount", ValueType: models.MetadataValueMoney},
+		{Id: 2, Key: "due", ValueType: models.MetadataValueDate},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"amount:>100", []string{"1"}},
+		{"amount:<=120", []string{"1", "2"}},
+		{"amount:>50 and not amount:>100", []string{"2"}},
+		{"due:<2024-01", []string{"3"}},
+		{"due:>2023", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			qs, err := parseFilter(tt.query, testNow)
+			if err != nil {
+				t.Fatalf("parse filter: %v", err)
+			}
+			if err := resolveMetadataTypes(qs.Filter, keys, testNow); err != nil {
+				t.Fatalf("resolve types: %v", err)
+			}
+			got := []string{}
+			for i := range docs {
+				if _, ok := localDocumentScore(newLocalDocument(&docs[i]), qs, nil); ok {
+					got = append(got, docs[i].Id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalEngine_searchHierarchicalMetadata(t *testing.T) {
+	docs := []models.Document{
+		{Id: "1", Metadata: []models.Metadata{{Key: "project", Value: "invoices", Path: "acme/website/invoices"}}},
+		{Id: "2", Metadata: []models.Metadata{{Key: "project", Value: "blog", Path: "acme/blog"}}},
+		{Id: "3", Metadata: []models.Metadata{{Key: "project", Value: "other"}}},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"project:acme", []string{"1", "2"}},
+		{"project:acme/website", []string{"1"}},
+		{"project:acme/website/invoices", []string{"1"}},
+		{"project:invoices", []string{"1"}},
+		{"project:acme and not project:acme/blog", []string{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			qs, err := parseFilter(tt.query, testNow)
+			if err != nil {
+				t.Fatalf("parse filter: %v", err)
+			}
+			got := []string{}
+			for i := range docs {
+				if _, ok := localDocumentScore(newLocalDocument(&docs[i]), qs, nil); ok {
+					got = append(got, docs[i].Id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalEngine_userIndexBuildDoesNotBlock(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	stateColumns := []string{"count", "deleted", "updated_at"}
+	mock.ExpectQuery("count\\(id\\) AS count").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(stateColumns).AddRow(1, 0, nil))
+	mock.ExpectQuery("SELECT id, user_id, name").WithArgs(1).
+		WillDelayFor(300 * time.Millisecond).WillReturnError(fmt.Errorf("db error"))
+	mock.ExpectQuery("count\\(id\\) AS count").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(stateColumns).AddRow(0, 0, nil))
+
+	engine := NewLocalEngine(db)
+	engine.users[2] = &localIndex{documents: map[string]*localDocument{}}
+
+	building := make(chan error)
+	go func() {
+		_, err := engine.userIndex(1)
+		building <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := engine.userIndex(2); err != nil {
+		t.Fatalf("get cached index: %v", err)
+	}
+	if took := time.Since(start); took > 150*time.Millisecond {
+		t.Errorf("cached index blocked by another user's build for %s", took)
+	}
+	if err := <-building; err == nil {
+		t.Errorf("expected build to fail")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,723 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// QueryError is a syntax error in search query. Position is the index of the character
+// in the query where the error was detected, starting from 0.
+type QueryError struct {
+	Position int
+	Message  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+func queryError(pos int, format string, args ...interface{}) *QueryError {
+	return &QueryError{Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// queryNode is a node in parsed search query.
+type queryNode interface {
+	position() int
+}
+
+// queryAnd matches if all nodes match.
+type queryAnd struct {
+	Pos   int
+	Nodes []queryNode
+}
+
+// queryOr matches if any of the nodes match.
+type queryOr struct {
+	Pos   int
+	Nodes []queryNode
+}
+
+// queryNot matches if node does not match.
+type queryNot struct {
+	Pos  int
+	Node queryNode
+}
+
+// queryText is a free-text term or a quoted phrase.
+type queryText struct {
+	Pos    int
+	Text   string
+	Phrase bool
+}
+
+// queryField is a field-scoped term: name, description, content or metadata key.
+type queryField struct {
+	Pos   int
+	Field string
+	// Key is the metadata key, if Field is metadata.
+	Key   string
+	Value string
+}
+
+// queryDocuments matches the listed documents. Meilisearch engine resolves field-scoped terms of document
+// attributes into the documents that match them, see Meilisearch.resolveFieldTerms.
+type queryDocuments struct {
+	Pos int
+	Ids []string
+}
+
+// queryDate matches documents whose date, creation or modification time is within the range.
+// Range is in calendar dates and either limit can be zero.
+type queryDate struct {
+	Pos int
+	// Field is the document attribute: date, created_at or updated_at.
+	Field  string
+	After  time.Time
+	Before time.Time
+	// Location is user's timezone.
+	Location *time.Location
+}
+
+// queryCompare compares values of a typed metadata key, e.g. amount:>100 or due:<2024-01.
+// The value is converted to conditions once key types are known, see resolveMetadataTypes.
+type queryCompare struct {
+	Pos      int
+	ValuePos int
+	Key      string
+	Operator string
+	Value    string
+	// KeyId is the id of the metadata key.
+	KeyId      int
+	Conditions []numericCondition
+}
+
+// numericCondition is a comparison against the numeric value of metadata.
+type numericCondition struct {
+	Operator string
+	Value    float64
+}
+
+func (c numericCondition) match(value float64) bool {
+	switch c.Operator {
+	case ">":
+		return value > c.Value
+	case ">=":
+		return value >= c.Value
+	case "<":
+		return value < c.Value
+	case "<=":
+		return value <= c.Value
+	}
+	return false
+}
+
+// bounds returns the range as timestamps. Document date is a calendar date stored as midnight UTC,
+// but creation and modification times are instants, so their range is in user's timezone.
+func (n *queryDate) bounds() (time.Time, time.Time) {
+	if n.Field == "date" || n.Location == nil {
+		return n.After, n.Before
+	}
+	inLocation := func(t time.Time) time.Time {
+		if t.IsZero() {
+			return t
+		}
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, n.Location)
+	}
+	return inLocation(n.After), inLocation(n.Before)
+}
+
+func (n *queryAnd) position() int       { return n.Pos }
+func (n *queryOr) position() int        { return n.Pos }
+func (n *queryNot) position() int       { return n.Pos }
+func (n *queryText) position() int      { return n.Pos }
+func (n *queryField) position() int     { return n.Pos }
+func (n *queryDocuments) position() int { return n.Pos }
+func (n *queryDate) position() int      { return n.Pos }
+func (n *queryCompare) position() int   { return n.Pos }
+
+const (
+	queryFieldName        = "name"
+	queryFieldDescription = "description"
+	queryFieldContent     = "content"
+	queryFieldDate        = "date"
+	queryFieldAdded       = "added"
+	queryFieldUpdated     = "updated"
+	queryFieldMetadata    = "metadata"
+)
+
+type queryTokenType int
+
+const (
+	queryTokenWord queryTokenType = iota
+	queryTokenPhrase
+	queryTokenField
+	queryTokenLParen
+	queryTokenRParen
+	queryTokenAnd
+	queryTokenOr
+	queryTokenNot
+)
+
+type queryToken struct {
+	Type  queryTokenType
+	Pos   int
+	Key   string
+	Value string
+	// ValuePos is the position of the value of field token.
+	ValuePos int
+}
+
+func (t queryToken) String() string {
+	switch t.Type {
+	case queryTokenLParen:
+		return "("
+	case queryTokenRParen:
+		return ")"
+	case queryTokenAnd:
+		return "AND"
+	case queryTokenOr:
+		return "OR"
+	case queryTokenNot:
+		return "NOT"
+	case queryTokenField:
+		return t.Key + ":" + t.Value
+	}
+	return t.Value
+}
+
+// lexQuery splits the query into tokens. Operators AND, OR and NOT are case-insensitive,
+// '-' before a term is same as NOT. Phrases, metadata keys and values can be quoted with '"'.
+func lexQuery(query string) ([]queryToken, error) {
+	return lexTokens(query, false)
+}
+
+// lexPartialQuery splits a query that is still being typed into tokens.
+// Last quote may be left open and last field may have an empty value.
+func lexPartialQuery(query string) ([]queryToken, error) {
+	return lexTokens(query, true)
+}
+
+func lexTokens(query string, partial bool) ([]queryToken, error) {
+	runes := []rune(query)
+	tokens := []queryToken{}
+	i := 0
+
+	isSeparator := func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	}
+
+	readQuoted := func() (string, error) {
+		start := i
+		i += 1
+		for i < len(runes) && runes[i] != '"' {
+			i += 1
+		}
+		if i >= len(runes) {
+			if partial {
+				return string(runes[start+1:]), nil
+			}
+			return "", queryError(start, "missing closing quote")
+		}
+		value := string(runes[start+1 : i])
+		i += 1
+		return value, nil
+	}
+
+	readValue := func(key string, keyPos int) (queryToken, error) {
+		// runes[i] is ':'
+		i += 1
+		token := queryToken{Type: queryTokenField, Pos: keyPos, Key: key, ValuePos: i}
+		if i < len(runes) && runes[i] == '"' {
+			value, err := readQuoted()
+			if err != nil {
+				return token, err
+			}
+			token.Value = value
+		} else {
+			start := i
+			for i < len(runes) && !isSeparator(runes[i]) {
+				i += 1
+			}
+			token.Value = string(runes[start:i])
+		}
+		if strings.TrimSpace(token.Value) == "" && !partial {
+			return token, queryError(token.ValuePos, "missing value for '%s'", key)
+		}
+		return token, nil
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i += 1
+		case r == '(':
+			tokens = append(tokens, queryToken{Type: queryTokenLParen, Pos: i, Value: "("})
+			i += 1
+		case r == ')':
+			tokens = append(tokens, queryToken{Type: queryTokenRParen, Pos: i, Value: ")"})
+			i += 1
+		case r == '-' && i+1 < len(runes) && !isSeparator(runes[i+1]) &&
+			(i == 0 || isSeparator(runes[i-1])):
+			tokens = append(tokens, queryToken{Type: queryTokenNot, Pos: i, Value: "-"})
+			i += 1
+		case r == '"':
+			start := i
+			value, err := readQuoted()
+			if err != nil {
+				return tokens, err
+			}
+			if i < len(runes) && runes[i] == ':' {
+				token, err := readValue(value, start)
+				if err != nil {
+					return tokens, err
+				}
+				tokens = append(tokens, token)
+			} else {
+				tokens = append(tokens, queryToken{Type: queryTokenPhrase, Pos: start, Value: value})
+			}
+		default:
+			start := i
+			for i < len(runes) && !isSeparator(runes[i]) && runes[i] != ':' && runes[i] != '"' {
+				i += 1
+			}
+			word := string(runes[start:i])
+			if i < len(runes) && runes[i] == ':' {
+				if word == "" {
+					return tokens, queryError(start, "missing key before ':'")
+				}
+				token, err := readValue(word, start)
+				if err != nil {
+					return tokens, err
+				}
+				tokens = append(tokens, token)
+				continue
+			}
+			token := queryToken{Type: queryTokenWord, Pos: start, Value: word}
+			switch strings.ToLower(word) {
+			case "and":
+				token.Type = queryTokenAnd
+			case "or":
+				token.Type = queryTokenOr
+			case "not":
+				token.Type = queryTokenNot
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// dateFields maps date keys in query to document attributes.
+var dateFields = map[string]string{
+	queryFieldDate:    "date",
+	queryFieldAdded:   "created_at",
+	queryFieldUpdated: "updated_at",
+}
+
+// parseQuery parses search query into an AST. NOT binds tightest, then AND and then OR.
+// Terms without an operator between them are joined with AND. Returns nil for empty query.
+// Relative dates are computed from now, which should be in user's timezone.
+func parseQuery(query string, now time.Time) (queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &queryParser{tokens: tokens, end: len([]rune(query)), now: now}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token != nil {
+		return nil, queryError(token.Pos, "unexpected '%s'", token.String())
+	}
+	return node, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	end    int
+	now    time.Time
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryOr{Pos: first.position(), Nodes: []queryNode{first}}
+	for {
+		token := p.peek()
+		if token == nil || token.Type != queryTokenOr {
+			break
+		}
+		p.pos += 1
+		if p.peek() == nil {
+			return nil, queryError(token.Pos, "expected term after OR")
+		}
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if or, ok := next.(*queryOr); ok {
+			node.Nodes = append(node.Nodes, or.Nodes...)
+		} else {
+			node.Nodes = append(node.Nodes, next)
+		}
+	}
+	if len(node.Nodes) == 1 {
+		return first, nil
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryAnd{Pos: first.position()}
+	add := func(n queryNode) {
+		if and, ok := n.(*queryAnd); ok {
+			node.Nodes = append(node.Nodes, and.Nodes...)
+		} else {
+			node.Nodes = append(node.Nodes, n)
+		}
+	}
+	add(first)
+	for {
+		token := p.peek()
+		if token == nil || token.Type == queryTokenOr || token.Type == queryTokenRParen {
+			break
+		}
+		if token.Type == queryTokenAnd {
+			p.pos += 1
+			if p.peek() == nil {
+				return nil, queryError(token.Pos, "expected term after AND")
+			}
+		}
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		add(next)
+	}
+	if len(node.Nodes) == 1 {
+		return first, nil
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	token := p.peek()
+	if token == nil {
+		return nil, queryError(p.end, "unexpected end of query")
+	}
+	p.pos += 1
+
+	switch token.Type {
+	case queryTokenNot:
+		if p.peek() == nil {
+			return nil, queryError(token.Pos, "expected term after NOT")
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if not, ok := node.(*queryNot); ok {
+			// double negation
+			return not.Node, nil
+		}
+		return &queryNot{Pos: token.Pos, Node: node}, nil
+	case queryTokenLParen:
+		next := p.peek()
+		if next == nil {
+			return nil, queryError(token.Pos, "missing closing parenthesis")
+		}
+		if next.Type == queryTokenRParen {
+			return nil, queryError(token.Pos, "empty parentheses")
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		next = p.peek()
+		if next == nil || next.Type != queryTokenRParen {
+			return nil, queryError(token.Pos, "missing closing parenthesis")
+		}
+		p.pos += 1
+		return node, nil
+	case queryTokenRParen, queryTokenAnd, queryTokenOr:
+		return nil, queryError(token.Pos, "unexpected '%s'", token.String())
+	case queryTokenPhrase:
+		return &queryText{Pos: token.Pos, Text: token.Value, Phrase: true}, nil
+	case queryTokenField:
+		return parseQueryField(token, p.now)
+	default:
+		return &queryText{Pos: token.Pos, Text: token.Value}, nil
+	}
+}
+
+func parseQueryField(token *queryToken, now time.Time) (queryNode, error) {
+	switch token.Key {
+	case queryFieldName, queryFieldDescription, queryFieldContent:
+		return &queryField{Pos: token.Pos, Field: token.Key, Value: token.Value}, nil
+	case queryFieldDate, queryFieldAdded, queryFieldUpdated:
+		status, _, after, before := matchDate(token.Value, now)
+		if status != valueMatchStatusOk {
+			return nil, queryError(token.ValuePos, "invalid date '%s'", token.Value)
+		}
+		return &queryDate{
+			Pos:      token.Pos,
+			Field:    dateFields[token.Key],
+			After:    after,
+			Before:   before,
+			Location: now.Location(),
+		}, nil
+	default:
+		for _, operator := range compareOperators {
+			if strings.HasPrefix(token.Value, operator) && len(token.Value) > len(operator) {
+				return &queryCompare{
+					Pos:      token.Pos,
+					ValuePos: token.ValuePos + len(operator),
+					Key:      token.Key,
+					Operator: operator,
+					Value:    strings.TrimPrefix(token.Value, operator),
+				}, nil
+			}
+		}
+		return &queryField{Pos: token.Pos, Field: queryFieldMetadata, Key: token.Key, Value: token.Value}, nil
+	}
+}
+
+// compareOperators are the operators for typed metadata, longest first.
+var compareOperators = []string{">=", "<=", ">", "<"}
+
+// resolveMetadataTypes converts comparisons to numeric conditions using the types of the user's keys.
+// Dates are compared as calendar dates, so e.g. due:<2024-01 means before January 2024 and
+// due:>2024-01 after it.
+func resolveMetadataTypes(node queryNode, keys []models.MetadataKey, now time.Time) error {
+	switch n := node.(type) {
+	case *queryAnd:
+		for _, v := range n.Nodes {
+			if err := resolveMetadataTypes(v, keys, now); err != nil {
+				return err
+			}
+		}
+	case *queryOr:
+		for _, v := range n.Nodes {
+			if err := resolveMetadataTypes(v, keys, now); err != nil {
+				return err
+			}
+		}
+	case *queryNot:
+		return resolveMetadataTypes(n.Node, keys, now)
+	case *queryCompare:
+		var key *models.MetadataKey
+		for i := range keys {
+			if strings.ToLower(normalizeMetadataKey(keys[i].Key)) == normalizeMetadataKey(n.Key) {
+				key = &keys[i]
+				break
+			}
+		}
+		if key == nil || !key.ValueType.Typed() {
+			return queryError(n.Pos, "'%s' is not a number, date, money or boolean key", n.Key)
+		}
+		n.KeyId = key.Id
+		n.Conditions = nil
+		if key.ValueType == models.MetadataValueDate {
+			after, before, ok := dateRange(n.Operator+n.Value, now)
+			if !ok {
+				return queryError(n.ValuePos, "invalid date '%s'", n.Value)
+			}
+			if !after.IsZero() {
+				n.Conditions = append(n.Conditions, numericCondition{Operator: ">=", Value: float64(after.Unix())})
+			}
+			if !before.IsZero() {
+				n.Conditions = append(n.Conditions, numericCondition{Operator: "<", Value: float64(before.Unix())})
+			}
+			return nil
+		}
+		value, err := key.ValueType.NumericValue(n.Value)
+		if err != nil {
+			return queryError(n.ValuePos, "invalid %s '%s'", key.ValueType, n.Value)
+		}
+		n.Conditions = []numericCondition{{Operator: n.Operator, Value: value}}
+	}
+	return nil
+}
+
+// metadataNumKey is the field of the key in indexed metadata_num object, which contains numeric values of typed keys.
+func metadataNumKey(keyId int) string {
+	return fmt.Sprintf("key_%d", keyId)
+}
+
+// containsText returns the first free-text term in the node.
+func containsText(node queryNode) *queryText {
+	switch n := node.(type) {
+	case *queryText:
+		return n
+	case *queryNot:
+		return containsText(n.Node)
+	case *queryAnd:
+		for _, v := range n.Nodes {
+			if text := containsText(v); text != nil {
+				return text
+			}
+		}
+	case *queryOr:
+		for _, v := range n.Nodes {
+			if text := containsText(v); text != nil {
+				return text
+			}
+		}
+	}
+	return nil
+}
+
+// searchTerm is a free-text term of the query.
+type searchTerm struct {
+	Text    string
+	Phrase  bool
+	Negated bool
+}
+
+func (t searchTerm) String() string {
+	text := t.Text
+	if t.Phrase {
+		text = `"` + text + `"`
+	}
+	if t.Negated {
+		text = "-" + text
+	}
+	return text
+}
+
+// splitQuery separates free-text terms from the filter. Full-text search engines match all terms,
+// so free-text terms can only be combined with AND, and negated only as single terms.
+func splitQuery(root queryNode) ([]searchTerm, queryNode, error) {
+	terms := []searchTerm{}
+	if root == nil {
+		return terms, nil, nil
+	}
+
+	nodes := []queryNode{root}
+	if and, ok := root.(*queryAnd); ok {
+		nodes = and.Nodes
+	}
+
+	filters := []queryNode{}
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *queryText:
+			terms = append(terms, searchTerm{Text: n.Text, Phrase: n.Phrase})
+			continue
+		case *queryNot:
+			if text, ok := n.Node.(*queryText); ok {
+				terms = append(terms, searchTerm{Text: text.Text, Phrase: text.Phrase, Negated: true})
+				continue
+			}
+		}
+		if text := containsText(node); text != nil {
+			return terms, nil, queryError(text.Pos, "free-text '%s' can only be combined with AND", text.Text)
+		}
+		filters = append(filters, node)
+	}
+
+	switch len(filters) {
+	case 0:
+		return terms, nil, nil
+	case 1:
+		return terms, filters[0], nil
+	default:
+		return terms, &queryAnd{Pos: filters[0].position(), Nodes: filters}, nil
+	}
+}
+
+func escapeFilterValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// meiliFilter converts the filter into Meilisearch filter expression. Meilisearch can only filter
+// attributes by their whole value, so field-scoped terms of name, description and content must be
+// resolved to documents before.
+func meiliFilter(node queryNode) string {
+	join := func(nodes []queryNode, operator string) string {
+		parts := make([]string, len(nodes))
+		for i, v := range nodes {
+			parts[i] = meiliFilter(v)
+		}
+		return "(" + strings.Join(parts, " "+operator+" ") + ")"
+	}
+
+	switch n := node.(type) {
+	case *queryAnd:
+		return join(n.Nodes, "AND")
+	case *queryOr:
+		return join(n.Nodes, "OR")
+	case *queryNot:
+		return "NOT " + meiliFilter(n.Node)
+	case *queryField:
+		value := normalizeMetadataKey(n.Key) + ":" + normalizeMetadataValue(n.Value)
+		return fmt.Sprintf(`metadata = "%s"`, escapeFilterValue(value))
+	case *queryDocuments:
+		quoted := make([]string, len(n.Ids))
+		for i, v := range n.Ids {
+			quoted[i] = `"` + escapeFilterValue(v) + `"`
+		}
+		return fmt.Sprintf("document_id IN [%s]", strings.Join(quoted, ", "))
+	case *queryCompare:
+		parts := make([]string, len(n.Conditions))
+		for i, v := range n.Conditions {
+			parts[i] = fmt.Sprintf("metadata_num.%s %s %s", metadataNumKey(n.KeyId), v.Operator,
+				strconv.FormatFloat(v.Value, 'f', -1, 64))
+		}
+		return "(" + strings.Join(parts, " AND ") + ")"
+	case *queryDate:
+		after, before := n.bounds()
+		parts := []string{}
+		if !after.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s >= %d", n.Field, after.Unix()))
+		}
+		if !before.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s < %d", n.Field, before.Unix()))
+		}
+		return "(" + strings.Join(parts, " AND ") + ")"
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// formatQueryNode prints the query tree in prefix notation.
+func formatQueryNode(node queryNode) string {
+	join := func(operator string, nodes []queryNode) string {
+		parts := make([]string, len(nodes))
+		for i, v := range nodes {
+			parts[i] = formatQueryNode(v)
+		}
+		return operator + "(" + strings.Join(parts, " ") + ")"
+	}
+	switch n := node.(type) {
+	case *queryAnd:
+		return join("and", n.Nodes)
+	case *queryOr:
+		return join("or", n.Nodes)
+	case *queryNot:
+		return "not(" + formatQueryNode(n.Node) + ")"
+	case *queryText:
+		if n.Phrase {
+			return `"` + n.Text + `"`
+		}
+		return n.Text
+	case *queryField:
+		if n.Field == queryFieldMetadata {
+			return n.Key + ":" + n.Value
+		}
+		return n.Field + ":" + n.Value
+	case *queryDate:
+		return "date"
+	case *queryCompare:
+		return n.Key + n.Operator + n.Value
+	}
+	return ""
+}
+
+func Test_parseQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"empty", "  ", ""},
+		{"single term", "invoice", "invoice"},
+		{"implicit and", "a b c", "and(a b c)"},
+		{"and before or", "a:1 or b:2 and c:3", "or(a:1 and(b:2 c:3))"},
+		{"or chain", "a:1 OR b:2 or c:3", "or(a:1 b:2 c:3)"},
+		{"parentheses", "(a:1 or b:2) and c:3", "and(or(a:1 b:2) c:3)"},
+		{"nested parentheses", "((a:1 or (b:2 c:3)) d:4)", "and(or(a:1 and(b:2 c:3)) d:4)"},
+		{"not", "not a:1 b:2", "and(not(a:1) b:2)"},
+		{"not group", "NOT (a:1 or b:2)", "not(or(a:1 b:2))"},
+		{"double not", "not not a:1", "a:1"},
+		{"minus", "invoice -draft", "and(invoice not(draft))"},
+		{"minus inside word", "e-mail", "e-mail"},
+		{"phrase", `"ice cream" -"old milk"`, `and("ice cream" not("old milk"))`},
+		{"quoted key and value", `"key 2":"value 2" name:x`, "and(key 2:value 2 name:x)"},
+		{"date", "date:2022 class:a", "and(date class:a)"},
+		{"compare", "amount:>=100 due:<2024-01", "and(amount>=100 due<2024-01)"},
+		{"operator only is text", "amount:>", "amount:>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseQuery(tt.query, testNow)
+			if err != nil {
+				t.Fatalf("parseQuery() error = %v", err)
+			}
+			if got := formatQueryNode(node); got != tt.want {
+				t.Errorf("parseQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseQuery_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		message  string
+		position int
+	}{
+		{"missing quote", `invoice "ice cream`, "missing closing quote", 8},
+		{"missing value", "invoice class:", "missing value for 'class'", 14},
+		{"missing key", "a :b", "missing key before ':'", 2},
+		{"missing parenthesis", "a (b:1 or c:2", "missing closing parenthesis", 2},
+		{"extra parenthesis", "a:1 or b:2)", "unexpected ')'", 10},
+		{"empty parentheses", "a ()", "empty parentheses", 2},
+		{"trailing and", "a:1 and", "expected term after AND", 4},
+		{"trailing or", "a:1 OR", "expected term after OR", 4},
+		{"trailing not", "a:1 not", "expected term after NOT", 4},
+		{"leading or", "or a:1", "unexpected 'OR'", 0},
+		{"or after and", "a:1 and or b:2", "unexpected 'OR'", 8},
+		{"invalid date", "date:tomorrow-ish", "invalid date 'tomorrow-ish'", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseQuery(tt.query, testNow)
+			queryErr, ok := err.(*QueryError)
+			if !ok {
+				t.Fatalf("parseQuery() error = %v, want QueryError", err)
+			}
+			if queryErr.Message != tt.message || queryErr.Position != tt.position {
+				t.Errorf("parseQuery() error = '%s' at %d, want '%s' at %d",
+					queryErr.Message, queryErr.Position, tt.message, tt.position)
+			}
+		})
+	}
+}
+
+func Test_splitQuery(t *testing.T) {
+	qs, err := parseFilter("invoice -draft class:a", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qs.Query != "invoice -draft" {
+		t.Errorf("query = %s", qs.Query)
+	}
+	if filter := meiliFilter(qs.Filter); filter != `metadata = "class:a"` {
+		t.Errorf("filter = %s", filter)
+	}
+
+	_, err = parseFilter("class:a or (invoice class:b)", testNow)
+	if queryErr, ok := err.(*QueryError); !ok || queryErr.Position != 12 {
+		t.Errorf("expected error at free-text term, got %v", err)
+	}
+}
+
+func Test_resolveMetadataTypes(t *testing.T) {
+	keys := []models.MetadataKey{
+		{Id: 1, Key: "Amount", ValueType: models.MetadataValueMoney},
+		{Id: 2, Key: "Due date", ValueType: models.MetadataValueDate},
+		{Id: 3, Key: "category", ValueType: models.MetadataValueText},
+	}
+	unix := func(y int, m time.Month, d int) int64 {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()
+	}
+
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"amount:>100", "(metadata_num.key_1 > 100)", false},
+		{"amount:<=99,90", "(metadata_num.key_1 <= 99.9)", false},
+		{"due_date:<2024-01", "(metadata_num.key_2 < " + fmt.Sprint(unix(2024, 1, 1)) + ")", false},
+		{"due_date:>2024-01", "(metadata_num.key_2 >= " + fmt.Sprint(unix(2024, 2, 1)) + ")", false},
+		{"not amount:>100 or category:bills", `(NOT (metadata_num.key_1 > 100) OR metadata = "category:bills")`, false},
+		{"category:>100", "", true},
+		{"unknown:>100", "", true},
+		{"amount:>many", "", true},
+		{"due_date:>someday", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			node, err := parseQuery(tt.query, testNow)
+			if err != nil {
+				t.Fatalf("parseQuery() error = %v", err)
+			}
+			err = resolveMetadataTypes(node, keys, testNow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveMetadataTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := meiliFilter(node); got != tt.want {
+				t.Errorf("meiliFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"strings"
 	"time"
-	"unicode/utf8"
 
 	"github.com/meilisearch/meilisearch-go"
 	"github.com/sirupsen/logrus"
@@ -42,54 +41,76 @@ type DocumentFilter struct {
 	SortMode string    `json:"sort_mode"`
 }
 
+// SortRelevance sorts best matching documents first.
+const SortRelevance = "relevance"
+
+// SortAttributes are the attributes that search results can be sorted by.
+// With any sort, documents that are otherwise equal are sorted newest first.
+var SortAttributes = []string{SortRelevance, "date", "name", "created_at", "updated_at"}
+
+// IsSortAttribute returns true if search results can be sorted by the attribute.
+func IsSortAttribute(key string) bool {
+	for _, v := range SortAttributes {
+		if v == key {
+			return true
+		}
+	}
+	return false
+}
+
 // SearchDocuments searches documents for given user. Query can be anything. If field="", search in any field,
 // else search only specified field
-func (e *Engine) SearchDocuments(userId int, query string, sort storage.SortKey, paging storage.Paging) ([]*models.Document, int, error) {
-
-	qs, err := parseFilter(query)
+func (e *Meilisearch) SearchDocuments(userId int, query string, sort storage.SortKey, paging storage.Paging) ([]*models.Document, int, error) {
+	qs, err := e.parseUserQuery(userId, query)
 	if err != nil {
-		e := errors.ErrInvalid
-		e.ErrMsg = err.Error()
-		return nil, 0, e
+		return nil, 0, err
 	}
 
 	request := qs.prepareMeiliQuery(userId, sort, paging)
+	filter, _ := request.Filter.(string)
+	if filter = e.layout.userFilter(userId, filter); filter != "" {
+		request.Filter = filter
+	}
 	logrus.Debugf("Meilisearch query: %s, %v", qs.Query, request.Filter)
 
 	docs := make([]*models.Document, 0)
 
-	res, err := e.client.Index(indexName(userId)).Search(qs.Query, request)
+	res, err := e.client.Index(e.layout.indexName(userId)).Search(qs.Query, request)
 	if err != nil {
-		if meiliError, ok := err.(*meilisearch.Error); ok {
-			if meiliError.StatusCode == 400 {
-				logrus.Debugf("meilisearch invalid query: %v", meiliError)
-				// invalid query
-				userError := errors.ErrInvalid
-				userError.ErrMsg = "Invalid query"
-				return nil, 0, userError
-			} else {
-				logrus.Errorf("meilisearch error: %v", meiliError)
-			}
-		}
-		return docs, 0, err
+		return docs, 0, searchError(err)
 	}
 	if len(res.Hits) == 0 {
 		return docs, 0, nil
 	}
 
-	docs = make([]*models.Document, len(res.Hits))
+	docs = make([]*models.Document, 0, len(res.Hits))
+	// index has distinct attribute set, but make sure each document is returned only once
+	seen := make(map[string]bool, len(res.Hits))
 
-	for i, v := range res.Hits {
+	for _, v := range res.Hits {
 		isMap, ok := v.(map[string]interface{})
 		if ok {
 			doc := &models.Document{}
 			doc.Id = getString("document_id", isMap)
+			if seen[doc.Id] {
+				continue
+			}
+			seen[doc.Id] = true
 			doc.Name = getString("name", isMap)
 			doc.Content = getString("content", isMap)
 			doc.Description = getString("description", isMap)
 			doc.Date = time.Unix(int64(getInt("date", isMap)), 0)
 			doc.Mimetype = getString("mimetype", isMap)
-			docs[i] = doc
+			if qs.Query != "" {
+				doc.SearchMatch = &models.DocumentSearchMatch{
+					Chunk: getInt("chunk", isMap),
+					Page:  getInt("page", isMap),
+				}
+				if doc.SearchMatch.Page == 0 {
+					doc.SearchMatch.Page = 1
+				}
+			}
+			docs = append(docs, doc)
 
 			formatted := isMap["_formatted"]
 			if formattedMap, ok := formatted.(map[string]interface{}); ok {
@@ -105,194 +126,290 @@ func (e *Engine) SearchDocuments(userId int, query string, sort storage.SortKey,
 
 		}
 	}
-	// If there are only filters and no query, meilisearch returns larger nbHits, probably count of all documents,
-	// which is incorrect for given filter.
-	nHits := int(res.EstimatedTotalHits)
-	return docs, nHits, nil
+	if request.Page != 0 {
+		// exact, but at most maxTotalHits that is configured to the index
+		return docs, int(res.TotalHits), nil
+	}
+	return docs, int(res.EstimatedTotalHits), nil
 }
 
-func getString(key string, container map[string]interface{}) string {
-	val, ok := container[key].(string)
-	if !ok {
-		return ""
+// maxFacetDocuments is the maximum number of documents matching a full-text query that facets are counted for.
+const maxFacetDocuments = 1000
+
+// SearchFacets counts metadata, mimetype and date facets of documents matching the query.
+// Meilisearch counts records and not documents, so only the first chunk of each document is counted.
+// Chunk cannot be filtered when the query has free text, since the text can match any chunk.
+// In that case matching documents are searched first and facets are counted for them.
+func (e *Meilisearch) SearchFacets(userId int, query string) (*SearchFacets, error) {
+	qs, err := e.parseUserQuery(userId, query)
+	if err != nil {
+		return nil, err
 	}
-	return val
-}
+	keys, err := e.db.UserStore.GetFacetKeys(userId)
+	if err != nil {
+		logrus.Warningf("get facet keys for user %d: %v", userId, err)
+	}
+	counter := newFacetCounter(keys)
+	index := e.client.Index(e.layout.indexName(userId))
 
-func getInt(key string, container map[string]interface{}) int {
-	// int
-	intVal, ok := container[key].(int)
-	if ok {
-		return intVal
+	filter := ""
+	if qs.Filter != nil {
+		filter = meiliFilter(qs.Filter)
 	}
-	int64Val, ok := container[key].(int64)
-	if ok {
-		return int(int64Val)
+	if qs.Query != "" {
+		res, err := index.Search(qs.Query, &meilisearch.SearchRequest{
+			Limit:                maxFacetDocuments,
+			AttributesToRetrieve: []string{"document_id"},
+			Filter:               e.layout.userFilter(userId, filter),
+		})
+		if err != nil {
+			return nil, searchError(err)
+		}
+		if len(res.Hits) == 0 {
+			return counter.result(), nil
+		}
+		quoted := make([]string, 0, len(res.Hits))
+		for _, v := range res.Hits {
+			if hit, ok := v.(map[string]interface{}); ok {
+				quoted = append(quoted, `"`+getString("document_id", hit)+`"`)
+			}
+		}
+		filter = fmt.Sprintf("document_id IN [%s]", strings.Join(quoted, ", "))
 	}
-	float32Val, ok := container[key].(float32)
-	if ok {
-		return int(float32Val)
+	filter = firstChunkFilter(filter)
+
+	res, err := index.Search("", &meilisearch.SearchRequest{
+		Limit:                1,
+		AttributesToRetrieve: []string{"document_id"},
+		Filter:               e.layout.userFilter(userId, filter),
+		Facets:               counter.attributes(),
+		PlaceholderSearch:    true,
+	})
+	if err != nil {
+		return nil, searchError(err)
 	}
-	float64Val, ok := container[key].(float64)
-	if ok {
-		return int(float64Val)
+	distribution, _ := res.FacetDistribution.(map[string]interface{})
+	for facet, values := range distribution {
+		valueMap, ok := values.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for value := range valueMap {
+			counter.add(facet, value, getInt(value, valueMap))
+		}
 	}
-	return 0
+	return counter.result(), nil
 }
 
-// tokenizes filter: 'a:misc and (topic:"unknown topic")' -> [a:misc, and, (, topic:unknown topic, )]
-func tokenizeFilter(filter string) []string {
-	if filter == "" {
-		return []string{}
+// searchError converts invalid query error to user error.
+func searchError(err error) error {
+	if meiliError, ok := err.(*meilisearch.Error); ok {
+		if meiliError.StatusCode == 400 {
+			logrus.Debugf("meilisearch invalid query: %v", meiliError)
+			userError := errors.ErrInvalid
+			userError.ErrMsg = "Invalid query"
+			return userError
+		}
+		logrus.Errorf("meilisearch error: %v", meiliError)
 	}
+	return err
+}
 
-	tokens := make([]string, 0, 10)
-	escapeChar := '"'
-	inEscape := false
-	textLeft := filter
-	token := ""
+// parseUserQuery parses the query. In multi-tenant index user's stop words are removed from the query,
+// since they can't be configured to the index.
+func (e *Meilisearch) parseUserQuery(userId int, query string) (*searchQuery, error) {
+	qs, err := parseUserFilter(e.db, userId, query)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		if textLeft == "" {
-			if token != "" {
-				tokens = append(tokens, token)
-			}
-			break
-		}
-		character, width := utf8.DecodeRuneInString(textLeft)
-		if inEscape {
-			if character == escapeChar {
-				inEscape = false
-				textLeft = textLeft[width:]
-			} else {
-				token += string(character)
-				textLeft = textLeft[width:]
-			}
+	if e.layout.multiTenant() {
+		stopWords, _, err := e.db.UserStore.GetSearchPreferences(userId)
+		if err != nil {
+			logrus.Warningf("get stop words for user %d: %v", userId, err)
 		} else {
-			if character == escapeChar {
-				inEscape = true
-				textLeft = textLeft[width:]
-			} else if character == ' ' {
-				// next token
-				tokens = append(tokens, token)
-				textLeft = textLeft[width:]
-				token = ""
-			} else if character == ')' || character == '(' {
-				if token != "" {
-					tokens = append(tokens, token)
-					token = ""
-				}
-				tokens = append(tokens, string(character))
-				textLeft = textLeft[width:]
-				textLeft = strings.TrimLeft(textLeft, " ")
-			} else {
-				token += string(character)
-				textLeft = textLeft[width:]
-			}
+			qs.removeStopWords(stopWords)
+		}
+	}
+	if qs.Filter != nil {
+		qs.Filter, err = e.resolveFieldTerms(userId, qs.Filter)
+		if err != nil {
+			return nil, err
 		}
 	}
+	return qs, nil
+}
 
-	return tokens
+// fieldTermPageSize is the number of hits fetched at a time when resolving field-scoped terms.
+const fieldTermPageSize = 1000
+
+// fieldTermResolver replaces field-scoped terms of name, description and content with the documents
+// that match them. Each term is searched as a phrase from its own attribute only,
+// which also finds the phrase from any chunk of long content.
+type fieldTermResolver struct {
+	engine *Meilisearch
+	userId int
+	index  string
+	// searchable contains the searchable attributes of the index, loaded on first field-scoped term.
+	searchable []string
 }
 
-func parseFilter(filter string) (*searchQuery, error) {
-	sq := &searchQuery{RawQuery: filter}
-	tokens := tokenizeFilter(strings.ToLower(filter))
-
-	operators := []string{"and", "or", "not", "(", ")"}
-	metadataQuery := []string{}
-
-	textQuery := []string{}
-
-	matchers := map[string]parseFunc{
-		"date":        parseDate,
-		"name":        parseName,
-		"content":     parseContent,
-		"description": parseDescription,
-	}
-
-	tokensLeft := tokens
-	removeToken := func() {
-		tokensLeft = tokensLeft[1:]
-	}
-
-	maxIterations := len(tokens)
-	iteration := 0
-	for iteration < maxIterations && len(tokensLeft) > 0 {
-		iteration += 1
-		token := tokensLeft[0]
-		splits := strings.Split(tokensLeft[0], ":")
-		if len(splits) == 1 {
-			found := false
-			for _, v := range operators {
-				if token == v {
-					metadataQuery = append(metadataQuery, strings.ToUpper(v))
-					removeToken()
-					found = true
-					break
-				}
+func (e *Meilisearch) resolveFieldTerms(userId int, node queryNode) (queryNode, error) {
+	r := &fieldTermResolver{engine: e, userId: userId, index: e.layout.indexName(userId)}
+	return r.resolve(node)
+}
+
+func (r *fieldTermResolver) resolve(node queryNode) (queryNode, error) {
+	var err error
+	switch n := node.(type) {
+	case *queryAnd:
+		for i, v := range n.Nodes {
+			if n.Nodes[i], err = r.resolve(v); err != nil {
+				return nil, err
 			}
-			if found {
-				continue
+		}
+	case *queryOr:
+		for i, v := range n.Nodes {
+			if n.Nodes[i], err = r.resolve(v); err != nil {
+				return nil, err
 			}
-			textQuery = append(textQuery, token)
-			removeToken()
-			continue
 		}
-		found := false
-		for key, matcher := range matchers {
-
-			if splits[0] == key {
-				ok := matcher(splits[1], sq)
-				if !ok {
-					return sq, fmt.Errorf("invalid query: %v", token)
-				} else {
-					removeToken()
-					found = true
-					break
+	case *queryNot:
+		if n.Node, err = r.resolve(n.Node); err != nil {
+			return nil, err
+		}
+	case *queryField:
+		if n.Field == queryFieldMetadata {
+			return n, nil
+		}
+		ids, err := r.documents(n)
+		if err != nil {
+			return nil, err
+		}
+		return &queryDocuments{Pos: n.Pos, Ids: ids}, nil
+	}
+	return node, nil
+}
+
+// documents returns ids of all documents that match the field-scoped term. Hits are paged through
+// until they run out, since chunks of the same document can take several hits.
+func (r *fieldTermResolver) documents(n *queryField) ([]string, error) {
+	if r.searchable == nil {
+		r.searchable = r.engine.searchableAttributes(r.engine.indexSettings(r.index))
+	}
+	searchable := false
+	for _, v := range r.searchable {
+		searchable = searchable || v == n.Field
+	}
+	if !searchable {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("field '%s' is not searchable, its weight in search settings is 0", n.Field)
+		return nil, e
+	}
+
+	request := &meilisearch.SearchRequest{
+		Limit:                fieldTermPageSize,
+		AttributesToRetrieve: []string{"document_id"},
+		AttributesToSearchOn: []string{n.Field},
+	}
+	if filter := r.engine.layout.userFilter(r.userId, ""); filter != "" {
+		request.Filter = filter
+	}
+	phrase := `"` + strings.ReplaceAll(n.Value, `"`, " ") + `"`
+	ids := []string{}
+	seen := map[string]bool{}
+	for {
+		res, err := r.engine.client.Index(r.index).Search(phrase, request)
+		if err != nil {
+			return nil, searchError(err)
+		}
+		for _, v := range res.Hits {
+			if hit, ok := v.(map[string]interface{}); ok {
+				id := getString("document_id", hit)
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
 				}
 			}
-
 		}
-		if found {
-			continue
+		if int64(len(res.Hits)) < request.Limit {
+			return ids, nil
+		}
+		request.Offset += request.Limit
+		if request.Offset >= maxTotalHits {
+			// Meilisearch does not return hits beyond max total hits, results would be incomplete
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("search term %s:%s matches too many documents", n.Field, n.Value)
+			return nil, e
 		}
-
-		metadataFilter := fmt.Sprintf(`metadata="%s:%s"`, normalizeMetadataKey(splits[0]), normalizeMetadataValue(splits[1]))
-		metadataQuery = append(metadataQuery, metadataFilter)
-		removeToken()
 	}
-	sq.MetadataQuery = metadataQuery
-	sq.MetadataString = strings.Join(metadataQuery, " ")
-	sq.Query = strings.Join(textQuery, " ")
-	return sq, nil
 }
 
-type parseFunc func(value string, sq *searchQuery) bool
-
-func parseDate(value string, sq *searchQuery) bool {
-	status, _, startT, endT := matchDate(value)
-	if status == valueMatchStatusOk {
-		sq.DateAfter = startT
-		sq.DateBefore = endT
-		return true
+// parseUserFilter parses the query with user's timezone and metadata key types.
+func parseUserFilter(db *storage.Database, userId int, query string) (*searchQuery, error) {
+	now := userNow(db, userId)
+	qs, err := parseFilter(query, now)
+	if err == nil && qs.Filter != nil {
+		var keys []models.MetadataKey
+		keys, err = db.MetadataStore.GetTypedKeys(userId)
+		if err != nil {
+			return nil, err
+		}
+		err = resolveMetadataTypes(qs.Filter, keys, now)
 	}
-	return false
+	if err != nil {
+		e := errors.ErrInvalid
+		e.ErrMsg = err.Error()
+		return nil, e
+	}
+	return qs, nil
 }
 
-func parseName(value string, sq *searchQuery) bool {
-	sq.Name = value
-	return true
+func getString(key string, container map[string]interface{}) string {
+	val, ok := container[key].(string)
+	if !ok {
+		return ""
+	}
+	return val
 }
 
-func parseContent(value string, sq *searchQuery) bool {
-	sq.Content = value
-	return true
+func getInt(key string, container map[string]interface{}) int {
+	// int
+	intVal, ok := container[key].(int)
+	if ok {
+		return intVal
+	}
+	int64Val, ok := container[key].(int64)
+	if ok {
+		return int(int64Val)
+	}
+	float32Val, ok := container[key].(float32)
+	if ok {
+		return int(float32Val)
+	}
+	float64Val, ok := container[key].(float64)
+	if ok {
+		return int(float64Val)
+	}
+	return 0
 }
 
-func parseDescription(value string, sq *searchQuery) bool {
-	sq.Description = value
-	return true
+// parseFilter parses the search query. Query is case-insensitive.
+// Free-text terms are searched with full-text-search and all other terms are converted to filters,
+// see parseQuery for syntax. Relative dates are computed from now.
+func parseFilter(filter string, now time.Time) (*searchQuery, error) {
+	sq := &searchQuery{RawQuery: filter, Terms: []searchTerm{}}
+
+	root, err := parseQuery(strings.ToLower(filter), now)
+	if err != nil {
+		return sq, err
+	}
+	sq.Terms, sq.Filter, err = splitQuery(root)
+	if err != nil {
+		return sq, err
+	}
+	sq.updateQuery()
+	return sq, nil
 }
 
 func normalizeMetadataKey(key string) string {
@@ -303,6 +420,21 @@ func normalizeMetadataValue(value string) string {
 	return normalizeMetadataKey(value)
 }
 
+// metadataEntries returns the indexed 'key:value' entries of the metadata. Values with parents are
+// indexed with the paths of the value and all its ancestors too, so that filtering by a value
+// matches all values under it.
+func metadataEntries(m models.Metadata) []string {
+	key := normalizeMetadataKey(m.Key)
+	entries := []string{key + ":" + normalizeMetadataValue(m.Value)}
+	for _, path := range m.Paths() {
+		entry := key + ":" + normalizeMetadataValue(path)
+		if entry != entries[0] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 func escapeMetadataKey(key string) string {
 	if strings.Contains(key, " ") {
 		return `"` + key + `"`
@@ -19,248 +19,510 @@
 package search
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
-	"tryffel.net/go/virtualpaper/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/meilisearch/meilisearch-go"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/storage"
 )
 
-func Test_tokenizeFilter(t *testing.T) {
+func Test_parseFilter(t *testing.T) {
+	dateFilter := func(after, before time.Time) string {
+		return fmt.Sprintf("(date >= %d AND date < %d)", after.Unix(), before.Unix())
+	}
+	today := calendarDate(testNow)
+
 	type args struct {
 		filter string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []string
+		name       string
+		args       args
+		wantQuery  string
+		wantFilter string
+		wantErr    bool
 	}{
 		{
-			args: args{`a author:doyle OR (topic:"misc topic" AND author:doyle) and one more`},
-			want: []string{"a", "author:doyle", "OR", "(", "topic:misc topic", "AND", "author:doyle", ")", "and", "one", "more"},
+			name:      "simple fts",
+			args:      args{"test one"},
+			wantQuery: "test one",
 		},
 		{
-			args: args{`a "complex key":"complex value"`},
-			want: []string{"a", "complex key:complex value"},
+			name:       "simple key:value",
+			args:       args{"simple key:value"},
+			wantQuery:  "simple",
+			wantFilter: `metadata = "key:value"`,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := tokenizeFilter(tt.args.filter); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("tokenizeFilter() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_parseFilter(t *testing.T) {
-	type args struct {
-		filter string
-	}
-	tests := []struct {
-		name    string
-		args    args
-		want    *searchQuery
-		wantErr bool
-	}{
 		{
-			name: "simple fts",
-			args: args{"test one"},
-			want: &searchQuery{
-				RawQuery:      "test one",
-				Query:         "test one",
-				MetadataQuery: []string{},
-			},
-			wantErr: false,
+			name:       "multi word key values",
+			args:       args{`simple "key 2":"complex value" AND key:value`},
+			wantQuery:  "simple",
+			wantFilter: `(metadata = "key_2:complex_value" AND metadata = "key:value")`,
 		},
 		{
-			name: "simple key:value",
-			args: args{"simple key:value"},
-			want: &searchQuery{
-				RawQuery:       "simple key:value",
-				Query:          "simple",
-				MetadataQuery:  []string{`metadata="key:value"`},
-				MetadataString: `metadata="key:value"`,
-			},
-			wantErr: false,
+			name:       "multiple metadata",
+			args:       args{"simple key:value AND another:value more search"},
+			wantQuery:  "simple more search",
+			wantFilter: `(metadata = "key:value" AND metadata = "another:value")`,
 		},
 		{
-			name: "multi word key values",
-			args: args{`simple "key 2":"complex value" AND key:value`},
-			want: &searchQuery{
-				RawQuery:       `simple "key 2":"complex value" AND key:value`,
-				Query:          "simple",
-				MetadataQuery:  []string{`metadata="key_2:complex_value"`, "AND", `metadata="key:value"`},
-				MetadataString: `metadata="key_2:complex_value" AND metadata="key:value"`,
-			},
-			wantErr: false,
+			name:       "multiple metadata with parentheses",
+			args:       args{"simple key:value AND (another:value OR key:value) more search"},
+			wantQuery:  "simple more search",
+			wantFilter: `(metadata = "key:value" AND (metadata = "another:value" OR metadata = "key:value"))`,
 		},
 		{
-			name: "multiple metadata",
-			args: args{"simple key:value AND another:value more search"},
-			want: &searchQuery{
-				RawQuery:       "simple key:value AND another:value more search",
-				Query:          "simple more search",
-				MetadataQuery:  []string{`metadata="key:value"`, "AND", `metadata="another:value"`},
-				MetadataString: `metadata="key:value" AND metadata="another:value"`,
-			},
-			wantErr: false,
+			name:       "date today",
+			args:       args{"date:today"},
+			wantFilter: dateFilter(today, today.AddDate(0, 0, 1)),
 		},
 		{
-			name: "multiple metadata with parentheses",
-			args: args{"simple key:value AND (another:value OR key:value) more search"},
-			want: &searchQuery{
-				RawQuery:       "simple key:value AND (another:value OR key:value) more search",
-				Query:          "simple more search",
-				MetadataQuery:  []string{`metadata="key:value"`, "AND", "(", `metadata="another:value"`, "OR", `metadata="key:value"`, ")"},
-				MetadataString: `metadata="key:value" AND ( metadata="another:value" OR metadata="key:value" )`,
-			},
-			wantErr: false,
+			name:       "date yesterday",
+			args:       args{"date:yesterday"},
+			wantFilter: dateFilter(today.AddDate(0, 0, -1), today),
 		},
 		{
-			name: "date today",
-			args: args{"date:today"},
-			want: &searchQuery{
-				RawQuery:       "date:today",
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     models.MidnightForDate(time.Now().AddDate(0, 0, 1).Local()),
-				DateAfter:      models.MidnightForDate(time.Now().AddDate(0, 0, 0).Local()),
-			},
-			wantErr: false,
+			name:      "combined",
+			args:      args{`fts test date:today (class:paper OR class:invoice)`},
+			wantQuery: "fts test",
+			wantFilter: "(" + dateFilter(today, today.AddDate(0, 0, 1)) +
+				` AND (metadata = "class:paper" OR metadata = "class:invoice"))`,
 		},
 		{
-			name: "date yesterday",
-			args: args{"date:yesterday"},
-			want: &searchQuery{
-				RawQuery:       "date:yesterday",
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     models.MidnightForDate(time.Now().AddDate(0, 0, 0).Local()),
-				DateAfter:      models.MidnightForDate(time.Now().AddDate(0, 0, -1).Local()),
-			},
-			wantErr: false,
+			name:       "date: year",
+			args:       args{`date:2022`},
+			wantFilter: dateFilter(timeFromDate(2022, 1, 1), timeFromDate(2023, 1, 1)),
 		},
 		{
-			name: "name",
-			args: args{`name:"docname two"`},
-			want: &searchQuery{
-				RawQuery:       `name:"docname two"`,
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				Name:           "docname two",
-			},
-			wantErr: false,
+			name:       "date: month range",
+			args:       args{`date:2022|2022-06`},
+			wantFilter: dateFilter(timeFromDate(2022, 1, 1), timeFromDate(2022, 7, 1)),
 		},
 		{
-			name: "content",
-			args: args{`content:"one two three"`},
-			want: &searchQuery{
-				RawQuery:       `content:"one two three"`,
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				Content:        "one two three",
-			},
-			wantErr: false,
+			name:       "date: open range",
+			args:       args{`date:2020..`},
+			wantFilter: fmt.Sprintf("(date >= %d)", timeFromDate(2020, 1, 1).Unix()),
 		},
 		{
-			name: "description",
-			args: args{`description:"one two three"`},
-			want: &searchQuery{
-				RawQuery:       `description:"one two three"`,
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				Description:    "one two three",
-			},
-
-			wantErr: false,
+			name:       "date: range to today",
+			args:       args{`date:2015-6-12..today`},
+			wantFilter: dateFilter(timeFromDate(2015, 6, 12), today.AddDate(0, 0, 1)),
 		},
 		{
-			name: "combined",
-			args: args{`fts test date:today class:paper OR class:invoice"`},
-			want: &searchQuery{
-				RawQuery:       `fts test date:today class:paper OR class:invoice"`,
-				Query:          "fts test",
-				MetadataQuery:  []string{`metadata="class:paper"`, "OR", `metadata="class:invoice"`},
-				MetadataString: `metadata="class:paper" OR metadata="class:invoice"`,
-				DateBefore:     models.MidnightForDate(time.Now().Local().AddDate(0, 0, 1)),
-				DateAfter:      models.MidnightForDate(time.Now().Local().AddDate(0, 0, 0)),
-			},
-
-			wantErr: false,
+			name: "added last week",
+			args: args{`added:last-week`},
+			wantFilter: fmt.Sprintf("(created_at >= %d AND created_at < %d)",
+				timeFromDate(2023, 6, 5).Unix(), timeFromDate(2023, 6, 12).Unix()),
 		},
 		{
-			name: "date: year",
-			args: args{`date:2022`},
-			want: &searchQuery{
-				RawQuery:       `date:2022`,
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     timeFromDate(2023, 1, 1),
-				DateAfter:      timeFromDate(2022, 1, 1),
-			},
-
-			wantErr: false,
+			name:       "updated before",
+			args:       args{`updated:<2023`},
+			wantFilter: fmt.Sprintf("(updated_at < %d)", timeFromDate(2023, 1, 1).Unix()),
 		},
 		{
-			name: "date: month range",
-			args: args{`date:2022|2022-06`},
-			want: &searchQuery{
-				RawQuery:       `date:2022|2022-06`,
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     timeFromDate(2022, 7, 1),
-				DateAfter:      timeFromDate(2022, 1, 1),
-			},
-
-			wantErr: false,
+			name:      "phrase and negated term",
+			args:      args{`"ice cream" -milk`},
+			wantQuery: `"ice cream" -milk`,
 		},
 		{
-			name: "date: year range",
-			args: args{`date:2020|today`},
-			want: &searchQuery{
-				RawQuery:       `date:2020|today`,
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     models.MidnightForDate(time.Now().AddDate(0, 0, 0)),
-				DateAfter:      timeFromDate(2020, 1, 1),
-			},
-
-			wantErr: false,
+			name:       "not metadata",
+			args:       args{`invoice NOT class:paper`},
+			wantQuery:  "invoice",
+			wantFilter: `NOT metadata = "class:paper"`,
 		},
 		{
-			name: "date: day-to-yesterday",
-			args: args{`date:2015-6-12|yesterday`},
-			want: &searchQuery{
-				RawQuery:       `date:2015-6-12|yesterday`,
-				Query:          "",
-				MetadataQuery:  []string{},
-				MetadataString: "",
-				DateBefore:     models.MidnightForDate(time.Now().AddDate(0, 0, -1)),
-				DateAfter:      timeFromDate(2015, 6, 12),
-			},
-
-			wantErr: false,
+			name:       "escape quotes",
+			args:       args{`class:a\"b`},
+			wantFilter: `metadata = "class:a\\\"b"`,
+		},
+		{
+			name:    "free text in or",
+			args:    args{"invoice OR class:paper"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid date",
+			args:    args{"date:someday"},
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced parentheses",
+			args:    args{"(class:paper"},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseFilter(tt.args.filter)
+			got, err := parseFilter(tt.args.filter, testNow)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseFilter() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parseFilter() = %v, want %v", got, tt.want)
+			if tt.wantErr {
+				return
+			}
+			if got.Query != tt.wantQuery {
+				t.Errorf("parseFilter() query = %v, want %v", got.Query, tt.wantQuery)
+			}
+			if filter := meiliFilter(got.Filter); filter != tt.wantFilter {
+				t.Errorf("parseFilter() filter = %v, want %v", filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+// testNow is Wednesday.
+var testNow = time.Date(2023, 6, 14, 15, 30, 0, 0, time.UTC)
+
+func Test_dateRange(t *testing.T) {
+	tests := []struct {
+		token      string
+		wantAfter  time.Time
+		wantBefore time.Time
+		wantOk     bool
+	}{
+		{"today", timeFromDate(2023, 6, 14), timeFromDate(2023, 6, 15), true},
+		{"yesterday", timeFromDate(2023, 6, 13), timeFromDate(2023, 6, 14), true},
+		{"this-week", timeFromDate(2023, 6, 12), timeFromDate(2023, 6, 19), true},
+		{"last-week", timeFromDate(2023, 6, 5), timeFromDate(2023, 6, 12), true},
+		{"this-month", timeFromDate(2023, 6, 1), timeFromDate(2023, 7, 1), true},
+		{"last-month", timeFromDate(2023, 5, 1), timeFromDate(2023, 6, 1), true},
+		{"this-year", timeFromDate(2023, 1, 1), timeFromDate(2024, 1, 1), true},
+		{"last-year", timeFromDate(2022, 1, 1), timeFromDate(2023, 1, 1), true},
+		{"2021..2022", timeFromDate(2021, 1, 1), timeFromDate(2023, 1, 1), true},
+		{"2021-3..last-month", timeFromDate(2021, 3, 1), timeFromDate(2023, 6, 1), true},
+		{"2021|", timeFromDate(2021, 1, 1), timeFromDate(2023, 6, 15), true},
+		{"..2021", time.Time{}, timeFromDate(2022, 1, 1), true},
+		{">2023-05", timeFromDate(2023, 6, 1), time.Time{}, true},
+		{">=2023-05", timeFromDate(2023, 5, 1), time.Time{}, true},
+		{"<2023-05", time.Time{}, timeFromDate(2023, 5, 1), true},
+		{"<=2023-05", time.Time{}, timeFromDate(2023, 6, 1), true},
+		{"2023..2021", time.Time{}, time.Time{}, false},
+		{"..", time.Time{}, time.Time{}, false},
+		{">", time.Time{}, time.Time{}, false},
+		{"last-decade", time.Time{}, time.Time{}, false},
+		{"2023-13", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			after, before, ok := dateRange(tt.token, testNow)
+			if ok != tt.wantOk || !after.Equal(tt.wantAfter) || !before.Equal(tt.wantBefore) {
+				t.Errorf("dateRange() = %v, %v, %v, want %v, %v, %v", after, before, ok, tt.wantAfter, tt.wantBefore, tt.wantOk)
 			}
 		})
 	}
 }
 
+func Test_dateRange_timezone(t *testing.T) {
+	// already Thursday in Helsinki
+	helsinki := time.FixedZone("EEST", 3*3600)
+	now := time.Date(2023, 6, 14, 22, 30, 0, 0, time.UTC).In(helsinki)
+
+	qs, err := parseFilter("date:today added:today", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thursday := time.Date(2023, 6, 15, 0, 0, 0, 0, helsinki)
+	want := fmt.Sprintf("((date >= %d AND date < %d) AND (created_at >= %d AND created_at < %d))",
+		timeFromDate(2023, 6, 15).Unix(), timeFromDate(2023, 6, 16).Unix(),
+		thursday.Unix(), thursday.AddDate(0, 0, 1).Unix())
+	if got := meiliFilter(qs.Filter); got != want {
+		t.Errorf("filter = %s, want %s", got, want)
+	}
+}
+
 func timeFromDate(year, month, day int) time.Time {
 	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 }
+
+func Test_prepareMeiliQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		sort        storage.SortKey
+		paging      storage.Paging
+		wantFilter  interface{}
+		wantSort    []string
+		wantPage    int64
+		wantPerPage int64
+		wantOffset  int64
+	}{
+		{
+			name:        "filter only",
+			query:       "category:bills",
+			paging:      storage.Paging{Offset: 20, Limit: 10},
+			wantFilter:  `(metadata = "category:bills") AND chunk = 0`,
+			wantPage:    3,
+			wantPerPage: 10,
+		},
+		{
+			name:        "empty",
+			paging:      storage.Paging{Offset: 0, Limit: 10},
+			wantFilter:  "chunk = 0",
+			wantPage:    1,
+			wantPerPage: 10,
+		},
+		{
+			name:        "text with relevance",
+			query:       "invoice",
+			sort:        storage.SortKey{Key: SortRelevance},
+			paging:      storage.Paging{Offset: 0, Limit: 10},
+			wantPage:    1,
+			wantPerPage: 10,
+		},
+		{
+			name:        "sort by name",
+			query:       "invoice",
+			sort:        storage.SortKey{Key: "name", Order: true},
+			paging:      storage.Paging{Offset: 0, Limit: 10},
+			wantSort:    []string{"name:desc", "date:desc", "document_id:asc"},
+			wantPage:    1,
+			wantPerPage: 10,
+		},
+		{
+			name:        "sort by date",
+			query:       "invoice",
+			sort:        storage.SortKey{Key: "date"},
+			paging:      storage.Paging{Offset: 0, Limit: 10},
+			wantSort:    []string{"date:asc", "document_id:asc"},
+			wantPage:    1,
+			wantPerPage: 10,
+		},
+		{
+			name:       "invalid sort and offset not on page",
+			query:      "invoice",
+			sort:       storage.SortKey{Key: "content"},
+			paging:     storage.Paging{Offset: 5, Limit: 10},
+			wantOffset: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, err := parseFilter(tt.query, testNow)
+			if err != nil {
+				t.Fatalf("parse filter: %v", err)
+			}
+			got := qs.prepareMeiliQuery(1, tt.sort, tt.paging)
+			if got.Filter != tt.wantFilter {
+				t.Errorf("filter = %v, want %v", got.Filter, tt.wantFilter)
+			}
+			if !reflect.DeepEqual(got.Sort, tt.wantSort) {
+				t.Errorf("sort = %v, want %v", got.Sort, tt.wantSort)
+			}
+			if got.Page != tt.wantPage || got.HitsPerPage != tt.wantPerPage || got.Offset != tt.wantOffset {
+				t.Errorf("page = %d, hits per page = %d, offset = %d", got.Page, got.HitsPerPage, got.Offset)
+			}
+		})
+	}
+}
+
+// fakeSearchIndex searches phrases from the records and returns a hit for each matching chunk,
+// paged with offset and limit. Search without query returns first chunks of the documents listed in the filter.
+type fakeSearchIndex struct {
+	lock     sync.Mutex
+	records  []map[string]interface{}
+	requests []map[string]interface{}
+}
+
+func (f *fakeSearchIndex) addDocument(id, name, content string) {
+	for _, chunk := range chunkContent(content, ChunkWords, ChunkOverlapWords) {
+		f.records = append(f.records, map[string]interface{}{
+			"id":          chunkId(id, chunk.Index),
+			"document_id": id,
+			"chunk":       chunk.Index,
+			"name":        name,
+			"content":     chunk.Content,
+		})
+	}
+}
+
+func (f *fakeSearchIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	request := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&request)
+	f.requests = append(f.requests, request)
+
+	query, _ := request["q"].(string)
+	phrase := strings.ToLower(strings.Trim(query, `"`))
+	filter, _ := request["filter"].(string)
+	attributes := []string{"name", "content"}
+	if on, ok := request["attributesToSearchOn"].([]interface{}); ok {
+		attributes = []string{}
+		for _, v := range on {
+			attributes = append(attributes, v.(string))
+		}
+	}
+
+	hits := []map[string]interface{}{}
+	for _, record := range f.records {
+		id := record["document_id"].(string)
+		match := false
+		if phrase == "" {
+			match = record["chunk"] == 0 && strings.Contains(filter, `"`+id+`"`)
+		}
+		for _, attribute := range attributes {
+			text, _ := record[attribute].(string)
+			if phrase != "" && strings.Contains(strings.ToLower(text), phrase) {
+				match = true
+			}
+		}
+		if match {
+			hits = append(hits, record)
+		}
+	}
+	total := len(hits)
+	offset, _ := request["offset"].(float64)
+	limit, ok := request["limit"].(float64)
+	if !ok {
+		limit = 20
+	}
+	start, end := int(offset), int(offset+limit)
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	hits = hits[start:end]
+	json.NewEncoder(w).Encode(map[string]interface{}{"hits": hits, "totalHits": total})
+}
+
+func newFakeSearchEngine(t *testing.T, fake *fakeSearchIndex, db *storage.Database) *Meilisearch {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	layout, err := newIndexLayout(IndexModeUser, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Meilisearch{
+		client: meilisearch.NewClient(meilisearch.ClientConfig{Host: server.URL}),
+		db:     db,
+		layout: layout,
+	}
+}
+
+func TestMeilisearch_SearchDocuments_fieldTerms(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	fake := &fakeSearchIndex{}
+	// phrase is in the last chunk of the long document and only in the name of the short one
+	words := strings.Repeat("lorem ipsum ", ChunkWords)
+	fake.addDocument("long", "Annual report", words+"the red balloon "+words)
+	fake.addDocument("short", "The red balloon", "Invoice for balloons")
+	for _, v := range fake.records {
+		if v["chunk"] == 0 && strings.Contains(v["content"].(string), "red balloon") {
+			t.Fatalf("phrase must not be in the first chunk of the content")
+		}
+	}
+	engine := newFakeSearchEngine(t, fake, db)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`content:"red balloon"`, []string{"long"}},
+		{`name:"red balloon"`, []string{"short"}},
+		{`content:balloons`, []string{"short"}},
+		{`content:"balloon red"`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mock.ExpectQuery("FROM user_preferences").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+			mock.ExpectQuery("FROM user_preferences").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+			mock.ExpectQuery("FROM metadata_keys").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			fake.requests = nil
+
+			docs, _, err := engine.SearchDocuments(1, tt.query, storage.SortKey{}, storage.Paging{Limit: 10})
+			if err != nil {
+				t.Fatalf("SearchDocuments() error = %v", err)
+			}
+			got := []string{}
+			for _, v := range docs {
+				got = append(got, v.Id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchDocuments() = %v, want %v", got, tt.want)
+			}
+			for _, v := range fake.requests {
+				if filter, _ := v["filter"].(string); strings.Contains(filter, "name =") || strings.Contains(filter, "content =") {
+					t.Errorf("field-scoped term must not be an equality filter, got: %s", filter)
+				}
+			}
+		})
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMeilisearch_resolveFieldTerms_paging(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeSearchIndex{}
+	// each document matches with two chunks, so hits of the documents span several pages
+	documents := fieldTermPageSize + fieldTermPageSize/5
+	for i := 0; i < documents; i++ {
+		id := strconv.Itoa(i)
+		for chunk := 0; chunk < 2; chunk++ {
+			fake.records = append(fake.records, map[string]interface{}{
+				"id":          chunkId(id, chunk),
+				"document_id": id,
+				"chunk":       chunk,
+				"content":     "the red balloon",
+			})
+		}
+	}
+	engine := newFakeSearchEngine(t, fake, db)
+
+	mock.ExpectQuery("FROM user_preferences").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	got, err := engine.resolveFieldTerms(1, &queryNot{Node: &queryField{Field: "content", Value: "red balloon"}})
+	if err != nil {
+		t.Fatalf("resolveFieldTerms() error = %v", err)
+	}
+	docs := got.(*queryNot).Node.(*queryDocuments)
+	if len(docs.Ids) != documents {
+		t.Errorf("resolveFieldTerms() got %d documents, want %d", len(docs.Ids), documents)
+	}
+	if len(fake.requests) != 3 {
+		t.Errorf("resolveFieldTerms() made %d requests, want 3", len(fake.requests))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMeilisearch_resolveFieldTerms_notSearchable(t *testing.T) {
+	db, mock, err := storage.NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeSearchIndex{}
+	engine := newFakeSearchEngine(t, fake, db)
+
+	mock.ExpectQuery("FROM user_preferences").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(`{"attribute_weights":{"name":2,"content":0}}`))
+	_, err = engine.resolveFieldTerms(1, &queryField{Field: "content", Value: "balloon"})
+	if !errors.Is(err, errors.ErrInvalid) {
+		t.Errorf("resolveFieldTerms() error = %v, want invalid", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("field that is not searchable must not be searched")
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+const (
+	// SearchSettingsApplied means the index uses user's current search settings.
+	SearchSettingsApplied = "applied"
+	// SearchSettingsUpdating means the index is being rebuilt with new search settings.
+	SearchSettingsUpdating = "updating"
+	// SearchSettingsFailed means the latest search settings could not be applied.
+	SearchSettingsFailed = "failed"
+)
+
+// SearchSettingsStatus tells whether user's search settings have been applied to the index.
+type SearchSettingsStatus struct {
+	// Supported is false if the engine cannot apply the settings,
+	// e.g. when user's documents are in an index shared with other users.
+	Supported bool `json:"supported"`
+	// Status is one of applied, updating or failed.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// meiliSearchableAttributes are the searchable attributes in order of importance, when user has not weighted them.
+var meiliSearchableAttributes = []string{
+	"document_id",
+	"user_id",
+	"name",
+	"file_name",
+	"content",
+	"hash",
+	"created_at",
+	"updated_at",
+	"tags",
+	"metadata",
+	"date",
+	"description",
+	"tags",
+	"metadata_key",
+	"metadata_value",
+	"mimetype",
+}
+
+// searchRankingRules returns ranking rules with user's ordering of relevancy rules.
+// Explicit sort is always applied first and ties are sorted newest first.
+func searchRankingRules(settings models.SearchSettings) []string {
+	if len(settings.RankingRules) == 0 {
+		return meiliRankingRules
+	}
+	rules := append([]string{"sort"}, settings.RankingRules...)
+	return append(rules, "date:desc")
+}
+
+// searchableAttributes orders attributes by user's weights. Weighted attributes come first by descending weight,
+// then the rest of the attributes in default order. Attributes with weight 0 are not searched.
+func searchableAttributes(settings models.SearchSettings, attributes []string) []string {
+	if len(settings.AttributeWeights) == 0 {
+		return attributes
+	}
+	searchable := []string{}
+	for _, v := range models.SearchAttributes {
+		if settings.AttributeWeights[v] > 0 {
+			searchable = append(searchable, v)
+		}
+	}
+	sort.SliceStable(searchable, func(i, j int) bool {
+		return settings.AttributeWeights[searchable[i]] > settings.AttributeWeights[searchable[j]]
+	})
+	for _, v := range attributes {
+		if _, weighted := settings.AttributeWeights[v]; !weighted {
+			searchable = append(searchable, v)
+		}
+	}
+	return searchable
+}
+
+// indexSettings returns search settings of the index. Only indices of a single user have custom settings.
+func (e *Meilisearch) indexSettings(index string) models.SearchSettings {
+	userId, ok := indexUserId(index)
+	if !ok || e.layout.multiTenant() {
+		return models.SearchSettings{}
+	}
+	settings, err := e.db.UserStore.GetSearchSettings(userId)
+	if err != nil {
+		logrus.Errorf("get search settings for user %d: %v", userId, err)
+	}
+	return settings
+}
+
+// updateSearchSettings applies user's ranking rules, attribute weights and typo tolerance to the index.
+// Meilisearch rebuilds the index in the background when searchable attributes change.
+func (e *Meilisearch) updateSearchSettings(index string, settings models.SearchSettings) error {
+	rules := searchRankingRules(settings)
+	_, err := e.client.Index(index).UpdateRankingRules(&rules)
+	if err != nil {
+		return fmt.Errorf("update ranking rules: %v", err)
+	}
+
+	searchable := e.searchableAttributes(settings)
+	_, err = e.client.Index(index).UpdateSearchableAttributes(&searchable)
+	if err != nil {
+		return fmt.Errorf("update searchable attributes: %v", err)
+	}
+
+	// settings are updated partially and disabled typo tolerance cannot be sent,
+	// so reset typo tolerance first and disable typos on all attributes instead.
+	_, err = e.client.Index(index).ResetTypoTolerance()
+	if err != nil {
+		return fmt.Errorf("reset typo tolerance: %v", err)
+	}
+	typo := settings.TypoTolerance
+	if typo == nil {
+		return nil
+	}
+	tolerance := &meilisearch.TypoTolerance{
+		Enabled: true,
+		MinWordSizeForTypos: meilisearch.MinWordSizeForTypos{
+			OneTypo:  int64(typo.MinWordSizeOneTypo),
+			TwoTypos: int64(typo.MinWordSizeTwoTypos),
+		},
+	}
+	if !typo.Enabled {
+		tolerance = &meilisearch.TypoTolerance{DisableOnAttributes: searchable}
+	}
+	_, err = e.client.Index(index).UpdateTypoTolerance(tolerance)
+	if err != nil {
+		return fmt.Errorf("update typo tolerance: %v", err)
+	}
+	return nil
+}
+
+func (e *Meilisearch) searchableAttributes(settings models.SearchSettings) []string {
+	searchable := append([]string{}, meiliSearchableAttributes...)
+	if e.layout.multiTenant() {
+		searchable = append(searchable, "synonyms")
+	}
+	return searchableAttributes(settings, searchable)
+}
+
+// GetUserSearchSettingsStatus returns the status of the latest settings update of user's index.
+func (e *Meilisearch) GetUserSearchSettingsStatus(userId int) (*SearchSettingsStatus, error) {
+	if e.layout.multiTenant() {
+		return &SearchSettingsStatus{Supported: false}, nil
+	}
+	index := e.layout.indexName(userId)
+	tasks, err := e.client.Index(index).GetTasks(&meilisearch.TasksQuery{
+		Limit: 1,
+		Types: []string{"settingsUpdate"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get index tasks: %v", err)
+	}
+	status := &SearchSettingsStatus{Supported: true, Status: SearchSettingsApplied}
+	if len(tasks.Results) == 0 {
+		return status, nil
+	}
+	switch task := tasks.Results[0]; task.Status {
+	case meilisearch.TaskStatusEnqueued, meilisearch.TaskStatusProcessing:
+		status.Status = SearchSettingsUpdating
+	case meilisearch.TaskStatusFailed:
+		status.Status = SearchSettingsFailed
+		status.Error = task.Error.Message
+	}
+	return status, nil
+}
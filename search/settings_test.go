@@ -0,0 +1,51 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package search
+
+import (
+	"reflect"
+	"testing"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+func Test_searchRankingRules(t *testing.T) {
+	if got := searchRankingRules(models.SearchSettings{}); !reflect.DeepEqual(got, meiliRankingRules) {
+		t.Errorf("default ranking rules = %v", got)
+	}
+	got := searchRankingRules(models.SearchSettings{RankingRules: []string{"attribute", "words"}})
+	want := []string{"sort", "attribute", "words", "date:desc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ranking rules = %v, want %v", got, want)
+	}
+}
+
+func Test_searchableAttributes(t *testing.T) {
+	attributes := []string{"name", "content", "metadata", "description"}
+	if got := searchableAttributes(models.SearchSettings{}, attributes); !reflect.DeepEqual(got, attributes) {
+		t.Errorf("default searchable attributes = %v", got)
+	}
+
+	settings := models.SearchSettings{AttributeWeights: map[string]int{"description": 5, "content": 8, "metadata": 0}}
+	got := searchableAttributes(settings, attributes)
+	want := []string{"content", "description", "name"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchable attributes = %v, want %v", got, want)
+	}
+}
@@ -19,13 +19,9 @@
 package search
 
 import (
-	"fmt"
-	"regexp"
 	"strings"
 	"time"
 
-	"tryffel.net/go/virtualpaper/models"
-
 	"github.com/meilisearch/meilisearch-go"
 	"github.com/sirupsen/logrus"
 	"tryffel.net/go/virtualpaper/storage"
@@ -37,12 +33,21 @@ const MaxSuggestions = 50
 // max for either metadate keys or values
 const MaxSuggestMetadata = 10
 
-func (e *Engine) SuggestSearch(userId int, query string) (*QuerySuggestions, error) {
+func (e *Meilisearch) SuggestSearch(userId int, query string) (*QuerySuggestions, error) {
+	return suggestUserSearch(e.db, userId, query), nil
+}
+
+// suggestUserSearch creates suggestions from user's metadata and validates the query.
+// Suggestions do not depend on the search engine.
+func suggestUserSearch(db *storage.Database, userId int, query string) *QuerySuggestions {
 	metadata := &metadataSuggest{
-		db:     e.db,
+		db:     db,
 		userId: userId,
 	}
-	return suggest(query, metadata), nil
+	now := userNow(db, userId)
+	suggestions := suggest(query, metadata, now)
+	suggestions.validate(query, now)
+	return suggestions
 }
 
 type metadataSuggest struct {
@@ -95,16 +100,14 @@ func (m *metadataSuggest) queryValues(key, value string) []string {
 }
 
 type searchQuery struct {
-	RawQuery       string
-	Query          string
-	Name           string
-	Description    string
-	Content        string
-	DateBefore     time.Time
-	DateAfter      time.Time
-	MetadataQuery  []string
-	MetadataString string
-	Suggestions    []string
+	RawQuery string
+	// Query is the free-text part of the query in Meilisearch syntax.
+	Query string
+	// Terms are the free-text terms. Documents must match all terms that are not negated.
+	Terms []searchTerm
+	// Filter contains field-scoped terms, nil if there are none.
+	Filter      queryNode
+	Suggestions []string
 }
 
 func (s *searchQuery) addSuggestion(text string) {
@@ -112,68 +115,93 @@ func (s *searchQuery) addSuggestion(text string) {
 	s.Suggestions = append(s.Suggestions, text)
 }
 
+// removeStopWords removes stop words from free-text terms. Phrases are kept as they are.
+// If query consists only of stop words, it is not changed.
+func (s *searchQuery) removeStopWords(stopWords []string) {
+	if len(stopWords) == 0 {
+		return
+	}
+	words := make(map[string]bool, len(stopWords))
+	for _, v := range stopWords {
+		words[strings.ToLower(v)] = true
+	}
+	terms := make([]searchTerm, 0, len(s.Terms))
+	for _, v := range s.Terms {
+		if !v.Phrase && !v.Negated && words[strings.ToLower(v.Text)] {
+			continue
+		}
+		terms = append(terms, v)
+	}
+	if len(terms) == 0 {
+		return
+	}
+	s.Terms = terms
+	s.updateQuery()
+}
+
+func (s *searchQuery) updateQuery() {
+	parts := make([]string, len(s.Terms))
+	for i, v := range s.Terms {
+		parts[i] = v.String()
+	}
+	s.Query = strings.Join(parts, " ")
+}
+
+// prepareMeiliQuery builds the search request. Page-based pagination is used when possible,
+// since only then Meilisearch counts the total number of hits exhaustively, up to maxTotalHits.
 func (s *searchQuery) prepareMeiliQuery(userId int, sort storage.SortKey, paging storage.Paging) *meilisearch.SearchRequest {
 
 	request := &meilisearch.SearchRequest{
-		Offset:                int64(paging.Offset),
-		Limit:                 int64(paging.Limit),
-		AttributesToRetrieve:  []string{"document_id", "name", "content", "description", "date", "mimetype"},
+		AttributesToRetrieve:  []string{"document_id", "name", "content", "description", "date", "mimetype", "chunk", "page"},
 		AttributesToCrop:      []string{"content"},
 		CropLength:            1000,
 		AttributesToHighlight: []string{"name"},
 		PlaceholderSearch:     false,
+		Sort:                  meiliSort(sort),
 	}
-	filter := s.MetadataString
-	if s.Query == "" {
-		request.PlaceholderSearch = true
-	}
-
-	//facets := make([]interface{}, 0)
-	//if d.Tag != "" {
-	//facets = append(facets, fmt.Sprintf(`tags:%s`, d.Tag))
-	//}
-
-	datefilters := []string{}
-	if !s.DateAfter.IsZero() {
-		datefilters = append(datefilters, fmt.Sprintf("date >= %d", s.DateAfter.Unix()))
-		logrus.Tracef("search after %s", s.DateAfter.Format("2006-1-2"))
+	if paging.Limit > 0 && paging.Offset%paging.Limit == 0 {
+		request.Page = int64(paging.Offset/paging.Limit) + 1
+		request.HitsPerPage = int64(paging.Limit)
+	} else {
+		request.Offset = int64(paging.Offset)
+		request.Limit = int64(paging.Limit)
 	}
-	if !s.DateBefore.IsZero() {
-		datefilters = append(datefilters, fmt.Sprintf("date < %d", s.DateBefore.Unix()))
-		logrus.Tracef("search before %s", s.DateBefore.Format("2006-1-2"))
 
+	filter := ""
+	if s.Filter != nil {
+		filter = meiliFilter(s.Filter)
 	}
-	if len(datefilters) > 0 {
-		filter = strings.Join(append(datefilters, filter), " AND ")
-	}
-	filter = strings.TrimSuffix(filter, " AND ")
-	//logrus.Infof("filter: %s", filter)
-	// TODO : fix
-	//if sort.Key != "" {
-	//request.Sort = []string{sort.Key + " :" + strings.ToLower(sort.SortOrder())}
-	//}
-
-	if s.Name != "" {
-		q := fmt.Sprintf(`name="%s"`, s.Name)
-		filter = strings.Join([]string{filter, q}, " AND ")
-	} else if s.Description != "" {
-		q := fmt.Sprintf(`description="%s"`, s.Description)
-		filter = strings.Join([]string{filter, q}, " AND ")
-	} else if s.Content != "" {
-		q := fmt.Sprintf(`content="%s"`, s.Content)
-		filter = strings.Join([]string{filter, q}, " AND ")
+	if s.Query == "" {
+		request.PlaceholderSearch = true
+		// without text only the first chunk of each document is needed, which also keeps hit count exact.
+		filter = firstChunkFilter(filter)
 	}
-
 	if filter != "" {
 		// don't set empty filter, it will block all results
 		request.Filter = filter
 	}
+	return request
+}
 
-	if sort.Key != "" && sort.Key != "id" {
-		request.Sort = []string{sort.Key + ":" + strings.ToLower(sort.SortOrder())}
-		//logrus.Info("sort: ", request.Sort)
+// firstChunkFilter limits the filter to the first chunk of each document.
+func firstChunkFilter(filter string) string {
+	if filter == "" {
+		return "chunk = 0"
 	}
-	return request
+	return "(" + filter + ") AND chunk = 0"
+}
+
+// meiliSort returns sort for the search request. Index ranking rules sort ties newest first,
+// and document id makes the order stable for pagination. Relevance needs no sort.
+func meiliSort(sort storage.SortKey) []string {
+	if sort.Key == "" || sort.Key == "id" || sort.Key == SortRelevance || !IsSortAttribute(sort.Key) {
+		return nil
+	}
+	keys := []string{sort.Key + ":" + strings.ToLower(sort.SortOrder())}
+	if sort.Key != "date" {
+		keys = append(keys, "date:desc")
+	}
+	return append(keys, "document_id:asc")
 }
 
 const (
@@ -196,6 +224,27 @@ type QuerySuggestions struct {
 	Suggestions []Suggestion `json:"suggestions"`
 	Prefix      string       `json:"prefix"`
 	ValidQuery  bool         `json:"valid_query"`
+	// Error describes why query is not valid.
+	Error string `json:"error,omitempty"`
+	// ErrorPosition is the index of the character where the error is, if query is not valid.
+	ErrorPosition int `json:"error_position"`
+}
+
+// validate parses the query and sets the error, if there is any.
+func (q *QuerySuggestions) validate(query string, now time.Time) {
+	_, err := parseFilter(query, now)
+	if err == nil {
+		q.ValidQuery = true
+		q.Error = ""
+		q.ErrorPosition = 0
+		return
+	}
+	q.ValidQuery = false
+	q.Error = err.Error()
+	if queryErr, ok := err.(*QueryError); ok {
+		q.Error = queryErr.Message
+		q.ErrorPosition = queryErr.Position
+	}
 }
 
 func (q *QuerySuggestions) addSuggestion(s ...Suggestion) {
@@ -207,28 +256,25 @@ func (q *QuerySuggestions) addSuggestionValues(value, suggestionType, hint strin
 }
 
 // create suggestions. Does not actually validate the query, just analyzes last token(s).
-func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
+// Date suggestions are relative to now.
+func suggest(query string, metadata metadataQuerier, now time.Time) *QuerySuggestions {
 	qs := &QuerySuggestions{Suggestions: []Suggestion{}}
 	if query == "" || query == " " {
 		qs.Suggestions = suggestEmpty(metadata)
 		return qs
 	}
 
-	normalized := strings.ToLower(query)
-	tokens := tokenizeFilter(normalized)
-	inParantheses := false
-
-	normalizedTokens := []string{}
-	if len(tokens) > 1 {
-		normalizedTokens = tokens[:len(tokens)-1]
-	} else if len(tokens) == 1 {
-		normalizedTokens = []string{}
+	normalized := []rune(strings.ToLower(query))
+	tokens, err := lexPartialQuery(string(normalized))
+	if err != nil || len(tokens) == 0 {
+		qs.Prefix = query
+		return qs
 	}
 
 	lastToken := tokens[len(tokens)-1]
-	if lastToken == "(" {
-		inParantheses = true
-
+	// prefix is the query before the last token
+	prefix := string(normalized[:lastToken.Pos])
+	if lastToken.Type == queryTokenLParen {
 		// suggest metadata keys
 		keys := metadata.queryKeys("", "", ":")
 		for _, v := range keys {
@@ -236,29 +282,30 @@ func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
 		}
 		qs.Prefix = query
 		return qs
-	} else {
-		for i := len(tokens) - 1; i > 0; i-- {
-			if tokens[i] == "(" {
-				//qs.addSuggestion(")")
-				inParantheses = true
-				break
-			}
+	}
+	depth := 0
+	for _, v := range tokens {
+		if v.Type == queryTokenLParen {
+			depth += 1
+		} else if v.Type == queryTokenRParen {
+			depth -= 1
 		}
 	}
+	inParantheses := depth > 0
 
-	keys := []string{"name", "description", "content", "date"}
+	keys := []string{"name", "description", "content", "date", "added", "updated"}
 	operators := []string{"AND", "OR", "NOT"}
 
-	parts := strings.Split(lastToken, ":")
-	if len(parts) == 1 {
+	if lastToken.Type != queryTokenField {
 		// no value yet, suggest key
+		key := lastToken.Value
 		for _, v := range keys {
-			if strings.Contains(v, parts[0]) {
+			if strings.Contains(v, key) {
 				qs.addSuggestionValues(v+":", SuggestionTypeKey, "")
 			}
 		}
 
-		metadataKeys := metadata.queryKeys(parts[0], "", "")
+		metadataKeys := metadata.queryKeys(key, "", "")
 		for _, v := range metadataKeys {
 			if strings.Contains(v, " ") {
 				v = `"` + v + `"`
@@ -267,47 +314,44 @@ func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
 		}
 
 		// suggest values too
-		values := metadata.queryValues(parts[0], "")
+		values := metadata.queryValues(key, "")
 		for i, v := range values {
 			if i > 5 {
 				// show only 5 keys per key when still typing key
 				break
 			}
-			qs.addSuggestionValues(parts[0]+":"+escapeMetadataValue(v), SuggestionTypeMetadata, "")
+			qs.addSuggestionValues(key+":"+escapeMetadataValue(v), SuggestionTypeMetadata, "")
 		}
-
-		qs.Prefix = strings.Join(normalizedTokens, " ") + " "
-	} else if len(parts) == 2 {
-		tokenPrefix := escapeMetadataKey(parts[0])
+		qs.Prefix = prefix
+	} else {
+		key, value := lastToken.Key, lastToken.Value
+		tokenPrefix := escapeMetadataKey(key)
 		addWhiteSpace := true
 		// suggest value
-		if inParantheses && parts[0] != "" {
+		if inParantheses {
 			// key-value must be non empty before closing parantheses
-
 			for _, v := range operators {
 				qs.addSuggestionValues(v, SuggestionTypeOperand, "")
 			}
 			qs.addSuggestionValues(")", SuggestionTypeOperand, "")
 			qs.ValidQuery = false
 		}
-		if parts[0] == "date" {
-			dateSuggestions := suggestDate(parts[1])
-			tokenPrefix = "date:"
+		if _, ok := dateFields[key]; ok {
+			dateSuggestions := suggestDate(value, now)
+			tokenPrefix = key + ":"
 			if len(dateSuggestions) > 0 {
-				//tokenPrefix = "date:"
 				addWhiteSpace = false
 				for _, v := range dateSuggestions {
 					qs.addSuggestionValues(v, SuggestionTypeKey, "")
 				}
 			}
 		} else {
-
-			values := metadata.queryValues(parts[0], parts[1])
+			values := metadata.queryValues(key, value)
 			perfectMatch := false
 			for _, v := range values {
-				if parts[1] == v {
+				if value == v {
 					// don't suggest if perfect match
-					tokenPrefix = strings.Join(parts, ":")
+					tokenPrefix = key + ":" + value
 					perfectMatch = true
 					break
 				}
@@ -316,26 +360,13 @@ func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
 			if len(values) > 0 && !perfectMatch {
 				tokenPrefix = tokenPrefix + ":"
 				addWhiteSpace = false
-			} else {
-
 			}
 		}
-		qs.Prefix = strings.Join(append(normalizedTokens, tokenPrefix), " ")
+		qs.Prefix = prefix + tokenPrefix
 		if addWhiteSpace {
 			qs.Prefix += " "
 		}
 	}
-	if qs.Prefix == "" {
-		if len(tokens) < 2 {
-			if len(qs.Suggestions) > 0 {
-				qs.Prefix = ""
-			} else {
-				qs.Prefix = query
-			}
-		} else {
-			qs.Prefix = strings.Join(normalizedTokens, " ")
-		}
-	}
 
 	if len(qs.Suggestions) == 0 {
 		qs.Prefix = query
@@ -345,20 +376,13 @@ func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
 	}
 
 	// remove suggestions that are already in query
-	for i, v := range qs.Suggestions {
-		// doesn't seem to actually remove the items bc of prefixing vs value.
-		if lastToken == v.Value {
-			if i == 0 {
-				qs.Suggestions = qs.Suggestions[i+1:]
-			} else if i == len(qs.Suggestions)-1 {
-				qs.Suggestions = qs.Suggestions[:i]
-			} else {
-				qs.Suggestions = append(qs.Suggestions[:i], qs.Suggestions[i+1:]...)
-			}
-			// item removed from array, compensate index
-			i -= 1
+	suggestions := make([]Suggestion, 0, len(qs.Suggestions))
+	for _, v := range qs.Suggestions {
+		if v.Value != lastToken.String() {
+			suggestions = append(suggestions, v)
 		}
 	}
+	qs.Suggestions = suggestions
 
 	if len(qs.Suggestions) > MaxSuggestions {
 		qs.Suggestions = qs.Suggestions[:MaxSuggestions]
@@ -368,7 +392,7 @@ func suggest(query string, metadata metadataQuerier) *QuerySuggestions {
 
 func suggestEmpty(metadata metadataQuerier) []Suggestion {
 
-	keys := []string{"name", "description", "content", "date"}
+	keys := []string{"name", "description", "content", "date", "added", "updated"}
 	results := metadata.queryKeys("", "", ":")
 
 	suggestions := make([]Suggestion, 0, len(keys)+len(results))
@@ -392,144 +416,6 @@ const (
 	valueMatchStatusOk
 )
 
-func suggestDate(token string) []string {
-	suggestions := []string{}
-	keys := []string{"today", "yesterday", "week", "month", "year"}
-
-	now := time.Now().UTC()
-
-	dateCandidates := []string{
-		now.Format("2006"),
-		now.Format("2006-1"),
-		now.Format("2006-1-2"),
-		now.AddDate(-2, 0, 0).Format("2006") + "|",
-		now.AddDate(-2, 0, 0).Format("2006") + "|today",
-		now.AddDate(-5, 0, 0).Format("2006") + "|" + now.AddDate(-1, 0, 0).Format("2006"),
-	}
-
-	if token == "" {
-		return append(keys, dateCandidates...)
-	}
-
-	for _, v := range keys {
-		if strings.Contains(v, token) {
-			suggestions = append(suggestions, v)
-		}
-	}
-
-	return suggestions
-}
-
-// matchDate tries to validate and autocomplete date filter
-func matchDate(token string) (valueMatchStatus, string, time.Time, time.Time) {
-	if token == "" {
-		return valueMatchStatusIncomplete, "", time.Time{}, time.Time{}
-	}
-	if token == "today" {
-		return valueMatchStatusOk, "today", models.MidnightForDate(time.Now()), models.MidnightForDate(time.Now().AddDate(0, 0, 1))
-	} else if token == "yesterday" {
-		return valueMatchStatusOk, "yesterday", models.MidnightForDate(time.Now().AddDate(0, 0, -1)), models.MidnightForDate(time.Now())
-	} else if token == "week" {
-		return valueMatchStatusOk, "week", models.MidnightForDate(time.Now().AddDate(0, 0, -7)), models.MidnightForDate(time.Now())
-	} else if token == "month" {
-		return valueMatchStatusOk, "month", models.MidnightForDate(time.Now().AddDate(0, -1, 0)), models.MidnightForDate(time.Now())
-	} else if token == "year" {
-		return valueMatchStatusOk, "year", models.MidnightForDate(time.Now().AddDate(-1, 0, 0)), models.MidnightForDate(time.Now())
-	}
-
-	separator := "|"
-	splits := strings.Split(token, separator)
-	if len(splits) == 1 {
-		t := parseDateFromLayout(token, false, false)
-		if !t.IsZero() {
-			endDate := parseDateFromLayout(token, true, false)
-			return valueMatchStatusOk, token, t, endDate
-		}
-	}
-
-	if len(splits) == 2 {
-		startD := parseDateFromLayout(splits[0], false, false)
-
-		if splits[1] == "" {
-			splits[1] = "today"
-		}
-
-		stopD := parseDateFromLayout(splits[1], true, false)
-		if startD.IsZero() || stopD.IsZero() {
-			return valueMatchStatusInvalid, token, startD, stopD
-		}
-		return valueMatchStatusOk, token, startD, stopD
-	}
-
-	layouts := []string{
-		//"1/2/2006",
-		//"2.1.2006",
-		"2006-1-2",
-	}
-
-	for _, layout := range layouts {
-		t, err := time.Parse(layout, token)
-		if err == nil {
-			return valueMatchStatusOk, token, t.UTC(), t.UTC()
-		}
-	}
-
-	return valueMatchStatusInvalid, "", time.Time{}, time.Time{}
-}
-
-var regexYear = regexp.MustCompile(`^(\d{4})$`)
-var regexYearMonth = regexp.MustCompile(`^(\d{4}-\d{1,2})$`)
-var regexDate = regexp.MustCompile(`^(\d{4}-\d{1,2}-\d{1,2})$`)
-
-func parseDateFromLayout(token string, addDigit bool, removeDigit bool) time.Time {
-
-	if token == "today" {
-		return models.MidnightForDate(time.Now())
-	} else if token == "yesterday" {
-		return models.MidnightForDate(time.Now().AddDate(0, 0, -1))
-	} else if token == "week" {
-		return models.MidnightForDate(time.Now().AddDate(0, 0, 7))
-	} else if token == "month" {
-		return models.MidnightForDate(time.Now().AddDate(0, 1, 0))
-	} else if token == "year" {
-		return models.MidnightForDate(time.Now().AddDate(1, 0, 0))
-	}
-
-	t, err := time.Parse("2006", "2006")
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	fmt.Println(t.Format("2006-01-02"))
-	layout := ""
-	addYears := 0
-	addMonths := 0
-	addDays := 0
-	if regexYear.MatchString(token) {
-		addYears = 1
-		layout = "2006"
-	} else if regexYearMonth.MatchString(token) {
-		addMonths = 1
-		layout = "2006-1"
-	} else if regexDate.MatchString(token) {
-		addDays = 1
-		layout = "2006-1-2"
-	}
-
-	if layout != "" {
-		date, err := time.Parse(layout, token)
-		if err == nil {
-			if addDigit {
-				date = date.AddDate(addYears, addMonths, addDays)
-			}
-			if removeDigit {
-				date = date.AddDate(-addYears, -addMonths, -addDays)
-			}
-			return models.MidnightForDate(date)
-		}
-	}
-	return time.Time{}
-}
-
 type metadataQuerier interface {
 	queryKeys(key string, prefis string, suffix string) []string
 	queryValues(key, value string) []string
@@ -59,7 +59,7 @@ func Test_matchDate(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, got2, got3 := matchDate(tt.args.token)
+			got, got1, got2, got3 := matchDate(tt.args.token, testNow)
 			if got != tt.want {
 				t.Errorf("matchDate() got = %v, want %v", got, tt.want)
 			}
@@ -177,6 +177,7 @@ func Test_suggest(t *testing.T) {
 			args: args{"one da"},
 			want: &QuerySuggestions{Suggestions: []Suggestion{
 				{Value: "date:", Type: "key", Hint: ""},
+				{Value: "updated:", Type: "key", Hint: ""},
 				{Value: "datasource:", Type: "metadata", Hint: ""},
 			}, Prefix: "one ", ValidQuery: false},
 		},
@@ -249,15 +250,23 @@ func Test_suggest(t *testing.T) {
 			want: &QuerySuggestions{Suggestions: []Suggestion{
 				{Value: "today", Type: "key", Hint: ""},
 				{Value: "yesterday", Type: "key", Hint: ""},
+				{Value: "this-week", Type: "key", Hint: ""},
+				{Value: "last-week", Type: "key", Hint: ""},
+				{Value: "this-month", Type: "key", Hint: ""},
+				{Value: "last-month", Type: "key", Hint: ""},
+				{Value: "this-year", Type: "key", Hint: ""},
+				{Value: "last-year", Type: "key", Hint: ""},
 				{Value: "week", Type: "key", Hint: ""},
 				{Value: "month", Type: "key", Hint: ""},
 				{Value: "year", Type: "key", Hint: ""},
-				{Value: time.Now().Format("2006"), Type: "key", Hint: ""},
-				{Value: time.Now().Format("2006-1"), Type: "key", Hint: ""},
-				{Value: time.Now().Format("2006-1-2"), Type: "key", Hint: ""},
-				{Value: time.Now().AddDate(-2, 0, 0).Format("2006") + "|", Type: "key", Hint: ""},
-				{Value: time.Now().AddDate(-2, 0, 0).Format("2006") + "|today", Type: "key", Hint: ""},
-				{Value: time.Now().AddDate(-5, 0, 0).Format("2006") + "|" + time.Now().AddDate(-1, 0, 0).Format("2006"), Type: "key", Hint: ""},
+				{Value: "2023", Type: "key", Hint: ""},
+				{Value: "2023-6", Type: "key", Hint: ""},
+				{Value: "2023-6-14", Type: "key", Hint: ""},
+				{Value: "2021..", Type: "key", Hint: ""},
+				{Value: "2021..today", Type: "key", Hint: ""},
+				{Value: "2018..2022", Type: "key", Hint: ""},
+				{Value: ">2022-12", Type: "key", Hint: ""},
+				{Value: "<2023", Type: "key", Hint: ""},
 			}, Prefix: "one date:", ValidQuery: false},
 		},
 		{
@@ -267,6 +276,22 @@ func Test_suggest(t *testing.T) {
 				{Value: "today", Type: "key", Hint: ""},
 			}, Prefix: "one date:", ValidQuery: false},
 		},
+		{
+			name: "autocomplete relative date",
+			args: args{"one date:last-m"},
+			want: &QuerySuggestions{Suggestions: []Suggestion{
+				{Value: "last-month", Type: "key", Hint: ""},
+			}, Prefix: "one date:", ValidQuery: false},
+		},
+		{
+			name: "autocomplete end of date range",
+			args: args{"one added:2021..this"},
+			want: &QuerySuggestions{Suggestions: []Suggestion{
+				{Value: "2021..this-week", Type: "key", Hint: ""},
+				{Value: "2021..this-month", Type: "key", Hint: ""},
+				{Value: "2021..this-year", Type: "key", Hint: ""},
+			}, Prefix: "one added:", ValidQuery: false},
+		},
 		{
 			name: "don't autocomplete date",
 			args: args{"one date:an"},
@@ -313,7 +338,7 @@ func Test_suggest(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := suggest(tt.args.query, metadata)
+			got := suggest(tt.args.query, metadata, testNow)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("suggest() = %v, want %v", got, tt.want)
 			}
@@ -0,0 +1,142 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+var apiTokenColumns = []string{
+	"id", "user_id", "name", "prefix", "token_hash", "scopes", "expires_at", "last_used_at", "last_used_ip",
+	"created_at", "updated_at",
+}
+
+func (s *AuthStore) AddApiToken(token *models.ApiToken) error {
+	query := s.sq.Insert("api_tokens").
+		Columns("user_id", "name", "prefix", "token_hash", "scopes", "expires_at", "created_at", "updated_at").
+		Values(token.UserId, token.Name, token.Prefix, token.Hash, token.Scopes, token.ExpiresAt,
+			token.CreatedAt, token.UpdatedAt).
+		Suffix("RETURNING id")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+	err = s.db.Get(&token.Id, sql, args...)
+	return s.parseError(err, "add api token")
+}
+
+// GetApiTokens returns user's personal access tokens, newest first.
+func (s *AuthStore) GetApiTokens(userId int) ([]models.ApiToken, error) {
+	query := s.sq.Select(apiTokenColumns...).
+		From("api_tokens").
+		Where("user_id = ?", userId).
+		OrderBy("created_at DESC")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+	tokens := []models.ApiToken{}
+	err = s.db.Select(&tokens, sql, args...)
+	return tokens, s.parseError(err, "get api tokens")
+}
+
+// apiTokenUseInterval is how often use of a token is recorded, unless it is used from another address.
+const apiTokenUseInterval = time.Minute
+
+// UseApiToken returns the token and records it as used from ip address. Use is recorded at most once
+// in apiTokenUseInterval for each address.
+func (s *AuthStore) UseApiToken(token string, ipAddr string) (*models.ApiToken, error) {
+	hash := models.HashApiToken(token)
+	cacheKey := fmt.Sprintf("api-token-%s", hash)
+	var apiToken *models.ApiToken
+	if cached, found := s.cache.Get(cacheKey); found {
+		apiToken, _ = cached.(*models.ApiToken)
+	}
+
+	if apiToken == nil {
+		query := s.sq.Select(apiTokenColumns...).
+			From("api_tokens").
+			Where("token_hash = ?", hash)
+		sql, args, err := query.ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("build sql: %v", err)
+		}
+		apiToken = &models.ApiToken{}
+		err = s.db.Get(apiToken, sql, args...)
+		if err != nil {
+			return nil, s.parseError(err, "get api token")
+		}
+		s.cache.Set(cacheKey, apiToken, cache.DefaultExpiration)
+	}
+
+	now := time.Now()
+	if apiToken.LastUsedAt != nil && apiToken.LastUsedIp == ipAddr && now.Sub(*apiToken.LastUsedAt) < apiTokenUseInterval {
+		return apiToken, nil
+	}
+
+	// cached token is shared between requests, update a copy
+	used := *apiToken
+	used.LastUsedAt = &now
+	used.LastUsedIp = ipAddr
+	update := s.sq.Update("api_tokens").
+		Set("last_used_at", now).
+		Set("last_used_ip", ipAddr).
+		Where("id = ?", used.Id)
+	sql, args, err := update.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+	_, err = s.db.Exec(sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "update api token last used")
+	}
+	s.cache.Set(cacheKey, &used, cache.DefaultExpiration)
+	return &used, nil
+}
+
+// DeleteApiToken revokes user's token.
+func (s *AuthStore) DeleteApiToken(userId, tokenId int) error {
+	hash := ""
+	err := s.db.Get(&hash, "DELETE FROM api_tokens WHERE user_id = $1 AND id = $2 RETURNING token_hash",
+		userId, tokenId)
+	if err != nil {
+		return s.parseError(err, "delete api token")
+	}
+	s.cache.Delete(fmt.Sprintf("api-token-%s", hash))
+	return nil
+}
+
+// DeleteUserApiTokens revokes all tokens of the user and returns the number of tokens revoked.
+func (s *AuthStore) DeleteUserApiTokens(userId int) (int, error) {
+	hashes := []string{}
+	err := s.db.Select(&hashes, "DELETE FROM api_tokens WHERE user_id = $1 RETURNING token_hash", userId)
+	if err != nil {
+		return 0, s.parseError(err, "delete user api tokens")
+	}
+	for _, v := range hashes {
+		s.cache.Delete(fmt.Sprintf("api-token-%s", v))
+	}
+	return len(hashes), nil
+}
@@ -0,0 +1,52 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAuthStore_UseApiToken(t *testing.T) {
+	db, mock, err := NewMockDatabase(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT (.+) FROM api_tokens WHERE token_hash = \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "last_used_ip"}).AddRow(1, 2, ""))
+	mock.ExpectExec("UPDATE api_tokens SET last_used_at = \\$1, last_used_ip = \\$2 WHERE id = \\$3").
+		WithArgs(sqlmock.AnyArg(), "10.0.0.1", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	// use from another address is recorded even if token is cached
+	mock.ExpectExec("UPDATE api_tokens SET last_used_at = \\$1, last_used_ip = \\$2 WHERE id = \\$3").
+		WithArgs(sqlmock.AnyArg(), "10.0.0.2", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		token, err := db.AuthStore.UseApiToken("token", ip)
+		if err != nil {
+			t.Fatalf("UseApiToken() error = %v", err)
+		}
+		if token.LastUsedIp != ip || token.LastUsedAt == nil {
+			t.Errorf("UseApiToken() last used = %v, %s, want %s", token.LastUsedAt, token.LastUsedIp, ip)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
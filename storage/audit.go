@@ -0,0 +1,147 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+type AuditStore struct {
+	*resource
+	sq squirrel.StatementBuilderType
+}
+
+func newAuditStore(db *sqlx.DB) *AuditStore {
+	return &AuditStore{
+		resource: &resource{name: "Audit log", db: db},
+		sq:       squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+var auditColumns = []string{
+	"id", "created_at", "actor_id", "actor_name", "action", "resource", "resource_id", "success", "ip_address",
+	"request_id", "old_value", "new_value", "message",
+}
+
+// AuditFilter filters audit events. Empty fields are not filtered.
+type AuditFilter struct {
+	ActorId    int
+	Action     string
+	Resource   string
+	ResourceId string
+	Success    *bool
+	IpAddr     string
+	RequestId  string
+	Since      time.Time
+	Until      time.Time
+}
+
+func (f *AuditFilter) apply(query squirrel.SelectBuilder) squirrel.SelectBuilder {
+	if f.ActorId != 0 {
+		query = query.Where("actor_id = ?", f.ActorId)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.Resource != "" {
+		query = query.Where("resource = ?", f.Resource)
+	}
+	if f.ResourceId != "" {
+		query = query.Where("resource_id = ?", f.ResourceId)
+	}
+	if f.Success != nil {
+		query = query.Where("success = ?", *f.Success)
+	}
+	if f.IpAddr != "" {
+		query = query.Where("ip_address = ?", f.IpAddr)
+	}
+	if f.RequestId != "" {
+		query = query.Where("request_id = ?", f.RequestId)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("created_at < ?", f.Until)
+	}
+	return query
+}
+
+// AddEvent adds event to the audit log.
+func (s *AuditStore) AddEvent(event *models.AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	query := s.sq.Insert("audit_log").
+		Columns(auditColumns[1:]...).
+		Values(event.CreatedAt, event.ActorId, event.ActorName, event.Action, event.Resource, event.ResourceId,
+			event.Success, event.IpAddr, event.RequestId, event.OldValue, event.NewValue, event.Message).
+		Suffix("RETURNING id")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+	err = s.db.Get(&event.Id, sql, args...)
+	return s.parseError(err, "add audit event")
+}
+
+// GetEvents returns audit events matching the filter, latest first, and the total number of matching events.
+func (s *AuditStore) GetEvents(filter AuditFilter, paging Paging) ([]models.AuditEvent, int, error) {
+	query := filter.apply(s.sq.Select(auditColumns...).From("audit_log")).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(uint64(paging.Offset)).
+		Limit(uint64(paging.Limit))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("build sql: %v", err)
+	}
+	events := []models.AuditEvent{}
+	err = s.db.Select(&events, sql, args...)
+	if err != nil {
+		return nil, 0, s.parseError(err, "get audit events")
+	}
+
+	sql, args, err = filter.apply(s.sq.Select("count(id)").From("audit_log")).ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("build sql: %v", err)
+	}
+	total := 0
+	err = s.db.Get(&total, sql, args...)
+	if err != nil {
+		return nil, 0, s.parseError(err, "count audit events")
+	}
+	return events, total, nil
+}
+
+// DeleteEventsBefore removes events older than given time and returns the number of deleted events.
+func (s *AuditStore) DeleteEventsBefore(t time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM audit_log WHERE created_at < $1`, t)
+	if err != nil {
+		return 0, s.parseError(err, "delete audit events")
+	}
+	affected, err := res.RowsAffected()
+	return int(affected), s.parseError(err, "delete audit events")
+}
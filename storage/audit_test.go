@@ -0,0 +1,72 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+func TestAuditFilter_apply(t *testing.T) {
+	success := false
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		wantSql  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "empty",
+			filter:   AuditFilter{},
+			wantSql:  "SELECT id FROM audit_log",
+			wantArgs: nil,
+		},
+		{
+			name:     "actor and action",
+			filter:   AuditFilter{ActorId: 2, Action: "auth.login"},
+			wantSql:  "SELECT id FROM audit_log WHERE actor_id = $1 AND action = $2",
+			wantArgs: []interface{}{2, "auth.login"},
+		},
+		{
+			name:     "failed since",
+			filter:   AuditFilter{Success: &success, Since: since},
+			wantSql:  "SELECT id FROM audit_log WHERE success = $1 AND created_at >= $2",
+			wantArgs: []interface{}{false, since},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar).Select("id").From("audit_log")
+			sql, args, err := tt.filter.apply(query).ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.wantSql {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSql)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -61,10 +61,13 @@ func (s *AuthStore) setTokenCache(token *models.Token) {
 	s.cache.Set(fmt.Sprintf("token-%s", token.Key), token, cache.DefaultExpiration)
 }
 
+var tokenColumns = []string{"id", "user_id", "key", "name", "ip_address", "created_at", "updated_at", "expires_at", "last_seen", "last_confirmed", "impersonator_id"}
+
 func (s *AuthStore) InsertToken(token *models.Token) error {
 	builder := s.sq.Insert("auth_tokens").
-		Columns("user_id", "key", "name", "expires_at", "last_seen", "ip_address", "last_confirmed").
-		Values(token.UserId, token.Key, token.Name, token.ExpiresAt, token.LastSeen, token.IpAddr, token.LastConfirmed).
+		Columns("user_id", "key", "name", "expires_at", "last_seen", "ip_address", "last_confirmed", "impersonator_id").
+		Values(token.UserId, token.Key, token.Name, token.ExpiresAt, token.LastSeen, token.IpAddr, token.LastConfirmed,
+			token.ImpersonatorId).
 		Suffix("RETURNING id")
 
 	sql, args, err := builder.ToSql()
@@ -87,7 +90,7 @@ func (s *AuthStore) GetToken(key string, updateLastSeen bool) (*models.Token, er
 		return cached, nil
 	}
 
-	builder := s.sq.Select("id", "user_id", "key", "name", "created_at", "updated_at", "expires_at", "last_seen", "last_confirmed").
+	builder := s.sq.Select(tokenColumns...).
 		From("auth_tokens").
 		Where("key=?", key)
 
@@ -146,6 +149,58 @@ func (s *AuthStore) RevokeToken(key string) error {
 	return s.parseError(err, "delete token")
 }
 
+// GetUserTokens returns all login sessions of the user, most recently seen first.
+func (s *AuthStore) GetUserTokens(userId int) ([]models.Token, error) {
+	builder := s.sq.Select(tokenColumns...).
+		From("auth_tokens").
+		Where("user_id = ?", userId).
+		OrderBy("last_seen DESC", "id DESC")
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	tokens := []models.Token{}
+	err = s.db.Select(&tokens, sql, args...)
+	return tokens, s.parseError(err, "get user tokens")
+}
+
+// RevokeUserToken revokes user's login session by its id.
+func (s *AuthStore) RevokeUserToken(userId int, tokenId int) error {
+	sql := `DELETE FROM auth_tokens WHERE user_id = $1 AND id = $2 RETURNING key`
+	key := ""
+	err := s.db.Get(&key, sql, userId, tokenId)
+	if err != nil {
+		return s.parseError(err, "revoke user token")
+	}
+	s.deleteTokenFromCache(key)
+	return nil
+}
+
+// RevokeUserTokens revokes all login sessions of the user, except the session with exceptKey, if not empty.
+// It returns the number of sessions revoked.
+func (s *AuthStore) RevokeUserTokens(userId int, exceptKey string) (int, error) {
+	builder := s.sq.Delete("auth_tokens").Where("user_id = ?", userId).Suffix("RETURNING key")
+	if exceptKey != "" {
+		builder = builder.Where("key != ?", exceptKey)
+	}
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("build sql: %v", err)
+	}
+
+	keys := []string{}
+	err = s.db.Select(&keys, sql, args...)
+	if err != nil {
+		return 0, s.parseError(err, "revoke user tokens")
+	}
+	for _, v := range keys {
+		s.deleteTokenFromCache(v)
+	}
+	return len(keys), nil
+}
+
 func (s *AuthStore) DeleteExpiredAuthTokens() (int, error) {
 	// expires_at must be non-zero value and expired
 	sql := `DELETE FROM auth_tokens WHERE expires_at < now() AND EXTRACT(EPOCH from expires_at) > 1`
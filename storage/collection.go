@@ -0,0 +1,150 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// CollectionStore is storage for user's document collections.
+type CollectionStore struct {
+	*resource
+	sq squirrel.StatementBuilderType
+}
+
+func newCollectionStore(db *sqlx.DB) *CollectionStore {
+	return &CollectionStore{
+		resource: &resource{name: "Collection", db: db},
+		sq:       squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (s *CollectionStore) collectionsQuery(userId int) squirrel.SelectBuilder {
+	return s.sq.Select("c.id", "c.user_id", "c.name", "c.created_at", "c.updated_at",
+		"count(d.id) AS document_count").
+		From("collections c").
+		LeftJoin("collection_documents cd ON cd.collection_id = c.id").
+		LeftJoin("documents d ON d.id = cd.document_id AND d.deleted_at IS NULL").
+		Where("c.user_id = ?", userId).
+		GroupBy("c.id")
+}
+
+// GetCollections returns user's collections ordered by name.
+func (s *CollectionStore) GetCollections(userId int, paging Paging) ([]models.Collection, int, error) {
+	query := s.collectionsQuery(userId).
+		OrderBy("lower(c.name) ASC").
+		Offset(uint64(paging.Offset)).
+		Limit(uint64(paging.Limit))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("build sql: %v", err)
+	}
+
+	collections := []models.Collection{}
+	err = s.db.Select(&collections, sql, args...)
+	if err != nil {
+		return nil, 0, s.parseError(err, "get collections")
+	}
+
+	total := 0
+	err = s.db.Get(&total, "SELECT count(id) FROM collections WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, 0, s.parseError(err, "count collections")
+	}
+	return collections, total, nil
+}
+
+func (s *CollectionStore) GetCollection(userId, id int) (*models.Collection, error) {
+	sql, args, err := s.collectionsQuery(userId).Where("c.id = ?", id).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	collection := &models.Collection{}
+	err = s.db.Get(collection, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get collection")
+	}
+	return collection, nil
+}
+
+// GetCollectionDocumentIds returns ids of the documents in the collection, excluding trashed documents.
+func (s *CollectionStore) GetCollectionDocumentIds(userId, id int) ([]string, error) {
+	sql := `
+SELECT cd.document_id
+FROM collection_documents cd
+JOIN collections c ON c.id = cd.collection_id
+JOIN documents d ON d.id = cd.document_id
+WHERE c.user_id = $1
+AND c.id = $2
+AND d.deleted_at IS NULL
+ORDER BY cd.created_at DESC
+`
+	ids := []string{}
+	err := s.db.Select(&ids, sql, userId, id)
+	return ids, s.parseError(err, "get collection documents")
+}
+
+// AddDocumentToCollection adds document to user's collection with given name.
+// Collection is created if it does not exist.
+func (s *CollectionStore) AddDocumentToCollection(userId int, name string, documentId string) error {
+	tx, err := s.beginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	now := time.Now()
+	id := 0
+	sql := `
+INSERT INTO collections (user_id, name, created_at, updated_at)
+VALUES ($1, $2, $3, $3)
+ON CONFLICT (user_id, name) DO
+UPDATE SET updated_at = $3
+RETURNING id`
+	err = tx.tx.Get(&id, sql, userId, name, now)
+	if err != nil {
+		return s.parseError(err, "get or create collection")
+	}
+
+	sql = `
+INSERT INTO collection_documents (collection_id, document_id, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING`
+	_, err = tx.tx.Exec(sql, id, documentId, now)
+	if err != nil {
+		return s.parseError(err, "add document to collection")
+	}
+	tx.ok = true
+	return nil
+}
+
+func (s *CollectionStore) DeleteCollection(userId, id int) error {
+	res, err := s.db.Exec("DELETE FROM collections WHERE user_id = $1 AND id = $2", userId, id)
+	if err != nil {
+		return s.parseError(err, "delete collection")
+	}
+	return s.requireRowsAffected(res.RowsAffected())
+}
@@ -14,13 +14,16 @@ import (
 type Database struct {
 	conn *sqlx.DB
 
-	UserStore     *UserStore
-	DocumentStore *DocumentStore
-	JobStore      *JobStore
-	MetadataStore *MetadataStore
-	StatsStore    *StatsStore
-	RuleStore     *RuleStore
-	AuthStore     *AuthStore
+	UserStore       *UserStore
+	DocumentStore   *DocumentStore
+	JobStore        *JobStore
+	MetadataStore   *MetadataStore
+	StatsStore      *StatsStore
+	RuleStore       *RuleStore
+	AuthStore       *AuthStore
+	SearchStore     *SavedSearchStore
+	AuditStore      *AuditStore
+	CollectionStore *CollectionStore
 }
 
 // NewDatabase returns working instance of database connection.
@@ -47,6 +50,9 @@ func NewDatabase(conf config.Database) (*Database, error) {
 	db.StatsStore = NewStatsStore(db.conn)
 	db.RuleStore = newRuleStore(db.conn, db.MetadataStore)
 	db.AuthStore = newAuthStore(db.conn)
+	db.SearchStore = newSavedSearchStore(db.conn)
+	db.AuditStore = newAuditStore(db.conn)
+	db.CollectionStore = newCollectionStore(db.conn)
 	return db, nil
 }
 
@@ -69,6 +75,9 @@ func NewMockDatabase(matcher sqlmock.QueryMatcher) (*Database, sqlmock.Sqlmock,
 	db.JobStore = newJobStore(db.conn)
 	db.StatsStore = &StatsStore{db: db.conn}
 	db.AuthStore = newAuthStore(db.conn)
+	db.SearchStore = newSavedSearchStore(db.conn)
+	db.AuditStore = newAuditStore(db.conn)
+	db.CollectionStore = newCollectionStore(db.conn)
 
 	return db, mock, nil
 }
@@ -19,6 +19,7 @@
 package storage
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -53,7 +54,7 @@ func (s DocumentStore) parseError(err error, action string) error {
 	return getDatabaseError(err, s, action)
 }
 
-// GetDocuments returns user's documents according to paging. In addition, return total count of documents available.
+// GetDocuments returns user's documents, including documents shared with the user, according to paging. In addition, return total count of documents available.
 func (s *DocumentStore) GetDocuments(userId int, paging Paging, sort SortKey, limitContent bool) (*[]models.Document, int, error) {
 	sort.SetDefaults("date", false)
 
@@ -65,10 +66,11 @@ func (s *DocumentStore) GetDocuments(userId int, paging Paging, sort SortKey, li
 	}
 
 	sql := `
-SELECT id, name, ` + contenSelect + `, filename, created_at, updated_at
+SELECT id, user_id, name, ` + contenSelect + `, filename, created_at, updated_at
 hash, mimetype, size, date, description
-FROM documents
-WHERE user_id = $1
+FROM documents d
+WHERE ` + accessibleDocuments("d", "$1") + `
+AND deleted_at IS NULL
 ORDER BY ` + sort.QueryKey() + " " + sort.SortOrder() + `
 OFFSET $2
 LIMIT $3;
@@ -90,8 +92,9 @@ LIMIT $3;
 
 	sql = `
 SELECT count(id) 
-FROM documents
-WHERE user_id = $1
+FROM documents d
+WHERE ` + accessibleDocuments("d", "$1") + `
+AND deleted_at IS NULL
 `
 	var count int
 	err = s.db.Get(&count, sql, userId)
@@ -99,6 +102,30 @@ WHERE user_id = $1
 	return dest, count, err
 }
 
+// GetTrashedDocuments returns user's own documents that are in trash, latest trashed first,
+// and total count of trashed documents.
+func (s *DocumentStore) GetTrashedDocuments(userId int, paging Paging) (*[]models.Document, int, error) {
+	sql := `
+SELECT id, user_id, name, LEFT(content, 500) AS content, filename, created_at, updated_at,
+hash, mimetype, size, date, description, deleted_at
+FROM documents
+WHERE user_id = $1
+AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+OFFSET $2
+LIMIT $3;
+`
+	dest := &[]models.Document{}
+	err := s.db.Select(dest, sql, userId, paging.Offset, paging.Limit)
+	if err != nil {
+		return dest, 0, s.parseError(err, "get trashed documents")
+	}
+
+	var count int
+	err = s.db.Get(&count, "SELECT count(id) FROM documents WHERE user_id = $1 AND deleted_at IS NOT NULL", userId)
+	return dest, count, s.parseError(err, "get trashed documents")
+}
+
 // GetDocument returns document by its id. If userId != 0, user must be owner of the document.
 func (s *DocumentStore) GetDocument(userId int, id string) (*models.Document, error) {
 	sql := `
@@ -117,29 +144,8 @@ WHERE id = $1
 	return dest, s.parseError(err, "get document")
 }
 
-// UserOwnsDocumet returns true if user has ownership for document.
-func (s *DocumentStore) UserOwnsDocument(documentId string, userId int) (bool, error) {
-
-	sql := `
-select case when exists
-    (
-        select id
-        from documents
-        where id = $1
-        and user_id = $2
-    )
-    then true
-    else false
-end;
-`
-
-	var ownership bool
-
-	err := s.db.Get(&ownership, sql, documentId, userId)
-	return ownership, s.parseError(err, "check ownership")
-
-}
-
+// UserOwnsDocuments returns true if user owns all the documents. Sharing documents does not grant ownership,
+// use GetDocumentPermission to check access to shared documents.
 func (s *DocumentStore) UserOwnsDocuments(userId int, documents []string) (bool, error) {
 	sql := `SELECT count(distinct(id)) FROM documents
 	WHERE user_id=$1 AND id IN (
@@ -165,7 +171,7 @@ func (s *DocumentStore) UserOwnsDocuments(userId int, documents []string) (bool,
 	return documentCount == len(documents), s.parseError(err, "check user owns documents")
 }
 
-// GetByHash returns a document by its hash.
+// GetByHash returns a document by its hash. Documents in trash are not returned.
 // If userId != 0, user has to be the owner of the document.
 func (s *DocumentStore) GetByHash(userId int, hash string) (*models.Document, error) {
 
@@ -173,6 +179,7 @@ func (s *DocumentStore) GetByHash(userId int, hash string) (*models.Document, er
 	SELECT *
 	FROM documents
 	WHERE hash = $1
+	AND deleted_at IS NULL
 `
 	args := []interface{}{hash}
 	if userId != 0 {
@@ -181,7 +188,7 @@ func (s *DocumentStore) GetByHash(userId int, hash string) (*models.Document, er
 	}
 
 	object := &models.Document{}
-	err := s.db.Get(object, sql, hash)
+	err := s.db.Get(object, sql, args...)
 	if err != nil {
 		e := s.parseError(err, "get by hash")
 		if errors.Is(e, errors.ErrRecordNotFound) {
@@ -222,18 +229,18 @@ func addDocumentHistoryAction(db *sqlx.DB, queryBuilder squirrel.StatementBuilde
 		return nil
 	}
 
-	query := queryBuilder.Insert("document_history").Columns("document_id", "action", "old_value", "new_value")
+	query := queryBuilder.Insert("document_history").Columns("document_id", "action", "old_value", "new_value", "rule_id", "rule_version")
 	if userId != UserIdInternal {
 		query = query.Columns("user_id")
 	}
 
 	if userId != UserIdInternal {
 		for _, v := range items {
-			query = query.Values(v.DocumentId, v.Action, v.OldValue, v.NewValue, userId)
+			query = query.Values(v.DocumentId, v.Action, v.OldValue, v.NewValue, v.RuleId, v.RuleVersion, userId)
 		}
 	} else {
 		for _, v := range items {
-			query = query.Values(v.DocumentId, v.Action, v.OldValue, v.NewValue)
+			query = query.Values(v.DocumentId, v.Action, v.OldValue, v.NewValue, v.RuleId, v.RuleVersion)
 		}
 	}
 
@@ -273,7 +280,7 @@ WHERE id=$1
 	if userId != 0 {
 		err = s.db.Get(&content, sql, id, userId)
 	} else {
-		err = s.db.Get(&content, sql, id, userId)
+		err = s.db.Get(&content, sql, id)
 	}
 	return &content, s.parseError(err, "get content")
 }
@@ -318,6 +325,12 @@ WHERE id IN ($3);
 
 // Update sets complete document record, not just changed attributes. Thus document must be read before updating.
 func (s *DocumentStore) Update(userId int, doc *models.Document) error {
+	return s.UpdateByRules(userId, doc, nil)
+}
+
+// UpdateByRules updates document like Update. Rules maps history actions (e.g. 'rename')
+// to the processing rule that made the change, and history items are stamped with the rule version.
+func (s *DocumentStore) UpdateByRules(userId int, doc *models.Document, rules map[string]*models.Rule) error {
 
 	// TODO: metadata diff is not saved, bc the document metadata is saved separately
 	oldDoc, err := s.GetDocument(0, doc.Id)
@@ -328,12 +341,12 @@ func (s *DocumentStore) Update(userId int, doc *models.Document) error {
 	sql := `
 UPDATE documents SET 
 name=$2, content=$3, filename=$4, hash=$5, mimetype=$6, size=$7, date=$8,
-updated_at=$9, description=$10
+updated_at=$9, description=$10, deleted_at=$11
 WHERE id=$1
 `
 
 	_, err = s.db.Exec(sql, doc.Id, doc.Name, doc.Content, doc.Filename, doc.Hash, doc.Mimetype, doc.Size,
-		doc.Date, doc.UpdatedAt, doc.Description)
+		doc.Date, doc.UpdatedAt, doc.Description, doc.DeletedAt)
 	if err != nil {
 		return s.parseError(err, "update")
 	}
@@ -342,6 +355,12 @@ WHERE id=$1
 	if err != nil {
 		return fmt.Errorf("get diff for document: %v", err)
 	}
+	for i, v := range diff {
+		if rule, ok := rules[v.Action]; ok {
+			diff[i].RuleId = models.IntId(rule.Id)
+			diff[i].RuleVersion = models.IntId(rule.Version)
+		}
+	}
 
 	err = addDocumentHistoryAction(s.db, s.sq, diff, userId)
 	logrus.Infof("User %d edited document %s with %d actions", userId, doc.Id, len(diff))
@@ -370,7 +389,9 @@ func (s *DocumentStore) GetDocumentHistory(userId int, docId string) (*[]models.
 		dh.new_value as new_value,
 		dh.created_at as created_at,
 		coalesce(dh.user_id, 0) as user_id,
-		coalesce(u.name, 'Server') as user
+		coalesce(u.name, 'Server') as user,
+		dh.rule_id as rule_id,
+		dh.rule_version as rule_version
 	FROM document_history dh 
 	LEFT JOIN documents d ON dh.document_id=d.id 
 	LEFT JOIN users u ON dh.user_id=u.id
@@ -392,3 +413,82 @@ func (s *DocumentStore) AddVisited(userId int, documentId string) error {
 	_, err = s.db.Exec(sql, args...)
 	return s.parseError(err, "add document_view_history")
 }
+
+// DocumentsState summarizes user's documents. It changes whenever user's documents are added, modified or deleted,
+// or documents are shared with the user.
+type DocumentsState struct {
+	Count     int          `db:"count"`
+	Deleted   int          `db:"deleted"`
+	UpdatedAt sql.NullTime `db:"updated_at"`
+}
+
+// GetDocumentsState returns state of user's documents.
+func (s *DocumentStore) GetDocumentsState(userId int) (DocumentsState, error) {
+	sql := `
+SELECT
+	count(id) AS count,
+	count(id) FILTER (WHERE deleted_at IS NOT NULL) AS deleted,
+	GREATEST(max(updated_at), (SELECT max(updated_at) FROM document_shares WHERE document_id IN ` + sharedDocumentIds("$1") + `)) AS updated_at
+FROM documents d
+WHERE ` + accessibleDocuments("d", "$1") + `;
+`
+	state := DocumentsState{}
+	err := s.db.Get(&state, sql, userId)
+	return state, s.parseError(err, "get documents state")
+}
+
+// GetSearchDocuments returns all user's documents and documents shared with the user that are not deleted,
+// including their content and metadata.
+func (s *DocumentStore) GetSearchDocuments(userId int) (*[]models.Document, error) {
+	sql := `
+SELECT id, user_id, name, description, content, filename, hash, mimetype, size, date,
+created_at, updated_at, deleted_at
+FROM documents d
+WHERE ` + accessibleDocuments("d", "$1") + `
+AND deleted_at IS NULL;
+`
+	docs := &[]models.Document{}
+	err := s.db.Select(docs, sql, userId)
+	if err != nil {
+		return docs, s.parseError(err, "get search documents")
+	}
+
+	// metadata values of shared documents belong to their owners
+	sql = valuePathsCte("ANY(ARRAY(SELECT d.user_id FROM documents d WHERE "+accessibleDocuments("d", "$1")+"))") + `
+SELECT
+	dm.document_id AS document_id,
+	mk.id AS key_id,
+	mk.key AS key,
+	mv.id AS value_id,
+	mv.value AS value,
+	COALESCE(vp.path, mv.value) AS path,
+	mk.value_type AS value_type
+FROM document_metadata dm
+JOIN documents d ON dm.document_id = d.id
+JOIN metadata_keys mk ON dm.key_id = mk.id
+JOIN metadata_values mv ON dm.value_id = mv.id
+LEFT JOIN value_paths vp ON mv.id = vp.id
+WHERE ` + accessibleDocuments("d", "$1") + `
+AND d.deleted_at IS NULL
+ORDER BY key ASC;
+`
+	metadata := &[]struct {
+		DocumentId string `db:"document_id"`
+		models.Metadata
+	}{}
+	err = s.db.Select(metadata, sql, userId)
+	if err != nil {
+		return docs, s.parseError(err, "get search documents metadata")
+	}
+
+	documents := make(map[string]*models.Document, len(*docs))
+	for i := range *docs {
+		documents[(*docs)[i].Id] = &(*docs)[i]
+	}
+	for _, v := range *metadata {
+		if doc, ok := documents[v.DocumentId]; ok {
+			doc.Metadata = append(doc.Metadata, v.Metadata)
+		}
+	}
+	return docs, nil
+}
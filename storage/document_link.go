@@ -0,0 +1,104 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// AddDocumentLink saves a new public link.
+func (s *DocumentStore) AddDocumentLink(link *models.DocumentLink) error {
+	sql := `
+INSERT INTO document_links (document_id, user_id, prefix, token_hash, password_hash, expires_at, max_downloads)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at, updated_at;
+`
+	err := s.db.QueryRowx(sql, link.DocumentId, link.UserId, link.Prefix, link.Hash, link.PasswordHash,
+		link.ExpiresAt, link.MaxDownloads).StructScan(link)
+	return s.parseError(err, "add document link")
+}
+
+// GetDocumentLinks returns all public links of the document.
+func (s *DocumentStore) GetDocumentLinks(docId string) (*[]models.DocumentLink, error) {
+	links := &[]models.DocumentLink{}
+	err := s.db.Select(links, `SELECT * FROM document_links WHERE document_id = $1 ORDER BY created_at DESC`, docId)
+	return links, s.parseError(err, "get document links")
+}
+
+// GetDocumentLinkByToken returns the link that matches the token.
+func (s *DocumentStore) GetDocumentLinkByToken(token string) (*models.DocumentLink, error) {
+	link := &models.DocumentLink{}
+	err := s.db.Get(link, `SELECT * FROM document_links WHERE token_hash = $1`, models.HashApiToken(token))
+	return link, s.parseError(err, "get document link")
+}
+
+// DeleteDocumentLink revokes the link.
+func (s *DocumentStore) DeleteDocumentLink(docId string, linkId int) error {
+	res, err := s.db.Exec(`DELETE FROM document_links WHERE document_id = $1 AND id = $2`, docId, linkId)
+	if err != nil {
+		return s.parseError(err, "delete document link")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return s.parseError(err, "delete document link")
+	}
+	if affected == 0 {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "link not found"
+		return e
+	}
+	return nil
+}
+
+// CountLinkDownload increments the download counter of the link. It returns false if the link has
+// reached its download limit.
+func (s *DocumentStore) CountLinkDownload(linkId int) (bool, error) {
+	res, err := s.db.Exec(`UPDATE document_links SET download_count = download_count + 1
+		WHERE id = $1 AND (max_downloads IS NULL OR download_count < max_downloads)`, linkId)
+	if err != nil {
+		return false, s.parseError(err, "count link download")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, s.parseError(err, "count link download")
+	}
+	return affected == 1, nil
+}
+
+// AddDocumentLinkAccess records an access to the link.
+func (s *DocumentStore) AddDocumentLinkAccess(access *models.DocumentLinkAccess) error {
+	_, err := s.db.Exec(`INSERT INTO document_link_access (link_id, action, success, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5)`, access.LinkId, access.Action, access.Success, access.IpAddr, access.UserAgent)
+	if err != nil {
+		return s.parseError(err, "add document link access")
+	}
+	_, err = s.db.Exec(`UPDATE document_links SET last_accessed_at = now() WHERE id = $1`, access.LinkId)
+	return s.parseError(err, "add document link access")
+}
+
+// GetDocumentLinkAccess returns the latest accesses to the link.
+func (s *DocumentStore) GetDocumentLinkAccess(docId string, linkId int, paging Paging) (*[]models.DocumentLinkAccess, error) {
+	access := &[]models.DocumentLinkAccess{}
+	err := s.db.Select(access, `SELECT a.* FROM document_link_access a
+		JOIN document_links l ON a.link_id = l.id
+		WHERE l.document_id = $1 AND a.link_id = $2
+		ORDER BY a.created_at DESC OFFSET $3 LIMIT $4`, docId, linkId, paging.Offset, paging.Limit)
+	return access, s.parseError(err, "get document link access")
+}
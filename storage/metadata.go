@@ -102,23 +102,43 @@ func (s MetadataStore) parseError(err error, action string) error {
 	return getDatabaseError(err, s, action)
 }
 
+// valuePathsCte returns a recursive common table expression 'value_paths' that resolves paths of user's
+// metadata values, e.g. 'acme/website/invoices'. UserId is the sql expression of the user id.
+// Values that are part of a parent cycle have no path.
+func valuePathsCte(userId string) string {
+	return `
+WITH RECURSIVE value_paths AS (
+	SELECT id, value::TEXT AS path
+	FROM metadata_values
+	WHERE parent_id IS NULL
+	AND user_id = ` + userId + `
+	UNION ALL
+	SELECT mv.id, vp.path || '` + models.MetadataPathSeparator + `' || mv.value
+	FROM metadata_values mv
+	JOIN value_paths vp ON mv.parent_id = vp.id
+)
+`
+}
+
 // GetDocumentMetadata returns key-value metadata for given document. If userId != 0, user must own document.
 func (s *MetadataStore) GetDocumentMetadata(userId int, documentId string) (*[]models.Metadata, error) {
 	var sql string
 	var args []interface{}
 
 	if userId != 0 {
-		sql = `
-
+		sql = valuePathsCte("$2") + `
 SELECT
 	mk.id AS key_id,
 	mk.key AS key,
 	mv.id AS value_id,
-	mv.value AS value
+	mv.value AS value,
+	COALESCE(vp.path, mv.value) AS path,
+	COALESCE(mk.value_type, 'text') AS value_type
 FROM documents d
 LEFT JOIN document_metadata dm ON d.id = dm.document_id
 LEFT JOIN metadata_keys mk ON dm.key_id = mk.id
 LEFT JOIN metadata_values mv ON dm.value_id = mv.id
+LEFT JOIN value_paths vp ON mv.id = vp.id
 WHERE d.id = $1
 AND d.user_id = $2
 ORDER BY key ASC;
@@ -126,16 +146,19 @@ ORDER BY key ASC;
 		args = []interface{}{documentId, userId}
 
 	} else {
-		sql = `
+		sql = valuePathsCte("(SELECT user_id FROM documents WHERE id = $1)") + `
 SELECT
 	mk.id AS key_id,
 	mk.key AS key,
 	mv.id AS value_id,
-	mv.value AS value
+	mv.value AS value,
+	COALESCE(vp.path, mv.value) AS path,
+	COALESCE(mk.value_type, 'text') AS value_type
 FROM documents d
 LEFT JOIN document_metadata dm ON d.id = dm.document_id
 LEFT JOIN metadata_keys mk ON dm.key_id = mk.id
 LEFT JOIN metadata_values mv ON dm.value_id = mv.id
+LEFT JOIN value_paths vp ON mv.id = vp.id
 WHERE d.id = $1
 ORDER BY key ASC;
 `
@@ -220,7 +243,8 @@ func (s *MetadataStore) GetKeys(userId int, ids []int, sort SortKey, paging Pagi
 	paging.Validate()
 	sort.Validate("id")
 	query := s.sq.Select("mk.id as id", "mk.key as key", "mk.comment as comment",
-		"mk.created_at as created_at", "COUNT(distinct(dm.document_id)) as documents_count", "COUNT(distinct(mv.id)) as values_count").
+		"mk.created_at as created_at", "mk.single_value as single_value", "mk.value_type as value_type",
+		"COUNT(distinct(dm.document_id)) as documents_count", "COUNT(distinct(mv.id)) as values_count").
 		From("metadata_keys mk").
 		LeftJoin("document_metadata dm ON mk.id = dm.key_id").
 		LeftJoin("metadata_values mv on mk.id = mv.key_id").
@@ -265,6 +289,22 @@ AND id = $2;
 	return key, s.parseError(err, "get key")
 }
 
+// GetValue returns single value of the key.
+func (s *MetadataStore) GetValue(userId int, keyId int, valueId int) (*models.MetadataValue, error) {
+	sql := `
+SELECT mv.id, mv.user_id, mv.key_id, mk.key, mv.value, mv.created_at, mv.comment,
+	mv.match_documents, mv.match_type, mv.match_filter, mv.parent_id
+FROM metadata_values mv
+LEFT JOIN metadata_keys mk ON mv.key_id = mk.id
+WHERE mv.user_id = $1
+AND mv.key_id = $2
+AND mv.id = $3;
+`
+	value := &models.MetadataValue{}
+	err := s.db.Get(value, sql, userId, keyId, valueId)
+	return value, s.parseError(err, "get value")
+}
+
 // GetValues returns all values to given key.
 func (s *MetadataStore) GetValues(userId int, keyId int, sort SortKey, paging Paging) (*[]models.MetadataValue, error) {
 	paging.Validate()
@@ -272,17 +312,21 @@ func (s *MetadataStore) GetValues(userId int, keyId int, sort SortKey, paging Pa
 	query := s.sq.Select(
 		"mv.id as id",
 		"mv.value as value",
+		"mv.parent_id as parent_id",
+		"COALESCE(vp.path, mv.value) as path",
 		"mk.key as key",
 		"mv.created_at as created_at",
 		"match_documents",
 		"match_type",
 		"match_filter",
 		"count(dm.document_id) as documents_count").
+		Prefix(valuePathsCte("?"), userId).
 		From("metadata_values mv").
 		LeftJoin("document_metadata dm on mv.id = dm.value_id").
 		LeftJoin("metadata_keys mk on mv.key_id = mk.id").
+		LeftJoin("value_paths vp on mv.id = vp.id").
 		Where(squirrel.Eq{"mv.user_id": userId}).
-		Where(squirrel.Eq{"mv.key_id": keyId}).GroupBy("mv.id", "mv.value", "mk.key").
+		Where(squirrel.Eq{"mv.key_id": keyId}).GroupBy("mv.id", "mv.value", "mk.key", "vp.path").
 		OrderBy(sort.QueryKey() + " " + sort.SortOrder()).Limit(uint64(paging.Limit)).Offset(uint64(paging.Offset))
 
 	sql, args, err := query.ToSql()
@@ -396,10 +440,11 @@ END;
 // have Metadatavalue.MatchDocuments enabled.
 func (s *MetadataStore) GetUserValuesWithMatching(userId int) (*[]models.MetadataValue, error) {
 	sql := `
-SELECT * FROM
-metadata_values
-WHERE user_id = $1
-AND match_documents = TRUE;
+SELECT mv.*, mk.single_value AS key_single_value
+FROM metadata_values mv
+LEFT JOIN metadata_keys mk ON mv.key_id = mk.id
+WHERE mv.user_id = $1
+AND mv.match_documents = TRUE;
 `
 
 	values := &[]models.MetadataValue{}
@@ -434,12 +479,15 @@ func (s *MetadataStore) CreateKey(userId int, key *models.MetadataKey) error {
 
 	sql := `
 INSERT INTO metadata_keys
-(user_id, key, comment)
-VALUES ($1, $2, $3)
+(user_id, key, comment, single_value, value_type)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id;
 `
 
-	res, err := s.db.Query(sql, userId, key.Key, key.Comment)
+	if key.ValueType == "" {
+		key.ValueType = models.MetadataValueText
+	}
+	res, err := s.db.Query(sql, userId, key.Key, key.Comment, key.SingleValue, key.ValueType)
 	if err != nil {
 		return s.parseError(err, "create key")
 	}
@@ -460,12 +508,13 @@ RETURNING id;
 func (s *MetadataStore) CreateValue(userId int, value *models.MetadataValue) error {
 	sql := `
 INSERT INTO metadata_values
-(user_id, key_id, value, match_documents, match_type, match_filter)
-VALUES ($1, $2, $3, $4, $5, $6)
+(user_id, key_id, value, match_documents, match_type, match_filter, parent_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 RETURNING id;
 `
 
-	res, err := s.db.Query(sql, userId, value.KeyId, value.Value, value.MatchDocuments, value.MatchType, value.MatchFilter)
+	res, err := s.db.Query(sql, userId, value.KeyId, value.Value, value.MatchDocuments, value.MatchType, value.MatchFilter,
+		value.ParentId)
 	if err != nil {
 		return s.parseError(err, "create value")
 	}
@@ -643,22 +692,26 @@ WHERE user_id=$1 AND id IN (
 func (s *MetadataStore) UpdateValue(value *models.MetadataValue) error {
 	sql := `
 	UPDATE metadata_values
-	SET value=$1, match_documents=$2, match_type=$3, match_filter=$4
-	WHERE id=$5;
+	SET value=$1, match_documents=$2, match_type=$3, match_filter=$4, parent_id=$5
+	WHERE id=$6;
 `
 
-	_, err := s.db.Exec(sql, value.Value, value.MatchDocuments, value.MatchType, value.MatchFilter, value.Id)
+	_, err := s.db.Exec(sql, value.Value, value.MatchDocuments, value.MatchType, value.MatchFilter, value.ParentId,
+		value.Id)
 	return s.parseError(err, "update value")
 }
 
 func (s *MetadataStore) UpdateKey(key *models.MetadataKey) error {
 	sql := `
 UPDATE metadata_keys 
-SET key=$1, comment=$2
-WHERE id=$3;
+SET key=$1, comment=$2, single_value=$3, value_type=$4
+WHERE id=$5;
 `
 
-	_, err := s.db.Exec(sql, key.Key, key.Comment, key.Id)
+	if key.ValueType == "" {
+		key.ValueType = models.MetadataValueText
+	}
+	_, err := s.db.Exec(sql, key.Key, key.Comment, key.SingleValue, key.ValueType, key.Id)
 	if err != nil {
 		return s.parseError(err, "update key")
 	}
@@ -666,6 +719,90 @@ WHERE id=$3;
 	return nil
 }
 
+// GetTypedKeys returns user's keys that have other than text values.
+func (s *MetadataStore) GetTypedKeys(userId int) ([]models.MetadataKey, error) {
+	sql := `
+SELECT id, user_id, key, created_at, comment, single_value, value_type
+FROM metadata_keys
+WHERE user_id = $1
+AND value_type != 'text';
+`
+	keys := []models.MetadataKey{}
+	err := s.db.Select(&keys, sql, userId)
+	return keys, s.parseError(err, "get typed keys")
+}
+
+// GetKeyValueNames returns all values of the key.
+func (s *MetadataStore) GetKeyValueNames(userId int, keyId int) ([]string, error) {
+	sql := `
+SELECT value
+FROM metadata_values
+WHERE user_id = $1
+AND key_id = $2
+ORDER BY id;
+`
+	values := []string{}
+	err := s.db.Select(&values, sql, userId, keyId)
+	return values, s.parseError(err, "get key value names")
+}
+
+// GetValueAncestors returns ancestor value ids of each of user's values that have a parent,
+// starting from the parent of the value.
+func (s *MetadataStore) GetValueAncestors(userId int) (map[int][]int, error) {
+	sql := `
+SELECT id, parent_id
+FROM metadata_values
+WHERE user_id = $1
+AND parent_id IS NOT NULL;
+`
+	rows := &[]struct {
+		Id       int `db:"id"`
+		ParentId int `db:"parent_id"`
+	}{}
+	err := s.db.Select(rows, sql, userId)
+	if err != nil {
+		return nil, s.parseError(err, "get value ancestors")
+	}
+	parents := make(map[int]int, len(*rows))
+	for _, v := range *rows {
+		parents[v.Id] = v.ParentId
+	}
+	return valueAncestors(parents), nil
+}
+
+// valueAncestors resolves ancestors of each value from their parents. Cycles are cut.
+func valueAncestors(parents map[int]int) map[int][]int {
+	ancestors := make(map[int][]int, len(parents))
+	for id := range parents {
+		seen := map[int]bool{id: true}
+		for parent := parents[id]; parent != 0 && !seen[parent]; parent = parents[parent] {
+			seen[parent] = true
+			ancestors[id] = append(ancestors[id], parent)
+		}
+	}
+	return ancestors
+}
+
+// GetValueDescendants returns ids of all values under the value.
+func (s *MetadataStore) GetValueDescendants(userId int, valueId int) ([]int, error) {
+	sql := `
+WITH RECURSIVE descendants AS (
+	SELECT id
+	FROM metadata_values
+	WHERE parent_id = $2
+	AND user_id = $1
+	UNION
+	SELECT mv.id
+	FROM metadata_values mv
+	JOIN descendants d ON mv.parent_id = d.id
+)
+SELECT id FROM descendants;
+`
+	ids := []int{}
+	err := s.db.Select(&ids, sql, userId, valueId)
+	return ids, s.parseError(err, "get value descendants")
+}
+
 // CheckKeyValuesExist verifies key-value pairs exist and user owns them.
 func (s *MetadataStore) CheckKeyValuesExist(userId int, values []models.Metadata) error {
 	array := make(squirrel.Or, len(values))
@@ -872,6 +1009,26 @@ func (s *MetadataStore) GetLinkedDocuments(userId int, docId string) ([]*models.
 	return docs, nil
 }
 
+// GetDocumentsByKeyValue returns ids of all user's documents that have given key-value assigned.
+// Trashed documents are excluded.
+func (s *MetadataStore) GetDocumentsByKeyValue(userId, keyId, valueId int) ([]string, error) {
+	query := s.sq.Select("dm.document_id").
+		From("document_metadata dm").
+		Join("documents d ON dm.document_id = d.id").
+		Where(squirrel.Eq{"d.user_id": userId, "dm.key_id": keyId, "dm.value_id": valueId}).
+		Where("d.deleted_at IS NULL").
+		Limit(config.MaxRows)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("create sql: %v", err)
+	}
+
+	ids := make([]string, 0)
+	err = s.db.Select(&ids, sql, args...)
+	return ids, s.parseError(err, "get documents by key-value")
+}
+
 // UpdateLinkedDocuments updates document. This does not validate ownership of the documents.
 func (s *MetadataStore) UpdateLinkedDocuments(userId int, docId string, docs []string) error {
 	tx, err := s.beginTx()
@@ -0,0 +1,43 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2020  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_valueAncestors(t *testing.T) {
+	parents := map[int]int{
+		2: 1,
+		3: 2,
+		// cycle
+		5: 6,
+		6: 5,
+	}
+	want := map[int][]int{
+		2: {1},
+		3: {2, 1},
+		5: {6},
+		6: {5},
+	}
+	if got := valueAncestors(parents); !reflect.DeepEqual(got, want) {
+		t.Errorf("valueAncestors() = %v, want %v", got, want)
+	}
+}
@@ -103,6 +103,81 @@ var Migrations = []Migrator{
 		Level:  15,
 		Schema: schemaV15,
 	},
+	&Migration{
+		Name:   "add document collections",
+		Level:  16,
+		Schema: schemaV16,
+	},
+	&Migration{
+		Name:   "add table for document rule traces",
+		Level:  17,
+		Schema: schemaV17,
+	},
+	&Migration{
+		Name:   "add rule versions",
+		Level:  18,
+		Schema: schemaV18,
+	},
+	&Migration{
+		Name:   "add metadata key cardinality",
+		Level:  19,
+		Schema: schemaV19,
+	},
+	&Migration{
+		Name:   "add saved searches",
+		Level:  20,
+		Schema: schemaV20,
+	},
+	&Migration{
+		Name:   "add metadata value types",
+		Level:  21,
+		Schema: schemaV21,
+	},
+	&Migration{
+		Name:   "add metadata value hierarchy",
+		Level:  22,
+		Schema: schemaV22,
+	},
+	&Migration{
+		Name:   "add personal access tokens",
+		Level:  23,
+		Schema: schemaV23,
+	},
+	&Migration{
+		Name:   "add two-factor authentication",
+		Level:  24,
+		Schema: schemaV24,
+	},
+	&Migration{
+		Name:   "add external user identities",
+		Level:  25,
+		Schema: schemaV25,
+	},
+	&Migration{
+		Name:   "add document sharing and user groups",
+		Level:  26,
+		Schema: schemaV26,
+	},
+	&Migration{
+		Name:   "add public document links",
+		Level:  27,
+		Schema: schemaV27,
+	},
+	&Migration{
+		Name:   "add audit log",
+		Level:  28,
+		Schema: schemaV28,
+	},
+	&Migration{
+		Name:   "add impersonation sessions",
+		Level:  29,
+		Schema: schemaV29,
+	},
+	&Migration{
+		Name:   "add email verification tokens",
+		Level:  30,
+		Schema: schemaV30,
+	},
 }
 
 type Schema struct {
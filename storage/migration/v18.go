@@ -0,0 +1,50 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package migration
+
+const schemaV18 = `
+ALTER TABLE rules ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE rule_versions (
+    id SERIAL PRIMARY KEY,
+    rule_id INT NOT NULL,
+    user_id INT NOT NULL,
+    version INT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    content JSONB NOT NULL,
+
+	CONSTRAINT fk_rule_id
+		FOREIGN KEY (rule_id)
+		REFERENCES rules(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT fk_user_id
+		FOREIGN KEY (user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT rule_versions_unique UNIQUE (rule_id, version)
+);
+
+ALTER TABLE document_history ADD COLUMN rule_id INT;
+ALTER TABLE document_history ADD COLUMN rule_version INT;
+ALTER TABLE document_history ADD CONSTRAINT fk_rule
+    FOREIGN KEY (rule_id)
+    REFERENCES rules(id)
+    ON DELETE SET NULL;
+`
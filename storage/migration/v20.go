@@ -0,0 +1,59 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package migration
+
+const schemaV20 = `
+CREATE TABLE saved_searches (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    name TEXT NOT NULL,
+    query TEXT NOT NULL,
+    sort_key TEXT NOT NULL DEFAULT '',
+    sort_desc BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    digest BOOLEAN NOT NULL DEFAULT FALSE,
+    digest_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now(),
+
+	CONSTRAINT fk_user_id
+		FOREIGN KEY (user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT saved_searches_unique_name UNIQUE (user_id, name)
+);
+
+-- documents that matched saved search on last digest run
+CREATE TABLE saved_search_documents (
+    saved_search_id INT NOT NULL,
+    document_id TEXT NOT NULL,
+
+	CONSTRAINT fk_saved_search_id
+		FOREIGN KEY (saved_search_id)
+		REFERENCES saved_searches(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT fk_document_id
+		FOREIGN KEY (document_id)
+		REFERENCES documents(id)
+		ON DELETE CASCADE,
+
+	PRIMARY KEY (saved_search_id, document_id)
+);
+`
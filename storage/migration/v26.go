@@ -0,0 +1,79 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV26 = `
+CREATE TABLE user_groups (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE user_group_members (
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+
+	PRIMARY KEY (group_id, user_id),
+
+	CONSTRAINT fk_group_id
+		FOREIGN KEY (group_id)
+		REFERENCES user_groups(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT fk_user_id
+		FOREIGN KEY (user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE
+);
+
+CREATE TABLE document_shares (
+    id SERIAL PRIMARY KEY,
+    document_id TEXT NOT NULL,
+    user_id INT,
+    group_id INT,
+    permission TEXT NOT NULL DEFAULT 'read',
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now(),
+
+	CONSTRAINT fk_document_id
+		FOREIGN KEY (document_id)
+		REFERENCES documents(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT fk_user_id
+		FOREIGN KEY (user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT fk_group_id
+		FOREIGN KEY (group_id)
+		REFERENCES user_groups(id)
+		ON DELETE CASCADE,
+
+	CONSTRAINT share_target CHECK ((user_id IS NULL) != (group_id IS NULL)),
+	CONSTRAINT share_permission CHECK (permission IN ('read', 'edit')),
+	CONSTRAINT unique_user_share UNIQUE (document_id, user_id),
+	CONSTRAINT unique_group_share UNIQUE (document_id, group_id)
+);
+
+CREATE INDEX document_shares_user_id ON document_shares(user_id);
+CREATE INDEX document_shares_group_id ON document_shares(group_id);
+CREATE INDEX user_group_members_user_id ON user_group_members(user_id);
+`
@@ -0,0 +1,46 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package migration
+
+const schemaV28 = `
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id INT,
+    actor_name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    resource TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    old_value JSONB,
+    new_value JSONB,
+    message TEXT NOT NULL DEFAULT '',
+
+	CONSTRAINT fk_actor_id
+		FOREIGN KEY (actor_id)
+		REFERENCES users(id)
+		ON DELETE SET NULL
+);
+
+CREATE INDEX audit_log_created_at ON audit_log(created_at);
+CREATE INDEX audit_log_actor_id ON audit_log(actor_id);
+CREATE INDEX audit_log_action ON audit_log(action);
+`
@@ -142,11 +142,22 @@ func (s *RuleStore) AddRule(userId int, rule *models.Rule) error {
 	}
 
 	tx, err := s.beginTx()
+	if err != nil {
+		return err
+	}
 	defer tx.Close()
+
+	err = s.addRule(tx, userId, rule)
 	if err != nil {
-		return fmt.Errorf("begin tx: %v", err)
+		return err
 	}
+	tx.ok = true
+	return nil
+}
 
+// addRule inserts rule in transaction. Rules at and after the rule's order are moved down by one.
+// Rule is not validated.
+func (s *RuleStore) addRule(tx *tx, userId int, rule *models.Rule) error {
 	// Increase remaining rules rule_order by on.
 	// Due to unique constraint a temporary value will need to be first set.
 	updateSql := `
@@ -154,7 +165,7 @@ func (s *RuleStore) AddRule(userId int, rule *models.Rule) error {
 				SET rule_order = -rule_order
 				WHERE user_id = $1 AND rule_order >= $2;
 `
-	_, err = tx.tx.Exec(updateSql, userId, rule.Order)
+	_, err := tx.tx.Exec(updateSql, userId, rule.Order)
 	if err != nil {
 		return getDatabaseError(err, s, "increase rule order")
 	}
@@ -184,6 +195,7 @@ func (s *RuleStore) AddRule(userId int, rule *models.Rule) error {
 		return getDatabaseError(err, s, "insert rule")
 	}
 	rule.Id = id
+	rule.Version = 1
 	err = s.addActionsToRule(tx, rule.Id, rule.Actions)
 	if err != nil {
 		return fmt.Errorf("add actions: %v", err)
@@ -193,8 +205,8 @@ func (s *RuleStore) AddRule(userId int, rule *models.Rule) error {
 	if err != nil {
 		return fmt.Errorf("add conditions: %v", err)
 	}
-	tx.ok = true
-	return nil
+
+	return s.addRuleVersion(tx, userId, rule)
 }
 
 // GetActiveUresRules returns all enabled rules (with some limit) for given user.
@@ -288,6 +300,16 @@ ORDER BY rule_id, rule_actions.id ASC;
 	if err != nil {
 		return s.parseError(err, "get rule conditions")
 	}
+
+	ancestors, err := s.metadata.GetValueAncestors(userId)
+	if err != nil {
+		return err
+	}
+	for i, v := range *actions {
+		if v.Action == models.RuleActionAddMetadata {
+			(*actions)[i].MetadataAncestors = ancestors[int(v.MetadataValue)]
+		}
+	}
 	mapActionsToRules(rules, actions)
 	return nil
 }
@@ -325,12 +347,34 @@ func (s *RuleStore) UpdateRule(userId int, rule *models.Rule) error {
 		return err
 	}
 
+	current, err := s.GetUserRule(userId, rule.Id)
+	if err != nil {
+		return err
+	}
+
 	tx, err := s.beginTx()
 	if err != nil {
 		return err
 	}
 	defer tx.Close()
 
+	err = s.updateRule(tx, userId, rule, current)
+	if err != nil {
+		return err
+	}
+	tx.ok = true
+	return nil
+}
+
+// updateRule updates rule in transaction. Current is the stored rule before the update.
+// Rule is not validated.
+func (s *RuleStore) updateRule(tx *tx, userId int, rule *models.Rule, current *models.Rule) error {
+	// rules created before versioning do not have their current version stored yet.
+	err := s.addRuleVersion(tx, userId, current)
+	if err != nil {
+		return err
+	}
+
 	rule.Update()
 	query := s.sq.Update("rules").SetMap(map[string]interface{}{
 		"name":        rule.Name,
@@ -339,14 +383,16 @@ func (s *RuleStore) UpdateRule(userId int, rule *models.Rule) error {
 		"rule_order":  rule.Order,
 		"mode":        rule.Mode,
 		"updated_at":  rule.UpdatedAt,
-	}).Where(squirrel.Eq{"user_id": userId, "id": rule.Id})
+		"version":     squirrel.Expr("version + 1"),
+	}).Where(squirrel.Eq{"user_id": userId, "id": rule.Id}).
+		Suffix("RETURNING version")
 
 	sql, args, err := query.ToSql()
 	if err != nil {
 		return fmt.Errorf("build sql: %v", err)
 	}
 
-	_, err = tx.tx.Exec(sql, args...)
+	err = tx.tx.Get(&rule.Version, sql, args...)
 	if err != nil {
 		return getDatabaseError(err, s, "update")
 	}
@@ -375,8 +421,7 @@ func (s *RuleStore) UpdateRule(userId int, rule *models.Rule) error {
 
 	//TODO: handle changing rule_order
 
-	tx.ok = true
-	return nil
+	return s.addRuleVersion(tx, userId, rule)
 }
 
 func (s *RuleStore) DeleteRule(userId, ruleId int) error {
@@ -409,6 +454,11 @@ func (s *RuleStore) validateRule(userId int, rule *models.Rule) error {
 		return err
 	}
 
+	err = s.validateConditionValues(userId, rule)
+	if err != nil {
+		return err
+	}
+
 	metadata := make([]models.Metadata, 0, 5)
 	for _, v := range rule.Conditions {
 		if v.MetadataValue > 0 && v.MetadataKey > 0 {
@@ -438,6 +488,33 @@ func (s *RuleStore) validateRule(userId int, rule *models.Rule) error {
 	return nil
 }
 
+// validateConditionValues checks that conditions comparing values have a typed metadata key
+// and a value of the key's type.
+func (s *RuleStore) validateConditionValues(userId int, rule *models.Rule) error {
+	for i, v := range rule.Conditions {
+		if !v.IsValueComparison() {
+			continue
+		}
+		key, err := s.metadata.GetKey(userId, int(v.MetadataKey))
+		if err != nil {
+			return err
+		}
+		e := errors.ErrInvalid
+		if !key.ValueType.Typed() {
+			e.ErrMsg = fmt.Sprintf("condition %d: metadata key '%s' does not have number, date, money or boolean values", i+1, key.Key)
+			return e
+		}
+		if _, err := key.ValueType.NumericValue(v.Value); err != nil {
+			if isErr, ok := err.(errors.Error); ok {
+				isErr.ErrMsg = fmt.Sprintf("condition %d: %s", i+1, isErr.ErrMsg)
+				return isErr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *RuleStore) addActionsToRule(tx *tx, ruleId int, actions []*models.RuleAction) error {
 	query := s.sq.Insert("rule_actions").
 		Columns("rule_id", "enabled", "on_condition", "action", "value", "metadata_key", "metadata_value")
@@ -513,3 +590,174 @@ func mapActionsToRules(rules []*models.Rule, actions *[]models.RuleAction) {
 		}
 	}
 }
+
+// addRuleVersion stores a copy of the rule with its current version. Existing version is not overwritten.
+func (s *RuleStore) addRuleVersion(tx *tx, userId int, rule *models.Rule) error {
+	err := s.setMetadataNames(tx, userId, rule)
+	if err != nil {
+		return fmt.Errorf("get metadata names: %v", err)
+	}
+
+	query := s.sq.Insert("rule_versions").
+		Columns("rule_id", "user_id", "version", "content").
+		Values(rule.Id, userId, rule.Version, models.RuleSnapshot(*rule)).
+		Suffix("ON CONFLICT (rule_id, version) DO NOTHING")
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build insert rule version sql: %v", err)
+	}
+	_, err = tx.tx.Exec(sql, args...)
+	if err != nil {
+		return getDatabaseError(err, s, "add rule version")
+	}
+	return nil
+}
+
+// setMetadataNames sets metadata key and value names for rule conditions and actions.
+// Names are read in the transaction, so that metadata created in it is included.
+func (s *RuleStore) setMetadataNames(tx *tx, userId int, rule *models.Rule) error {
+	keys := &[]models.MetadataKey{}
+	err := tx.tx.Select(keys, "SELECT id, key FROM metadata_keys WHERE user_id = $1", userId)
+	if err != nil {
+		return s.parseError(err, "get metadata keys")
+	}
+	values := &[]models.MetadataValue{}
+	err = tx.tx.Select(values, "SELECT id, value FROM metadata_values WHERE user_id = $1", userId)
+	if err != nil {
+		return s.parseError(err, "get metadata values")
+	}
+
+	keyNames := make(map[models.IntId]models.Text, len(*keys))
+	for _, v := range *keys {
+		keyNames[models.IntId(v.Id)] = models.Text(v.Key)
+	}
+	valueNames := make(map[models.IntId]models.Text, len(*values))
+	for _, v := range *values {
+		valueNames[models.IntId(v.Id)] = models.Text(v.Value)
+	}
+
+	for _, v := range rule.Conditions {
+		v.MetadataKeyName = keyNames[v.MetadataKey]
+		v.MetadataValueName = valueNames[v.MetadataValue]
+	}
+	for _, v := range rule.Actions {
+		v.MetadataKeyName = keyNames[v.MetadataKey]
+		v.MetadataValueName = valueNames[v.MetadataValue]
+	}
+	return nil
+}
+
+// GetRuleVersions returns all stored versions of the rule, newest first.
+func (s *RuleStore) GetRuleVersions(userId, ruleId int) (*[]models.RuleVersion, error) {
+	sql := `
+SELECT rv.id, rv.rule_id, rv.user_id, coalesce(u.name, '') AS user, rv.version, rv.created_at, rv.content
+FROM rule_versions rv
+LEFT JOIN rules r ON rv.rule_id = r.id
+LEFT JOIN users u ON rv.user_id = u.id
+WHERE r.user_id = $1 AND rv.rule_id = $2
+ORDER BY rv.version DESC;`
+
+	versions := &[]models.RuleVersion{}
+	err := s.db.Select(versions, sql, userId, ruleId)
+	if err != nil {
+		return versions, s.parseError(err, "get rule versions")
+	}
+	return versions, nil
+}
+
+// GetRuleVersion returns given version of the rule.
+func (s *RuleStore) GetRuleVersion(userId, ruleId, version int) (*models.RuleVersion, error) {
+	sql := `
+SELECT rv.id, rv.rule_id, rv.user_id, coalesce(u.name, '') AS user, rv.version, rv.created_at, rv.content
+FROM rule_versions rv
+LEFT JOIN rules r ON rv.rule_id = r.id
+LEFT JOIN users u ON rv.user_id = u.id
+WHERE r.user_id = $1 AND rv.rule_id = $2 AND rv.version = $3;`
+
+	ruleVersion := &models.RuleVersion{}
+	err := s.db.Get(ruleVersion, sql, userId, ruleId, version)
+	if err != nil {
+		return ruleVersion, s.parseError(err, "get rule version")
+	}
+	return ruleVersion, nil
+}
+
+// RestoreRuleVersion restores rule contents to given version. Restoring creates a new version,
+// and the rule keeps its current order.
+func (s *RuleStore) RestoreRuleVersion(userId, ruleId, version int) (*models.Rule, error) {
+	current, err := s.GetUserRule(userId, ruleId)
+	if err != nil {
+		return nil, err
+	}
+	ruleVersion, err := s.GetRuleVersion(userId, ruleId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := models.Rule(ruleVersion.Content)
+	rule.Id = current.Id
+	rule.UserId = userId
+	rule.Order = current.Order
+	err = s.UpdateRule(userId, &rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// AddRuleTrace stores rule execution trace for document and removes traces
+// exceeding config.MaxRuleTraces for the document.
+func (s *RuleStore) AddRuleTrace(trace *models.RuleTrace) error {
+	tx, err := s.beginTx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	defer tx.Close()
+
+	query := s.sq.Insert("document_rule_traces").
+		Columns("user_id", "document_id", "took_ms", "trace").
+		Values(trace.UserId, trace.DocumentId, trace.TookMs, trace.Trace).
+		Suffix("RETURNING \"id\", created_at")
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build insert trace sql: %v", err)
+	}
+
+	err = tx.tx.QueryRowx(sql, args...).Scan(&trace.Id, &trace.CreatedAt)
+	if err != nil {
+		return getDatabaseError(err, s, "add rule trace")
+	}
+
+	sql = `
+DELETE FROM document_rule_traces
+WHERE document_id = $1
+AND id NOT IN (
+    SELECT id FROM document_rule_traces
+    WHERE document_id = $1
+    ORDER BY created_at DESC, id DESC
+    LIMIT $2
+);`
+	_, err = tx.tx.Exec(sql, trace.DocumentId, config.MaxRuleTraces)
+	if err != nil {
+		return getDatabaseError(err, s, "delete old rule traces")
+	}
+	tx.ok = true
+	return nil
+}
+
+// GetDocumentRuleTraces returns rule execution traces for document, newest first.
+func (s *RuleStore) GetDocumentRuleTraces(userId int, docId string) (*[]models.RuleTrace, error) {
+	sql := `
+SELECT id, user_id, document_id, created_at, took_ms, trace
+FROM document_rule_traces
+WHERE user_id = $1 AND document_id = $2
+ORDER BY created_at DESC, id DESC
+LIMIT $3;`
+
+	traces := &[]models.RuleTrace{}
+	err := s.db.Select(traces, sql, userId, docId, config.MaxRuleTraces)
+	if err != nil {
+		return traces, s.parseError(err, "get document rule traces")
+	}
+	return traces, nil
+}
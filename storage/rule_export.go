@@ -0,0 +1,406 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"tryffel.net/go/virtualpaper/config"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// ExportRules returns all user's rules in portable format.
+func (s *RuleStore) ExportRules(userId int) (*models.RulesExport, error) {
+	rules, _, err := s.GetUserRules(userId, Paging{Offset: 0, Limit: config.MaxRows})
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.RulesExport{
+		Version: models.RuleExportVersion,
+		Rules:   make([]models.RuleExport, len(rules)),
+	}
+	for i, v := range rules {
+		export.Rules[i] = v.ToExport()
+	}
+	return export, nil
+}
+
+// metadataNames maps metadata key and value names to ids. Names are case-insensitive.
+type metadataNames struct {
+	keys   map[string]int
+	values map[int]map[string]int
+}
+
+func (m *metadataNames) keyId(key string) int {
+	return m.keys[strings.ToLower(key)]
+}
+
+func (m *metadataNames) valueId(keyId int, value string) int {
+	values, ok := m.values[keyId]
+	if !ok {
+		return 0
+	}
+	return values[strings.ToLower(value)]
+}
+
+func (m *metadataNames) addKey(key string, id int) {
+	m.keys[strings.ToLower(key)] = id
+}
+
+func (m *metadataNames) addValue(keyId int, value string, id int) {
+	if _, ok := m.values[keyId]; !ok {
+		m.values[keyId] = map[string]int{}
+	}
+	m.values[keyId][strings.ToLower(value)] = id
+}
+
+func (s *RuleStore) getMetadataNames(userId int) (*metadataNames, error) {
+	names := &metadataNames{
+		keys:   map[string]int{},
+		values: map[int]map[string]int{},
+	}
+
+	keys := &[]models.MetadataKey{}
+	err := s.db.Select(keys, "SELECT id, key FROM metadata_keys WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, s.parseError(err, "get metadata keys")
+	}
+	for _, v := range *keys {
+		names.addKey(v.Key, v.Id)
+	}
+
+	values := &[]models.MetadataValue{}
+	err = s.db.Select(values, "SELECT id, key_id, value FROM metadata_values WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, s.parseError(err, "get metadata values")
+	}
+	for _, v := range *values {
+		names.addValue(v.KeyId, v.Value, v.Id)
+	}
+	return names, nil
+}
+
+// ImportRules imports rules from portable format. Metadata is resolved by names.
+// Rule is in conflict with existing rule if they share the same name.
+// The import is validated completely before any rule is stored, and it is stored in a single transaction.
+// Overwritten rules keep their current order.
+func (s *RuleStore) ImportRules(userId int, export *models.RulesExport, opts models.RuleImportOptions) (*models.RuleImportResult, error) {
+	result := &models.RuleImportResult{
+		Created:       []string{},
+		Updated:       []string{},
+		Skipped:       []string{},
+		Conflicts:     []string{},
+		KeysCreated:   []string{},
+		ValuesCreated: []string{},
+		MissingKeys:   []string{},
+		MissingValues: []string{},
+	}
+
+	if export.Version > models.RuleExportVersion {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("unsupported rule export version: %d", export.Version)
+		return result, e
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = models.RuleConflictSkip
+	}
+
+	names, err := s.getMetadataNames(userId)
+	if err != nil {
+		return result, err
+	}
+
+	// collect metadata that does not exist yet
+	missingKeys := map[string]string{}
+	missingValues := map[string][2]string{}
+	checkMetadata := func(key, value string) error {
+		if key == "" {
+			if value != "" {
+				e := errors.ErrInvalid
+				e.ErrMsg = fmt.Sprintf("metadata value '%s' has no key", value)
+				return e
+			}
+			return nil
+		}
+		for _, v := range []string{key, value} {
+			if strings.ContainsAny(v, ";:\n") || len(v) > 30 {
+				e := errors.ErrInvalid
+				e.ErrMsg = fmt.Sprintf("invalid metadata name: '%s'", v)
+				return e
+			}
+		}
+		keyId := names.keyId(key)
+		if keyId == 0 {
+			missingKeys[strings.ToLower(key)] = key
+		}
+		if value != "" && (keyId == 0 || names.valueId(keyId, value) == 0) {
+			missingValues[strings.ToLower(key)+":"+strings.ToLower(value)] = [2]string{key, value}
+		}
+		return nil
+	}
+
+	for i, rule := range export.Rules {
+		for _, v := range rule.Conditions {
+			err = checkMetadata(v.MetadataKey, v.MetadataValue)
+			if err != nil {
+				return result, ruleImportError(i, err)
+			}
+		}
+		for _, v := range rule.Actions {
+			err = checkMetadata(v.MetadataKey, v.MetadataValue)
+			if err != nil {
+				return result, ruleImportError(i, err)
+			}
+		}
+	}
+
+	for _, v := range missingKeys {
+		result.MissingKeys = append(result.MissingKeys, v)
+	}
+	for _, v := range missingValues {
+		result.MissingValues = append(result.MissingValues, v[0]+":"+v[1])
+	}
+	sort.Strings(result.MissingKeys)
+	sort.Strings(result.MissingValues)
+
+	if !opts.CreateMissingMetadata && (len(missingKeys) > 0 || len(missingValues) > 0) {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("metadata does not exist: %s", strings.Join(append(result.MissingKeys, result.MissingValues...), ", "))
+		return result, e
+	}
+
+	existingRules, _, err := s.GetUserRules(userId, Paging{Offset: 0, Limit: config.MaxRows})
+	if err != nil {
+		return result, err
+	}
+	existing := map[string]*models.Rule{}
+	for _, v := range existingRules {
+		existing[strings.ToLower(v.Name)] = v
+	}
+
+	// validate rules before storing anything. Metadata that does not exist yet is created later,
+	// so use a placeholder id for it.
+	placeholder := &metadataNames{keys: map[string]int{}, values: map[int]map[string]int{}}
+	for _, v := range missingKeys {
+		placeholder.addKey(v, 1)
+	}
+	for _, v := range missingValues {
+		placeholder.addValue(1, v[1], 1)
+	}
+	resolveValidate := func(key, value string) (int, int) {
+		keyId := names.keyId(key)
+		valueId := names.valueId(keyId, value)
+		if key != "" && keyId == 0 {
+			keyId = placeholder.keyId(key)
+		}
+		if value != "" && valueId == 0 {
+			valueId = 1
+		}
+		return keyId, valueId
+	}
+
+	for i, v := range export.Rules {
+		if strings.TrimSpace(v.Name) == "" {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("rule %d: name cannot be empty", i+1)
+			return result, e
+		}
+		if _, found := existing[strings.ToLower(v.Name)]; found {
+			result.Conflicts = append(result.Conflicts, v.Name)
+		}
+		rule, err := ruleFromExport(&v, resolveValidate)
+		if err == nil {
+			err = rule.Validate()
+		}
+		if err == nil {
+			err = s.validateImportedConditionValues(userId, &v, rule, names)
+		}
+		if err != nil {
+			return result, ruleImportError(i, err)
+		}
+	}
+
+	if len(result.Conflicts) > 0 && opts.OnConflict == models.RuleConflictFail {
+		e := errors.ErrInvalid
+		e.ErrMsg = fmt.Sprintf("rules already exist: %s", strings.Join(result.Conflicts, ", "))
+		return result, e
+	}
+
+	tx, err := s.beginTx()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		tx.Close()
+		if len(missingKeys) > 0 {
+			s.metadata.flushCachedUserKeys(userId)
+		}
+	}()
+
+	for _, v := range missingKeys {
+		var keyId int
+		err = tx.tx.Get(&keyId, "INSERT INTO metadata_keys (user_id, key, value_type) VALUES ($1, $2, $3) RETURNING id",
+			userId, v, models.MetadataValueText)
+		if err != nil {
+			return result, getDatabaseError(err, s, fmt.Sprintf("create metadata key %s", v))
+		}
+		names.addKey(v, keyId)
+		result.KeysCreated = append(result.KeysCreated, v)
+	}
+	for _, v := range missingValues {
+		keyId := names.keyId(v[0])
+		var valueId int
+		err = tx.tx.Get(&valueId, "INSERT INTO metadata_values (user_id, key_id, value, match_type) VALUES ($1, $2, $3, $4) RETURNING id",
+			userId, keyId, v[1], models.MetadataMatchExact)
+		if err != nil {
+			return result, getDatabaseError(err, s, fmt.Sprintf("create metadata value %s:%s", v[0], v[1]))
+		}
+		names.addValue(keyId, v[1], valueId)
+		result.ValuesCreated = append(result.ValuesCreated, v[0]+":"+v[1])
+	}
+	sort.Strings(result.KeysCreated)
+	sort.Strings(result.ValuesCreated)
+
+	resolve := func(key, value string) (int, int) {
+		keyId := names.keyId(key)
+		return keyId, names.valueId(keyId, value)
+	}
+
+	// insert rules in their original order, so that rule_order is preserved
+	rules := make([]models.RuleExport, len(export.Rules))
+	copy(rules, export.Rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Order < rules[j].Order
+	})
+
+	for i, v := range rules {
+		rule, err := ruleFromExport(&v, resolve)
+		if err != nil {
+			return result, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		current, found := existing[strings.ToLower(v.Name)]
+		if found {
+			if opts.OnConflict == models.RuleConflictSkip {
+				result.Skipped = append(result.Skipped, v.Name)
+				continue
+			}
+			// overwritten rule keeps its current order, which rules added before it may have moved.
+			err = tx.tx.Get(&current.Order, "SELECT rule_order FROM rules WHERE user_id = $1 AND id = $2", userId, current.Id)
+			if err != nil {
+				return result, getDatabaseError(err, s, "get rule order")
+			}
+			rule.Id = current.Id
+			rule.UserId = userId
+			rule.Order = current.Order
+			err = s.updateRule(tx, userId, rule, current)
+			if err != nil {
+				return result, fmt.Errorf("update rule %s: %w", v.Name, err)
+			}
+			result.Updated = append(result.Updated, v.Name)
+		} else {
+			err = s.addRule(tx, userId, rule)
+			if err != nil {
+				return result, fmt.Errorf("add rule %s: %w", v.Name, err)
+			}
+			existing[strings.ToLower(v.Name)] = rule
+			result.Created = append(result.Created, v.Name)
+		}
+	}
+	tx.ok = true
+
+	logrus.Infof("user %d imported rules, created: %d, updated: %d, skipped: %d",
+		userId, len(result.Created), len(result.Updated), len(result.Skipped))
+	return result, nil
+}
+
+// validateImportedConditionValues validates value comparisons of the rule. Metadata keys created
+// by the import only have text values, so comparisons must use existing keys.
+func (s *RuleStore) validateImportedConditionValues(userId int, export *models.RuleExport, rule *models.Rule, names *metadataNames) error {
+	for i, v := range rule.Conditions {
+		if v.IsValueComparison() && names.keyId(export.Conditions[i].MetadataKey) == 0 {
+			e := errors.ErrInvalid
+			e.ErrMsg = fmt.Sprintf("condition %d: metadata key '%s' does not have number, date, money or boolean values",
+				i+1, export.Conditions[i].MetadataKey)
+			return e
+		}
+	}
+	return s.validateConditionValues(userId, rule)
+}
+
+func ruleImportError(index int, err error) error {
+	if appErr, ok := err.(errors.Error); ok {
+		appErr.ErrMsg = fmt.Sprintf("rule %d: %s", index+1, appErr.ErrMsg)
+		return appErr
+	}
+	return fmt.Errorf("rule %d: %v", index+1, err)
+}
+
+func ruleFromExport(export *models.RuleExport, resolve func(key, value string) (int, int)) (*models.Rule, error) {
+	mode := models.RuleMatchAll
+	if export.Mode != "" {
+		err := mode.FromString(export.Mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rule := &models.Rule{
+		Name:        export.Name,
+		Description: export.Description,
+		Enabled:     export.Enabled,
+		Order:       export.Order,
+		Mode:        mode,
+		Conditions:  make([]*models.RuleCondition, len(export.Conditions)),
+		Actions:     make([]*models.RuleAction, len(export.Actions)),
+	}
+
+	for i, v := range export.Conditions {
+		keyId, valueId := resolve(v.MetadataKey, v.MetadataValue)
+		rule.Conditions[i] = &models.RuleCondition{
+			Enabled:         v.Enabled,
+			CaseInsensitive: v.CaseInsensitive,
+			Inverted:        v.Inverted,
+			ConditionType:   models.RuleConditionType(v.ConditionType),
+			IsRegex:         v.IsRegex,
+			Value:           v.Value,
+			DateFmt:         v.DateFmt,
+			MetadataKey:     models.IntId(keyId),
+			MetadataValue:   models.IntId(valueId),
+		}
+	}
+
+	for i, v := range export.Actions {
+		keyId, valueId := resolve(v.MetadataKey, v.MetadataValue)
+		rule.Actions[i] = &models.RuleAction{
+			Enabled:       v.Enabled,
+			OnCondition:   v.OnCondition,
+			Action:        models.RuleActionType(v.Action),
+			Value:         v.Value,
+			MetadataKey:   models.IntId(keyId),
+			MetadataValue: models.IntId(valueId),
+		}
+	}
+	return rule, nil
+}
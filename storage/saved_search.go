@@ -0,0 +1,206 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// SavedSearchStore is storage for user's saved search queries.
+type SavedSearchStore struct {
+	*resource
+	sq squirrel.StatementBuilderType
+}
+
+func newSavedSearchStore(db *sqlx.DB) *SavedSearchStore {
+	return &SavedSearchStore{
+		resource: &resource{name: "Saved search", db: db},
+		sq:       squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+var savedSearchColumns = []string{
+	"id", "user_id", "name", "query", "sort_key", "sort_desc", "pinned", "digest", "digest_at", "created_at", "updated_at",
+}
+
+// GetSavedSearches returns user's saved searches, pinned searches first.
+func (s *SavedSearchStore) GetSavedSearches(userId int, paging Paging) ([]models.SavedSearch, int, error) {
+	query := s.sq.Select(savedSearchColumns...).
+		From("saved_searches").
+		Where("user_id = ?", userId).
+		OrderBy("pinned DESC", "lower(name) ASC").
+		Offset(uint64(paging.Offset)).
+		Limit(uint64(paging.Limit))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("build sql: %v", err)
+	}
+
+	searches := []models.SavedSearch{}
+	err = s.db.Select(&searches, sql, args...)
+	if err != nil {
+		return nil, 0, s.parseError(err, "get saved searches")
+	}
+
+	total := 0
+	err = s.db.Get(&total, "SELECT count(id) FROM saved_searches WHERE user_id = $1", userId)
+	if err != nil {
+		return nil, 0, s.parseError(err, "count saved searches")
+	}
+	return searches, total, nil
+}
+
+func (s *SavedSearchStore) GetSavedSearch(userId, id int) (*models.SavedSearch, error) {
+	query := s.sq.Select(savedSearchColumns...).
+		From("saved_searches").
+		Where("user_id = ?", userId).
+		Where("id = ?", id)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+
+	search := &models.SavedSearch{}
+	err = s.db.Get(search, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get saved search")
+	}
+	return search, nil
+}
+
+func (s *SavedSearchStore) AddSavedSearch(search *models.SavedSearch) error {
+	search.CreatedAt = time.Now()
+	search.UpdatedAt = search.CreatedAt
+	query := s.sq.Insert("saved_searches").
+		Columns("user_id", "name", "query", "sort_key", "sort_desc", "pinned", "digest", "created_at", "updated_at").
+		Values(search.UserId, search.Name, search.Query, search.SortKey, search.SortDesc, search.Pinned, search.Digest,
+			search.CreatedAt, search.UpdatedAt).
+		Suffix("RETURNING id")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+	err = s.db.Get(&search.Id, sql, args...)
+	return s.parseError(err, "add saved search")
+}
+
+func (s *SavedSearchStore) UpdateSavedSearch(search *models.SavedSearch) error {
+	search.Update()
+	query := s.sq.Update("saved_searches").
+		SetMap(map[string]interface{}{
+			"name":       search.Name,
+			"query":      search.Query,
+			"sort_key":   search.SortKey,
+			"sort_desc":  search.SortDesc,
+			"pinned":     search.Pinned,
+			"digest":     search.Digest,
+			"updated_at": search.UpdatedAt,
+		}).
+		Where("user_id = ?", search.UserId).
+		Where("id = ?", search.Id)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return fmt.Errorf("build sql: %v", err)
+	}
+	res, err := s.db.Exec(sql, args...)
+	if err != nil {
+		return s.parseError(err, "update saved search")
+	}
+	return s.requireRowsAffected(res.RowsAffected())
+}
+
+func (s *SavedSearchStore) DeleteSavedSearch(userId, id int) error {
+	res, err := s.db.Exec("DELETE FROM saved_searches WHERE user_id = $1 AND id = $2", userId, id)
+	if err != nil {
+		return s.parseError(err, "delete saved search")
+	}
+	return s.requireRowsAffected(res.RowsAffected())
+}
+
+// GetDigestSearches returns saved searches of all users that have digest enabled.
+func (s *SavedSearchStore) GetDigestSearches() ([]models.SavedSearch, error) {
+	query := s.sq.Select(savedSearchColumns...).
+		From("saved_searches").
+		Where("digest = TRUE").
+		OrderBy("user_id", "lower(name)")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("build sql: %v", err)
+	}
+	searches := []models.SavedSearch{}
+	err = s.db.Select(&searches, sql, args...)
+	if err != nil {
+		return nil, s.parseError(err, "get digest searches")
+	}
+	return searches, nil
+}
+
+// GetDigestDocuments returns ids of the documents that matched the search on last digest run.
+func (s *SavedSearchStore) GetDigestDocuments(searchId int) ([]string, error) {
+	ids := []string{}
+	err := s.db.Select(&ids, "SELECT document_id FROM saved_search_documents WHERE saved_search_id = $1", searchId)
+	if err != nil {
+		return nil, s.parseError(err, "get digest documents")
+	}
+	return ids, nil
+}
+
+// SetDigestDocuments replaces the documents that match the search and marks the digest as run.
+func (s *SavedSearchStore) SetDigestDocuments(searchId int, documentIds []string, at time.Time) error {
+	tx, err := s.beginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	_, err = tx.tx.Exec("DELETE FROM saved_search_documents WHERE saved_search_id = $1", searchId)
+	if err != nil {
+		return s.parseError(err, "clear digest documents")
+	}
+
+	// documents may have been deleted after searching
+	insertSql := `
+INSERT INTO saved_search_documents (saved_search_id, document_id)
+SELECT $1, id FROM documents WHERE id = ANY($2)
+ON CONFLICT DO NOTHING;`
+	if len(documentIds) > 0 {
+		_, err = tx.tx.Exec(insertSql, searchId, pq.Array(documentIds))
+		if err != nil {
+			return s.parseError(err, "insert digest documents")
+		}
+	}
+
+	_, err = tx.tx.Exec("UPDATE saved_searches SET digest_at = $2 WHERE id = $1", searchId, at)
+	if err != nil {
+		return s.parseError(err, "update digest time")
+	}
+	tx.ok = true
+	return nil
+}
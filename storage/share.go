@@ -0,0 +1,282 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"tryffel.net/go/virtualpaper/errors"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// sharedDocumentIds returns a subquery of ids of documents that are shared with the user, either directly
+// or through a group. UserId is the sql expression of the user id.
+func sharedDocumentIds(userId string) string {
+	return `(
+	SELECT ds.document_id
+	FROM document_shares ds
+	WHERE ds.user_id = ` + userId + `
+	OR ds.group_id IN (SELECT group_id FROM user_group_members WHERE user_id = ` + userId + `)
+)`
+}
+
+// accessibleDocuments returns a condition that matches documents the user owns or that are shared with the user.
+// Alias is the alias of the documents table.
+func accessibleDocuments(alias, userId string) string {
+	return fmt.Sprintf("(%s.user_id = %s OR %s.id IN %s)", alias, userId, alias, sharedDocumentIds(userId))
+}
+
+// GetDocumentPermission returns the permission the user has to the document. If user has no access to the
+// document or the document does not exist, it returns PermissionNone. Shares do not grant access to
+// documents that are in the trash bin.
+func (s *DocumentStore) GetDocumentPermission(userId int, docId string) (models.SharePermission, error) {
+	sql := `
+SELECT CASE
+	WHEN d.user_id = $2 THEN 'owner'
+	WHEN bool_or(ds.permission = 'edit') THEN 'edit'
+	WHEN count(ds.id) > 0 THEN 'read'
+	ELSE ''
+END
+FROM documents d
+LEFT JOIN document_shares ds ON ds.document_id = d.id AND d.deleted_at IS NULL
+	AND (ds.user_id = $2 OR ds.group_id IN (SELECT group_id FROM user_group_members WHERE user_id = $2))
+WHERE d.id = $1
+GROUP BY d.id, d.user_id;
+`
+	var permission string
+	err := s.db.Get(&permission, sql, docId, userId)
+	if err != nil {
+		err = s.parseError(err, "get document permission")
+		if errors.Is(err, errors.ErrRecordNotFound) {
+			return models.PermissionNone, nil
+		}
+		return models.PermissionNone, err
+	}
+	return models.SharePermission(permission), nil
+}
+
+// GetDocumentReaders returns ids of users that can read each of the documents, including the owner.
+func (s *DocumentStore) GetDocumentReaders(docIds []string) (map[string][]int, error) {
+	readers := make(map[string][]int, len(docIds))
+	if len(docIds) == 0 {
+		return readers, nil
+	}
+	sql := `
+SELECT id AS document_id, user_id FROM documents WHERE id = ANY($1)
+UNION
+SELECT document_id, user_id FROM document_shares WHERE user_id IS NOT NULL AND document_id = ANY($1)
+UNION
+SELECT ds.document_id, ugm.user_id FROM document_shares ds
+JOIN user_group_members ugm ON ds.group_id = ugm.group_id
+WHERE ds.document_id = ANY($1)
+ORDER BY document_id, user_id;
+`
+	rows := &[]struct {
+		DocumentId string `db:"document_id"`
+		UserId     int    `db:"user_id"`
+	}{}
+	err := s.db.Select(rows, sql, pq.Array(docIds))
+	if err != nil {
+		return readers, s.parseError(err, "get document readers")
+	}
+	for _, v := range *rows {
+		readers[v.DocumentId] = append(readers[v.DocumentId], v.UserId)
+	}
+	return readers, nil
+}
+
+const documentShareColumns = `
+	ds.id, ds.document_id, ds.user_id, u.name AS user_name, ds.group_id, ug.name AS group_name,
+	ds.permission, ds.created_at, ds.updated_at
+`
+
+// GetDocumentShares returns all shares of the document.
+func (s *DocumentStore) GetDocumentShares(docId string) (*[]models.DocumentShare, error) {
+	sql := `
+SELECT` + documentShareColumns + `
+FROM document_shares ds
+LEFT JOIN users u ON ds.user_id = u.id
+LEFT JOIN user_groups ug ON ds.group_id = ug.id
+WHERE ds.document_id = $1
+ORDER BY ds.created_at ASC;
+`
+	shares := &[]models.DocumentShare{}
+	err := s.db.Select(shares, sql, docId)
+	return shares, s.parseError(err, "get document shares")
+}
+
+// SetDocumentShare shares the document with a user or a group. If the document is already shared with
+// the user or group, the permission is updated.
+func (s *DocumentStore) SetDocumentShare(share *models.DocumentShare) error {
+	conflict := "unique_user_share"
+	if share.GroupId != nil {
+		conflict = "unique_group_share"
+	}
+	sql := `
+INSERT INTO document_shares (document_id, user_id, group_id, permission)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT ON CONSTRAINT ` + conflict + `
+DO UPDATE SET permission = EXCLUDED.permission, updated_at = now()
+RETURNING id, created_at, updated_at;
+`
+	err := s.db.QueryRowx(sql, share.DocumentId, share.UserId, share.GroupId, share.Permission).StructScan(share)
+	return s.parseError(err, "set document share")
+}
+
+// DeleteDocumentShare removes the share from the document and returns the removed share.
+func (s *DocumentStore) DeleteDocumentShare(docId string, shareId int) (*models.DocumentShare, error) {
+	share := &models.DocumentShare{}
+	err := s.db.Get(share, `DELETE FROM document_shares WHERE document_id = $1 AND id = $2
+		RETURNING id, document_id, user_id, group_id, permission, created_at, updated_at`, docId, shareId)
+	return share, s.parseError(err, "delete document share")
+}
+
+// GetGroupSharedDocuments returns documents that are shared with the group.
+func (s *DocumentStore) GetGroupSharedDocuments(groupId int) (*[]models.Document, error) {
+	docs := &[]models.Document{}
+	err := s.db.Select(docs, `SELECT d.id, d.user_id FROM documents d
+		JOIN document_shares ds ON ds.document_id = d.id WHERE ds.group_id = $1`, groupId)
+	return docs, s.parseError(err, "get group shared documents")
+}
+
+// GetGroups returns all user groups with their members.
+func (s *UserStore) GetGroups() (*[]models.UserGroup, error) {
+	groups := &[]models.UserGroup{}
+	err := s.db.Select(groups, `SELECT id, name, created_at, updated_at FROM user_groups ORDER BY name ASC`)
+	if err != nil {
+		return groups, s.parseError(err, "get groups")
+	}
+	return groups, s.getGroupMembers(*groups)
+}
+
+// GetUserGroups returns the groups the user is member of.
+func (s *UserStore) GetUserGroups(userId int) (*[]models.UserGroup, error) {
+	groups := &[]models.UserGroup{}
+	err := s.db.Select(groups, `SELECT ug.id, ug.name, ug.created_at, ug.updated_at FROM user_groups ug
+		JOIN user_group_members ugm ON ug.id = ugm.group_id
+		WHERE ugm.user_id = $1 ORDER BY ug.name ASC`, userId)
+	if err != nil {
+		return groups, s.parseError(err, "get user groups")
+	}
+	return groups, s.getGroupMembers(*groups)
+}
+
+// GetGroup returns the group with its members.
+func (s *UserStore) GetGroup(groupId int) (*models.UserGroup, error) {
+	group := &models.UserGroup{}
+	err := s.db.Get(group, `SELECT id, name, created_at, updated_at FROM user_groups WHERE id = $1`, groupId)
+	if err != nil {
+		return group, s.parseError(err, "get group")
+	}
+	groups := []models.UserGroup{*group}
+	err = s.getGroupMembers(groups)
+	return &groups[0], err
+}
+
+func (s *UserStore) getGroupMembers(groups []models.UserGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	ids := make([]int, len(groups))
+	index := make(map[int]*models.UserGroup, len(groups))
+	for i := range groups {
+		ids[i] = groups[i].Id
+		groups[i].Members = []int{}
+		index[groups[i].Id] = &groups[i]
+	}
+	members := &[]struct {
+		GroupId int `db:"group_id"`
+		UserId  int `db:"user_id"`
+	}{}
+	err := s.db.Select(members, `SELECT group_id, user_id FROM user_group_members
+		WHERE group_id = ANY($1) ORDER BY user_id ASC`, pq.Array(ids))
+	if err != nil {
+		return s.parseError(err, "get group members")
+	}
+	for _, v := range *members {
+		index[v.GroupId].Members = append(index[v.GroupId].Members, v.UserId)
+	}
+	return nil
+}
+
+// AddGroup creates a new group with its members.
+func (s *UserStore) AddGroup(group *models.UserGroup) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	err = tx.QueryRowx(`INSERT INTO user_groups (name) VALUES ($1) RETURNING id, created_at, updated_at`,
+		group.Name).StructScan(group)
+	if err == nil {
+		err = setGroupMembers(tx, group.Id, group.Members)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "add group")
+	}
+	return s.parseError(tx.Commit(), "add group")
+}
+
+// UpdateGroup updates group name and replaces its members.
+func (s *UserStore) UpdateGroup(group *models.UserGroup) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	err = tx.QueryRowx(`UPDATE user_groups SET name = $2, updated_at = now() WHERE id = $1
+		RETURNING created_at, updated_at`, group.Id, group.Name).StructScan(group)
+	if err == nil {
+		err = setGroupMembers(tx, group.Id, group.Members)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "update group")
+	}
+	return s.parseError(tx.Commit(), "update group")
+}
+
+func setGroupMembers(tx *sqlx.Tx, groupId int, members []int) error {
+	_, err := tx.Exec(`DELETE FROM user_group_members WHERE group_id = $1`, groupId)
+	if err != nil || len(members) == 0 {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO user_group_members (group_id, user_id)
+		SELECT $1, unnest($2::INT[]) ON CONFLICT DO NOTHING`, groupId, pq.Array(members))
+	return err
+}
+
+// DeleteGroup deletes the group. Documents shared with the group are no longer shared with its members.
+func (s *UserStore) DeleteGroup(groupId int) error {
+	res, err := s.db.Exec(`DELETE FROM user_groups WHERE id = $1`, groupId)
+	if err != nil {
+		return s.parseError(err, "delete group")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return s.parseError(err, "delete group")
+	}
+	if affected == 0 {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = "group not found"
+		return e
+	}
+	return nil
+}
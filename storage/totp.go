@@ -0,0 +1,147 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// GetUserTotp returns user's two-factor authentication enrolment, or ErrRecordNotFound if user has none.
+func (s *AuthStore) GetUserTotp(userId int) (*models.UserTotp, error) {
+	totp := &models.UserTotp{}
+	sql := `SELECT user_id, secret, enabled, enabled_at, last_used_step, created_at, updated_at
+		FROM user_totp WHERE user_id = $1`
+	err := s.db.Get(totp, sql, userId)
+	if err != nil {
+		return nil, s.parseError(err, "get user totp")
+	}
+	return totp, nil
+}
+
+// SetUserTotpSecret starts a new enrolment for the user. Enrolment is not enabled before EnableUserTotp.
+func (s *AuthStore) SetUserTotpSecret(userId int, secret string) error {
+	sql := `INSERT INTO user_totp (user_id, secret, enabled, enabled_at, last_used_step)
+		VALUES ($1, $2, FALSE, NULL, 0)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = $2, enabled = FALSE, enabled_at = NULL, last_used_step = 0, updated_at = now()`
+	_, err := s.db.Exec(sql, userId, secret)
+	return s.parseError(err, "set user totp secret")
+}
+
+// EnableUserTotp enables the enrolment and replaces user's recovery codes with the given code hashes.
+func (s *AuthStore) EnableUserTotp(userId int, step int64, recoveryCodes []string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE user_totp SET enabled = TRUE, enabled_at = now(), last_used_step = $2, updated_at = now()
+		WHERE user_id = $1`, userId, step)
+	if err == nil {
+		err = setRecoveryCodes(tx, userId, recoveryCodes)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "enable user totp")
+	}
+	return s.parseError(tx.Commit(), "enable user totp")
+}
+
+// UseTotpStep marks the time step used. It returns false if the step, or a later one, has already been used.
+func (s *AuthStore) UseTotpStep(userId int, step int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`,
+		userId, step)
+	if err != nil {
+		return false, s.parseError(err, "update totp last used step")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, s.parseError(err, "update totp last used step")
+	}
+	return affected == 1, nil
+}
+
+// DeleteUserTotp disables two-factor authentication for the user and deletes the recovery codes.
+func (s *AuthStore) DeleteUserTotp(userId int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userId)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "delete user totp")
+	}
+	return s.parseError(tx.Commit(), "delete user totp")
+}
+
+// SetRecoveryCodes replaces user's recovery codes with the given code hashes.
+func (s *AuthStore) SetRecoveryCodes(userId int, recoveryCodes []string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	err = setRecoveryCodes(tx, userId, recoveryCodes)
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "set recovery codes")
+	}
+	return s.parseError(tx.Commit(), "set recovery codes")
+}
+
+func setRecoveryCodes(tx *sqlx.Tx, userId int, recoveryCodes []string) error {
+	_, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+	for _, v := range recoveryCodes {
+		_, err = tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userId, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code used. It returns false if there is no unused code with the hash.
+func (s *AuthStore) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE user_recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userId, codeHash, time.Now())
+	if err != nil {
+		return false, s.parseError(err, "use recovery code")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, s.parseError(err, "use recovery code")
+	}
+	return affected > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes.
+func (s *AuthStore) CountRecoveryCodes(userId int) (int, error) {
+	count := 0
+	err := s.db.Get(&count, `SELECT COUNT(id) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userId)
+	return count, s.parseError(err, "count recovery codes")
+}
@@ -319,29 +319,72 @@ GROUP BY(s.id);`
 		return pref, s.parseError(err, "get preferences")
 	}
 
-	stopWords, err := s.GetPreferenceValue(userid, PreferenceStopWords)
+	pref.StopWords, pref.Synonyms, err = s.GetSearchPreferences(userid)
 	if err != nil {
-		return pref, fmt.Errorf("get stopwords: %v", err)
+		return pref, err
 	}
-	if stopWords != "" {
-		err = json.Unmarshal([]byte(stopWords), &pref.StopWords)
-		if err != nil {
-			return pref, fmt.Errorf("unmarshal stopwords: %v", err)
-		}
+
+	pref.DateLocale, err = s.GetPreferenceValue(userid, PreferenceDateLocale)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		err = nil
+	} else if err != nil {
+		return pref, fmt.Errorf("get date locale: %v", err)
 	}
 
-	synonyms, err := s.GetPreferenceValue(userid, PreferenceSynonyms)
+	pref.Timezone, err = s.GetPreferenceValue(userid, PreferenceTimezone)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		err = nil
+	} else if err != nil {
+		return pref, fmt.Errorf("get timezone: %v", err)
+	}
+
+	pref.FacetKeys, err = s.GetFacetKeys(userid)
 	if err != nil {
-		return pref, fmt.Errorf("get synonyms: %v", err)
+		return pref, err
 	}
-	if synonyms != "" {
-		err = json.Unmarshal([]byte(synonyms), &pref.Synonyms)
+
+	pref.SearchSettings, err = s.GetSearchSettings(userid)
+	if err != nil {
+		return pref, err
+	}
+
+	pref.Notifications, err = s.GetNotificationSettings(userid)
+	if err != nil {
+		return pref, err
+	}
+
+	pref.PendingEmail, err = s.GetPendingEmail(userid)
+	return pref, err
+
+}
+
+// GetSearchPreferences returns user's stop words and synonyms.
+func (s *UserStore) GetSearchPreferences(userId int) ([]string, [][]string, error) {
+	stopWords := []string{}
+	synonyms := [][]string{}
+
+	value, err := s.GetPreferenceValue(userId, PreferenceStopWords)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return stopWords, synonyms, fmt.Errorf("get stopwords: %v", err)
+	}
+	if value != "" {
+		err = json.Unmarshal([]byte(value), &stopWords)
 		if err != nil {
-			return pref, fmt.Errorf("unmarshal synonyms: %v", err)
+			return stopWords, synonyms, fmt.Errorf("unmarshal stopwords: %v", err)
 		}
 	}
-	return pref, err
 
+	value, err = s.GetPreferenceValue(userId, PreferenceSynonyms)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return stopWords, synonyms, fmt.Errorf("get synonyms: %v", err)
+	}
+	if value != "" {
+		err = json.Unmarshal([]byte(value), &synonyms)
+		if err != nil {
+			return stopWords, synonyms, fmt.Errorf("unmarshal synonyms: %v", err)
+		}
+	}
+	return stopWords, synonyms, nil
 }
 
 type PreferenceKey string
@@ -349,8 +392,117 @@ type PreferenceKey string
 const (
 	PreferenceStopWords PreferenceKey = "stop_words"
 	PreferenceSynonyms  PreferenceKey = "synonyms"
+	// PreferenceDateLocale is the language of dates when extracting dates from documents.
+	PreferenceDateLocale PreferenceKey = "date_locale"
+	// PreferenceTimezone is the IANA name of user's timezone, e.g. Europe/Helsinki.
+	PreferenceTimezone PreferenceKey = "timezone"
+	// PreferenceFacetKeys are the metadata keys and other facets that are counted for search results.
+	PreferenceFacetKeys PreferenceKey = "facet_keys"
+	// PreferenceSearchSettings are user's ranking rules, typo tolerance and attribute weights.
+	PreferenceSearchSettings PreferenceKey = "search_settings"
+	// PreferenceNotifications are the notifications user wants to receive by mail.
+	PreferenceNotifications PreferenceKey = "notifications"
 )
 
+// GetFacetKeys returns user's search facets. Empty list means all facets.
+func (s *UserStore) GetFacetKeys(userId int) ([]string, error) {
+	keys := []string{}
+	value, err := s.GetPreferenceValue(userId, PreferenceFacetKeys)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return keys, fmt.Errorf("get facet keys: %v", err)
+	}
+	if value != "" {
+		err = json.Unmarshal([]byte(value), &keys)
+		if err != nil {
+			return keys, fmt.Errorf("unmarshal facet keys: %v", err)
+		}
+	}
+	return keys, nil
+}
+
+func (s *UserStore) UpdateFacetKeys(userId int, keys []string) error {
+	value, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("serialize facet keys: %v", err)
+	}
+	err = s.SetPreferenceValue(userId, PreferenceFacetKeys, string(value))
+	if err != nil {
+		return fmt.Errorf("save facet keys: %v", err)
+	}
+	return nil
+}
+
+// GetSearchSettings returns user's search settings. If user has not set them, returns default settings.
+func (s *UserStore) GetSearchSettings(userId int) (models.SearchSettings, error) {
+	settings := models.SearchSettings{}
+	value, err := s.GetPreferenceValue(userId, PreferenceSearchSettings)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return settings, fmt.Errorf("get search settings: %v", err)
+	}
+	if value != "" {
+		err = json.Unmarshal([]byte(value), &settings)
+		if err != nil {
+			return settings, fmt.Errorf("unmarshal search settings: %v", err)
+		}
+	}
+	return settings, nil
+}
+
+func (s *UserStore) UpdateSearchSettings(userId int, settings models.SearchSettings) error {
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("serialize search settings: %v", err)
+	}
+	err = s.SetPreferenceValue(userId, PreferenceSearchSettings, string(value))
+	if err != nil {
+		return fmt.Errorf("save search settings: %v", err)
+	}
+	return nil
+}
+
+// GetNotificationSettings returns user's notification settings. If user has not set them, returns default settings.
+func (s *UserStore) GetNotificationSettings(userId int) (models.NotificationSettings, error) {
+	settings := models.DefaultNotificationSettings()
+	value, err := s.GetPreferenceValue(userId, PreferenceNotifications)
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		return settings, fmt.Errorf("get notification settings: %v", err)
+	}
+	if value != "" {
+		err = json.Unmarshal([]byte(value), &settings)
+		if err != nil {
+			return models.DefaultNotificationSettings(), fmt.Errorf("unmarshal notification settings: %v", err)
+		}
+	}
+	return settings, nil
+}
+
+func (s *UserStore) UpdateNotificationSettings(userId int, settings models.NotificationSettings) error {
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("serialize notification settings: %v", err)
+	}
+	err = s.SetPreferenceValue(userId, PreferenceNotifications, string(value))
+	if err != nil {
+		return fmt.Errorf("save notification settings: %v", err)
+	}
+	return nil
+}
+
+// GetUserLocation returns user's timezone. If user has not set timezone, returns UTC.
+func (s *UserStore) GetUserLocation(userId int) (*time.Location, error) {
+	value, err := s.GetPreferenceValue(userId, PreferenceTimezone)
+	if errors.Is(err, errors.ErrRecordNotFound) || (err == nil && value == "") {
+		return time.UTC, nil
+	} else if err != nil {
+		return time.UTC, fmt.Errorf("get timezone: %v", err)
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return time.UTC, fmt.Errorf("load timezone: %v", err)
+	}
+	return location, nil
+}
+
 func (s *UserStore) GetPreferenceValue(userId int, key PreferenceKey) (string, error) {
 	sql := `
 SELECT value
@@ -446,3 +598,66 @@ func (s *UserStore) DeleteExpiredPasswordResetTokens() (int, error) {
 	}
 	return int(affected), nil
 }
+
+// SetEmailVerificationToken saves a pending email change. User has at most one pending change,
+// which replaces any earlier change.
+func (s *UserStore) SetEmailVerificationToken(token *models.EmailVerificationToken) error {
+	err := token.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `INSERT INTO email_verification_tokens (token, user_id, email, created_at, updated_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id) DO
+UPDATE SET token=$1, email=$3, created_at=$4, updated_at=$5, expires_at=$6
+RETURNING id`
+
+	id := 0
+	err = s.db.Get(&id, sql, token.Token, token.UserId, token.Email, token.CreatedAt, token.UpdatedAt, token.ExpiresAt)
+	if err == nil {
+		token.Id = id
+	}
+	return s.parseError(err, "save email verification token")
+}
+
+func (s *UserStore) GetEmailVerificationToken(tokenId int) (*models.EmailVerificationToken, error) {
+	sql := `SELECT * FROM email_verification_tokens WHERE id=$1`
+
+	token := &models.EmailVerificationToken{}
+	err := s.db.Get(token, sql, tokenId)
+	return token, s.parseError(err, "get email verification token")
+}
+
+// GetPendingEmail returns the email that user has requested but not yet verified.
+// Empty string means there is no pending change.
+func (s *UserStore) GetPendingEmail(userId int) (string, error) {
+	sql := `SELECT email FROM email_verification_tokens WHERE user_id=$1 AND expires_at > now()`
+
+	email := ""
+	err := s.parseError(s.db.Get(&email, sql, userId), "get pending email")
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		return "", nil
+	}
+	return email, err
+}
+
+func (s *UserStore) DeleteEmailVerificationToken(tokenId int) error {
+	sql := `DELETE FROM email_verification_tokens WHERE id = $1`
+	_, err := s.db.Exec(sql, tokenId)
+	return s.parseError(err, "delete email verification token")
+}
+
+func (s *UserStore) DeleteExpiredEmailVerificationTokens() (int, error) {
+	sql := `DELETE FROM email_verification_tokens WHERE expires_at < now()`
+	out, err := s.db.Exec(sql)
+	if err != nil {
+		return 0, s.parseError(err, "delete expired email verification tokens")
+	}
+
+	affected, err := out.RowsAffected()
+	if err != nil {
+		logrus.Warningf("get rows affected for deleting expired email verification tokens: %v", err)
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,90 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+
+	"tryffel.net/go/virtualpaper/models"
+)
+
+// GetUserDataSummary counts the data that is removed when the user is deleted.
+func (s *UserStore) GetUserDataSummary(userId int) (*models.UserDataSummary, error) {
+	sql := `
+SELECT
+	u.id AS user_id,
+	u.name AS user_name,
+	(SELECT count(id) FROM documents WHERE user_id = u.id) AS documents_count,
+	(SELECT coalesce(sum(size), 0) FROM documents WHERE user_id = u.id) AS documents_size,
+	(SELECT count(id) FROM metadata_keys WHERE user_id = u.id) AS metadata_keys_count,
+	(SELECT count(id) FROM metadata_values WHERE user_id = u.id) AS metadata_values_count,
+	(SELECT count(id) FROM rules WHERE user_id = u.id) AS rules_count,
+	(SELECT count(id) FROM saved_searches WHERE user_id = u.id) AS saved_searches_count,
+	(SELECT count(id) FROM auth_tokens WHERE user_id = u.id) AS sessions_count,
+	(SELECT count(id) FROM api_tokens WHERE user_id = u.id) AS api_tokens_count,
+	(SELECT count(ds.id) FROM document_shares ds
+		JOIN documents d ON ds.document_id = d.id
+		WHERE d.user_id = u.id) AS document_shares_count,
+	(SELECT count(id) FROM document_links WHERE user_id = u.id) AS document_links_count,
+	(SELECT count(group_id) FROM user_group_members WHERE user_id = u.id) AS group_memberships_count,
+	(SELECT count(id) FROM collections WHERE user_id = u.id) AS collections_count
+FROM users u
+WHERE u.id = $1
+`
+	summary := &models.UserDataSummary{}
+	err := s.db.Get(summary, sql, userId)
+	return summary, s.parseError(err, "get user data summary")
+}
+
+// GetUserDocumentIds returns ids of all documents the user owns, including deleted documents.
+func (s *UserStore) GetUserDocumentIds(userId int) ([]string, error) {
+	ids := []string{}
+	err := s.db.Select(&ids, `SELECT id FROM documents WHERE user_id = $1`, userId)
+	return ids, s.parseError(err, "get user document ids")
+}
+
+// GetDocumentIdsSharedWithUser returns ids of documents that other users have shared with the user.
+func (s *UserStore) GetDocumentIdsSharedWithUser(userId int) ([]string, error) {
+	ids := []string{}
+	err := s.db.Select(&ids, `SELECT DISTINCT document_id FROM `+sharedDocumentIds("$1")+` AS shared`, userId)
+	return ids, s.parseError(err, "get documents shared with user")
+}
+
+// DeleteUser deletes the user and all the user's database records. Document history entries the user has made
+// on other users' documents are kept without the user. Files and search index are not removed.
+func (s *UserStore) DeleteUser(userId int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	defer s.FlushCache()
+
+	_, err = tx.Exec(`UPDATE document_history SET user_id = NULL WHERE user_id = $1`, userId)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM documents WHERE user_id = $1`, userId)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM users WHERE id = $1`, userId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return s.parseError(err, "delete user")
+	}
+	return s.parseError(tx.Commit(), "delete user")
+}
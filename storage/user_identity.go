@@ -0,0 +1,41 @@
+/*
+ * Virtualpaper is a service to manage users paper documents in virtual format.
+ * Copyright (C) 2023  Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+// GetUserIdByIdentity returns the user that is linked to the identity of an external identity provider.
+func (s *UserStore) GetUserIdByIdentity(issuer, subject string) (int, error) {
+	userId := 0
+	err := s.db.Get(&userId, `UPDATE user_identities SET last_login = now()
+		WHERE issuer = $1 AND subject = $2 RETURNING user_id`, issuer, subject)
+	return userId, s.parseError(err, "get user by identity")
+}
+
+// AddUserIdentity links the identity of an external identity provider to the user.
+func (s *UserStore) AddUserIdentity(userId int, issuer, subject string) error {
+	_, err := s.db.Exec(`INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)`,
+		userId, issuer, subject)
+	return s.parseError(err, "add user identity")
+}
+
+// CountUsers returns the total number of users.
+func (s *UserStore) CountUsers() (int, error) {
+	count := 0
+	err := s.db.Get(&count, `SELECT COUNT(id) FROM users`)
+	return count, s.parseError(err, "count users")
+}
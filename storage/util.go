@@ -23,6 +23,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"regexp"
+	"tryffel.net/go/virtualpaper/errors"
 )
 
 // SortKey contains sortable key and order. Order 'false' = ASC, 'true' = DESC.
@@ -97,6 +98,19 @@ func (r *resource) parseError(e error, action string) error {
 	return getDatabaseError(e, r, action)
 }
 
+// requireRowsAffected returns ErrRecordNotFound if no rows were affected.
+func (r *resource) requireRowsAffected(rows int64, err error) error {
+	if err != nil {
+		return r.parseError(err, "get affected rows")
+	}
+	if rows == 0 {
+		e := errors.ErrRecordNotFound
+		e.ErrMsg = r.Name() + " not found"
+		return e
+	}
+	return nil
+}
+
 type tx struct {
 	tx       *sqlx.Tx
 	ok       bool